@@ -0,0 +1,335 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// wheelSlots is the number of slots of the timer wheel of a bounded cache
+	wheelSlots = 64
+	// minimumTick is the shortest interval between two ticks of the timer wheel
+	minimumTick = 10 * time.Millisecond
+	// evictionScan is the number of least recently used entries that are
+	// searched for an evictable entry when a bounded cache is full
+	evictionScan = 32
+)
+
+// BoundedCacheConfig configures a bounded cache
+type BoundedCacheConfig struct {
+	// MaxSize is the maximum number of entries. Zero means no limit.
+	MaxSize int
+	// Lifetime is the time after which an entry that is not updated expires.
+	// Zero means that the entries never expire.
+	Lifetime time.Duration
+	// Expirer is called for the entries that expire
+	Expirer ExpirationNotifier
+	// Evictable returns true for the entries that are evicted first when the
+	// cache is full, for example the connections that are half-open
+	Evictable func(item interface{}) bool
+	// Evicted is called for the entries evicted because the cache is full
+	Evicted func(id interface{}, item interface{})
+}
+
+// boundedEntry is an entry of a bounded cache
+type boundedEntry struct {
+	key      interface{}
+	value    interface{}
+	deadline time.Time
+	slot     int
+	element  *list.Element
+}
+
+// BoundedCache is a data store with a maximum number of entries. When it is
+// full the least recently used entry is evicted, preferring the evictable
+// entries. The entries expire on the ticks of a timer wheel shared by the
+// whole cache instead of a timer per entry. The wheel only runs while the
+// cache is not empty.
+type BoundedCache struct {
+	config    BoundedCacheConfig
+	data      map[interface{}]*boundedEntry
+	lru       *list.List
+	slots     []map[interface{}]*boundedEntry
+	tick      time.Duration
+	running   bool
+	evictions uint64
+	sync.Mutex
+}
+
+// NewBoundedCache creates a new bounded cache
+func NewBoundedCache(config BoundedCacheConfig) *BoundedCache {
+
+	c := &BoundedCache{
+		config: config,
+		data:   map[interface{}]*boundedEntry{},
+		lru:    list.New(),
+	}
+
+	if config.Lifetime > 0 {
+		// A deadline is always less than one turn of the wheel away
+		c.tick = config.Lifetime / (wheelSlots - 2)
+		if c.tick < minimumTick {
+			c.tick = minimumTick
+		}
+		c.slots = make([]map[interface{}]*boundedEntry, wheelSlots)
+		for i := range c.slots {
+			c.slots[i] = map[interface{}]*boundedEntry{}
+		}
+	}
+
+	return c
+}
+
+// Add stores an entry into the cache
+func (c *BoundedCache) Add(u interface{}, value interface{}) (err error) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.data[u]; ok {
+		return fmt.Errorf("Item Exists - Use update")
+	}
+
+	c.store(u, value)
+
+	return nil
+}
+
+// Update changes the value of an entry into the cache. The lifetime of the
+// entry starts again.
+func (c *BoundedCache) Update(u interface{}, value interface{}) (err error) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.data[u]; !ok {
+		return fmt.Errorf("Cannot update item - it doesn't exist")
+	}
+
+	c.store(u, value)
+
+	return nil
+}
+
+// AddOrUpdate adds a new value in the cache or updates the existing value.
+// The lifetime of the entry starts again.
+func (c *BoundedCache) AddOrUpdate(u interface{}, value interface{}) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.store(u, value)
+}
+
+// Get retrieves the entry from the cache and marks it as recently used
+func (c *BoundedCache) Get(u interface{}) (i interface{}, err error) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.data[u]
+	if !ok {
+		return nil, fmt.Errorf("Item does not exist")
+	}
+
+	c.lru.MoveToFront(e.element)
+
+	return e.value, nil
+}
+
+// Remove removes the entry from the cache and returns error if not there
+func (c *BoundedCache) Remove(u interface{}) (err error) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.data[u]
+	if !ok {
+		return fmt.Errorf("Item does not exist")
+	}
+
+	c.remove(e)
+
+	return nil
+}
+
+// SizeOf returns the number of elements in the cache
+func (c *BoundedCache) SizeOf() int {
+
+	c.Lock()
+	defer c.Unlock()
+
+	return len(c.data)
+}
+
+// Evictions returns the number of entries evicted because the cache was full
+func (c *BoundedCache) Evictions() uint64 {
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.evictions
+}
+
+// KeyList returns all the keys that are currently stored in the cache
+func (c *BoundedCache) KeyList() []interface{} {
+
+	c.Lock()
+	defer c.Unlock()
+
+	list := make([]interface{}, 0, len(c.data))
+	for k := range c.data {
+		list = append(list, k)
+	}
+
+	return list
+}
+
+// LockedModify changes the value of an entry with the add function while the
+// cache is locked. The lifetime of the entry starts again.
+func (c *BoundedCache) LockedModify(u interface{}, add func(a, b interface{}) interface{}, increment interface{}) (interface{}, error) {
+
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.data[u]
+	if !ok {
+		return nil, fmt.Errorf("Item not found")
+	}
+
+	value := add(e.value, increment)
+	c.store(u, value)
+
+	return value, nil
+}
+
+// DumpStore is not supported
+func (c *BoundedCache) DumpStore() {}
+
+// store adds or updates an entry, evicting an entry if the cache is full. The
+// caller must hold the lock.
+func (c *BoundedCache) store(u interface{}, value interface{}) {
+
+	e, ok := c.data[u]
+	if ok {
+		e.value = value
+		c.lru.MoveToFront(e.element)
+	} else {
+		if c.config.MaxSize > 0 && len(c.data) >= c.config.MaxSize {
+			c.evict()
+		}
+		e = &boundedEntry{key: u, value: value}
+		e.element = c.lru.PushFront(e)
+		c.data[u] = e
+	}
+
+	if c.slots == nil {
+		return
+	}
+
+	if ok {
+		delete(c.slots[e.slot], u)
+	}
+
+	e.deadline = time.Now().Add(c.config.Lifetime)
+	e.slot = c.slotOf(e.deadline)
+	c.slots[e.slot][u] = e
+
+	if !c.running {
+		c.running = true
+		go c.runWheel()
+	}
+}
+
+// remove removes an entry. The caller must hold the lock.
+func (c *BoundedCache) remove(e *boundedEntry) {
+
+	c.lru.Remove(e.element)
+	delete(c.data, e.key)
+
+	if c.slots != nil {
+		delete(c.slots[e.slot], e.key)
+	}
+}
+
+// evict removes the least recently used evictable entry among the oldest
+// entries, or the least recently used entry. The caller must hold the lock.
+func (c *BoundedCache) evict() {
+
+	victim := c.lru.Back()
+	if victim == nil {
+		return
+	}
+
+	if c.config.Evictable != nil {
+		element := victim
+		for i := 0; i < evictionScan && element != nil; i++ {
+			if c.config.Evictable(element.Value.(*boundedEntry).value) {
+				victim = element
+				break
+			}
+			element = element.Prev()
+		}
+	}
+
+	e := victim.Value.(*boundedEntry)
+	c.remove(e)
+	c.evictions++
+
+	if c.config.Evicted != nil {
+		c.config.Evicted(e.key, e.value)
+	}
+}
+
+// slotOf returns the slot of the wheel that is processed on the first tick
+// after the deadline
+func (c *BoundedCache) slotOf(deadline time.Time) int {
+
+	return int((deadline.UnixNano()/int64(c.tick) + 1) % wheelSlots)
+}
+
+// runWheel expires the entries of the slots of the wheel on every tick. It
+// returns when the cache is empty.
+func (c *BoundedCache) runWheel() {
+
+	ticker := time.NewTicker(c.tick)
+	defer ticker.Stop()
+
+	last := time.Now().UnixNano() / int64(c.tick)
+
+	for now := range ticker.C {
+		current := now.UnixNano() / int64(c.tick)
+
+		expired := []*boundedEntry{}
+
+		c.Lock()
+		// Slots are processed up to one turn of the wheel when ticks were missed
+		for t := last + 1; t <= current && t <= last+wheelSlots; t++ {
+			for _, e := range c.slots[int(t%wheelSlots)] {
+				if !now.Before(e.deadline) {
+					c.remove(e)
+					expired = append(expired, e)
+				}
+			}
+		}
+		last = current
+
+		empty := len(c.data) == 0
+		if empty {
+			c.running = false
+		}
+		c.Unlock()
+
+		if c.config.Expirer != nil {
+			for _, e := range expired {
+				c.config.Expirer(c, e.key, e.value)
+			}
+		}
+
+		if empty {
+			return
+		}
+	}
+}
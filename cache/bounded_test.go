@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBoundedCacheElements(t *testing.T) {
+
+	t.Parallel()
+
+	Convey("Given a bounded cache without limits", t, func() {
+
+		c := NewBoundedCache(BoundedCacheConfig{})
+
+		Convey("When I add an element, I should get it back", func() {
+			So(c.Add("a", 1), ShouldBeNil)
+			v, err := c.Get("a")
+			So(err, ShouldBeNil)
+			So(v, ShouldEqual, 1)
+			So(c.SizeOf(), ShouldEqual, 1)
+
+			Convey("When I add it again, I should get an error", func() {
+				So(c.Add("a", 2), ShouldNotBeNil)
+			})
+
+			Convey("When I update it, I should get the new value", func() {
+				So(c.Update("a", 2), ShouldBeNil)
+				v, err := c.Get("a")
+				So(err, ShouldBeNil)
+				So(v, ShouldEqual, 2)
+			})
+
+			Convey("When I modify it, I should get the sum", func() {
+				v, err := c.LockedModify("a", func(a, b interface{}) interface{} {
+					return a.(int) + b.(int)
+				}, 3)
+				So(err, ShouldBeNil)
+				So(v, ShouldEqual, 4)
+			})
+
+			Convey("When I remove it, it should be gone", func() {
+				So(c.Remove("a"), ShouldBeNil)
+				So(c.Remove("a"), ShouldNotBeNil)
+				_, err := c.Get("a")
+				So(err, ShouldNotBeNil)
+				So(c.KeyList(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I update an element that does not exist, I should get an error", func() {
+			So(c.Update("b", 1), ShouldNotBeNil)
+			_, err := c.LockedModify("b", func(a, b interface{}) interface{} { return a }, 1)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestBoundedCacheEviction(t *testing.T) {
+
+	t.Parallel()
+
+	Convey("Given a bounded cache of 3 entries where the odd values are evictable", t, func() {
+
+		evicted := []interface{}{}
+		c := NewBoundedCache(BoundedCacheConfig{
+			MaxSize: 3,
+			Evictable: func(item interface{}) bool {
+				return item.(int)%2 == 1
+			},
+			Evicted: func(id interface{}, item interface{}) {
+				evicted = append(evicted, id)
+			},
+		})
+
+		c.AddOrUpdate("a", 2)
+		c.AddOrUpdate("b", 1)
+		c.AddOrUpdate("c", 4)
+
+		Convey("When I add a fourth entry, the evictable entry should be evicted", func() {
+			c.AddOrUpdate("d", 6)
+			So(evicted, ShouldResemble, []interface{}{"b"})
+			So(c.SizeOf(), ShouldEqual, 3)
+			So(c.Evictions(), ShouldEqual, 1)
+
+			Convey("When I add a fifth entry, the least recently used entry should be evicted", func() {
+				_, err := c.Get("a")
+				So(err, ShouldBeNil)
+				c.AddOrUpdate("e", 8)
+				So(evicted, ShouldResemble, []interface{}{"b", "c"})
+				So(c.Evictions(), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When I update an entry, nothing should be evicted", func() {
+			c.AddOrUpdate("b", 3)
+			So(evicted, ShouldBeEmpty)
+			So(c.SizeOf(), ShouldEqual, 3)
+		})
+	})
+}
+
+func TestBoundedCacheExpiration(t *testing.T) {
+
+	t.Parallel()
+
+	Convey("Given a bounded cache with a lifetime", t, func() {
+
+		var lock sync.Mutex
+		expired := []interface{}{}
+
+		c := NewBoundedCache(BoundedCacheConfig{
+			Lifetime: 100 * time.Millisecond,
+			Expirer: func(c DataStore, id interface{}, item interface{}) {
+				lock.Lock()
+				defer lock.Unlock()
+				expired = append(expired, id)
+			},
+		})
+
+		Convey("When I add entries and refresh one of them, only the other should expire", func() {
+			c.AddOrUpdate("a", 1)
+			c.AddOrUpdate("b", 2)
+			time.Sleep(60 * time.Millisecond)
+			So(c.Update("b", 3), ShouldBeNil)
+			time.Sleep(80 * time.Millisecond)
+
+			lock.Lock()
+			So(expired, ShouldResemble, []interface{}{"a"})
+			lock.Unlock()
+			So(c.KeyList(), ShouldResemble, []interface{}{"b"})
+
+			Convey("When the other expires, the cache should be empty", func() {
+				time.Sleep(100 * time.Millisecond)
+
+				lock.Lock()
+				So(expired, ShouldResemble, []interface{}{"a", "b"})
+				lock.Unlock()
+				So(c.SizeOf(), ShouldEqual, 0)
+			})
+		})
+	})
+}
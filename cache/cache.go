@@ -19,6 +19,8 @@ type DataStore interface {
 	Get(u interface{}) (i interface{}, err error)
 	Remove(u interface{}) (err error)
 	DumpStore()
+	KeyList() []interface{}
+	SizeOf() int
 	LockedModify(u interface{}, add func(a, b interface{}) interface{}, increment interface{}) (interface{}, error)
 }
 
@@ -224,6 +226,20 @@ func (c *Cache) SizeOf() int {
 	return len(c.data)
 }
 
+// KeyList returns all the keys that are currently stored in the cache
+func (c *Cache) KeyList() []interface{} {
+
+	c.Lock()
+	defer c.Unlock()
+
+	list := []interface{}{}
+	for k := range c.data {
+		list = append(list, k)
+	}
+
+	return list
+}
+
 // LockedModify  locks the data store
 func (c *Cache) LockedModify(u interface{}, add func(a, b interface{}) interface{}, increment interface{}) (interface{}, error) {
 
@@ -167,6 +167,28 @@ func TestLockedModify(t *testing.T) {
 	})
 }
 
+func TestKeyList(t *testing.T) {
+
+	t.Parallel()
+
+	Convey("Given a new cache with two elements", t, func() {
+		c := NewCache()
+		So(c.Add("key1", 1), ShouldBeNil)
+		So(c.Add("key2", 2), ShouldBeNil)
+
+		Convey("I should get both keys", func() {
+			So(c.KeyList(), ShouldHaveLength, 2)
+			So(c.KeyList(), ShouldContain, "key1")
+			So(c.KeyList(), ShouldContain, "key2")
+		})
+
+		Convey("When I remove an element, I should get the other key only", func() {
+			So(c.Remove("key1"), ShouldBeNil)
+			So(c.KeyList(), ShouldResemble, []interface{}{"key2"})
+		})
+	})
+}
+
 func TestTimerExpirationWithUpdate(t *testing.T) {
 
 	t.Parallel()
@@ -0,0 +1,301 @@
+// Package policysimulator simulates a connection between two processing units
+// without any enforcer. The tokens of the handshake are created and decoded
+// with real secrets and the tags they carry are evaluated with the receiver
+// rules of the server and the transmitter rules of the client, for example to
+// check the policies of a resolver before they are rolled out.
+package policysimulator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aporeto-inc/trireme/cmd/rulerenderer"
+	"github.com/aporeto-inc/trireme/enforcer"
+	"github.com/aporeto-inc/trireme/enforcer/connection"
+	"github.com/aporeto-inc/trireme/enforcer/lookup"
+	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+const (
+	// clientIssuer and serverIssuer are the servers that issue the tokens
+	clientIssuer = "simulator-client"
+	serverIssuer = "simulator-server"
+	// validity is the validity of the tokens of the handshake
+	validity = time.Minute
+)
+
+// Result is the outcome of a simulated connection
+type Result struct {
+	// Accepted is true if the connection is established
+	Accepted bool
+	// Reason explains why the connection is rejected
+	Reason string
+	// Receiver explains the decision of the receiver rules of the server. It is
+	// nil if the token of the client was not accepted.
+	Receiver *policy.PolicyExplanation
+	// Transmitter explains the decision of the transmitter rules of the client.
+	// It is nil if the connection was rejected before.
+	Transmitter *policy.PolicyExplanation
+}
+
+// SimulateConnection prints the outcome of a connection from a client PU to a
+// server PU. The arguments are:
+//
+//	<client>    the JSON file of the PU information of the client
+//	<server>    the JSON file of the PU information of the server
+//	--port      the destination port of the connection
+//	--psk       the pre-shared key that signs the tokens
+//	--key       the PEM file of the private key that signs the tokens
+//	--cert      the PEM file of the certificate of the key
+//	--ca        the PEM file of the certificate authority
+//	--mutual    the transmitter rules must accept the server too
+//
+// The tokens are signed with the pre-shared key unless a private key is given.
+// It returns an error if the connection is rejected.
+func SimulateConnection(arguments map[string]interface{}) error {
+
+	client, err := loadPUInfo(arguments, "<client>")
+	if err != nil {
+		return err
+	}
+
+	server, err := loadPUInfo(arguments, "<server>")
+	if err != nil {
+		return err
+	}
+
+	port := 0
+	if arg, ok := arguments["--port"].(string); ok {
+		if port, err = strconv.Atoi(arg); err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("Invalid port %s", arg)
+		}
+	} else {
+		return fmt.Errorf("The destination port is required")
+	}
+
+	secrets, err := loadSecrets(arguments)
+	if err != nil {
+		return err
+	}
+
+	mutualAuthorization, _ := arguments["--mutual"].(bool)
+
+	result, err := Simulate(client, server, port, secrets, mutualAuthorization)
+	if err != nil {
+		return err
+	}
+
+	if err := Report(os.Stdout, client, server, result); err != nil {
+		return err
+	}
+
+	if !result.Accepted {
+		return fmt.Errorf("Connection rejected: %s", result.Reason)
+	}
+
+	return nil
+}
+
+// loadPUInfo loads the PU information of the file of an argument
+func loadPUInfo(arguments map[string]interface{}, name string) (*policy.PUInfo, error) {
+
+	file, _ := arguments[name].(string)
+	if file == "" {
+		return nil, fmt.Errorf("The PU information %s is required", name)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the PU information %s: %s", file, err)
+	}
+
+	return rulerenderer.LoadPUInfo(data)
+}
+
+// loadSecrets returns the secrets of the arguments
+func loadSecrets(arguments map[string]interface{}) (tokens.Secrets, error) {
+
+	keyFile, _ := arguments["--key"].(string)
+	if keyFile == "" {
+		psk, _ := arguments["--psk"].(string)
+		if psk == "" {
+			return nil, fmt.Errorf("A pre-shared key or a private key is required")
+		}
+
+		return tokens.NewPSKSecrets([]byte(psk)), nil
+	}
+
+	certFile, _ := arguments["--cert"].(string)
+	caFile, _ := arguments["--ca"].(string)
+
+	pems := [][]byte{}
+	for _, file := range []string{keyFile, certFile, caFile} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read the secrets: %s", err)
+		}
+		pems = append(pems, data)
+	}
+
+	secrets := tokens.NewPKISecrets(pems[0], pems[1], pems[2], nil)
+	if secrets == nil {
+		return nil, fmt.Errorf("Invalid private key, certificate or certificate authority")
+	}
+
+	return secrets, nil
+}
+
+// Simulate simulates a connection from the client to the port of the server.
+// The client sends its identity in the Syn token that is evaluated with the
+// receiver rules of the server, and the server sends its identity in the SynAck
+// token that is evaluated with the transmitter rules of the client. The
+// transmitter rules reject the connection only with mutual authorization, like
+// in the datapath. The Ack token must match the contexts of the handshake.
+func Simulate(client, server *policy.PUInfo, port int, secrets tokens.Secrets, mutualAuthorization bool) (*Result, error) {
+
+	clientTokens, err := tokens.NewJWT(validity, clientIssuer, secrets)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create the tokens of the client: %s", err)
+	}
+
+	serverTokens, err := tokens.NewJWT(validity, serverIssuer, secrets)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create the tokens of the server: %s", err)
+	}
+
+	clientConn := connection.NewTCPConnection(false)
+	serverConn := connection.NewTCPConnection(false)
+
+	result := &Result{}
+
+	// Syn
+	claims, cert := serverTokens.Decode(false, clientTokens.CreateAndSign(false, &tokens.ConnectionClaims{
+		T:   identity(client),
+		LCL: clientConn.Auth.LocalContext,
+	}), nil)
+	if claims == nil || claims.T == nil {
+		result.Reason = "The server cannot decode the Syn token"
+		return result, nil
+	}
+	serverConn.Auth.RemoteContext = claims.LCL
+
+	claims.T.Add(enforcer.PortNumberLabelString, strconv.Itoa(port))
+
+	result.Receiver = lookup.Explain(claims.T, server.Policy.ReceiverRules())
+	if result.Receiver.Action&policy.Accept == 0 {
+		result.Reason = "The receiver rules of the server reject the client"
+		return result, nil
+	}
+
+	// SynAck
+	claims, _ = clientTokens.Decode(false, serverTokens.CreateAndSign(false, &tokens.ConnectionClaims{
+		T:   identity(server),
+		LCL: serverConn.Auth.LocalContext,
+		RMT: serverConn.Auth.RemoteContext,
+	}), nil)
+	if claims == nil || claims.T == nil {
+		result.Reason = "The client cannot decode the SynAck token"
+		return result, nil
+	}
+	clientConn.Auth.RemoteContext = claims.LCL
+
+	result.Transmitter = lookup.Explain(claims.T, client.Policy.TransmitterRules())
+	if mutualAuthorization && result.Transmitter.Action&policy.Accept == 0 {
+		result.Reason = "The transmitter rules of the client reject the server"
+		return result, nil
+	}
+
+	// Ack
+	claims, _ = serverTokens.Decode(true, clientTokens.CreateAndSign(true, &tokens.ConnectionClaims{
+		LCL: clientConn.Auth.LocalContext,
+		RMT: clientConn.Auth.RemoteContext,
+	}), cert)
+	if claims == nil || !bytes.Equal(claims.RMT, serverConn.Auth.LocalContext) || !bytes.Equal(claims.LCL, serverConn.Auth.RemoteContext) {
+		result.Reason = "The server cannot match the Ack token with the connection"
+		return result, nil
+	}
+
+	result.Accepted = true
+
+	return result, nil
+}
+
+// identity returns the identity that a PU sends in its tokens. The transmitter
+// label is added like trireme does when the PU is created.
+func identity(puInfo *policy.PUInfo) *policy.TagsMap {
+
+	tags := puInfo.Policy.Identity()
+
+	if puInfo.Policy.ManagementID == "" {
+		tags.Add(enforcer.TransmitterLabel, puInfo.ContextID)
+	} else {
+		tags.Add(enforcer.TransmitterLabel, puInfo.Policy.ManagementID)
+	}
+
+	return tags
+}
+
+// Report writes the decision of the rules of each direction and the verdict of
+// the connection
+func Report(w io.Writer, client, server *policy.PUInfo, result *Result) error {
+
+	lines := []string{}
+
+	if result.Receiver != nil {
+		lines = append(lines, "receiver rules of "+server.ContextID+": "+result.Receiver.String())
+		lines = append(lines, explanationLines(result.Receiver)...)
+	}
+
+	if result.Transmitter != nil {
+		lines = append(lines, "transmitter rules of "+client.ContextID+": "+result.Transmitter.String())
+		lines = append(lines, explanationLines(result.Transmitter)...)
+	}
+
+	if result.Accepted {
+		lines = append(lines, "verdict: accept")
+	} else {
+		lines = append(lines, "verdict: reject: "+result.Reason)
+	}
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+
+	return err
+}
+
+// explanationLines returns the lines that explain every selector and clause
+func explanationLines(e *policy.PolicyExplanation) []string {
+
+	lines := []string{}
+
+	for _, s := range e.Selectors {
+		status := "not matched"
+		if s.Matched {
+			status = "matched"
+		}
+
+		lines = append(lines, fmt.Sprintf("  selector %s (%s): %s", s.ID, actionString(s.Action), status))
+
+		for _, c := range s.Clauses {
+			lines = append(lines, "    "+c.Reason)
+		}
+	}
+
+	return lines
+}
+
+// actionString returns the name of the action of a selector
+func actionString(action policy.FlowAction) string {
+
+	if action&policy.Accept != 0 {
+		return "accept"
+	}
+
+	return "reject"
+}
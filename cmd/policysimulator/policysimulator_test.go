@@ -0,0 +1,176 @@
+package policysimulator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/cmd/rulerenderer"
+	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const clientJSON = `{
+	"ContextID": "client",
+	"Policy": {
+		"ManagementID": "frontend",
+		"Identity": {"Tags": {"app": "frontend"}},
+		"TransmitterRules": {"TagSelectors": [
+			{"Clause": [{"Key": "app", "Value": ["backend"], "Operator": "="}], "Action": 1, "ID": "to-backend"}
+		]}
+	},
+	"Runtime": {"Name": "client"}
+}`
+
+const serverJSON = `{
+	"ContextID": "server",
+	"Policy": {
+		"Identity": {"Tags": {"app": "backend"}},
+		"ReceiverRules": {"TagSelectors": [
+			{"Clause": [{"Key": "app", "Value": ["frontend"], "Operator": "="}, {"Key": "$sys:port", "Value": ["443"], "Operator": "="}], "Action": 1, "ID": "from-frontend"},
+			{"Clause": [{"Key": "AporetoContextID", "Value": ["blocked"], "Operator": "="}], "Action": 2, "ID": "blocked"}
+		]}
+	},
+	"Runtime": {"Name": "server"}
+}`
+
+func loadPUs() (*policy.PUInfo, *policy.PUInfo) {
+
+	client, err := rulerenderer.LoadPUInfo([]byte(clientJSON))
+	So(err, ShouldBeNil)
+
+	server, err := rulerenderer.LoadPUInfo([]byte(serverJSON))
+	So(err, ShouldBeNil)
+
+	return client, server
+}
+
+func TestSimulate(t *testing.T) {
+
+	Convey("Given a client and a server PU with pre-shared key secrets", t, func() {
+		client, server := loadPUs()
+		secrets := tokens.NewPSKSecrets([]byte("simulation"))
+
+		Convey("When the client connects to a port accepted by the server", func() {
+			result, err := Simulate(client, server, 443, secrets, true)
+
+			Convey("Then the connection should be accepted in both directions", func() {
+				So(err, ShouldBeNil)
+				So(result.Accepted, ShouldBeTrue)
+				So(result.Reason, ShouldBeEmpty)
+				So(result.Receiver.String(), ShouldEqual, "accept: selector from-frontend matched")
+				So(result.Transmitter.String(), ShouldEqual, "accept: selector to-backend matched")
+			})
+
+			Convey("Then the output should explain the decisions", func() {
+				var buf bytes.Buffer
+				So(Report(&buf, client, server, result), ShouldBeNil)
+				So(buf.String(), ShouldContainSubstring, "receiver rules of server: accept: selector from-frontend matched\n")
+				So(buf.String(), ShouldContainSubstring, "  selector from-frontend (accept): matched\n")
+				So(buf.String(), ShouldContainSubstring, "    $sys:port=443 matches 443\n")
+				So(buf.String(), ShouldEndWith, "verdict: accept\n")
+			})
+		})
+
+		Convey("When the client connects to another port", func() {
+			result, err := Simulate(client, server, 80, secrets, true)
+
+			Convey("Then the receiver rules should reject the connection", func() {
+				So(err, ShouldBeNil)
+				So(result.Accepted, ShouldBeFalse)
+				So(result.Reason, ShouldEqual, "The receiver rules of the server reject the client")
+				So(result.Receiver.Matched, ShouldBeNil)
+				So(result.Transmitter, ShouldBeNil)
+			})
+		})
+
+		Convey("When the client is rejected by its transmitter label", func() {
+			client.Policy.ManagementID = "blocked"
+			result, err := Simulate(client, server, 443, secrets, true)
+
+			Convey("Then the reject selector should decide", func() {
+				So(err, ShouldBeNil)
+				So(result.Accepted, ShouldBeFalse)
+				So(result.Receiver.Matched.ID, ShouldEqual, "blocked")
+			})
+		})
+
+		Convey("When the transmitter rules of the client do not accept the server", func() {
+			server.Policy.AddIdentityTag("app", "database")
+
+			Convey("Then the connection should be rejected with mutual authorization", func() {
+				result, err := Simulate(client, server, 443, secrets, true)
+				So(err, ShouldBeNil)
+				So(result.Accepted, ShouldBeFalse)
+				So(result.Reason, ShouldEqual, "The transmitter rules of the client reject the server")
+				So(result.Transmitter.Matched, ShouldBeNil)
+			})
+
+			Convey("Then the connection should be accepted without mutual authorization", func() {
+				result, err := Simulate(client, server, 443, secrets, false)
+				So(err, ShouldBeNil)
+				So(result.Accepted, ShouldBeTrue)
+				So(result.Transmitter.Action, ShouldEqual, policy.Reject)
+			})
+		})
+
+		Convey("When the secrets are missing", func() {
+			_, err := Simulate(client, server, 443, nil, true)
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestSimulateConnection(t *testing.T) {
+
+	Convey("Given the PU information files of a client and a server", t, func() {
+		dir, err := ioutil.TempDir("", "policysimulator")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		clientFile := filepath.Join(dir, "client.json")
+		serverFile := filepath.Join(dir, "server.json")
+		So(ioutil.WriteFile(clientFile, []byte(clientJSON), 0600), ShouldBeNil)
+		So(ioutil.WriteFile(serverFile, []byte(serverJSON), 0600), ShouldBeNil)
+
+		arguments := map[string]interface{}{
+			"<client>": clientFile,
+			"<server>": serverFile,
+			"--psk":    "simulation",
+			"--mutual": true,
+		}
+
+		Convey("When I simulate an accepted connection, I should get no error", func() {
+			arguments["--port"] = "443"
+			So(SimulateConnection(arguments), ShouldBeNil)
+		})
+
+		Convey("When I simulate a rejected connection, I should get an error", func() {
+			arguments["--port"] = "80"
+			So(SimulateConnection(arguments), ShouldNotBeNil)
+		})
+
+		Convey("When I simulate a connection without a port, I should get an error", func() {
+			So(SimulateConnection(arguments), ShouldNotBeNil)
+		})
+
+		Convey("When I simulate a connection with an invalid port, I should get an error", func() {
+			arguments["--port"] = "70000"
+			So(SimulateConnection(arguments), ShouldNotBeNil)
+		})
+	})
+
+	Convey("When I load secrets without any key", t, func() {
+		_, err := loadSecrets(map[string]interface{}{})
+
+		Convey("I should get an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -216,10 +216,15 @@ func (s *Server) InitSupervisor(req rpcwrapper.Request, resp *rpcwrapper.Respons
 		return fmt.Errorf("IPSets not supported yet")
 	default:
 
+		implementation := constants.IPTables
+		if payload.CaptureMethod == rpcwrapper.NFTables {
+			implementation = constants.NFTables
+		}
+
 		supervisorHandle, err := supervisor.NewSupervisor(s.statsclient.collector,
 			s.Enforcer,
 			constants.RemoteContainer,
-			constants.IPTables,
+			implementation,
 		)
 		if err != nil {
 			zap.L().Error("Failed to instantiate the iptables supervisor", zap.Error(err))
@@ -313,6 +318,117 @@ func (s *Server) Unsupervise(req rpcwrapper.Request, resp *rpcwrapper.Response)
 	return s.Supervisor.Unsupervise(payload.ContextID)
 }
 
+//SupervisorState This method returns the state of the PU programmed by the supervisor created during initsupervisor
+func (s *Server) SupervisorState(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpchdl.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = ("SupervisorState Message Auth Failed")
+		return errors.New(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	introspector, ok := s.Supervisor.(supervisor.Introspector)
+	if !ok {
+		resp.Status = ("Supervisor does not report its state")
+		return errors.New(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.SupervisorStateRequestPayload)
+	state, err := introspector.PUState(payload.ContextID)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = rpcwrapper.SupervisorStateResponsePayload{
+		State: state,
+	}
+
+	return nil
+}
+
+//Connections This method returns the connections of the PU tracked by the enforcer created during initenforcer
+func (s *Server) Connections(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpchdl.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = ("Connections Message Auth Failed")
+		return errors.New(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.Enforcer == nil {
+		resp.Status = ("Enforcer is not initialized")
+		return errors.New(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.ConnectionsRequestPayload)
+	connections, err := s.Enforcer.Connections(payload.ContextID)
+	if err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	resp.Payload = rpcwrapper.ConnectionsResponsePayload{
+		Connections: connections,
+	}
+
+	return nil
+}
+
+//AddExcludedIP This method excludes the IPs on the supervisor created during initsupervisor
+func (s *Server) AddExcludedIP(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpchdl.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = ("AddExcludedIP Message Auth Failed")
+		return errors.New(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.Supervisor == nil {
+		resp.Status = ("Supervisor is not initialized")
+		return errors.New(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.ExcludeIPRequestPayload)
+	if err := s.Supervisor.AddExcludedIPs(payload.IPs); err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	return nil
+}
+
+//RemoveExcludedIP This method removes the exclusion of the IPs on the supervisor created during initsupervisor
+func (s *Server) RemoveExcludedIP(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
+
+	if !s.rpchdl.CheckValidity(&req, s.rpcSecret) {
+		resp.Status = ("RemoveExcludedIP Message Auth Failed")
+		return errors.New(resp.Status)
+	}
+
+	cmdLock.Lock()
+	defer cmdLock.Unlock()
+
+	if s.Supervisor == nil {
+		resp.Status = ("Supervisor is not initialized")
+		return errors.New(resp.Status)
+	}
+
+	payload := req.Payload.(rpcwrapper.ExcludeIPRequestPayload)
+	if err := s.Supervisor.RemoveExcludedIPs(payload.IPs); err != nil {
+		resp.Status = err.Error()
+		return err
+	}
+
+	return nil
+}
+
 //Enforce this method calls the enforce method on the enforcer created during initenforcer
 func (s *Server) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Response) error {
 
@@ -350,6 +466,10 @@ func (s *Server) Enforce(req rpcwrapper.Request, resp *rpcwrapper.Response) erro
 		return err
 	}
 
+	if s.statsclient != nil {
+		s.statsclient.setContextID(payload.ContextID)
+	}
+
 	zap.L().Debug("Enforcer enabled", zap.String("contextID", payload.ContextID))
 
 	resp.Status = ""
@@ -4,16 +4,19 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/enforcer/utils/rpcwrapper"
+	"github.com/aporeto-inc/trireme/metrics"
 )
 
 const (
 	defaultStatsIntervalMiliseconds = 250
+	defaultMetricsIntervalSeconds   = 5
 	envStatsChannelPath             = "STATSCHANNEL_PATH"
 	envStatsSecret                  = "STATS_SECRET"
 	statsContextID                  = "UNUSED"
@@ -29,6 +32,9 @@ type StatsClient struct {
 	statsChannel  string
 	statsInterval time.Duration
 	stop          chan bool
+	// contextID is the PU of the remote enforcer. The metrics are sent once it is known.
+	contextID string
+	sync.Mutex
 }
 
 // NewStatsClient initializes a new stats client
@@ -64,9 +70,22 @@ func NewStatsClient() (*StatsClient, error) {
 func (s *StatsClient) SendStats() {
 
 	ticker := time.NewTicker(s.statsInterval)
+	metricsTicker := time.NewTicker(defaultMetricsIntervalSeconds * time.Second)
 	// nolint : gosimple
 	for {
 		select {
+		case <-metricsTicker.C:
+
+			contextID := s.getContextID()
+			if contextID == "" {
+				continue
+			}
+
+			s.sendPayload(&rpcwrapper.StatsPayload{
+				ContextID: contextID,
+				Metrics:   metrics.DefaultRegistry.Gather(),
+			})
+
 		case <-ticker.C:
 
 			s.collector.Lock()
@@ -78,32 +97,56 @@ func (s *StatsClient) SendStats() {
 				continue
 			}
 
-			rpcPayload := &rpcwrapper.StatsPayload{
+			s.sendPayload(&rpcwrapper.StatsPayload{
 				Flows: collected,
-			}
-
-			request := rpcwrapper.Request{
-				Payload: rpcPayload,
-			}
-
-			err := s.rpchdl.RemoteCall(
-				statsContextID,
-				statsRPCCommand,
-				&request,
-				&rpcwrapper.Response{},
-			)
-
-			if err != nil {
-				zap.L().Error("RPC failure in sending statistics: Unable to send flows")
-			}
+			})
 
 		case <-s.stop:
+			ticker.Stop()
+			metricsTicker.Stop()
 			return
 		}
 	}
 
 }
 
+// sendPayload sends flows or metrics to the controller
+func (s *StatsClient) sendPayload(rpcPayload *rpcwrapper.StatsPayload) {
+
+	request := rpcwrapper.Request{
+		Payload: rpcPayload,
+	}
+
+	err := s.rpchdl.RemoteCall(
+		statsContextID,
+		statsRPCCommand,
+		&request,
+		&rpcwrapper.Response{},
+	)
+
+	if err != nil {
+		zap.L().Error("RPC failure in sending statistics: Unable to send flows or metrics")
+	}
+}
+
+// setContextID sets the PU of the remote enforcer that identifies its metrics
+func (s *StatsClient) setContextID(contextID string) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.contextID = contextID
+}
+
+// getContextID returns the PU of the remote enforcer
+func (s *StatsClient) getContextID() string {
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.contextID
+}
+
 // connectStatsCLient  This is an private function called by the remoteenforcer to connect back
 // to the controller over a stats channel
 func (s *StatsClient) connectStatsClient() error {
@@ -0,0 +1,158 @@
+// Package rulerenderer renders the rules that a supervisor implementation
+// programs for a processing unit without changing the host. The commands are
+// printed in the order they are issued, for example to review a change of the
+// rules or to attach them to a support ticket.
+package rulerenderer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/ipsetctrl"
+	"github.com/aporeto-inc/trireme/supervisor/iptablesctrl"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+)
+
+// The queues and the mark are the defaults of the enforcer
+var (
+	networkQueues     = strconv.Itoa(enforcer.DefaultNetworkQueue) + ":" + strconv.Itoa(enforcer.DefaultNetworkQueue+enforcer.DefaultNumberOfQueues-1)
+	applicationQueues = strconv.Itoa(enforcer.DefaultApplicationQueue) + ":" + strconv.Itoa(enforcer.DefaultApplicationQueue+enforcer.DefaultNumberOfQueues-1)
+)
+
+// RenderRules prints the commands that configure the rules of a PU. The
+// arguments are:
+//
+//	<puinfo>           the JSON file of the PU information, - for the standard input
+//	--implementation   iptables (default) or ipsets
+//	--mode             container (default), server or remote
+//	--version          the version of the rules, 0 by default
+//	--start            also print the commands that set up the global rules
+func RenderRules(arguments map[string]interface{}) error {
+
+	var data []byte
+	var err error
+
+	file, _ := arguments["<puinfo>"].(string)
+	if file == "" || file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to read the PU information: %s", err)
+	}
+
+	puInfo, err := LoadPUInfo(data)
+	if err != nil {
+		return err
+	}
+
+	implementation := constants.IPTables
+	if arg, ok := arguments["--implementation"].(string); ok && arg != "" {
+		switch arg {
+		case "iptables":
+		case "ipsets":
+			implementation = constants.IPSets
+		default:
+			return fmt.Errorf("Invalid implementation %s", arg)
+		}
+	}
+
+	mode := constants.LocalContainer
+	if arg, ok := arguments["--mode"].(string); ok && arg != "" {
+		switch arg {
+		case "container":
+		case "server":
+			mode = constants.LocalServer
+		case "remote":
+			mode = constants.RemoteContainer
+		default:
+			return fmt.Errorf("Invalid mode %s", arg)
+		}
+	}
+
+	version := 0
+	if arg, ok := arguments["--version"].(string); ok && arg != "" {
+		if version, err = strconv.Atoi(arg); err != nil {
+			return fmt.Errorf("Invalid version %s", arg)
+		}
+	}
+
+	start, _ := arguments["--start"].(bool)
+
+	return Render(os.Stdout, implementation, mode, version, puInfo, start)
+}
+
+// LoadPUInfo returns the PU information of its JSON representation
+func LoadPUInfo(data []byte) (*policy.PUInfo, error) {
+
+	puInfo := policy.NewPUInfo("", constants.ContainerPU)
+	if err := json.Unmarshal(data, puInfo); err != nil {
+		return nil, fmt.Errorf("Invalid PU information: %s", err)
+	}
+
+	if puInfo.Policy == nil || puInfo.Runtime == nil {
+		return nil, fmt.Errorf("Invalid PU information: policy and runtime are required")
+	}
+
+	return puInfo, nil
+}
+
+// Render writes the commands that the implementation issues to configure the
+// rules of the PU, one per line. The commands that set up the global rules
+// are written first if start is true.
+func Render(w io.Writer, implementation constants.ImplementationType, mode constants.ModeType, version int, puInfo *policy.PUInfo, start bool) error {
+
+	var recorder *provider.Recorder
+
+	switch implementation {
+	case constants.IPTables:
+		var i *iptablesctrl.Instance
+		i, recorder = iptablesctrl.NewRenderer(networkQueues, applicationQueues, enforcer.DefaultMarkValue, mode)
+
+		if start {
+			if err := i.Start(); err != nil {
+				return fmt.Errorf("Unable to render the global rules: %s", err)
+			}
+		}
+
+		if err := i.ConfigureRules(version, puInfo.ContextID, puInfo); err != nil {
+			return fmt.Errorf("Unable to render the rules: %s", err)
+		}
+
+	case constants.IPSets:
+		var i *ipsetctrl.Instance
+		i, recorder = ipsetctrl.NewRenderer(networkQueues, applicationQueues, enforcer.DefaultMarkValue, false, mode)
+
+		// The rules of the PU need the global sets
+		if err := i.Start(); err != nil {
+			return fmt.Errorf("Unable to render the global rules: %s", err)
+		}
+
+		if !start {
+			recorder.Reset()
+		}
+
+		if err := i.ConfigureRules(version, puInfo.ContextID, puInfo); err != nil {
+			return fmt.Errorf("Unable to render the rules: %s", err)
+		}
+
+	default:
+		return fmt.Errorf("Rendering is not supported for this implementation")
+	}
+
+	for _, command := range recorder.Commands() {
+		if _, err := fmt.Fprintln(w, command); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
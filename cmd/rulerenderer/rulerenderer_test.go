@@ -0,0 +1,118 @@
+package rulerenderer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const puInfoJSON = `{
+	"ContextID": "pu1",
+	"Policy": {
+		"ManagementID": "policy1",
+		"TriremeAction": 2,
+		"ApplicationACLs": {"Rules": [{"Address": "192.30.253.0/24", "Port": "443", "Protocol": "TCP", "Action": 1, "ID": "rule1"}]},
+		"NetworkACLs": {"Rules": [{"Address": "10.1.0.0/16", "Port": "22", "Protocol": "TCP", "Action": 2}]},
+		"IPAddresses": {"IPs": {"bridge": "172.17.0.2"}},
+		"TriremeNetworks": ["172.17.0.0/16"]
+	},
+	"Runtime": {
+		"PUType": 0,
+		"Name": "pu1",
+		"IPAddresses": {"IPs": {"bridge": "172.17.0.2"}}
+	}
+}`
+
+func TestLoadPUInfo(t *testing.T) {
+
+	Convey("When I load a valid PU information", t, func() {
+		puInfo, err := LoadPUInfo([]byte(puInfoJSON))
+
+		Convey("I should get the policy and the runtime", func() {
+			So(err, ShouldBeNil)
+			So(puInfo.ContextID, ShouldEqual, "pu1")
+			So(puInfo.Policy.ManagementID, ShouldEqual, "policy1")
+			So(puInfo.Policy.ApplicationACLs().Rules, ShouldHaveLength, 1)
+			So(puInfo.Policy.ApplicationACLs().Rules[0].ID, ShouldEqual, "rule1")
+			So(puInfo.Policy.NetworkACLs().Rules, ShouldHaveLength, 1)
+			So(puInfo.Policy.TriremeNetworks(), ShouldResemble, []string{"172.17.0.0/16"})
+			So(puInfo.Policy.ExcludedNetworks(), ShouldResemble, []string{})
+			So(puInfo.Runtime.Name(), ShouldEqual, "pu1")
+			So(puInfo.Runtime.Options().Tags, ShouldBeEmpty)
+		})
+	})
+
+	Convey("When I load an invalid PU information", t, func() {
+		_, err := LoadPUInfo([]byte(`{"Policy": null}`))
+
+		Convey("I should get an error", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestRender(t *testing.T) {
+
+	Convey("Given a PU information", t, func() {
+		puInfo, err := LoadPUInfo([]byte(puInfoJSON))
+		So(err, ShouldBeNil)
+
+		Convey("When I render the iptables rules of a container", func() {
+			var buf bytes.Buffer
+			err := Render(&buf, constants.IPTables, constants.LocalContainer, 1, puInfo, false)
+			So(err, ShouldBeNil)
+			commands := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+			Convey("I should get the chains first and then their rules in order", func() {
+				So(commands[0], ShouldEqual, "iptables -t raw -N TRIREME-App-pu1-1")
+				So(commands, ShouldContain, "iptables -t mangle -N TRIREME-App-pu1-1")
+				So(commands, ShouldContain, "iptables -t mangle -N TRIREME-Net-pu1-1")
+				So(commands, ShouldContain, "iptables -t mangle -A TRIREME-App-pu1-1 -p TCP -m state --state NEW -d 192.30.253.0/24 --dport 443 -j ACCEPT")
+				So(buf.String(), ShouldNotContainSubstring, "ip6tables")
+			})
+
+			Convey("When I render the same rules again, I should get the same commands", func() {
+				var again bytes.Buffer
+				So(Render(&again, constants.IPTables, constants.LocalContainer, 1, puInfo, false), ShouldBeNil)
+				So(again.String(), ShouldEqual, buf.String())
+			})
+		})
+
+		Convey("When I render the ipset rules of a container", func() {
+			var buf bytes.Buffer
+			err := Render(&buf, constants.IPSets, constants.LocalContainer, 0, puInfo, false)
+			So(err, ShouldBeNil)
+			commands := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+			Convey("I should get the sets and rules of the PU only", func() {
+				So(commands[0], ShouldEqual, "ipset add ContainerSet 172.17.0.2")
+				So(commands, ShouldContain, "ipset create TRIREME-App-pu1-A-0 hash:net,port family inet")
+				So(commands, ShouldContain, "ipset add TRIREME-App-pu1-A-0 192.30.253.0/24,443")
+				So(commands, ShouldContain, "ipset add TRIREME-Net-pu1-R-0 10.1.0.0/16,22")
+				So(commands[len(commands)-1], ShouldEqual, "ipset add TriremeSet 172.17.0.0/16")
+			})
+		})
+
+		Convey("When I render the ipset rules with the global rules", func() {
+			var buf bytes.Buffer
+			err := Render(&buf, constants.IPSets, constants.LocalContainer, 0, puInfo, true)
+
+			Convey("I should get the global sets first", func() {
+				So(err, ShouldBeNil)
+				So(buf.String(), ShouldStartWith, "ipset create TriremeSet hash:net family inet\n")
+			})
+		})
+
+		Convey("When I render the rules of an unsupported implementation", func() {
+			var buf bytes.Buffer
+			err := Render(&buf, constants.NFTables, constants.LocalContainer, 0, puInfo, false)
+
+			Convey("I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -1,28 +1,18 @@
 package collector
 
-import "github.com/aporeto-inc/trireme/policy"
+import (
+	"time"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
 
 const (
 	// FlowReject indicates that a flow was rejected
 	FlowReject = "reject"
 	// FlowAccept logs that a flow is accepted
 	FlowAccept = "accept"
-	// MissingToken indicates that the token was missing
-	MissingToken = "missingtoken"
-	// InvalidToken indicates that the token was invalid
-	InvalidToken = "token"
-	// InvalidFormat indicates that the packet metadata were not correct
-	InvalidFormat = "format"
-	// InvalidContext indicates that there was no context in the metadata
-	InvalidContext = "context"
-	// InvalidConnection indicates that there was no connection found
-	InvalidConnection = "connection"
-	// InvalidState indicates that a packet was received without proper state information
-	InvalidState = "state"
-	// InvalidNonse indicates that the nonse check failed
-	InvalidNonse = "nonse"
-	// PolicyDrop indicates that the flow is rejected because of the policy decision
-	PolicyDrop = "policy"
+	// FlowAudit indicates that a flow was accepted although the policy of a PU in audit mode rejects it
+	FlowAudit = "audit"
 	// ContainerStart indicates a container start event
 	ContainerStart = "start"
 	// ContainerStop indicates a container stop event
@@ -33,6 +23,10 @@ const (
 	ContainerDelete = "delete"
 	// ContainerUpdate indicates a container policy update event
 	ContainerUpdate = "update"
+	// ContainerRepaired indicates that the rules of a container were repaired after they were altered
+	ContainerRepaired = "repair"
+	// ContainerRollback indicates that the rules of a container were rolled back after a step of their configuration failed
+	ContainerRollback = "rollback"
 	// ContainerFailed indicates an event that a container was stopped because of policy issues
 	ContainerFailed = "forcestop"
 	// ContainerIgnored indicates that the container will be ignored by Trireme
@@ -43,6 +37,46 @@ const (
 	PolicyValid = "V"
 )
 
+// DropReason is the reason why the enforcer dropped a packet or rejected a flow
+type DropReason string
+
+const (
+	// NoDrop is the reason of the flows that are accepted
+	NoDrop DropReason = ""
+	// MalformedPacket indicates that the packet could not be parsed
+	MalformedPacket DropReason = "malformed"
+	// InvalidProtocol indicates that the IP protocol of the packet is not handled by the enforcer
+	InvalidProtocol DropReason = "protocol"
+	// MissingToken indicates that the token was missing
+	MissingToken DropReason = "missingtoken"
+	// InvalidToken indicates that the token was invalid
+	InvalidToken DropReason = "token"
+	// ExpiredToken indicates that the token was valid but its validity period has expired
+	ExpiredToken DropReason = "expiredtoken"
+	// InvalidFormat indicates that the packet metadata were not correct
+	InvalidFormat DropReason = "format"
+	// InvalidContext indicates that there was no context in the metadata
+	InvalidContext DropReason = "context"
+	// InvalidConnection indicates that there was no connection found
+	InvalidConnection DropReason = "connection"
+	// InvalidState indicates that a packet was received without proper state information
+	InvalidState DropReason = "state"
+	// InvalidNonse indicates that the nonse check failed
+	InvalidNonse DropReason = "nonse"
+	// PolicyDrop indicates that the flow is rejected because of the policy decision
+	PolicyDrop DropReason = "policy"
+	// InvalidEncryption indicates that the flow is rejected because the encryption required by the policy could not be negotiated
+	InvalidEncryption DropReason = "encryption"
+	// ServicePreDrop indicates that the packet was dropped by the packet processor before the authorization
+	ServicePreDrop DropReason = "servicepre"
+	// ServicePostDrop indicates that the packet was dropped by the packet processor after the authorization
+	ServicePostDrop DropReason = "servicepost"
+	// PacketTooLarge indicates that the token could not be attached to the packet without exceeding the MTU
+	PacketTooLarge DropReason = "toolarge"
+	// Throttled indicates that the handshake exceeded the rates allowed by the enforcer
+	Throttled DropReason = "throttled"
+)
+
 // EventCollector is the interface for collecting events.
 type EventCollector interface {
 
@@ -64,7 +98,20 @@ type FlowRecord struct {
 	DestinationPort uint16
 	Tags            *policy.TagsMap
 	Action          string
-	Mode            string
+	// Mode is the reason of the rejected flows as a string. It is kept for the
+	// consumers that do not use DropReason.
+	Mode       string
+	DropReason DropReason
+
+	// Explanation explains the decision of the policy for the flows rejected by the policy
+	Explanation *policy.PolicyExplanation
+
+	// The following fields are provided for every match of a rule with the Log action
+	RuleID          string
+	ManagementID    string
+	SourceTags      *policy.TagsMap
+	DestinationTags *policy.TagsMap
+	Timestamp       time.Time
 }
 
 // ContainerRecord is a statistics record for a container
@@ -73,4 +120,7 @@ type ContainerRecord struct {
 	IPAddress string
 	Tags      *policy.TagsMap
 	Event     string
+
+	// Reason is the step that failed for the rollback events
+	Reason string
 }
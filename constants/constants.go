@@ -30,6 +30,8 @@ const (
 	IPSets ImplementationType = iota
 	// IPTables mandates an IPTable supervisor implementation
 	IPTables
+	// NFTables mandates an nftables supervisor implementation
+	NFTables
 	// Remote indicates that this is a remote supervisor
 )
 
@@ -2,6 +2,8 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/hmac"
@@ -87,6 +89,107 @@ func CreateEphemeralKey(curve func() elliptic.Curve, pub *ecdsa.PublicKey) (*ecd
 
 }
 
+// ComputeSharedKey computes the ECDH shared secret between the local ephemeral
+// private key and the marshalled public key of the peer. The returned key is the
+// SHA256 of the x coordinate of the shared point.
+func ComputeSharedKey(private *ecdsa.PrivateKey, peerPublic []byte) ([]byte, error) {
+
+	if private == nil {
+		return nil, fmt.Errorf("No private key provided")
+	}
+
+	x, y := elliptic.Unmarshal(private.Curve, peerPublic)
+	if x == nil {
+		return nil, fmt.Errorf("Invalid peer public key")
+	}
+
+	sx, _ := private.Curve.ScalarMult(x, y, private.D.Bytes())
+
+	secret := sha256.Sum256(sx.Bytes())
+
+	return secret[:], nil
+}
+
+// XORKeyStream encrypts or decrypts data in place using AES in counter mode.
+// The offset is the position of the first byte of data in the key stream. This
+// allows any segment of a stream to be processed independently of the others.
+func XORKeyStream(key []byte, offset uint64, data []byte) error {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[aes.BlockSize-8:], offset/aes.BlockSize)
+
+	stream := cipher.NewCTR(block, iv)
+
+	// Discard the part of the first block that precedes the offset
+	skip := make([]byte, offset%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+
+	stream.XORKeyStream(data, data)
+
+	return nil
+}
+
+// SegmentTagSize is the size of the tag that authenticates a segment of a stream
+const SegmentTagSize = 12
+
+// DeriveSegmentKeys derives the key that encrypts and the key that authenticates
+// the segments of a stream from a session key
+func DeriveSegmentKeys(sessionKey []byte) (encryptionKey []byte, authenticationKey []byte, err error) {
+
+	if encryptionKey, err = ComputeHmac256([]byte("segment encryption"), sessionKey); err != nil {
+		return nil, nil, err
+	}
+
+	if authenticationKey, err = ComputeHmac256([]byte("segment authentication"), sessionKey); err != nil {
+		return nil, nil, err
+	}
+
+	return encryptionKey, authenticationKey, nil
+}
+
+// SealSegment encrypts in place a segment of a stream at its position in the
+// stream and returns the tag that authenticates the position and the cipher
+// text. The position is the nonce of the segment and must never be reused
+// with different data for the same keys.
+func SealSegment(encryptionKey, authenticationKey []byte, position uint64, data []byte) ([]byte, error) {
+
+	if err := XORKeyStream(encryptionKey, position, data); err != nil {
+		return nil, err
+	}
+
+	return segmentTag(authenticationKey, position, data), nil
+}
+
+// OpenSegment verifies the tag of a segment of a stream at its position in the
+// stream and decrypts the segment in place. The segment is not modified if
+// the tag is invalid.
+func OpenSegment(encryptionKey, authenticationKey []byte, position uint64, data []byte, tag []byte) error {
+
+	if !hmac.Equal(segmentTag(authenticationKey, position, data), tag) {
+		return fmt.Errorf("Invalid segment tag")
+	}
+
+	return XORKeyStream(encryptionKey, position, data)
+}
+
+// segmentTag computes the truncated HMAC256 of the position and the data of a segment
+func segmentTag(authenticationKey []byte, position uint64, data []byte) []byte {
+
+	h := hmac.New(sha256.New, authenticationKey)
+
+	var buffer [8]byte
+	binary.BigEndian.PutUint64(buffer[:], position)
+	h.Write(buffer[:]) // nolint
+	h.Write(data)      // nolint
+
+	return h.Sum(nil)[:SegmentTagSize]
+}
+
 // LoadRootCertificates loads the certificates in the provide PEM buffer in a CertPool
 func LoadRootCertificates(rootPEM []byte) *x509.CertPool {
 
@@ -1,6 +1,9 @@
 package crypto
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -276,3 +279,107 @@ IG7Nv+YlTVp5qA==
 		})
 	})
 }
+
+// TestComputeSharedKey tests the ECDH key agreement between two ephemeral keys
+func TestComputeSharedKey(t *testing.T) {
+	Convey("Given two ephemeral keys on the same curve", t, func() {
+		curve := &ecdsa.PublicKey{Curve: elliptic.P256()}
+		key1, pub1 := CreateEphemeralKey(elliptic.P256, curve)
+		key2, pub2 := CreateEphemeralKey(elliptic.P256, curve)
+		So(key1, ShouldNotBeNil)
+		So(key2, ShouldNotBeNil)
+
+		Convey("Both sides should compute the same shared key", func() {
+			shared1, err1 := ComputeSharedKey(key1, pub2)
+			shared2, err2 := ComputeSharedKey(key2, pub1)
+			So(err1, ShouldBeNil)
+			So(err2, ShouldBeNil)
+			So(len(shared1), ShouldEqual, 32)
+			So(shared1, ShouldResemble, shared2)
+		})
+
+		Convey("An invalid public key should be rejected", func() {
+			_, err := ComputeSharedKey(key1, []byte("invalid"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A missing private key should be rejected", func() {
+			_, err := ComputeSharedKey(nil, pub1)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// TestXORKeyStream tests the encryption and decryption of stream segments
+func TestXORKeyStream(t *testing.T) {
+	Convey("Given a key and a message", t, func() {
+		key := make([]byte, 32)
+		for i := uint8(0); i < 32; i++ {
+			key[i] = i
+		}
+
+		message := []byte("this is a message that spans more than one aes block")
+		encrypted := make([]byte, len(message))
+		copy(encrypted, message)
+
+		Convey("When I encrypt the message as a single segment", func() {
+			err := XORKeyStream(key, 1000, encrypted)
+			So(err, ShouldBeNil)
+			So(bytes.Equal(encrypted, message), ShouldBeFalse)
+
+			Convey("I should be able to decrypt it in segments with arbitrary offsets", func() {
+				So(XORKeyStream(key, 1000, encrypted[:7]), ShouldBeNil)
+				So(XORKeyStream(key, 1007, encrypted[7:21]), ShouldBeNil)
+				So(XORKeyStream(key, 1021, encrypted[21:]), ShouldBeNil)
+				So(encrypted, ShouldResemble, message)
+			})
+		})
+
+		Convey("When I provide an invalid key, I should get an error", func() {
+			So(XORKeyStream([]byte("short"), 0, encrypted), ShouldNotBeNil)
+		})
+	})
+}
+
+// TestSealOpenSegment tests the authenticated encryption of stream segments
+func TestSealOpenSegment(t *testing.T) {
+	Convey("Given the keys derived from a session key and a message", t, func() {
+		encryptionKey, authenticationKey, err := DeriveSegmentKeys([]byte("session key"))
+		So(err, ShouldBeNil)
+		So(encryptionKey, ShouldNotResemble, authenticationKey)
+
+		message := []byte("this is a message that spans more than one aes block")
+		sealed := make([]byte, len(message))
+		copy(sealed, message)
+
+		Convey("When I seal the message at a position beyond 4GB", func() {
+			position := uint64(1)<<32 + 1000
+			tag, err := SealSegment(encryptionKey, authenticationKey, position, sealed)
+			So(err, ShouldBeNil)
+			So(len(tag), ShouldEqual, SegmentTagSize)
+			So(bytes.Equal(sealed, message), ShouldBeFalse)
+
+			Convey("I should be able to open it at the same position", func() {
+				So(OpenSegment(encryptionKey, authenticationKey, position, sealed, tag), ShouldBeNil)
+				So(sealed, ShouldResemble, message)
+			})
+
+			Convey("I should not be able to open it at the same sequence number of another wrap", func() {
+				So(OpenSegment(encryptionKey, authenticationKey, 1000, sealed, tag), ShouldNotBeNil)
+			})
+
+			Convey("I should not be able to open it if it was modified", func() {
+				sealed[3] ^= 1
+				So(OpenSegment(encryptionKey, authenticationKey, position, sealed, tag), ShouldNotBeNil)
+			})
+
+			Convey("The cipher text should differ from the one at the same sequence number of another wrap", func() {
+				other := make([]byte, len(message))
+				copy(other, message)
+				_, err := SealSegment(encryptionKey, authenticationKey, 1000, other)
+				So(err, ShouldBeNil)
+				So(other, ShouldNotResemble, sealed)
+			})
+		})
+	})
+}
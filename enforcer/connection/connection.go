@@ -1,8 +1,11 @@
 package connection
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"fmt"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -39,6 +42,27 @@ const (
 	TCPAckProcessed
 )
 
+// String returns the name of the state
+func (s TCPFlowState) String() string {
+
+	switch s {
+	case TCPSynSend:
+		return "TCPSynSend"
+	case TCPSynReceived:
+		return "TCPSynReceived"
+	case TCPSynAckSend:
+		return "TCPSynAckSend"
+	case TCPSynAckReceived:
+		return "TCPSynAckReceived"
+	case TCPAckSend:
+		return "TCPAckSend"
+	case TCPAckProcessed:
+		return "TCPAckProcessed"
+	}
+
+	return fmt.Sprintf("TCPFlowState(%d)", int(s))
+}
+
 // AuthInfo keeps authentication information about a connection
 type AuthInfo struct {
 	LocalContext    []byte
@@ -47,6 +71,55 @@ type AuthInfo struct {
 	RemotePublicKey interface{}
 	RemoteIP        string
 	RemotePort      string
+
+	// Ephemeral keys exchanged in the Syn and SynAck tokens. They are used
+	// to derive the session keys of encrypted connections.
+	EphemeralKey       *ecdsa.PrivateKey
+	EphemeralPublicKey []byte
+	RemoteEphemeralKey []byte
+}
+
+// GenerateEphemeralKey creates the ephemeral key pair that is offered to the remote side
+func (a *AuthInfo) GenerateEphemeralKey() error {
+
+	a.EphemeralKey, a.EphemeralPublicKey = crypto.CreateEphemeralKey(elliptic.P256, &ecdsa.PublicKey{Curve: elliptic.P256()})
+	if a.EphemeralKey == nil {
+		return fmt.Errorf("Unable to create ephemeral key")
+	}
+
+	return nil
+}
+
+// SessionKeys derives the keys that protect the two directions of a connection from
+// the ECDH exchange. The nonces of both sides are mixed in the derivation. It returns
+// the key for the data that we transmit and the key for the data that we receive.
+func (a *AuthInfo) SessionKeys(initiator bool) ([]byte, []byte, error) {
+
+	secret, err := crypto.ComputeSharedKey(a.EphemeralKey, a.RemoteEphemeralKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonces := append(append([]byte{}, a.LocalContext...), a.RemoteContext...)
+	if !initiator {
+		nonces = append(append([]byte{}, a.RemoteContext...), a.LocalContext...)
+	}
+
+	forward, err := crypto.ComputeHmac256(append([]byte("initiator"), nonces...), secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reverse, err := crypto.ComputeHmac256(append([]byte("responder"), nonces...), secret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if initiator {
+		return forward, reverse, nil
+	}
+
+	return reverse, forward, nil
 }
 
 // TCPConnection is information regarding TCP Connection
@@ -54,9 +127,22 @@ type TCPConnection struct {
 	state TCPFlowState
 	Auth  AuthInfo
 
+	// Encrypt is set when the payload of the connection is encrypted
+	Encrypt bool
+
+	// ContextID is the context of the PU that owns the connection
+	ContextID string
+
+	// Flow of the packet that created the connection
+	SourceAddress      string
+	DestinationAddress string
+	SourcePort         uint16
+	DestinationPort    uint16
+
 	// Debugging Information
 	flowReported bool
 	logs         []string
+	created      time.Time
 
 	sync.Mutex
 }
@@ -69,6 +155,21 @@ func TCPConnectionExpirationNotifier(c cache.DataStore, id interface{}, item int
 	}
 }
 
+// HalfOpenConnection returns true for the TCP connections whose handshake has
+// not completed and for the UDP flows that have not been replied to. They are
+// evicted first when a tracker is full.
+func HalfOpenConnection(item interface{}) bool {
+
+	switch conn := item.(type) {
+	case *TCPConnection:
+		return conn.GetState() < TCPAckSend
+	case *UDPConnection:
+		return conn.GetState() < UDPReplySend
+	}
+
+	return false
+}
+
 // String returns a printable version of connection
 func (c *TCPConnection) String() string {
 
@@ -133,6 +234,19 @@ func (c *TCPConnection) SetPacketInfo(flowHash, tcpFlags string) {
 	c.logs = append(c.logs, pktLog)
 }
 
+// Logs returns a copy of the debug logs of the connection. They are only
+// collected when TraceLogging is set.
+func (c *TCPConnection) Logs() []string {
+
+	return append([]string{}, c.logs...)
+}
+
+// Age returns the time since the connection was created
+func (c *TCPConnection) Age() time.Duration {
+
+	return time.Since(c.created)
+}
+
 // Cleanup will provide information when a connection is removed by a timer.
 func (c *TCPConnection) Cleanup(expiration bool) {
 
@@ -159,6 +273,7 @@ func NewTCPConnection(trackFlowReporting bool) *TCPConnection {
 		state:        TCPSynSend,
 		flowReported: trackFlowReporting,
 		logs:         make([]string, 0),
+		created:      time.Now(),
 	}
 	initConnection(&c.Auth)
 	return c
@@ -170,3 +285,62 @@ func initConnection(s *AuthInfo) {
 	nonse, _ := crypto.GenerateRandomBytes(32)
 	s.LocalContext = nonse
 }
+
+// UDPFlowState identifies the constants of the state of a UDP flow
+type UDPFlowState int
+
+const (
+
+	// UDPTokenSend is the state where the packets of the flow carry a token, but no reply has been received
+	UDPTokenSend UDPFlowState = iota
+
+	// UDPTokenReceived indicates that a valid token has been received and the flow is authorized
+	UDPTokenReceived
+
+	// UDPReplySend indicates that a reply carrying a token has been send
+	UDPReplySend
+
+	// UDPEstablished is the state where both sides have been authorized and tokens are no longer attached
+	UDPEstablished
+)
+
+// UDPConnection is information regarding a UDP flow
+type UDPConnection struct {
+	state UDPFlowState
+	Auth  AuthInfo
+
+	// ContextID is the context of the PU that owns the flow
+	ContextID string
+
+	sync.Mutex
+}
+
+// String returns a printable version of connection
+func (c *UDPConnection) String() string {
+
+	return fmt.Sprintf("state:%d context:%s auth: %+v", c.state, c.ContextID, c.Auth)
+}
+
+// GetState is used to return the state
+func (c *UDPConnection) GetState() UDPFlowState {
+
+	return c.state
+}
+
+// SetState is used to setup the state for the UDP flow
+func (c *UDPConnection) SetState(state UDPFlowState) {
+
+	c.state = state
+}
+
+// NewUDPConnection returns a UDPConnection information struct for a flow
+// owned by the given context
+func NewUDPConnection(contextID string) *UDPConnection {
+
+	c := &UDPConnection{
+		state:     UDPTokenSend,
+		ContextID: contextID,
+	}
+	initConnection(&c.Auth)
+	return c
+}
@@ -11,4 +11,8 @@ const (
 	TransmitterLabel = "AporetoContextID"
 	// DefaultNetwork to be used
 	DefaultNetwork = "0.0.0.0/0"
+	// UDPAuthenticationMarker is the marker that ends the token trailer attached to UDP packets
+	UDPAuthenticationMarker = "TRMU"
+	// UDPAuthenticationTrailerLen is the length of the token length field and the marker that follow the token in UDP packets
+	UDPAuthenticationTrailerLen = 2 + len(UDPAuthenticationMarker)
 )
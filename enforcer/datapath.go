@@ -3,7 +3,9 @@ package enforcer
 // Go libraries
 import (
 	"fmt"
+	"net"
 	"os/exec"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +17,7 @@ import (
 	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/enforcer/connection"
 	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+	"github.com/aporeto-inc/trireme/metrics"
 	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
 	"github.com/aporeto-inc/trireme/policy"
 )
@@ -52,6 +55,9 @@ type Datapath struct {
 	puFromIP       cache.DataStore
 	puFromMark     cache.DataStore
 	puFromPort     cache.DataStore
+	// The ranges of ports of puFromPort, searched when no PU listens on a single port
+	portRanges     []portRange
+	portRangesLock sync.RWMutex
 	// Key=FlowHash Value=Connection. Created on syn packet from network with regular flow hash
 	networkConnectionTracker cache.DataStore
 	// Key=FlowHash Value=Connection. Created on syn packet from application with regular flow hash
@@ -61,11 +67,30 @@ type Datapath struct {
 	sourcePortCache           cache.DataStore
 	sourcePortConnectionCache cache.DataStore
 
+	// Key=FlowHash Value=UDPConnection. Created on the first packet of a UDP flow from the application
+	appUDPConnectionTracker cache.DataStore
+	// Key=FlowHash Value=UDPConnection. Created when the first packet of a UDP flow from the network is authorized
+	netUDPConnectionTracker cache.DataStore
+
+	// Key=FlowHash Value=cipherState. Created when an encrypted connection is established
+	// and used to encrypt the payload of the application packets. Closed when the
+	// connection is reset or expires, until a new connection uses the same flow.
+	appEncryptionTracker cache.DataStore
+	// Key=FlowHash Value=cipherState. Created when an encrypted connection is established
+	// and used to decrypt the payload of the network packets. Closed with the
+	// application state.
+	netEncryptionTracker cache.DataStore
+
+	// handshakes limits the rates of the Syn packets that are authenticated
+	handshakes *handshakeLimiter
+
 	// stats
 	net    InterfaceStats
 	app    InterfaceStats
 	netTCP PacketStats
 	appTCP PacketStats
+	netUDP PacketStats
+	appUDP PacketStats
 
 	// mode captures the mode of the enforcer
 	mode constants.ModeType
@@ -79,6 +104,9 @@ type Datapath struct {
 
 	mutualAuthorization bool
 
+	// removeMetricsHook stops the updates of the tracker sizes
+	removeMetricsHook func()
+
 	sync.Mutex
 }
 
@@ -116,6 +144,15 @@ func New(
 		zap.L().Fatal("Unable to create TokenEngine in enforcer", zap.Error(err))
 	}
 
+	// The connection trackers are bounded to resist floods and scans. The
+	// encryption trackers are not: their entries are only created by
+	// authorized connections and evicting the keys of a live connection
+	// would break it.
+	maxTracked := filterQueue.MaxTrackedConnections
+	if maxTracked <= 0 {
+		maxTracked = DefaultMaxTrackedConnections
+	}
+
 	d := &Datapath{
 		puFromIP:   cache.NewCache(),
 		puFromMark: cache.NewCache(),
@@ -123,10 +160,14 @@ func New(
 
 		contextTracker: cache.NewCache(),
 
-		networkConnectionTracker:  cache.NewCacheWithExpirationNotifier(time.Second*60, connection.TCPConnectionExpirationNotifier),
-		appConnectionTracker:      cache.NewCacheWithExpirationNotifier(time.Second*60, connection.TCPConnectionExpirationNotifier),
-		sourcePortCache:           cache.NewCacheWithExpiration(time.Second * 60),
-		sourcePortConnectionCache: cache.NewCacheWithExpiration(time.Second * 60),
+		networkConnectionTracker:  newTracker(trackerNetConnections, maxTracked, time.Second*60, connection.TCPConnectionExpirationNotifier, connection.HalfOpenConnection),
+		appConnectionTracker:      newTracker(trackerAppConnections, maxTracked, time.Second*60, connection.TCPConnectionExpirationNotifier, connection.HalfOpenConnection),
+		sourcePortCache:           newTracker(trackerSourcePorts, maxTracked, time.Second*60, nil, nil),
+		sourcePortConnectionCache: newTracker(trackerSourcePortConnections, maxTracked, time.Second*60, nil, connection.HalfOpenConnection),
+		appUDPConnectionTracker:   newTracker(trackerAppUDPConnections, maxTracked, time.Second*60, nil, connection.HalfOpenConnection),
+		netUDPConnectionTracker:   newTracker(trackerNetUDPConnections, maxTracked, time.Second*60, nil, connection.HalfOpenConnection),
+		appEncryptionTracker:      newTracker(trackerAppEncryption, 0, encryptedConnectionTimeout, closeExpiredEncryptionState, nil),
+		netEncryptionTracker:      newTracker(trackerNetEncryption, 0, encryptedConnectionTimeout, closeExpiredEncryptionState, nil),
 		filterQueue:               filterQueue,
 		mutualAuthorization:       mutualAuth,
 		service:                   service,
@@ -136,6 +177,8 @@ func New(
 		app:                       InterfaceStats{},
 		netTCP:                    PacketStats{},
 		appTCP:                    PacketStats{},
+		netUDP:                    PacketStats{},
+		appUDP:                    PacketStats{},
 		ackSize:                   secrets.AckSize(),
 		mode:                      mode,
 		procMountPoint:            procMountPoint,
@@ -145,6 +188,8 @@ func New(
 		zap.L().Fatal("Unable to create enforcer")
 	}
 
+	d.handshakes = newHandshakeLimiter(filterQueue, maxTracked)
+
 	return d
 }
 
@@ -171,6 +216,11 @@ func NewWithDefaults(
 		ApplicationQueueSize:      DefaultQueueSize,
 		NumberOfApplicationQueues: DefaultNumberOfQueues,
 		MarkValue:                 DefaultMarkValue,
+		MaxTrackedConnections:     DefaultMaxTrackedConnections,
+		SynRatePerSource:          DefaultSynRatePerSource,
+		SynRatePerPU:              DefaultSynRatePerPU,
+		OverloadMode:              OverloadDrop,
+		MTU:                       DefaultMTU,
 	}
 
 	validity := time.Hour * 8760
@@ -218,6 +268,15 @@ func (d *Datapath) Unenforce(contextID string) error {
 		)
 	}
 
+	if pu.IPv6 != "" {
+		if err := d.puFromIP.Remove(pu.IPv6); err != nil {
+			zap.L().Warn("Unable to remove cache entry during unenforcement",
+				zap.String("IPv6", pu.IPv6),
+				zap.Error(err),
+			)
+		}
+	}
+
 	if err := d.puFromIP.Remove(pu.Mark); err != nil {
 		zap.L().Warn("Unable to remove cache entry during unenforcement",
 			zap.String("Mark", pu.Mark),
@@ -226,13 +285,14 @@ func (d *Datapath) Unenforce(contextID string) error {
 	}
 
 	for _, port := range pu.Ports {
-		if err := d.puFromIP.Remove(port); err != nil {
+		if err := d.puFromPort.Remove(port); err != nil {
 			zap.L().Warn("Unable to remove cache entry during unenforcement",
 				zap.String("Port", port),
 				zap.Error(err),
 			)
 		}
 	}
+	d.removePortRanges(pu)
 
 	if err := d.contextTracker.Remove(contextID); err != nil {
 		zap.L().Warn("Unable to remove context from cache",
@@ -241,6 +301,10 @@ func (d *Datapath) Unenforce(contextID string) error {
 		)
 	}
 
+	d.handshakes.remove(contextID)
+
+	metrics.DefaultRegistry.RemoveLabelValue("pu", contextID)
+
 	return nil
 }
 
@@ -250,6 +314,72 @@ func (d *Datapath) GetFilterQueue() *FilterQueue {
 	return d.filterQueue
 }
 
+// Connections returns the connections of a PU tracked during their handshake,
+// the oldest first
+func (d *Datapath) Connections(contextID string) ([]*TrackedConnection, error) {
+
+	if _, err := d.contextTracker.Get(contextID); err != nil {
+		return nil, fmt.Errorf("ContextID %s not found in Enforcer", contextID)
+	}
+
+	trackers := []struct {
+		connections cache.DataStore
+		application bool
+	}{
+		{d.appConnectionTracker, true},
+		{d.sourcePortConnectionCache, true},
+		{d.networkConnectionTracker, false},
+	}
+
+	// The caches share the connections
+	seen := map[*connection.TCPConnection]bool{}
+	connections := []*TrackedConnection{}
+
+	for _, tracker := range trackers {
+		for _, key := range tracker.connections.KeyList() {
+			item, err := tracker.connections.Get(key)
+			if err != nil {
+				continue
+			}
+
+			conn, ok := item.(*connection.TCPConnection)
+			if !ok || seen[conn] {
+				continue
+			}
+			seen[conn] = true
+
+			conn.Lock()
+			if conn.ContextID == contextID {
+				connections = append(connections, &TrackedConnection{
+					ContextID:          conn.ContextID,
+					Application:        tracker.application,
+					Protocol:           "tcp",
+					SourceAddress:      conn.SourceAddress,
+					DestinationAddress: conn.DestinationAddress,
+					SourcePort:         conn.SourcePort,
+					DestinationPort:    conn.DestinationPort,
+					State:              conn.GetState(),
+					RemoteContextID:    conn.Auth.RemoteContextID,
+					Age:                conn.Age(),
+					Logs:               conn.Logs(),
+				})
+			}
+			conn.Unlock()
+		}
+	}
+
+	sort.Sort(byAge(connections))
+
+	return connections, nil
+}
+
+// byAge sorts the tracked connections from the oldest
+type byAge []*TrackedConnection
+
+func (a byAge) Len() int           { return len(a) }
+func (a byAge) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byAge) Less(i, j int) bool { return a[i].Age > a[j].Age }
+
 // Start starts the application and network interceptors
 func (d *Datapath) Start() error {
 
@@ -258,6 +388,8 @@ func (d *Datapath) Start() error {
 	d.startApplicationInterceptor()
 	d.startNetworkInterceptor()
 
+	d.removeMetricsHook = metrics.DefaultRegistry.OnGather(d.updateTrackerSizes)
+
 	return nil
 }
 
@@ -274,6 +406,10 @@ func (d *Datapath) Stop() error {
 		d.netStop[i] <- true
 	}
 
+	if d.removeMetricsHook != nil {
+		d.removeMetricsHook()
+	}
+
 	return nil
 }
 
@@ -289,11 +425,78 @@ func (d *Datapath) getProcessKeys(puInfo *policy.PUInfo) (string, []string) {
 		ports = "0"
 	}
 
-	portlist := strings.Split(ports, ",")
+	// Ports are normalized so that a range is always keyed as first:last
+	spec, err := policy.ParsePortSpec(ports)
+	if err != nil {
+		return mark, strings.Split(ports, ",")
+	}
+
+	portlist := make([]string, len(spec))
+	for i, r := range spec {
+		portlist[i] = r.String()
+	}
 
 	return mark, portlist
 }
 
+// portRange is a range of ports of a Linux process PU, parsed once when the
+// PU is created
+type portRange struct {
+	key   string
+	ports policy.PortSpec
+	pu    *PUContext
+}
+
+// addPortRanges indexes the ranges of ports of the PU. A range that was
+// already indexed is now owned by the PU, as it is in puFromPort.
+func (d *Datapath) addPortRanges(pu *PUContext) {
+
+	d.portRangesLock.Lock()
+	defer d.portRangesLock.Unlock()
+
+	for _, key := range pu.Ports {
+		if !strings.Contains(key, ":") {
+			continue
+		}
+
+		ports, err := policy.ParsePortSpec(key)
+		if err != nil {
+			zap.L().Warn("Invalid range of ports",
+				zap.String("contextID", pu.ID),
+				zap.String("Port", key),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		d.removePortRange(key)
+		d.portRanges = append(d.portRanges, portRange{key: key, ports: ports, pu: pu})
+	}
+}
+
+// removePortRanges removes the ranges of ports of the PU from the index
+func (d *Datapath) removePortRanges(pu *PUContext) {
+
+	d.portRangesLock.Lock()
+	defer d.portRangesLock.Unlock()
+
+	for _, key := range pu.Ports {
+		d.removePortRange(key)
+	}
+}
+
+// removePortRange removes a range of ports from the index. The caller must
+// hold the lock of the index.
+func (d *Datapath) removePortRange(key string) {
+
+	for i, r := range d.portRanges {
+		if r.key == key {
+			d.portRanges = append(d.portRanges[:i], d.portRanges[i+1:]...)
+			return
+		}
+	}
+}
+
 func (d *Datapath) doCreatePU(contextID string, puInfo *policy.PUInfo) error {
 
 	ip, ok := puInfo.Runtime.DefaultIPAddress()
@@ -309,6 +512,7 @@ func (d *Datapath) doCreatePU(contextID string, puInfo *policy.PUInfo) error {
 		ManagementID: puInfo.Policy.ManagementID,
 		PUType:       puInfo.Runtime.PUType(),
 		IP:           ip,
+		metrics:      newPUMetrics(contextID),
 	}
 
 	// Cache PUs for retrieval based on packet information
@@ -318,12 +522,22 @@ func (d *Datapath) doCreatePU(contextID string, puInfo *policy.PUInfo) error {
 		for _, port := range pu.Ports {
 			d.puFromPort.AddOrUpdate(port, pu)
 		}
+		d.addPortRanges(pu)
 	} else {
 		if ip, ok := puInfo.Runtime.DefaultIPAddress(); ok {
 			d.puFromIP.AddOrUpdate(ip, pu)
 		} else {
 			d.puFromIP.AddOrUpdate(DefaultNetwork, pu)
 		}
+
+		// Dual stack PUs are also reachable through their IPv6 address. The
+		// address is normalized so that it matches the packet representation.
+		if ipv6, ok := puInfo.Runtime.DefaultIPv6Address(); ok {
+			if parsed := net.ParseIP(ipv6); parsed != nil {
+				pu.IPv6 = parsed.String()
+				d.puFromIP.AddOrUpdate(pu.IPv6, pu)
+			}
+		}
 	}
 
 	// Cache PU from contextID for management and policy updates
@@ -337,13 +551,22 @@ func (d *Datapath) doUpdatePU(puContext *PUContext, containerInfo *policy.PUInfo
 	puContext.Lock()
 	defer puContext.Unlock()
 
-	puContext.AcceptRcvRules, puContext.RejectRcvRules = createRuleDBs(containerInfo.Policy.ReceiverRules())
+	// Small changes of the rules are applied to the current databases
+	if !updateRuleDBs(puContext.AcceptRcvRules, puContext.RejectRcvRules, containerInfo.Policy.ReceiverRules()) {
+		puContext.AcceptRcvRules, puContext.RejectRcvRules = createRuleDBs(containerInfo.Policy.ReceiverRules())
+	}
 
-	puContext.AcceptTxtRules, puContext.RejectTxtRules = createRuleDBs(containerInfo.Policy.TransmitterRules())
+	if !updateRuleDBs(puContext.AcceptTxtRules, puContext.RejectTxtRules, containerInfo.Policy.TransmitterRules()) {
+		puContext.AcceptTxtRules, puContext.RejectTxtRules = createRuleDBs(containerInfo.Policy.TransmitterRules())
+	}
 
 	puContext.Identity = containerInfo.Policy.Identity()
 
 	puContext.Annotations = containerInfo.Policy.Annotations()
 
+	puContext.EncryptionEnabled = containerInfo.Policy.EncryptionEnabled()
+
+	puContext.Audit = containerInfo.Policy.TriremeAction == policy.Audit
+
 	return nil
 }
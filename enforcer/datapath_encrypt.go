@@ -0,0 +1,339 @@
+package enforcer
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/cache"
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/crypto"
+	"github.com/aporeto-inc/trireme/enforcer/connection"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+const (
+	// encryptedConnectionTimeout is the time after which the encryption state
+	// of an idle connection is closed, and the time after which a closed
+	// state is removed
+	encryptedConnectionTimeout = time.Hour
+
+	// encryptionOptionLen is the length of the TCP option that carries the
+	// count of the wraps of the sequence numbers and the tag of an encrypted
+	// payload
+	encryptionOptionLen = 4 + crypto.SegmentTagSize
+)
+
+// cipherState is the encryption state of one direction of a connection.
+// The payload is encrypted with AES in counter mode and authenticated with
+// a truncated HMAC256, with keys that are unique to the connection and the
+// direction. The nonce of a payload is its position in the stream: its
+// sequence number extended with the count of the wraps of the sequence
+// numbers, so that the key stream never repeats. The low bits of the count
+// and the tag are carried in a TCP option. The handshake reduces the MSS of
+// the connection and disables Sack so that the option always fits. A closed
+// state drops the payload of the connection instead of sending or delivering
+// it in clear.
+type cipherState struct {
+	key       []byte
+	macKey    []byte
+	highest   uint64
+	started   bool
+	closed    bool
+	refreshed time.Time
+	sync.Mutex
+}
+
+// newCipherState derives the keys of one direction of a connection from its session key
+func newCipherState(sessionKey []byte) (*cipherState, error) {
+
+	key, macKey, err := crypto.DeriveSegmentKeys(sessionKey)
+	if err != nil {
+		return nil, dropErrorf(collector.InvalidEncryption, "Unable to derive the keys of the payload: %s", err)
+	}
+
+	return &cipherState{key: key, macKey: macKey, refreshed: time.Now()}, nil
+}
+
+// position returns the position in the stream of the payload that starts at
+// the sequence number. It is the position nearest to the highest position
+// processed so far. The caller must hold the lock.
+func (s *cipherState) position(seq uint32) uint64 {
+
+	if !s.started {
+		return uint64(seq)
+	}
+
+	position := s.highest&^0xffffffff | uint64(seq)
+
+	switch {
+	case position > s.highest && position-s.highest > 1<<31 && position >= 1<<32:
+		position -= 1 << 32
+	case position < s.highest && s.highest-position > 1<<31:
+		position += 1 << 32
+	}
+
+	return position
+}
+
+// advance records the position of a payload that was processed. The caller
+// must hold the lock.
+func (s *cipherState) advance(position uint64) {
+
+	if !s.started || position > s.highest {
+		s.highest = position
+		s.started = true
+	}
+}
+
+// close forgets the keys of the state. The caller must hold the lock.
+func (s *cipherState) close() {
+
+	s.closed = true
+	s.key = nil
+	s.macKey = nil
+}
+
+// refresh restarts the lifetime of the state in the tracker. This is done at
+// most every quarter of the timeout. The caller must hold the lock.
+func (s *cipherState) refresh(tracker cache.DataStore, hash string) {
+
+	if time.Since(s.refreshed) > encryptedConnectionTimeout/4 {
+		s.refreshed = time.Now()
+		tracker.AddOrUpdate(hash, s)
+	}
+}
+
+// closeExpiredEncryptionState closes the encryption state of an idle connection
+// when it expires. The closed state is kept for another timeout so that the late
+// packets of the connection are dropped. Closed states are removed when they expire.
+func closeExpiredEncryptionState(c cache.DataStore, id interface{}, item interface{}) {
+
+	state := item.(*cipherState)
+
+	state.Lock()
+	defer state.Unlock()
+
+	if state.closed {
+		return
+	}
+
+	// The state was refreshed by a packet while it expired
+	if time.Since(state.refreshed) < encryptedConnectionTimeout {
+		c.AddOrUpdate(id, state)
+		return
+	}
+
+	state.close()
+	state.refreshed = time.Now()
+	c.AddOrUpdate(id, state)
+}
+
+// encryptionRequired returns true if the action of a matched rule requires encryption
+func encryptionRequired(action interface{}) bool {
+
+	flowAction, ok := action.(policy.FlowAction)
+
+	return ok && flowAction&policy.Encrypt != 0
+}
+
+// installEncryptionState derives the keys of an encrypted connection and
+// creates the state that the datapath uses for the payload of the connection.
+// The flow hashes are the hashes of the application and network packets.
+func (d *Datapath) installEncryptionState(conn *connection.TCPConnection, initiator bool, appHash, netHash string) error {
+
+	txKey, rxKey, err := conn.Auth.SessionKeys(initiator)
+	if err != nil {
+		return dropErrorf(collector.InvalidEncryption, "Unable to derive session keys: %s", err)
+	}
+
+	txState, err := newCipherState(txKey)
+	if err != nil {
+		return err
+	}
+
+	rxState, err := newCipherState(rxKey)
+	if err != nil {
+		return err
+	}
+
+	d.appEncryptionTracker.AddOrUpdate(appHash, txState)
+	d.netEncryptionTracker.AddOrUpdate(netHash, rxState)
+
+	return nil
+}
+
+// removeEncryptionState removes the encryption state of a previous connection
+// with the same flow hashes, once a new connection is authorized in clear
+func (d *Datapath) removeEncryptionState(appHash, netHash string) {
+
+	// Most connections are not encrypted and there is nothing to remove
+	if err := d.appEncryptionTracker.Remove(appHash); err == nil {
+		zap.L().Debug("Removed stale encryption state", zap.String("flow", appHash))
+	}
+
+	if err := d.netEncryptionTracker.Remove(netHash); err == nil {
+		zap.L().Debug("Removed stale encryption state", zap.String("flow", netHash))
+	}
+}
+
+// processApplicationEncryption encrypts the payload of the application packets of
+// encrypted connections and attaches the tag of the payload in a TCP option
+func (d *Datapath) processApplicationEncryption(p *packet.Packet) error {
+
+	// Handshake packets carry our tokens and are never encrypted
+	if p.TCPFlags&packet.TCPSynMask != 0 {
+		return nil
+	}
+
+	hash := p.L4FlowHash()
+
+	item, err := d.appEncryptionTracker.Get(hash)
+	if err != nil {
+		// The connection is not encrypted
+		return nil
+	}
+
+	state := item.(*cipherState)
+
+	state.Lock()
+	defer state.Unlock()
+
+	state.refresh(d.appEncryptionTracker, hash)
+
+	// The connection is closed in both directions when it is reset by the application
+	if p.TCPFlags&packet.TCPRstMask != 0 {
+		if !state.closed {
+			state.close()
+			d.closeNetworkEncryptionState(p.L4ReverseFlowHash())
+		}
+		return nil
+	}
+
+	data := p.ReadTCPData()
+	if len(data) == 0 {
+		return nil
+	}
+
+	if state.closed {
+		return dropErrorf(collector.InvalidEncryption, "Payload of a closed encrypted connection")
+	}
+
+	position := state.position(p.TCPSeq)
+
+	// The payload is detached before it is encrypted to keep the checksum right
+	if err := p.TCPDataDetach(0); err != nil {
+		return dropErrorf(collector.InvalidFormat, "Unable to detach the payload: %s", err)
+	}
+	p.DropDetachedBytes()
+
+	tag, err := crypto.SealSegment(state.key, state.macKey, position, data)
+	if err != nil {
+		return dropErrorf(collector.InvalidEncryption, "Unable to encrypt the payload: %s", err)
+	}
+
+	state.advance(position)
+
+	option := make([]byte, 4, encryptionOptionLen)
+	option[0] = packet.TCPEncryptionOption
+	option[1] = encryptionOptionLen
+	binary.BigEndian.PutUint16(option[2:4], uint16(position>>32))
+	option = append(option, tag...)
+
+	if err := p.TCPDataAttach(option, data); err != nil {
+		return dropErrorf(collector.InvalidEncryption, "Unable to attach the tag of the payload: %s", err)
+	}
+
+	return nil
+}
+
+// processNetworkDecryption verifies and decrypts the payload of the network packets of
+// encrypted connections and removes the TCP option of the tag. The payload of the
+// packets that do not match the encryption state of their connection is dropped.
+func (d *Datapath) processNetworkDecryption(p *packet.Packet) error {
+
+	// Handshake packets carry our tokens and are never encrypted
+	if p.TCPFlags&packet.TCPSynMask != 0 {
+		return nil
+	}
+
+	data := p.ReadTCPData()
+	encrypted := p.HasLastTCPOption(packet.TCPEncryptionOption, encryptionOptionLen)
+
+	hash := p.L4FlowHash()
+
+	item, err := d.netEncryptionTracker.Get(hash)
+	if err != nil {
+		if encrypted && len(data) > 0 {
+			return dropErrorf(collector.InvalidEncryption, "Encrypted payload of an unknown connection")
+		}
+		return nil
+	}
+
+	state := item.(*cipherState)
+
+	state.Lock()
+	defer state.Unlock()
+
+	state.refresh(d.netEncryptionTracker, hash)
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if state.closed {
+		return dropErrorf(collector.InvalidEncryption, "Payload of a closed encrypted connection")
+	}
+
+	if !encrypted {
+		return dropErrorf(collector.InvalidEncryption, "Payload in clear of an encrypted connection")
+	}
+
+	if err := p.TCPDataDetach(encryptionOptionLen); err != nil {
+		return dropErrorf(collector.InvalidFormat, "Unable to detach the encrypted payload: %s", err)
+	}
+
+	option := p.GetTCPOptions()
+	wraps := binary.BigEndian.Uint16(option[2:4])
+
+	// The count of the wraps is taken from the peer for the first payload
+	// and must match the position of the payload in the stream afterwards
+	position := uint64(wraps)<<32 | uint64(p.TCPSeq)
+	if state.started {
+		position = state.position(p.TCPSeq)
+		if uint16(position>>32) != wraps {
+			return dropErrorf(collector.InvalidEncryption, "Encrypted payload out of the window of the connection")
+		}
+	}
+
+	if err := crypto.OpenSegment(state.key, state.macKey, position, data, option[4:encryptionOptionLen]); err != nil {
+		return dropErrorf(collector.InvalidEncryption, "Unable to decrypt the payload: %s", err)
+	}
+
+	state.advance(position)
+
+	p.DropDetachedBytes()
+	if err := p.TCPDataAttach(nil, data); err != nil {
+		return dropErrorf(collector.InvalidFormat, "Unable to attach the decrypted payload: %s", err)
+	}
+
+	return nil
+}
+
+// closeNetworkEncryptionState closes the encryption state of the network packets of a connection
+func (d *Datapath) closeNetworkEncryptionState(hash string) {
+
+	item, err := d.netEncryptionTracker.Get(hash)
+	if err != nil {
+		return
+	}
+
+	state := item.(*cipherState)
+
+	state.Lock()
+	state.close()
+	state.Unlock()
+}
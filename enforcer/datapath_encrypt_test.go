@@ -0,0 +1,234 @@
+package enforcer
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// setupEncryptedProcessingUnits creates two processing units in the datapath. The
+// receiver rules of the server are given the provided action.
+func setupEncryptedProcessingUnits(serverAction, clientAction policy.FlowAction) (*Datapath, error, error) {
+
+	selector := func(action policy.FlowAction) *policy.TagSelector {
+		return &policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{
+					Key:      TransmitterLabel,
+					Value:    []string{"value"},
+					Operator: policy.Equal,
+				},
+			},
+			Action: action,
+		}
+	}
+
+	iteration = iteration + 1
+	serverID := "SomeProcessingUnitId" + strconv.Itoa(iteration) + "1"
+	clientID := "SomeProcessingUnitId" + strconv.Itoa(iteration) + "2"
+
+	server := policy.NewPUInfo(serverID, constants.ContainerPU)
+	server.Runtime.SetIPAddresses(policy.NewIPMap(map[string]string{"bridge": "164.67.228.152"}))
+	server.Policy.SetIPAddresses(policy.NewIPMap(map[string]string{policy.DefaultNamespace: "164.67.228.152"}))
+	server.Policy.AddIdentityTag(TransmitterLabel, "value")
+	server.Policy.AddReceiverRules(selector(serverAction))
+
+	client := policy.NewPUInfo(clientID, constants.ContainerPU)
+	client.Runtime.SetIPAddresses(policy.NewIPMap(map[string]string{"bridge": "10.1.10.76"}))
+	client.Policy.SetIPAddresses(policy.NewIPMap(map[string]string{policy.DefaultNamespace: "10.1.10.76"}))
+	client.Policy.AddIdentityTag(TransmitterLabel, "value")
+	client.Policy.AddReceiverRules(selector(policy.Accept))
+	client.Policy.AddTransmitterRules(selector(clientAction))
+
+	secret := tokens.NewPSKSecrets([]byte("Dummy Test Password"))
+	enforcer := NewWithDefaults("SomeServerId", &collector.DefaultCollector{}, nil, secret, constants.LocalContainer, "/proc").(*Datapath)
+
+	return enforcer, enforcer.Enforce(serverID, server), enforcer.Enforce(clientID, client)
+}
+
+// transmitTCPPacket passes a packet through the application and the network
+// side of the enforcer and returns the packet as seen on the wire and as
+// delivered to the receiver
+func transmitTCPPacket(enforcer *Datapath, i int, t *testing.T) (*packet.Packet, *packet.Packet, error) {
+
+	tcpPacket := selectPacket(i, t)[1]
+	if err := enforcer.processApplicationTCPPackets(tcpPacket); err != nil {
+		return nil, nil, err
+	}
+
+	wire := make([]byte, len(tcpPacket.GetBytes()))
+	copy(wire, tcpPacket.GetBytes())
+	wirePacket, err := packet.New(0, wire, "0")
+	So(err, ShouldBeNil)
+
+	output := make([]byte, len(tcpPacket.GetBytes()))
+	copy(output, tcpPacket.GetBytes())
+	receivedPacket, err := receiveTCPPacket(enforcer, output)
+
+	return wirePacket, receivedPacket, err
+}
+
+// receiveTCPPacket passes a packet from the wire through the network side of
+// the enforcer and returns the packet as delivered to the receiver
+func receiveTCPPacket(enforcer *Datapath, wire []byte) (*packet.Packet, error) {
+
+	input := make([]byte, len(wire))
+	copy(input, wire)
+	inPacket, err := packet.New(0, input, "0")
+	So(err, ShouldBeNil)
+
+	if err := enforcer.processNetworkTCPPackets(inPacket); err != nil {
+		return nil, err
+	}
+
+	outPacket, err := packet.New(0, inPacket.GetBytes(), "0")
+	So(err, ShouldBeNil)
+
+	return outPacket, nil
+}
+
+func TestEncryptedConnection(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance with a server that requires encryption", t, func() {
+
+		enforcer, err1, err2 := setupEncryptedProcessingUnits(policy.Accept|policy.Encrypt, policy.Accept|policy.Encrypt)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When the client and the server complete the handshake", func() {
+
+			for i := 0; i < 3; i++ {
+				_, _, err := transmitTCPPacket(enforcer, i, t)
+				So(err, ShouldBeNil)
+			}
+
+			Convey("Then the payload of the client should be encrypted on the wire and decrypted at the server", func() {
+				original := selectPacket(3, t)[1]
+
+				wire, received, err := transmitTCPPacket(enforcer, 3, t)
+				So(err, ShouldBeNil)
+				So(bytes.Equal(wire.ReadTCPData(), original.ReadTCPData()), ShouldBeFalse)
+				So(received.ReadTCPData(), ShouldResemble, original.ReadTCPData())
+				So(received.VerifyTCPChecksum(), ShouldBeTrue)
+			})
+
+			Convey("Then the tag of the payload should be carried in a TCP option", func() {
+				original := selectPacket(3, t)[1]
+
+				wire, received, err := transmitTCPPacket(enforcer, 3, t)
+				So(err, ShouldBeNil)
+				So(wire.HasLastTCPOption(packet.TCPEncryptionOption, encryptionOptionLen), ShouldBeTrue)
+				So(wire.IPTotalLength, ShouldEqual, original.IPTotalLength+encryptionOptionLen)
+				So(received.HasLastTCPOption(packet.TCPEncryptionOption, encryptionOptionLen), ShouldBeFalse)
+				So(received.IPTotalLength, ShouldEqual, original.IPTotalLength)
+			})
+
+			Convey("Then a modified payload should be dropped at the server", func() {
+				tcpPacket := selectPacket(3, t)[1]
+				So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+
+				wire := tcpPacket.GetBytes()
+				wire[len(wire)-1] ^= 1
+				_, err := receiveTCPPacket(enforcer, wire)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then a payload in clear should be dropped at the server", func() {
+				tcpPacket := selectPacket(3, t)[1]
+
+				_, err := receiveTCPPacket(enforcer, tcpPacket.GetBytes())
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then an encrypted payload without encryption state should be dropped at the server", func() {
+				tcpPacket := selectPacket(3, t)[1]
+				So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+				So(enforcer.netEncryptionTracker.Remove(tcpPacket.L4FlowHash()), ShouldBeNil)
+
+				_, err := receiveTCPPacket(enforcer, tcpPacket.GetBytes())
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("When the encryption state of the client expires", func() {
+				hash := selectPacket(3, t)[1].L4FlowHash()
+				item, err := enforcer.appEncryptionTracker.Get(hash)
+				So(err, ShouldBeNil)
+				state := item.(*cipherState)
+				state.refreshed = time.Now().Add(-encryptedConnectionTimeout)
+				closeExpiredEncryptionState(enforcer.appEncryptionTracker, hash, state)
+
+				Convey("Then the payload of the client should be dropped instead of sent in clear", func() {
+					_, _, err := transmitTCPPacket(enforcer, 3, t)
+					So(err, ShouldNotBeNil)
+				})
+			})
+
+			Convey("Then the payload of the server should be encrypted on the wire and decrypted at the client", func() {
+				original := selectPacket(5, t)[1]
+
+				wire, received, err := transmitTCPPacket(enforcer, 5, t)
+				So(err, ShouldBeNil)
+				So(bytes.Equal(wire.ReadTCPData(), original.ReadTCPData()), ShouldBeFalse)
+				So(received.ReadTCPData(), ShouldResemble, original.ReadTCPData())
+				So(received.VerifyTCPChecksum(), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given I create a new enforcer instance with a server that accepts plain connections", t, func() {
+
+		enforcer, err1, err2 := setupEncryptedProcessingUnits(policy.Accept, policy.Accept|policy.Encrypt)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When the client requires encryption, the SynAck should be rejected", func() {
+
+			_, _, err := transmitTCPPacket(enforcer, 0, t)
+			So(err, ShouldBeNil)
+
+			_, _, err = transmitTCPPacket(enforcer, 1, t)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given I create a new enforcer instance where only the server has encryption rules", t, func() {
+
+		enforcer, err1, err2 := setupEncryptedProcessingUnits(policy.Accept|policy.Encrypt, policy.Accept)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When the client does not offer a key, the Syn should be rejected", func() {
+
+			// The client does not offer a key since it has no encryption rules
+			_, _, err := transmitTCPPacket(enforcer, 0, t)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestEncryptionStateEviction(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance that tracks a single connection", t, func() {
+
+		secret := tokens.NewPSKSecrets([]byte("Dummy Test Password"))
+		enforcer := New(false, &FilterQueue{MaxTrackedConnections: 1}, &collector.DefaultCollector{}, nil, secret, "SomeServerId", 10*time.Second, constants.LocalContainer, "/proc").(*Datapath)
+
+		Convey("When I install the encryption state of two connections, both should be kept", func() {
+			enforcer.appEncryptionTracker.AddOrUpdate("flow1", &cipherState{})
+			enforcer.appEncryptionTracker.AddOrUpdate("flow2", &cipherState{})
+			enforcer.netEncryptionTracker.AddOrUpdate("flow1", &cipherState{})
+			enforcer.netEncryptionTracker.AddOrUpdate("flow2", &cipherState{})
+
+			So(enforcer.appEncryptionTracker.KeyList(), ShouldHaveLength, 2)
+			So(enforcer.netEncryptionTracker.KeyList(), ShouldHaveLength, 2)
+		})
+	})
+}
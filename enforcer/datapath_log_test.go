@@ -0,0 +1,267 @@
+package enforcer
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recordingCollector keeps the flow records that are reported
+type recordingCollector struct {
+	records []*collector.FlowRecord
+	sync.Mutex
+}
+
+func (r *recordingCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	r.Lock()
+	defer r.Unlock()
+	r.records = append(r.records, record)
+}
+
+func (r *recordingCollector) CollectContainerEvent(record *collector.ContainerRecord) {}
+
+// loggedRecords returns the records that carry a rule ID
+func (r *recordingCollector) loggedRecords() []*collector.FlowRecord {
+	r.Lock()
+	defer r.Unlock()
+
+	logged := []*collector.FlowRecord{}
+	for _, record := range r.records {
+		if record.RuleID != "" {
+			logged = append(logged, record)
+		}
+	}
+
+	return logged
+}
+
+// setupLoggedProcessingUnits creates a server with one receiver rule with the
+// given action and a client that is allowed to reach it. The server policy has
+// the given mode.
+func setupLoggedProcessingUnits(serverMode policy.PUAction, serverAction policy.FlowAction, c collector.EventCollector) (*Datapath, string, error, error) {
+
+	iteration = iteration + 1
+	serverID := "SomeProcessingUnitId" + strconv.Itoa(iteration) + "1"
+	clientID := "SomeProcessingUnitId" + strconv.Itoa(iteration) + "2"
+
+	selector := func(action policy.FlowAction, id string) *policy.TagSelector {
+		return &policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{
+					Key:      TransmitterLabel,
+					Value:    []string{"value"},
+					Operator: policy.Equal,
+				},
+			},
+			Action: action,
+			ID:     id,
+		}
+	}
+
+	server := policy.NewPUInfo(serverID, constants.ContainerPU)
+	server.Runtime.SetIPAddresses(policy.NewIPMap(map[string]string{"bridge": "164.67.228.152"}))
+	server.Policy.SetIPAddresses(policy.NewIPMap(map[string]string{policy.DefaultNamespace: "164.67.228.152"}))
+	server.Policy.ManagementID = "server-policy"
+	server.Policy.TriremeAction = serverMode
+	server.Policy.AddIdentityTag(TransmitterLabel, "value")
+	server.Policy.AddIdentityTag("app", "server")
+	server.Policy.AddReceiverRules(selector(serverAction, "server-rule"))
+
+	client := policy.NewPUInfo(clientID, constants.ContainerPU)
+	client.Runtime.SetIPAddresses(policy.NewIPMap(map[string]string{"bridge": "10.1.10.76"}))
+	client.Policy.SetIPAddresses(policy.NewIPMap(map[string]string{policy.DefaultNamespace: "10.1.10.76"}))
+	client.Policy.AddIdentityTag(TransmitterLabel, "value")
+	client.Policy.AddIdentityTag("app", "client")
+	client.Policy.AddReceiverRules(selector(policy.Accept, ""))
+	client.Policy.AddTransmitterRules(selector(policy.Accept, ""))
+
+	secret := tokens.NewPSKSecrets([]byte("Dummy Test Password"))
+	enforcer := NewWithDefaults("SomeServerId", c, nil, secret, constants.LocalContainer, "/proc").(*Datapath)
+
+	return enforcer, serverID, enforcer.Enforce(serverID, server), enforcer.Enforce(clientID, client)
+}
+
+func TestLoggedFlows(t *testing.T) {
+
+	Convey("Given a server with an accept rule with the log action", t, func() {
+
+		c := &recordingCollector{}
+		enforcer, serverID, err1, err2 := setupLoggedProcessingUnits(policy.Police, policy.Accept|policy.Log, c)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When the client opens a connection", func() {
+
+			_, _, err := transmitTCPPacket(enforcer, 0, t)
+			So(err, ShouldBeNil)
+
+			Convey("Then a detailed accept record should be reported for the rule", func() {
+				records := c.loggedRecords()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].ContextID, ShouldEqual, serverID)
+				So(records[0].RuleID, ShouldEqual, "server-rule")
+				So(records[0].ManagementID, ShouldEqual, "server-policy")
+				So(records[0].Action, ShouldEqual, collector.FlowAccept)
+				So(records[0].Timestamp.IsZero(), ShouldBeFalse)
+
+				source, _ := records[0].SourceTags.Get("app")
+				So(source, ShouldEqual, "client")
+				destination, _ := records[0].DestinationTags.Get("app")
+				So(destination, ShouldEqual, "server")
+			})
+		})
+	})
+
+	Convey("Given a server with a reject rule with the log action", t, func() {
+
+		c := &recordingCollector{}
+		enforcer, _, err1, err2 := setupLoggedProcessingUnits(policy.Police, policy.Reject|policy.Log, c)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When the client opens a connection", func() {
+
+			_, _, err := transmitTCPPacket(enforcer, 0, t)
+			So(err, ShouldNotBeNil)
+
+			Convey("Then a detailed reject record should be reported for the rule", func() {
+				records := c.loggedRecords()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].RuleID, ShouldEqual, "server-rule")
+				So(records[0].Action, ShouldEqual, collector.FlowReject)
+				So(records[0].DropReason, ShouldEqual, collector.PolicyDrop)
+				So(records[0].Mode, ShouldEqual, string(collector.PolicyDrop))
+				So(records[0].Explanation, ShouldNotBeNil)
+				So(records[0].Explanation.Matched.ID, ShouldEqual, "server-rule")
+			})
+		})
+	})
+
+	Convey("Given a server with a reject rule without the log action", t, func() {
+
+		c := &recordingCollector{}
+		enforcer, _, err1, err2 := setupLoggedProcessingUnits(policy.Police, policy.Reject, c)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When the client opens a connection", func() {
+
+			_, _, err := transmitTCPPacket(enforcer, 0, t)
+			So(err, ShouldNotBeNil)
+
+			Convey("Then the rejected record should explain the decision of the policy", func() {
+				c.Lock()
+				defer c.Unlock()
+
+				var rejected *collector.FlowRecord
+				for _, record := range c.records {
+					if record.DropReason == collector.PolicyDrop {
+						rejected = record
+					}
+				}
+
+				So(rejected, ShouldNotBeNil)
+				So(rejected.Explanation, ShouldNotBeNil)
+				So(rejected.Explanation.Action, ShouldEqual, policy.Reject)
+				So(rejected.Explanation.Matched.ID, ShouldEqual, "server-rule")
+				So(rejected.Explanation.Matched.Clauses[0].Matched, ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given a server with an accept rule without the log action", t, func() {
+
+		c := &recordingCollector{}
+		enforcer, _, err1, err2 := setupLoggedProcessingUnits(policy.Police, policy.Accept, c)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When the client opens a connection, no detailed record should be reported", func() {
+
+			_, _, err := transmitTCPPacket(enforcer, 0, t)
+			So(err, ShouldBeNil)
+			So(len(c.loggedRecords()), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestAuditedFlows(t *testing.T) {
+
+	Convey("Given a server in audit mode with a reject rule with the log action", t, func() {
+
+		c := &recordingCollector{}
+		enforcer, serverID, err1, err2 := setupLoggedProcessingUnits(policy.Audit, policy.Reject|policy.Log, c)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When the client opens a connection", func() {
+
+			_, _, err := transmitTCPPacket(enforcer, 0, t)
+
+			Convey("Then the connection should be accepted", func() {
+				So(err, ShouldBeNil)
+			})
+
+			Convey("Then a detailed audit record should be reported for the rule", func() {
+				records := c.loggedRecords()
+				So(len(records), ShouldEqual, 1)
+				So(records[0].ContextID, ShouldEqual, serverID)
+				So(records[0].RuleID, ShouldEqual, "server-rule")
+				So(records[0].Action, ShouldEqual, collector.FlowAudit)
+				So(records[0].DropReason, ShouldEqual, collector.PolicyDrop)
+				So(records[0].Mode, ShouldEqual, string(collector.PolicyDrop))
+				So(records[0].Explanation.Matched.ID, ShouldEqual, "server-rule")
+			})
+		})
+	})
+
+	Convey("Given a server in audit mode with a reject rule without the log action", t, func() {
+
+		c := &recordingCollector{}
+		enforcer, _, err1, err2 := setupLoggedProcessingUnits(policy.Audit, policy.Reject, c)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When the client opens a connection", func() {
+
+			_, _, err := transmitTCPPacket(enforcer, 0, t)
+			So(err, ShouldBeNil)
+
+			Convey("Then the rejected flow should be reported with the audit action", func() {
+				c.Lock()
+				defer c.Unlock()
+
+				actions := []string{}
+				for _, record := range c.records {
+					if record.DropReason == collector.PolicyDrop {
+						actions = append(actions, record.Action)
+					}
+				}
+
+				So(actions, ShouldNotBeEmpty)
+				So(actions, ShouldNotContain, collector.FlowReject)
+				So(actions, ShouldContain, collector.FlowAudit)
+			})
+		})
+
+		Convey("When the client sends the first packet of a UDP flow", func() {
+
+			appPacket := createUDPPacket("10.1.10.76", "164.67.228.152", 5000, 53, []byte("request"))
+			So(enforcer.processApplicationUDPPackets(appPacket), ShouldBeNil)
+
+			netPacket := transmitUDPPacket(appPacket)
+			err := enforcer.processNetworkUDPPackets(netPacket)
+
+			Convey("Then the flow should be accepted", func() {
+				So(err, ShouldBeNil)
+				So(string(netPacket.ReadUDPData()), ShouldEqual, "request")
+			})
+		})
+	})
+}
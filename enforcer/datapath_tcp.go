@@ -5,12 +5,14 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/enforcer/connection"
+	"github.com/aporeto-inc/trireme/enforcer/lookup"
 	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
 	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
 )
@@ -65,15 +67,21 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) error {
 			zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 			zap.Error(err),
 		)
+		countDrop(nil, metricsNetwork, "tcp", dropReason(err, collector.InvalidContext))
 		return err
 	}
 
 	// if no connection for Ack packets accept them. We are done processing
+	// except for the payload of encrypted connections
 	if conn == nil {
 		zap.L().Debug("Ack packet - ignore connection state ",
 			zap.String("flow", p.L4FlowHash()),
 			zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 		)
+		if err := d.processNetworkDecryption(p); err != nil {
+			countDrop(context, metricsNetwork, "tcp", dropReason(err, collector.InvalidEncryption))
+			return err
+		}
 		return nil
 	}
 
@@ -88,8 +96,9 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) error {
 	if d.service != nil {
 		if !d.service.PreProcessTCPNetPacket(p) {
 			d.netTCP.ServicePreDropPackets++
+			countDrop(context, metricsNetwork, "tcp", collector.ServicePreDrop)
 			p.Print(packet.PacketFailureService)
-			return fmt.Errorf("Pre service processing failed for network packet")
+			return dropErrorf(collector.ServicePreDrop, "Pre service processing failed for network packet")
 		}
 	}
 
@@ -98,9 +107,20 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) error {
 	// Match the tags of the packet against the policy rules - drop if the lookup fails
 	action, err := d.processNetworkTCPPacket(p, context, conn)
 	if err != nil {
+		reason := dropReason(err, collector.InvalidState)
+		d.netTCP.AuthDropPackets++
+		countDrop(context, metricsNetwork, "tcp", reason)
+		p.Print(packet.PacketFailureAuth)
+		return dropErrorf(reason, "Packet processing failed for network packet: %s", err.Error())
+	}
+
+	// The payload of encrypted connections is decrypted once the handshake
+	// packets have installed the encryption state
+	if err := d.processNetworkDecryption(p); err != nil {
 		d.netTCP.AuthDropPackets++
+		countDrop(context, metricsNetwork, "tcp", dropReason(err, collector.InvalidEncryption))
 		p.Print(packet.PacketFailureAuth)
-		return fmt.Errorf("Packet processing failed for network packet: %s", err.Error())
+		return err
 	}
 
 	p.Print(packet.PacketStageService)
@@ -109,8 +129,9 @@ func (d *Datapath) processNetworkTCPPackets(p *packet.Packet) error {
 		// PostProcessServiceInterface
 		if !d.service.PostProcessTCPNetPacket(p, action) {
 			d.netTCP.ServicePostDropPackets++
+			countDrop(context, metricsNetwork, "tcp", collector.ServicePostDrop)
 			p.Print(packet.PacketFailureService)
-			return fmt.Errorf("PostPost service processing failed for network packet")
+			return dropErrorf(collector.ServicePostDrop, "PostPost service processing failed for network packet")
 		}
 	}
 
@@ -143,15 +164,21 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) error {
 			zap.String("flow", p.L4FlowHash()),
 		)
 
-		return fmt.Errorf("No context found in app processing")
+		countDrop(nil, metricsApplication, "tcp", dropReason(err, collector.InvalidContext))
+		return dropErrorf(dropReason(err, collector.InvalidContext), "No context found in app processing")
 	}
 
 	// Only happens for TCP Ack packets after we are done processing - let them go
+	// after we encrypt the payload of encrypted connections
 	if conn == nil {
 		zap.L().Debug("Ignoring data ack packet ",
 			zap.String("flow", p.L4FlowHash()),
 			zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 		)
+		if err := d.processApplicationEncryption(p); err != nil {
+			countDrop(context, metricsApplication, "tcp", dropReason(err, collector.InvalidEncryption))
+			return err
+		}
 		return nil
 	}
 
@@ -166,8 +193,9 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) error {
 		// PreProcessServiceInterface
 		if !d.service.PreProcessTCPAppPacket(p) {
 			d.appTCP.ServicePreDropPackets++
+			countDrop(context, metricsApplication, "tcp", collector.ServicePreDrop)
 			p.Print(packet.PacketFailureService)
-			return fmt.Errorf("Pre service processing failed for application packet")
+			return dropErrorf(collector.ServicePreDrop, "Pre service processing failed for application packet")
 		}
 	}
 
@@ -181,9 +209,11 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) error {
 			zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 			zap.Error(err),
 		)
+		reason := dropReason(err, collector.InvalidState)
 		d.appTCP.AuthDropPackets++
+		countDrop(context, metricsApplication, "tcp", reason)
 		p.Print(packet.PacketFailureAuth)
-		return fmt.Errorf("Processing failed for application packet: %s", err.Error())
+		return dropErrorf(reason, "Processing failed for application packet: %s", err.Error())
 	}
 
 	zap.L().Debug("Finished processing ",
@@ -197,11 +227,20 @@ func (d *Datapath) processApplicationTCPPackets(p *packet.Packet) error {
 		// PostProcessServiceInterface
 		if !d.service.PostProcessTCPAppPacket(p, action) {
 			d.appTCP.ServicePostDropPackets++
+			countDrop(context, metricsApplication, "tcp", collector.ServicePostDrop)
 			p.Print(packet.PacketFailureService)
-			return fmt.Errorf("Post service processing failed for application packet")
+			return dropErrorf(collector.ServicePostDrop, "Post service processing failed for application packet")
 		}
 	}
 
+	// The first request packet is processed with the connection state
+	if err := d.processApplicationEncryption(p); err != nil {
+		d.appTCP.AuthDropPackets++
+		countDrop(context, metricsApplication, "tcp", dropReason(err, collector.InvalidEncryption))
+		p.Print(packet.PacketFailureAuth)
+		return err
+	}
+
 	// Accept the packet
 	d.appTCP.OutgoingPackets++
 	p.Print(packet.PacketStageOutgoing)
@@ -235,10 +274,24 @@ func (d *Datapath) processApplicationSynPacket(tcpPacket *packet.Packet, context
 	// Create TCP Option
 	tcpOptions := d.createTCPAuthenticationOption([]byte{})
 
-	// Create a token
+	// Create a token. PUs that have encryption rules offer an ephemeral key
+	// and the receiver decides if the connection will be encrypted
 	context.Lock()
+	if context.EncryptionEnabled && conn.Auth.EphemeralKey == nil {
+		if err := conn.Auth.GenerateEphemeralKey(); err != nil {
+			context.Unlock()
+			return nil, dropErrorf(collector.InvalidEncryption, "Unable to generate an ephemeral key: %s", err)
+		}
+	}
 	tcpData := d.createPacketToken(false, context, &conn.Auth)
 	context.Unlock()
+
+	// The segments of encrypted connections carry the tag of their payload
+	if conn.Auth.EphemeralKey != nil {
+		tcpPacket.ReduceTCPMSS(encryptionOptionLen)
+		tcpPacket.RemoveTCPSackPermitted()
+	}
+
 	// Track the connection/port cache
 	hash := tcpPacket.L4FlowHash()
 	conn.SetState(connection.TCPSynSend)
@@ -250,7 +303,7 @@ func (d *Datapath) processApplicationSynPacket(tcpPacket *packet.Packet, context
 	// sequence numbers between the TCP stacks automatically match
 	tcpPacket.DecreaseTCPSeq(uint32(len(tcpData)-1) + (d.ackSize))
 	if err := tcpPacket.TCPDataAttach(tcpOptions, tcpData); err != nil {
-		return nil, err
+		return nil, dropErrorf(collector.InvalidFormat, "Unable to attach the token: %s", err)
 	}
 
 	tcpPacket.UpdateTCPChecksum()
@@ -274,11 +327,16 @@ func (d *Datapath) processApplicationSynAckPacket(tcpPacket *packet.Packet, cont
 		tcpData := d.createPacketToken(false, context, &conn.Auth)
 		context.Unlock()
 
+		// The segments of encrypted connections carry the tag of their payload
+		if conn.Encrypt {
+			tcpPacket.ReduceTCPMSS(encryptionOptionLen)
+		}
+
 		// Attach the tags to the packet
 		tcpPacket.DecreaseTCPSeq(uint32(len(tcpData) - 1))
 		tcpPacket.DecreaseTCPAck(d.ackSize)
 		if err := tcpPacket.TCPDataAttach(tcpOptions, tcpData); err != nil {
-			return nil, err
+			return nil, dropErrorf(collector.InvalidFormat, "Unable to attach the token: %s", err)
 		}
 
 		tcpPacket.UpdateTCPChecksum()
@@ -291,7 +349,7 @@ func (d *Datapath) processApplicationSynAckPacket(tcpPacket *packet.Packet, cont
 		zap.String("state", fmt.Sprintf("%v", conn.GetState())),
 	)
 
-	return nil, fmt.Errorf("Received SynACK in wrong state %v", conn.GetState())
+	return nil, dropErrorf(collector.InvalidState, "Received SynACK in wrong state %v", conn.GetState())
 }
 
 // processApplicationAckPacket processes an application ack packet
@@ -310,13 +368,13 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 
 		// Since we adjust sequence numbers let's make sure we haven't made a mistake
 		if len(token) != int(d.ackSize) {
-			return nil, fmt.Errorf("Protocol Error %d", len(token))
+			return nil, dropErrorf(collector.InvalidToken, "Protocol Error %d", len(token))
 		}
 
 		// Attach the tags to the packet
 		tcpPacket.DecreaseTCPSeq(d.ackSize)
 		if err := tcpPacket.TCPDataAttach(tcpOptions, token); err != nil {
-			return nil, err
+			return nil, dropErrorf(collector.InvalidFormat, "Unable to attach the token: %s", err)
 		}
 		tcpPacket.UpdateTCPChecksum()
 
@@ -350,7 +408,7 @@ func (d *Datapath) processApplicationAckPacket(tcpPacket *packet.Packet, context
 		return nil, nil
 	}
 
-	return nil, fmt.Errorf("Received application ACK packet in the wrong state! %v", conn.GetState())
+	return nil, dropErrorf(collector.InvalidState, "Received application ACK packet in the wrong state! %v", conn.GetState())
 }
 
 // processNetworkTCPPacket processes a network TCP packet and dispatches it to different methods based on the flags
@@ -375,24 +433,28 @@ func (d *Datapath) processNetworkTCPPacket(tcpPacket *packet.Packet, context *PU
 
 // processNetworkSynPacket processes a syn packet arriving from the network
 func (d *Datapath) processNetworkSynPacket(context *PUContext, conn *connection.TCPConnection, tcpPacket *packet.Packet) (interface{}, error) {
+
+	// The token is verified before the PU is locked, so that the verification
+	// does not hold back the other packets of the PU
+	claims, err := d.verifySynToken(context, conn, tcpPacket)
+
 	context.Lock()
 	defer context.Unlock()
-	// Decode the JWT token using the context key
-	claims, err := d.parsePacketToken(&conn.Auth, tcpPacket.ReadTCPData())
 
-	// If the token signature is not valid or there are no claims
-	// we must drop the connection and we drop the Syn packet. The source will
-	// retry but we have no state to maintain here.
-	if err != nil || claims == nil {
-		d.reportRejectedFlow(tcpPacket, conn, "", context.ManagementID, context, collector.InvalidToken)
-		return nil, fmt.Errorf("Syn packet dropped because of invalid token %v %+v", err, claims)
+	if err != nil {
+		reason := dropReason(err, collector.InvalidToken)
+		if reason == collector.Throttled {
+			d.reportThrottledFlow(tcpPacket, conn, context)
+		} else {
+			d.reportRejectedFlow(tcpPacket, conn, "", context.ManagementID, context, reason)
+		}
+		return nil, err
 	}
 
 	txLabel, ok := claims.T.Get(TransmitterLabel)
-	if err := tcpPacket.CheckTCPAuthenticationOption(TCPAuthenticationOptionBaseLen); !ok || err != nil {
-
+	if !ok {
 		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.InvalidFormat)
-		return nil, fmt.Errorf("TCP Authentication Option not found %v", err)
+		return nil, dropErrorf(collector.InvalidFormat, "Syn packet dropped because of missing transmitter label")
 	}
 
 	// Remove any of our data from the packet. No matter what we don't need the
@@ -402,7 +464,7 @@ func (d *Datapath) processNetworkSynPacket(context *PUContext, conn *connection.
 
 	if err := tcpPacket.TCPDataDetach(TCPAuthenticationOptionBaseLen); err != nil {
 		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.InvalidFormat)
-		return nil, fmt.Errorf("Syn packet dropped because of invalid format %v", err)
+		return nil, dropErrorf(collector.InvalidFormat, "Syn packet dropped because of invalid format %v", err)
 	}
 
 	tcpPacket.DropDetachedBytes()
@@ -412,30 +474,103 @@ func (d *Datapath) processNetworkSynPacket(context *PUContext, conn *connection.
 	// If all policies are restricted by port numbers this will allow port-specific policies
 	claims.T.Add(PortNumberLabelString, strconv.Itoa(int(tcpPacket.DestinationPort)))
 
-	// Validate against reject rules first - We always process reject with higher priority
-	if index, _ := context.RejectRcvRules.Search(claims.T); index >= 0 {
+	// Validate against reject rules first - We always process reject with higher priority.
+	// A PU in audit mode reports the connection and accepts it.
+	if index, action := searchPolicy(context, context.RejectRcvRules, claims.T); index >= 0 {
 		// Reject the connection
-		d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.PolicyDrop)
-		return nil, fmt.Errorf("Connection rejected because of policy %+v", claims.T)
+		explanation := lookup.ExplainDecision(claims.T, context.RejectRcvRules, context.AcceptRcvRules, index, -1)
+		if !d.reportLoggedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, context.RejectRcvRules, index, action, policyRejectAction(context), collector.PolicyDrop, claims.T, context.Identity, explanation) {
+			d.reportPolicyDrop(tcpPacket, conn, txLabel, context.ManagementID, context, explanation)
+		}
+		if !context.Audit {
+			return nil, dropErrorf(collector.PolicyDrop, "Connection rejected because of policy %+v", claims.T)
+		}
+		d.acceptNetworkSynPacket(conn, tcpPacket)
+		return nil, nil
 	}
 
 	// Search the policy rules for a matching rule.
-	if index, action := context.AcceptRcvRules.Search(claims.T); index >= 0 {
+	if index, action := searchPolicy(context, context.AcceptRcvRules, claims.T); index >= 0 {
+
+		// The rule requires encryption. The transmitter must have offered an
+		// ephemeral key and we respond with our own key in the SynAck. The key
+		// is kept for retransmitted Syn packets
+		if encryptionRequired(action) {
+			if len(conn.Auth.RemoteEphemeralKey) == 0 {
+				d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.InvalidEncryption)
+				return nil, dropErrorf(collector.InvalidEncryption, "Connection rejected because encryption is required %+v", claims.T)
+			}
+
+			if conn.Auth.EphemeralKey == nil {
+				if err := conn.Auth.GenerateEphemeralKey(); err != nil {
+					d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.InvalidEncryption)
+					return nil, dropErrorf(collector.InvalidEncryption, "Unable to generate an ephemeral key: %s", err)
+				}
+			}
+
+			conn.Encrypt = true
+		}
 
-		hash := tcpPacket.L4FlowHash()
-		// Update the connection state and store the Nonse send to us by the host.
-		// We use the nonse in the subsequent packets to achieve randomization.
-		conn.SetState(connection.TCPSynReceived)
-		// Note that if the connection exists already we will just end-up replicating it. No
-		// harm here.
-		d.networkConnectionTracker.AddOrUpdate(hash, conn)
+		d.reportLoggedFlow(tcpPacket, nil, txLabel, context.ManagementID, context, context.AcceptRcvRules, index, action, collector.FlowAccept, collector.NoDrop, claims.T, context.Identity, nil)
+
+		// Only the sources of accepted connections are known to the PU
+		d.handshakes.authenticated(context.ID, tcpPacket.SourceAddress.String())
 
 		// Accept the connection
+		d.acceptNetworkSynPacket(conn, tcpPacket)
 		return action, nil
 	}
 
-	d.reportRejectedFlow(tcpPacket, conn, txLabel, context.ManagementID, context, collector.PolicyDrop)
-	return nil, fmt.Errorf("No matched tags - reject %+v", claims.T)
+	d.reportPolicyDrop(tcpPacket, conn, txLabel, context.ManagementID, context, lookup.ExplainDecision(claims.T, context.RejectRcvRules, context.AcceptRcvRules, -1, -1))
+	if !context.Audit {
+		return nil, dropErrorf(collector.PolicyDrop, "No matched tags - reject %+v", claims.T)
+	}
+
+	d.acceptNetworkSynPacket(conn, tcpPacket)
+	return nil, nil
+}
+
+// acceptNetworkSynPacket tracks the connection of a Syn packet accepted by the receiver
+func (d *Datapath) acceptNetworkSynPacket(conn *connection.TCPConnection, tcpPacket *packet.Packet) {
+
+	hash := tcpPacket.L4FlowHash()
+	// Update the connection state and store the Nonse send to us by the host.
+	// We use the nonse in the subsequent packets to achieve randomization.
+	conn.SetState(connection.TCPSynReceived)
+	// Note that if the connection exists already we will just end-up replicating it. No
+	// harm here.
+	d.networkConnectionTracker.AddOrUpdate(hash, conn)
+}
+
+// verifySynToken verifies the token of a Syn packet from the network and
+// returns its claims. It does not need the lock of the PU.
+func (d *Datapath) verifySynToken(context *PUContext, conn *connection.TCPConnection, tcpPacket *packet.Packet) (*tokens.ConnectionClaims, error) {
+
+	// Reject the packets without a token before the expensive verification
+	tcpData := tcpPacket.ReadTCPData()
+	if len(tcpData) == 0 {
+		return nil, dropErrorf(collector.MissingToken, "Syn packet dropped because of missing token")
+	}
+
+	if err := tcpPacket.CheckTCPAuthenticationOption(TCPAuthenticationOptionBaseLen); err != nil {
+		return nil, dropErrorf(collector.InvalidFormat, "TCP Authentication Option not found %v", err)
+	}
+
+	if !d.handshakes.allow(context.ID, tcpPacket.SourceAddress.String(), time.Now()) {
+		return nil, dropErrorf(collector.Throttled, "Syn packet dropped because the rate of handshakes is exceeded")
+	}
+
+	// Decode the JWT token using the context key
+	claims, err := d.parsePacketToken(context, &conn.Auth, tcpData)
+
+	// If the token signature is not valid or there are no claims
+	// we must drop the connection and we drop the Syn packet. The source will
+	// retry but we have no state to maintain here.
+	if err != nil || claims == nil {
+		return nil, dropErrorf(dropReason(err, collector.InvalidToken), "Syn packet dropped because of invalid token %v %+v", err, claims)
+	}
+
+	return claims, nil
 }
 
 // processNetworkSynAckPacket processes a SynAck packet arriving from the network
@@ -446,21 +581,22 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *connecti
 	tcpData := tcpPacket.ReadTCPData()
 	if len(tcpData) == 0 {
 		d.reportRejectedFlow(tcpPacket, nil, "", context.ManagementID, context, collector.MissingToken)
-		return nil, fmt.Errorf("SynAck packet dropped because of missing token")
+		return nil, dropErrorf(collector.MissingToken, "SynAck packet dropped because of missing token")
 	}
 
 	// Validate the certificate and parse the token
-	claims, cert := d.tokenEngine.Decode(false, tcpData, nil)
-	if claims == nil {
-		d.reportRejectedFlow(tcpPacket, nil, "", context.ManagementID, context, collector.MissingToken)
-		return nil, fmt.Errorf("Synack packet dropped because of bad claims %v", claims)
+	claims, cert, err := d.decodeToken(context, false, tcpData, nil)
+	if err != nil || claims == nil {
+		reason := tokenDropReason(err)
+		d.reportRejectedFlow(tcpPacket, nil, "", context.ManagementID, context, reason)
+		return nil, dropErrorf(reason, "Synack packet dropped because of bad claims %v", err)
 	}
 
 	// We always a need a valid remote context ID
 	remoteContextID, ok := claims.T.Get(TransmitterLabel)
 	if !ok {
 		d.reportRejectedFlow(tcpPacket, nil, "", context.ManagementID, context, collector.InvalidContext)
-		return nil, fmt.Errorf("No remote context %v", claims.T)
+		return nil, dropErrorf(collector.InvalidContext, "No remote context %v", claims.T)
 	}
 
 	// Stash connection
@@ -471,7 +607,7 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *connecti
 
 	if err := tcpPacket.CheckTCPAuthenticationOption(TCPAuthenticationOptionBaseLen); err != nil {
 		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, remoteContextID, context, collector.InvalidFormat)
-		return nil, fmt.Errorf("TCP Authentication Option not found")
+		return nil, dropErrorf(collector.InvalidFormat, "TCP Authentication Option not found")
 	}
 
 	// Remove any of our data
@@ -481,7 +617,7 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *connecti
 
 	if err := tcpPacket.TCPDataDetach(TCPAuthenticationOptionBaseLen); err != nil {
 		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, remoteContextID, context, collector.InvalidFormat)
-		return nil, fmt.Errorf("SynAck packet dropped because of invalid format")
+		return nil, dropErrorf(collector.InvalidFormat, "SynAck packet dropped because of invalid format")
 	}
 
 	tcpPacket.DropDetachedBytes()
@@ -489,20 +625,62 @@ func (d *Datapath) processNetworkSynAckPacket(context *PUContext, conn *connecti
 
 	// We can now verify the reverse policy. The system requires that policy
 	// is matched in both directions. We have to make this optional as it can
-	// become a very strong condition
+	// become a very strong condition. A PU in audit mode reports the
+	// connection and accepts it.
 
-	if index, _ := context.RejectTxtRules.Search(claims.T); d.mutualAuthorization && index >= 0 {
-		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, remoteContextID, context, collector.PolicyDrop)
-		return nil, fmt.Errorf("Dropping because of reject rule on transmitter")
+	if index, action := searchPolicy(context, context.RejectTxtRules, claims.T); d.mutualAuthorization && index >= 0 {
+		explanation := lookup.ExplainDecision(claims.T, context.RejectTxtRules, context.AcceptTxtRules, index, -1)
+		if !d.reportLoggedFlow(tcpPacket, conn, context.ManagementID, remoteContextID, context, context.RejectTxtRules, index, action, policyRejectAction(context), collector.PolicyDrop, context.Identity, claims.T, explanation) {
+			d.reportPolicyDrop(tcpPacket, conn, context.ManagementID, remoteContextID, context, explanation)
+		}
+		if !context.Audit {
+			return nil, dropErrorf(collector.PolicyDrop, "Dropping because of reject rule on transmitter")
+		}
+		return d.acceptNetworkSynAckPacket(context, conn, tcpPacket, claims, remoteContextID, -1, nil)
 	}
 
-	if index, action := context.AcceptTxtRules.Search(claims.T); !d.mutualAuthorization || index >= 0 {
-		conn.SetState(connection.TCPSynAckReceived)
-		return action, nil
+	if index, action := searchPolicy(context, context.AcceptTxtRules, claims.T); !d.mutualAuthorization || index >= 0 {
+		return d.acceptNetworkSynAckPacket(context, conn, tcpPacket, claims, remoteContextID, index, action)
 	}
 
-	d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, remoteContextID, context, collector.PolicyDrop)
-	return nil, fmt.Errorf("Dropping packet SYNACK at the network ")
+	d.reportPolicyDrop(tcpPacket, conn, context.ManagementID, remoteContextID, context, lookup.ExplainDecision(claims.T, context.RejectTxtRules, context.AcceptTxtRules, -1, -1))
+	if !context.Audit {
+		return nil, dropErrorf(collector.PolicyDrop, "Dropping packet SYNACK at the network ")
+	}
+
+	return d.acceptNetworkSynAckPacket(context, conn, tcpPacket, claims, remoteContextID, -1, nil)
+}
+
+// acceptNetworkSynAckPacket completes the negotiation of the encryption of a
+// connection accepted by the transmitter. The index and the action are the
+// ones of the matched accept rule, -1 and nil if no accept rule matched.
+func (d *Datapath) acceptNetworkSynAckPacket(context *PUContext, conn *connection.TCPConnection, tcpPacket *packet.Packet, claims *tokens.ConnectionClaims, remoteContextID string, index int, action interface{}) (interface{}, error) {
+
+	// The receiver encrypts the connection only if we offered a key. If our
+	// policy requires encryption the receiver must have accepted the offer
+	conn.Auth.RemoteEphemeralKey = claims.EK
+	conn.Encrypt = len(claims.EK) > 0
+
+	if (conn.Encrypt && conn.Auth.EphemeralKey == nil) || (!conn.Encrypt && index >= 0 && encryptionRequired(action)) {
+		d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, remoteContextID, context, collector.InvalidEncryption)
+		return nil, dropErrorf(collector.InvalidEncryption, "Dropping SynAck because encryption could not be negotiated")
+	}
+
+	if conn.Encrypt {
+		if err := d.installEncryptionState(conn, true, tcpPacket.L4ReverseFlowHash(), tcpPacket.L4FlowHash()); err != nil {
+			d.reportRejectedFlow(tcpPacket, conn, context.ManagementID, remoteContextID, context, collector.InvalidEncryption)
+			return nil, err
+		}
+	} else {
+		d.removeEncryptionState(tcpPacket.L4ReverseFlowHash(), tcpPacket.L4FlowHash())
+	}
+
+	if index >= 0 {
+		d.reportLoggedFlow(tcpPacket, nil, context.ManagementID, remoteContextID, context, context.AcceptTxtRules, index, action, collector.FlowAccept, collector.NoDrop, context.Identity, claims.T, nil)
+	}
+
+	conn.SetState(connection.TCPSynAckReceived)
+	return action, nil
 }
 
 // processNetworkAckPacket processes an Ack packet arriving from the network
@@ -517,12 +695,13 @@ func (d *Datapath) processNetworkAckPacket(context *PUContext, conn *connection.
 
 		if err := tcpPacket.CheckTCPAuthenticationOption(TCPAuthenticationOptionBaseLen); err != nil {
 			d.reportRejectedFlow(tcpPacket, conn, "", context.ManagementID, context, collector.InvalidFormat)
-			return nil, fmt.Errorf("TCP Authentication Option not found")
+			return nil, dropErrorf(collector.InvalidFormat, "TCP Authentication Option not found")
 		}
 
-		if _, err := d.parseAckToken(&conn.Auth, tcpPacket.ReadTCPData()); err != nil {
-			d.reportRejectedFlow(tcpPacket, conn, "", context.ManagementID, context, collector.InvalidFormat)
-			return nil, fmt.Errorf("Ack packet dropped because signature validation failed %v", err)
+		if _, err := d.parseAckToken(context, &conn.Auth, tcpPacket.ReadTCPData()); err != nil {
+			reason := dropReason(err, collector.InvalidToken)
+			d.reportRejectedFlow(tcpPacket, conn, "", context.ManagementID, context, reason)
+			return nil, dropErrorf(reason, "Ack packet dropped because signature validation failed %v", err)
 		}
 
 		// Remove any of our data - adjust the sequence numbers
@@ -530,13 +709,23 @@ func (d *Datapath) processNetworkAckPacket(context *PUContext, conn *connection.
 
 		if err := tcpPacket.TCPDataDetach(TCPAuthenticationOptionBaseLen); err != nil {
 			d.reportRejectedFlow(tcpPacket, conn, "", context.ManagementID, context, collector.InvalidFormat)
-			return nil, fmt.Errorf("Ack packet dropped because of invalid format %v", err)
+			return nil, dropErrorf(collector.InvalidFormat, "Ack packet dropped because of invalid format %v", err)
 		}
 
 		tcpPacket.DropDetachedBytes()
 
 		tcpPacket.UpdateTCPChecksum()
 
+		// The payload of the connection is encrypted from now on
+		if conn.Encrypt {
+			if err := d.installEncryptionState(conn, false, tcpPacket.L4ReverseFlowHash(), hash); err != nil {
+				d.reportRejectedFlow(tcpPacket, conn, "", context.ManagementID, context, collector.InvalidEncryption)
+				return nil, err
+			}
+		} else {
+			d.removeEncryptionState(tcpPacket.L4ReverseFlowHash(), hash)
+		}
+
 		// We accept the packet as a new flow
 		d.reportAcceptedFlow(tcpPacket, conn, conn.Auth.RemoteContextID, context.ManagementID, context)
 
@@ -557,7 +746,7 @@ func (d *Datapath) processNetworkAckPacket(context *PUContext, conn *connection.
 		zap.String("net-conn", hash),
 	)
 
-	return nil, fmt.Errorf("Ack packet dropped - Invalid State: %v", conn.GetState())
+	return nil, dropErrorf(collector.InvalidState, "Ack packet dropped - Invalid State: %v", conn.GetState())
 }
 
 // createPacketToken creates the authentication token
@@ -570,49 +759,78 @@ func (d *Datapath) createPacketToken(ackToken bool, context *PUContext, auth *co
 
 	if !ackToken {
 		claims.T = context.Identity
+		claims.EK = auth.EphemeralPublicKey
 	}
 
+	return d.signToken(ackToken, context, claims)
+}
+
+// signToken signs the claims. The time it takes is recorded for the PU.
+func (d *Datapath) signToken(ackToken bool, context *PUContext, claims *tokens.ConnectionClaims) []byte {
+
+	defer context.metrics.tokenSign.ObserveSince(time.Now())
+
 	return d.tokenEngine.CreateAndSign(ackToken, claims)
 }
 
+// decodeToken decodes a token and verifies its signature. The time it takes is
+// recorded for the PU. The token engines that do not report why a token is
+// rejected return a generic error.
+func (d *Datapath) decodeToken(context *PUContext, isAck bool, data []byte, publicKey interface{}) (*tokens.ConnectionClaims, interface{}, error) {
+
+	defer context.metrics.tokenVerify.ObserveSince(time.Now())
+
+	if verifier, ok := d.tokenEngine.(tokens.TokenVerifier); ok {
+		return verifier.Verify(isAck, data, publicKey)
+	}
+
+	claims, cert := d.tokenEngine.Decode(isAck, data, publicKey)
+	if claims == nil {
+		return nil, nil, fmt.Errorf("Invalid token")
+	}
+
+	return claims, cert, nil
+}
+
 // parsePacketToken parses the packet token and populates the right state.
 // Returns an error if the token cannot be parsed or the signature fails
-func (d *Datapath) parsePacketToken(auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error) {
+func (d *Datapath) parsePacketToken(context *PUContext, auth *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error) {
 
 	// Validate the certificate and parse the token
-	claims, cert := d.tokenEngine.Decode(false, data, auth.RemotePublicKey)
-	if claims == nil {
-		return nil, fmt.Errorf("Cannot decode the token")
+	claims, cert, err := d.decodeToken(context, false, data, auth.RemotePublicKey)
+	if err != nil || claims == nil {
+		return nil, dropErrorf(tokenDropReason(err), "Cannot decode the token: %v", err)
 	}
 
 	// We always a need a valid remote context ID
 	remoteContextID, ok := claims.T.Get(TransmitterLabel)
 	if !ok {
-		return nil, fmt.Errorf("No Transmitter Label ")
+		return nil, dropErrorf(collector.InvalidContext, "No Transmitter Label ")
 	}
 
 	auth.RemotePublicKey = cert
 	auth.RemoteContext = claims.LCL
 	auth.RemoteContextID = remoteContextID
+	auth.RemoteEphemeralKey = claims.EK
 
 	return claims, nil
 }
 
 // parseAckToken parses the tokens in Ack packets. They don't carry all the state context
 // and it needs to be recovered
-func (d *Datapath) parseAckToken(connection *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error) {
+func (d *Datapath) parseAckToken(context *PUContext, connection *connection.AuthInfo, data []byte) (*tokens.ConnectionClaims, error) {
 
 	// Validate the certificate and parse the token
-	claims, _ := d.tokenEngine.Decode(true, data, connection.RemotePublicKey)
-	if claims == nil {
-		return nil, fmt.Errorf("Cannot decode the token")
+	claims, _, err := d.decodeToken(context, true, data, connection.RemotePublicKey)
+	if err != nil || claims == nil {
+		return nil, dropErrorf(tokenDropReason(err), "Cannot decode the token: %v", err)
 	}
 
 	// Compare the incoming random context with the stored context
 	matchLocal := bytes.Compare(claims.RMT, connection.LocalContext)
 	matchRemote := bytes.Compare(claims.LCL, connection.RemoteContext)
 	if matchLocal != 0 || matchRemote != 0 {
-		return nil, fmt.Errorf("Failed to match context in ACK packet")
+		return nil, dropErrorf(collector.InvalidNonse, "Failed to match context in ACK packet")
 	}
 
 	return claims, nil
@@ -656,7 +874,7 @@ func (d *Datapath) appRetrieveState(tcpPacket *packet.Packet) (*PUContext, *conn
 			zap.String("port", contextPort),
 			zap.Error(cerr),
 		)
-		return nil, nil, fmt.Errorf("No Context in App Processing")
+		return nil, nil, cerr
 	}
 
 	// Find the connection state
@@ -665,7 +883,7 @@ func (d *Datapath) appRetrieveState(tcpPacket *packet.Packet) (*PUContext, *conn
 		hash := tcpPacket.L4FlowHash()
 		conn, err := d.appConnectionTracker.Get(hash)
 		if err != nil {
-			conn = connection.NewTCPConnection(false)
+			conn = newTCPConnection(context, tcpPacket, false)
 		}
 		conn.(*connection.TCPConnection).SetPacketInfo(hash, packet.TCPFlagsToStr(tcpPacket.TCPFlags))
 		return context, conn.(*connection.TCPConnection), nil
@@ -703,7 +921,7 @@ func (d *Datapath) netRetrieveState(p *packet.Packet) (*PUContext, *connection.T
 			zap.String("Flags", packet.TCPFlagsToStr(p.TCPFlags)),
 			zap.Error(cerr),
 		)
-		return nil, nil, fmt.Errorf("No context for packet")
+		return nil, nil, cerr
 	}
 
 	// Find the connection state
@@ -712,7 +930,7 @@ func (d *Datapath) netRetrieveState(p *packet.Packet) (*PUContext, *connection.T
 		hash := p.L4FlowHash()
 		conn, err := d.networkConnectionTracker.Get(hash)
 		if err != nil {
-			conn = connection.NewTCPConnection(true)
+			conn = newTCPConnection(cachedContext, p, true)
 		}
 		conn.(*connection.TCPConnection).SetPacketInfo(hash, packet.TCPFlagsToStr(p.TCPFlags))
 		return cachedContext, conn.(*connection.TCPConnection), nil
@@ -729,6 +947,20 @@ func (d *Datapath) netRetrieveState(p *packet.Packet) (*PUContext, *connection.T
 	return nil, nil, nil
 }
 
+// newTCPConnection creates the state of a connection of a PU from the packet
+// that starts it
+func newTCPConnection(context *PUContext, p *packet.Packet, trackFlowReporting bool) *connection.TCPConnection {
+
+	conn := connection.NewTCPConnection(trackFlowReporting)
+	conn.ContextID = context.ID
+	conn.SourceAddress = p.SourceAddress.String()
+	conn.DestinationAddress = p.DestinationAddress.String()
+	conn.SourcePort = p.SourcePort
+	conn.DestinationPort = p.DestinationPort
+
+	return conn
+}
+
 // netRetrieveSynAckState retrieves context and connection state for SynAck
 // packets. This is done using flow caches even for policy context
 // Dealing with all variations of NAT here since we want to maintain support
@@ -747,7 +979,7 @@ func (d *Datapath) netRetrieveSynAckState(p *packet.Packet) (*PUContext, *connec
 		zap.L().Debug("No connection for SynAck packet ",
 			zap.String("flow", p.L4FlowHash()),
 		)
-		return nil, nil, fmt.Errorf("No Synack Connection")
+		return nil, nil, dropErrorf(collector.InvalidConnection, "No Synack Connection")
 	}
 
 	return cachedContext.(*PUContext), cachedConn.(*connection.TCPConnection), nil
@@ -766,7 +998,7 @@ func (d *Datapath) contextFromIP(app bool, packetIP string, mark string, port st
 	}
 
 	if err != nil && d.mode == constants.LocalContainer {
-		return nil, fmt.Errorf("IP must be always populated to local containers")
+		return nil, dropErrorf(collector.InvalidContext, "IP must be always populated to local containers")
 	}
 
 	// Look for context based on the default IP
@@ -778,14 +1010,37 @@ func (d *Datapath) contextFromIP(app bool, packetIP string, mark string, port st
 	if app {
 		pu, err = d.puFromMark.Get(mark)
 		if err != nil {
-			return nil, fmt.Errorf("PU context cannot be found using mark %v ", mark)
+			return nil, dropErrorf(collector.InvalidContext, "PU context cannot be found using mark %v", mark)
 		}
 		return pu.(*PUContext), nil
 	}
 
 	pu, err = d.puFromPort.Get(port)
 	if err != nil {
-		return nil, fmt.Errorf("PU Context cannot be found using port key %v ", port)
+		if pu, err = d.contextFromPortRange(port); err != nil {
+			return nil, dropErrorf(collector.InvalidContext, "PU Context cannot be found using port key %v", port)
+		}
 	}
 	return pu.(*PUContext), nil
 }
+
+// contextFromPortRange returns the PU context of the Linux process that
+// listens on a range of ports that contains the port
+func (d *Datapath) contextFromPortRange(port string) (interface{}, error) {
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid port %s", port)
+	}
+
+	d.portRangesLock.RLock()
+	defer d.portRangesLock.RUnlock()
+
+	for _, r := range d.portRanges {
+		if r.ports.Contains(uint16(p)) {
+			return r.pu, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No range contains port %s", port)
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/aporeto-inc/trireme/enforcer/connection"
 	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
 	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+	"github.com/aporeto-inc/trireme/metrics"
 	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
 	"github.com/aporeto-inc/trireme/policy"
 	. "github.com/smartystreets/goconvey/convey"
@@ -467,6 +468,213 @@ func TestPacketHandlingDstPortCacheBehavior(t *testing.T) {
 	})
 }
 
+func TestConnections(t *testing.T) {
+	Convey("Given I create a new enforcer instance with two processing units", t, func() {
+		puInfo1, puInfo2, enforcer, err1, err2 := setupProcessingUnitsInDatapathAndEnforce()
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When I pass a syn packet through the enforcer", func() {
+			tcpPacket := selectPacket(0, t)[1]
+			So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+
+			output := make([]byte, len(tcpPacket.GetBytes()))
+			copy(output, tcpPacket.GetBytes())
+			outPacket, err := packet.New(0, output, "0")
+			So(err, ShouldBeNil)
+			So(enforcer.processNetworkTCPPackets(outPacket), ShouldBeNil)
+
+			connections1, err1 := enforcer.Connections(puInfo1.ContextID)
+			connections2, err2 := enforcer.Connections(puInfo2.ContextID)
+
+			Convey("Then I should get the connection tracked on each side", func() {
+				So(err1, ShouldBeNil)
+				So(err2, ShouldBeNil)
+
+				connections := append(connections1, connections2...)
+				So(connections, ShouldHaveLength, 2)
+
+				app, net := connections[0], connections[1]
+				if !app.Application {
+					app, net = net, app
+				}
+
+				So(app.Application, ShouldBeTrue)
+				So(app.State, ShouldEqual, connection.TCPSynSend)
+				So(app.Protocol, ShouldEqual, "tcp")
+				So(app.SourceAddress, ShouldEqual, tcpPacket.SourceAddress.String())
+				So(app.DestinationAddress, ShouldEqual, tcpPacket.DestinationAddress.String())
+				So(app.SourcePort, ShouldEqual, tcpPacket.SourcePort)
+				So(app.DestinationPort, ShouldEqual, tcpPacket.DestinationPort)
+
+				So(net.Application, ShouldBeFalse)
+				So(net.State, ShouldEqual, connection.TCPSynReceived)
+				So(net.State.String(), ShouldEqual, "TCPSynReceived")
+				So(net.RemoteContextID, ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("When I ask for the connections of an unknown context", func() {
+			_, err := enforcer.Connections("unknown")
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+// findSample returns the sample of a metric of the default registry with the label values
+func findSample(name string, labelValues ...string) *metrics.Sample {
+
+	for _, f := range metrics.DefaultRegistry.Gather() {
+		if f.Name != name {
+			continue
+		}
+		for _, s := range f.Samples {
+			matched := len(s.Labels) >= len(labelValues)
+			for i := 0; matched && i < len(labelValues); i++ {
+				matched = s.Labels[i].Value == labelValues[i]
+			}
+			if matched {
+				return s
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestMetrics(t *testing.T) {
+	Convey("Given I create a new enforcer instance with two processing units", t, func() {
+		puInfo1, puInfo2, enforcer, err1, err2 := setupProcessingUnitsInDatapathAndEnforce()
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		Convey("When I pass a syn packet through the enforcer", func() {
+			tcpPacket := selectPacket(0, t)[1]
+			So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+
+			output := make([]byte, len(tcpPacket.GetBytes()))
+			copy(output, tcpPacket.GetBytes())
+			outPacket, err := packet.New(0, output, "0")
+			So(err, ShouldBeNil)
+			So(enforcer.processNetworkTCPPackets(outPacket), ShouldBeNil)
+
+			Convey("Then the token and the policy lookup latencies should be recorded for the PUs", func() {
+				signed := findSample("trireme_enforcer_token_seconds", puInfo1.ContextID, tokenSign)
+				if signed == nil {
+					signed = findSample("trireme_enforcer_token_seconds", puInfo2.ContextID, tokenSign)
+				}
+				So(signed, ShouldNotBeNil)
+				So(signed.Count, ShouldBeGreaterThan, 0)
+
+				verified := findSample("trireme_enforcer_token_seconds", puInfo1.ContextID, tokenVerify)
+				if verified == nil {
+					verified = findSample("trireme_enforcer_token_seconds", puInfo2.ContextID, tokenVerify)
+				}
+				So(verified, ShouldNotBeNil)
+
+				lookup := findSample("trireme_enforcer_policy_lookup_seconds", puInfo1.ContextID)
+				if lookup == nil {
+					lookup = findSample("trireme_enforcer_policy_lookup_seconds", puInfo2.ContextID)
+				}
+				So(lookup, ShouldNotBeNil)
+			})
+
+			Convey("Then the samples of a PU should be removed when it is unenforced", func() {
+				So(enforcer.Unenforce(puInfo1.ContextID), ShouldBeNil)
+				So(enforcer.Unenforce(puInfo2.ContextID), ShouldBeNil)
+				So(findSample("trireme_enforcer_token_seconds", puInfo1.ContextID), ShouldBeNil)
+				So(findSample("trireme_enforcer_token_seconds", puInfo2.ContextID), ShouldBeNil)
+			})
+		})
+
+		Convey("When I pass a syn packet of a PU that is not enforced anymore", func() {
+			before := 0.0
+			if s := findSample("trireme_enforcer_dropped_packets_total", "", metricsApplication, "tcp", string(collector.InvalidContext)); s != nil {
+				before = s.Value
+			}
+
+			So(enforcer.Unenforce(puInfo1.ContextID), ShouldBeNil)
+			So(enforcer.Unenforce(puInfo2.ContextID), ShouldBeNil)
+
+			tcpPacket := selectPacket(0, t)[1]
+			err := enforcer.processApplicationTCPPackets(tcpPacket)
+			So(err, ShouldNotBeNil)
+			So(dropReason(err, collector.NoDrop), ShouldEqual, collector.InvalidContext)
+
+			Convey("Then the drop should be counted with its reason", func() {
+				s := findSample("trireme_enforcer_dropped_packets_total", "", metricsApplication, "tcp", string(collector.InvalidContext))
+				So(s, ShouldNotBeNil)
+				So(s.Value, ShouldEqual, before+1)
+			})
+		})
+
+		Convey("When I gather the metrics", func() {
+			remove := metrics.DefaultRegistry.OnGather(enforcer.updateTrackerSizes)
+			defer remove()
+
+			s := findSample("trireme_enforcer_tracked_entries", "contexts")
+
+			Convey("Then the size of the context tracker should be set", func() {
+				So(s, ShouldNotBeNil)
+				So(s.Value, ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestDropReasons(t *testing.T) {
+	Convey("Given errors returned by the datapath", t, func() {
+
+		Convey("Then a drop error should carry its reason", func() {
+			So(dropReason(dropErrorf(collector.PolicyDrop, "rejected %d", 1), collector.InvalidState), ShouldEqual, collector.PolicyDrop)
+			So(dropErrorf(collector.PolicyDrop, "rejected %d", 1).Error(), ShouldEqual, "rejected 1")
+		})
+
+		Convey("Then the other errors should get the default reason", func() {
+			So(dropReason(fmt.Errorf("failed"), collector.InvalidState), ShouldEqual, collector.InvalidState)
+		})
+
+		Convey("Then expired tokens should be told apart from invalid tokens", func() {
+			So(tokenDropReason(tokens.ErrTokenExpired), ShouldEqual, collector.ExpiredToken)
+			So(tokenDropReason(fmt.Errorf("Invalid token")), ShouldEqual, collector.InvalidToken)
+			So(tokenDropReason(nil), ShouldEqual, collector.InvalidToken)
+		})
+	})
+}
+
+func TestSynThrottling(t *testing.T) {
+	Convey("Given I create a new enforcer instance that authenticates one syn packet per source", t, func() {
+		_, _, enforcer, err1, err2 := setupProcessingUnitsInDatapathAndEnforce()
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		enforcer.handshakes = newHandshakeLimiter(&FilterQueue{SynRatePerSource: 1}, DefaultMaxTrackedConnections)
+
+		Convey("When I pass the same syn packet twice through the enforcer", func() {
+			tcpPacket := selectPacket(0, t)[1]
+			So(enforcer.processApplicationTCPPackets(tcpPacket), ShouldBeNil)
+
+			output := make([]byte, len(tcpPacket.GetBytes()))
+			copy(output, tcpPacket.GetBytes())
+			outPacket, err := packet.New(0, output, "0")
+			So(err, ShouldBeNil)
+			retransmitted, err := packet.New(0, append([]byte{}, output...), "0")
+			So(err, ShouldBeNil)
+
+			So(enforcer.processNetworkTCPPackets(outPacket), ShouldBeNil)
+			err = enforcer.processNetworkTCPPackets(retransmitted)
+
+			Convey("Then the second one should be throttled", func() {
+				So(err, ShouldNotBeNil)
+				So(dropReason(err, collector.NoDrop), ShouldEqual, collector.Throttled)
+			})
+		})
+	})
+}
+
 func TestConnectionTrackerStateLocalContainer(t *testing.T) {
 	Convey("Given I create a new enforcer instance and have a valid processing unit context", t, func() {
 		Convey("Given I create a two processing unit instances", func() {
@@ -880,6 +1088,76 @@ func TestDoCreatePU(t *testing.T) {
 	})
 }
 
+func TestDoUpdatePU(t *testing.T) {
+
+	selector := func(app string, action policy.FlowAction, id string) policy.TagSelector {
+		return policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "app", Value: []string{app}, Operator: policy.Equal},
+			},
+			Action: action,
+			ID:     id,
+		}
+	}
+
+	Convey("Given an initialized enforcer with a PU", t, func() {
+		secret := tokens.NewPSKSecrets([]byte("Dummy Test Password"))
+		collector := &collector.DefaultCollector{}
+		enforcer := NewWithDefaults("SomeServerId", collector, nil, secret, constants.LocalContainer, "/proc").(*Datapath)
+		enforcer.mode = constants.RemoteContainer
+
+		contextID := "123"
+		puInfo := policy.NewPUInfo(contextID, constants.ContainerPU)
+		puInfo.Policy = policy.NewPUPolicy(contextID, policy.Police, nil, nil, nil,
+			policy.NewTagSelectorList([]policy.TagSelector{
+				selector("web", policy.Accept, "web"),
+				selector("bad", policy.Reject, "bad"),
+			}), nil, nil, nil, nil, nil, nil)
+		So(enforcer.doCreatePU(contextID, puInfo), ShouldBeNil)
+
+		pu, err := enforcer.contextTracker.Get(contextID)
+		So(err, ShouldBeNil)
+		puContext := pu.(*PUContext)
+		acceptRules := puContext.AcceptRcvRules
+
+		Convey("When I update the PU with a few different rules", func() {
+			puInfo.Policy = policy.NewPUPolicy(contextID, policy.Police, nil, nil, nil,
+				policy.NewTagSelectorList([]policy.TagSelector{
+					selector("web", policy.Accept, "web"),
+					selector("db", policy.Accept, "db"),
+				}), nil, nil, nil, nil, nil, nil)
+			So(enforcer.doUpdatePU(puContext, puInfo), ShouldBeNil)
+
+			Convey("Then the rules should be changed in the same databases", func() {
+				So(puContext.AcceptRcvRules, ShouldEqual, acceptRules)
+				So(puContext.AcceptRcvRules.Selectors(), ShouldHaveLength, 2)
+				So(puContext.RejectRcvRules.Selectors(), ShouldBeEmpty)
+
+				index, _ := puContext.AcceptRcvRules.Search(policy.NewTagsMap(map[string]string{"app": "db"}))
+				So(puContext.AcceptRcvRules.PolicyID(index), ShouldEqual, "db")
+				index, _ = puContext.RejectRcvRules.Search(policy.NewTagsMap(map[string]string{"app": "bad"}))
+				So(index, ShouldEqual, -1)
+			})
+		})
+
+		Convey("When I update the PU with many different rules", func() {
+			selectors := []policy.TagSelector{}
+			for i := 0; i <= policy.MaxIncrementalChanges; i++ {
+				selectors = append(selectors, selector(fmt.Sprintf("app%d", i), policy.Accept, ""))
+			}
+			puInfo.Policy = policy.NewPUPolicy(contextID, policy.Police, nil, nil, nil,
+				policy.NewTagSelectorList(selectors), nil, nil, nil, nil, nil, nil)
+			So(enforcer.doUpdatePU(puContext, puInfo), ShouldBeNil)
+
+			Convey("Then new databases should be created", func() {
+				So(puContext.AcceptRcvRules, ShouldNotEqual, acceptRules)
+				So(puContext.AcceptRcvRules.Selectors(), ShouldHaveLength, policy.MaxIncrementalChanges+1)
+				So(puContext.AcceptRcvRules.Removed(), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
 func TestContextFromIP(t *testing.T) {
 
 	Convey("Given an initialized enforcer for Linux Processes", t, func() {
@@ -950,6 +1228,30 @@ func TestContextFromIP(t *testing.T) {
 			})
 		})
 
+		Convey("If there is no IP match, it should try the port ranges for net packets ", func() {
+			context.Ports = []string{"8000:8080"}
+			enforcer.puFromPort.AddOrUpdate("8000:8080", context)
+			enforcer.addPortRanges(context)
+			enforcer.mode = constants.LocalServer
+
+			Convey("If a range contains the port", func() {
+				ctx, err := enforcer.contextFromIP(false, "20.1.1.1", "", "8042")
+				So(err, ShouldBeNil)
+				So(ctx, ShouldEqual, context)
+			})
+
+			Convey("If no range contains the port", func() {
+				_, err := enforcer.contextFromIP(false, "20.1.1.1", "", "8081")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("If the range was removed", func() {
+				enforcer.removePortRanges(context)
+				_, err := enforcer.contextFromIP(false, "20.1.1.1", "", "8042")
+				So(err, ShouldNotBeNil)
+			})
+		})
+
 	})
 }
 
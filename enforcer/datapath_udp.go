@@ -0,0 +1,412 @@
+package enforcer
+
+// Go libraries
+import (
+	"encoding/binary"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/enforcer/connection"
+	"github.com/aporeto-inc/trireme/enforcer/lookup"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+)
+
+// UDP flows have no handshake. The application side attaches a signed token
+// at the end of the payload of every packet of a new flow, until a reply is
+// received. The network side validates the token of the first packet against
+// the receiver rules and tracks the flow. Replies carry a token as well, so
+// that the transmitter rules can be validated when mutual authorization is
+// required. Tokens are attached as a trailer:
+//
+//   | payload | token | token length (2 bytes) | UDPAuthenticationMarker |
+//
+// Tokens are bound to the flow of the packet, so that they cannot be replayed
+// from another source. They are not attached to the packets that would exceed
+// the MTU with the trailer, and these packets are dropped.
+
+// processNetworkUDPPackets processes UDP packets arriving from network and are destined to the application
+func (d *Datapath) processNetworkUDPPackets(p *packet.Packet) error {
+
+	zap.L().Debug("Processing network UDP packet",
+		zap.String("flow", p.L4FlowHash()),
+	)
+
+	d.netUDP.IncomingPackets++
+	p.Print(packet.PacketStageIncoming)
+
+	p.Print(packet.PacketStageAuth)
+
+	if context, err := d.processNetworkUDPPacket(p); err != nil {
+		zap.L().Debug("Dropping UDP packet",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+		reason := dropReason(err, collector.InvalidState)
+		d.netUDP.AuthDropPackets++
+		countDrop(context, metricsNetwork, "udp", reason)
+		p.Print(packet.PacketFailureAuth)
+		return dropErrorf(reason, "Packet processing failed for network UDP packet: %s", err.Error())
+	}
+
+	// Accept the packet
+	d.netUDP.OutgoingPackets++
+	p.Print(packet.PacketStageOutgoing)
+	return nil
+}
+
+// processApplicationUDPPackets processes UDP packets arriving from an application and are destined to the network
+func (d *Datapath) processApplicationUDPPackets(p *packet.Packet) error {
+
+	zap.L().Debug("Processing application UDP packet",
+		zap.String("flow", p.L4FlowHash()),
+	)
+
+	d.appUDP.IncomingPackets++
+	p.Print(packet.PacketStageIncoming)
+
+	p.Print(packet.PacketStageAuth)
+
+	if context, err := d.processApplicationUDPPacket(p); err != nil {
+		zap.L().Debug("Dropping UDP packet",
+			zap.String("flow", p.L4FlowHash()),
+			zap.Error(err),
+		)
+		reason := dropReason(err, collector.InvalidState)
+		d.appUDP.AuthDropPackets++
+		countDrop(context, metricsApplication, "udp", reason)
+		p.Print(packet.PacketFailureAuth)
+		return dropErrorf(reason, "Processing failed for application UDP packet: %s", err.Error())
+	}
+
+	// Accept the packet
+	d.appUDP.OutgoingPackets++
+	p.Print(packet.PacketStageOutgoing)
+	return nil
+}
+
+// processNetworkUDPPacket dispatches a network UDP packet based on the flow state.
+// It returns the context of the PU of the packet when it is known.
+func (d *Datapath) processNetworkUDPPacket(udpPacket *packet.Packet) (*PUContext, error) {
+
+	// Replies to a flow initiated by a local application
+	if item, err := d.appUDPConnectionTracker.Get(udpPacket.L4ReverseFlowHash()); err == nil {
+		conn := item.(*connection.UDPConnection)
+		conn.Lock()
+		defer conn.Unlock()
+
+		context, err := d.udpFlowContext(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		return context, d.processNetworkUDPReplyPacket(udpPacket, context, conn)
+	}
+
+	// Packets of a flow that has already been authorized
+	if item, err := d.netUDPConnectionTracker.Get(udpPacket.L4FlowHash()); err == nil {
+		conn := item.(*connection.UDPConnection)
+		conn.Lock()
+		defer conn.Unlock()
+
+		if !hasUDPToken(udpPacket) {
+			// The remote side stops sending tokens once it has received our reply
+			if conn.GetState() == connection.UDPReplySend {
+				conn.SetState(connection.UDPEstablished)
+			}
+			return nil, nil
+		}
+
+		if _, err := detachUDPToken(udpPacket); err != nil {
+			context, _ := d.udpFlowContext(conn) // nolint
+			return context, err
+		}
+
+		return nil, nil
+	}
+
+	// First packet of a new flow
+	context, err := d.contextFromIP(false, udpPacket.DestinationAddress.String(), udpPacket.Mark, strconv.Itoa(int(udpPacket.DestinationPort)))
+	if err != nil {
+		return nil, err
+	}
+
+	return context, d.processNetworkUDPNewFlowPacket(udpPacket, context)
+}
+
+// processNetworkUDPNewFlowPacket validates the token of the first packet of a
+// flow against the receiver rules and starts tracking the flow if it is accepted
+func (d *Datapath) processNetworkUDPNewFlowPacket(udpPacket *packet.Packet, context *PUContext) error {
+
+	context.Lock()
+	defer context.Unlock()
+
+	if !hasUDPToken(udpPacket) {
+		d.reportRejectedFlow(udpPacket, nil, "", context.ManagementID, context, collector.MissingToken)
+		return dropErrorf(collector.MissingToken, "UDP packet dropped because of missing token")
+	}
+
+	token, err := detachUDPToken(udpPacket)
+	if err != nil {
+		d.reportRejectedFlow(udpPacket, nil, "", context.ManagementID, context, collector.InvalidFormat)
+		return dropErrorf(collector.InvalidFormat, "UDP packet dropped because of invalid format %v", err)
+	}
+
+	conn := connection.NewUDPConnection(context.ID)
+
+	// Decode the JWT token using the context key
+	claims, err := d.parsePacketToken(context, &conn.Auth, token)
+	if err != nil || claims == nil {
+		reason := dropReason(err, collector.InvalidToken)
+		d.reportRejectedFlow(udpPacket, nil, "", context.ManagementID, context, reason)
+		return dropErrorf(reason, "UDP packet dropped because of invalid token %v %+v", err, claims)
+	}
+
+	if claims.F != udpPacket.L4FlowHash() {
+		d.reportRejectedFlow(udpPacket, nil, "", context.ManagementID, context, collector.InvalidToken)
+		return dropErrorf(collector.InvalidToken, "UDP packet dropped because the token is bound to another flow")
+	}
+
+	// Add the port as a label so that port-specific policies apply to UDP as well
+	claims.T.Add(PortNumberLabelString, strconv.Itoa(int(udpPacket.DestinationPort)))
+
+	// Validate against reject rules first - We always process reject with higher priority.
+	// A PU in audit mode reports the flow and accepts it.
+	if index, action := searchPolicy(context, context.RejectRcvRules, claims.T); index >= 0 {
+		explanation := lookup.ExplainDecision(claims.T, context.RejectRcvRules, context.AcceptRcvRules, index, -1)
+		if !d.reportLoggedFlow(udpPacket, nil, conn.Auth.RemoteContextID, context.ManagementID, context, context.RejectRcvRules, index, action, policyRejectAction(context), collector.PolicyDrop, claims.T, context.Identity, explanation) {
+			d.reportPolicyDrop(udpPacket, nil, conn.Auth.RemoteContextID, context.ManagementID, context, explanation)
+		}
+		if !context.Audit {
+			return dropErrorf(collector.PolicyDrop, "UDP flow rejected because of policy %+v", claims.T)
+		}
+		d.acceptNetworkUDPFlow(udpPacket, conn)
+		return nil
+	}
+
+	if index, action := searchPolicy(context, context.AcceptRcvRules, claims.T); index >= 0 {
+		d.acceptNetworkUDPFlow(udpPacket, conn)
+		if !d.reportLoggedFlow(udpPacket, nil, conn.Auth.RemoteContextID, context.ManagementID, context, context.AcceptRcvRules, index, action, collector.FlowAccept, collector.NoDrop, claims.T, context.Identity, nil) {
+			d.reportAcceptedFlow(udpPacket, nil, conn.Auth.RemoteContextID, context.ManagementID, context)
+		}
+		return nil
+	}
+
+	d.reportPolicyDrop(udpPacket, nil, conn.Auth.RemoteContextID, context.ManagementID, context, lookup.ExplainDecision(claims.T, context.RejectRcvRules, context.AcceptRcvRules, -1, -1))
+	if !context.Audit {
+		return dropErrorf(collector.PolicyDrop, "No matched tags for UDP flow - reject %+v", claims.T)
+	}
+
+	d.acceptNetworkUDPFlow(udpPacket, conn)
+	return nil
+}
+
+// acceptNetworkUDPFlow starts tracking a flow accepted by the receiver
+func (d *Datapath) acceptNetworkUDPFlow(udpPacket *packet.Packet, conn *connection.UDPConnection) {
+
+	conn.SetState(connection.UDPTokenReceived)
+	d.netUDPConnectionTracker.AddOrUpdate(udpPacket.L4FlowHash(), conn)
+}
+
+// processNetworkUDPReplyPacket processes replies to a flow initiated by a local
+// application. The first reply must carry the token of the remote side.
+func (d *Datapath) processNetworkUDPReplyPacket(udpPacket *packet.Packet, context *PUContext, conn *connection.UDPConnection) error {
+
+	if conn.GetState() != connection.UDPTokenSend {
+		// The remote side may still attach tokens until it sees our packets without one
+		if !hasUDPToken(udpPacket) {
+			return nil
+		}
+		_, err := detachUDPToken(udpPacket)
+		return err
+	}
+
+	context.Lock()
+	defer context.Unlock()
+
+	if !hasUDPToken(udpPacket) {
+		d.reportRejectedFlow(udpPacket, nil, "", context.ManagementID, context, collector.MissingToken)
+		return dropErrorf(collector.MissingToken, "UDP reply dropped because of missing token")
+	}
+
+	token, err := detachUDPToken(udpPacket)
+	if err != nil {
+		d.reportRejectedFlow(udpPacket, nil, "", context.ManagementID, context, collector.InvalidFormat)
+		return dropErrorf(collector.InvalidFormat, "UDP reply dropped because of invalid format %v", err)
+	}
+
+	claims, err := d.parsePacketToken(context, &conn.Auth, token)
+	if err != nil || claims == nil {
+		reason := dropReason(err, collector.InvalidToken)
+		d.reportRejectedFlow(udpPacket, nil, "", context.ManagementID, context, reason)
+		return dropErrorf(reason, "UDP reply dropped because of invalid token %v %+v", err, claims)
+	}
+
+	if claims.F != udpPacket.L4FlowHash() {
+		d.reportRejectedFlow(udpPacket, nil, "", context.ManagementID, context, collector.InvalidToken)
+		return dropErrorf(collector.InvalidToken, "UDP reply dropped because the token is bound to another flow")
+	}
+
+	// We can now verify the reverse policy if mutual authorization is required.
+	// A PU in audit mode reports the flow and accepts it.
+	if index, action := searchPolicy(context, context.RejectTxtRules, claims.T); d.mutualAuthorization && index >= 0 {
+		explanation := lookup.ExplainDecision(claims.T, context.RejectTxtRules, context.AcceptTxtRules, index, -1)
+		if !d.reportLoggedFlow(udpPacket, nil, context.ManagementID, conn.Auth.RemoteContextID, context, context.RejectTxtRules, index, action, policyRejectAction(context), collector.PolicyDrop, context.Identity, claims.T, explanation) {
+			d.reportPolicyDrop(udpPacket, nil, context.ManagementID, conn.Auth.RemoteContextID, context, explanation)
+		}
+		if !context.Audit {
+			return dropErrorf(collector.PolicyDrop, "Dropping UDP reply because of reject rule on transmitter")
+		}
+		conn.SetState(connection.UDPEstablished)
+		return nil
+	}
+
+	if index, action := searchPolicy(context, context.AcceptTxtRules, claims.T); !d.mutualAuthorization || index >= 0 {
+		if index >= 0 {
+			d.reportLoggedFlow(udpPacket, nil, context.ManagementID, conn.Auth.RemoteContextID, context, context.AcceptTxtRules, index, action, collector.FlowAccept, collector.NoDrop, context.Identity, claims.T, nil)
+		}
+		conn.SetState(connection.UDPEstablished)
+		return nil
+	}
+
+	d.reportPolicyDrop(udpPacket, nil, context.ManagementID, conn.Auth.RemoteContextID, context, lookup.ExplainDecision(claims.T, context.RejectTxtRules, context.AcceptTxtRules, -1, -1))
+	if !context.Audit {
+		return dropErrorf(collector.PolicyDrop, "Dropping UDP reply at the network")
+	}
+
+	conn.SetState(connection.UDPEstablished)
+	return nil
+}
+
+// processApplicationUDPPacket attaches tokens to the packets of new flows and
+// to the replies of flows that have been authorized by the network side
+func (d *Datapath) processApplicationUDPPacket(udpPacket *packet.Packet) (*PUContext, error) {
+
+	// Replies to a flow authorized by the network side
+	if item, err := d.netUDPConnectionTracker.Get(udpPacket.L4ReverseFlowHash()); err == nil {
+		conn := item.(*connection.UDPConnection)
+		conn.Lock()
+		defer conn.Unlock()
+
+		if conn.GetState() == connection.UDPEstablished {
+			return nil, nil
+		}
+
+		context, err := d.udpFlowContext(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := d.attachUDPToken(udpPacket, context, conn); err != nil {
+			return context, err
+		}
+
+		conn.SetState(connection.UDPReplySend)
+		return context, nil
+	}
+
+	context, err := d.contextFromIP(true, udpPacket.SourceAddress.String(), udpPacket.Mark, strconv.Itoa(int(udpPacket.DestinationPort)))
+	if err != nil {
+		return nil, err
+	}
+
+	hash := udpPacket.L4FlowHash()
+
+	var conn *connection.UDPConnection
+	if item, err := d.appUDPConnectionTracker.Get(hash); err == nil {
+		conn = item.(*connection.UDPConnection)
+	} else {
+		conn = connection.NewUDPConnection(context.ID)
+		d.appUDPConnectionTracker.AddOrUpdate(hash, conn)
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	// Tokens are attached until the remote side replies
+	if conn.GetState() != connection.UDPTokenSend {
+		return context, nil
+	}
+
+	return context, d.attachUDPToken(udpPacket, context, conn)
+}
+
+// udpFlowContext returns the context of the PU that owns a UDP flow
+func (d *Datapath) udpFlowContext(conn *connection.UDPConnection) (*PUContext, error) {
+
+	context, err := d.contextTracker.Get(conn.ContextID)
+	if err != nil {
+		return nil, dropErrorf(collector.InvalidContext, "No context for UDP flow %s", conn.ContextID)
+	}
+
+	return context.(*PUContext), nil
+}
+
+// attachUDPToken creates a token bound to the flow of the packet and attaches
+// it at the end of the payload. The token is not attached if the packet would
+// exceed the MTU.
+func (d *Datapath) attachUDPToken(udpPacket *packet.Packet, context *PUContext, conn *connection.UDPConnection) error {
+
+	context.Lock()
+	token := d.signToken(false, context, &tokens.ConnectionClaims{
+		T:   context.Identity,
+		LCL: conn.Auth.LocalContext,
+		RMT: conn.Auth.RemoteContext,
+		EK:  conn.Auth.EphemeralPublicKey,
+		F:   udpPacket.L4FlowHash(),
+	})
+	context.Unlock()
+
+	mtu := d.filterQueue.MTU
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+
+	if size := int(udpPacket.IPTotalLength) + len(token) + UDPAuthenticationTrailerLen; size > mtu {
+		return dropErrorf(collector.PacketTooLarge, "UDP token not attached because the packet would exceed the MTU (len=%d mtu=%d)", size, mtu)
+	}
+
+	trailer := make([]byte, len(token)+UDPAuthenticationTrailerLen)
+	copy(trailer, token)
+	binary.BigEndian.PutUint16(trailer[len(token):], uint16(len(token)))
+	copy(trailer[len(token)+2:], UDPAuthenticationMarker)
+
+	return udpPacket.UDPDataAttach(trailer)
+}
+
+// hasUDPToken returns true if the payload of the packet ends with a token trailer
+func hasUDPToken(udpPacket *packet.Packet) bool {
+
+	data := udpPacket.ReadUDPData()
+	if len(data) < UDPAuthenticationTrailerLen {
+		return false
+	}
+
+	return string(data[len(data)-len(UDPAuthenticationMarker):]) == UDPAuthenticationMarker
+}
+
+// detachUDPToken removes the token trailer from the payload of the packet and
+// returns the token
+func detachUDPToken(udpPacket *packet.Packet) ([]byte, error) {
+
+	data := udpPacket.ReadUDPData()
+	if len(data) < UDPAuthenticationTrailerLen {
+		return nil, dropErrorf(collector.MissingToken, "No UDP token trailer")
+	}
+
+	tokenLen := int(binary.BigEndian.Uint16(data[len(data)-UDPAuthenticationTrailerLen:]))
+	if tokenLen+UDPAuthenticationTrailerLen > len(data) {
+		return nil, dropErrorf(collector.InvalidFormat, "Invalid UDP token length %d", tokenLen)
+	}
+
+	token := make([]byte, tokenLen)
+	copy(token, data[len(data)-UDPAuthenticationTrailerLen-tokenLen:])
+
+	if err := udpPacket.UDPDataDetach(uint16(tokenLen + UDPAuthenticationTrailerLen)); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,179 @@
+package enforcer
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/enforcer/connection"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// createUDPPacket builds an IPv4 UDP packet with valid checksums
+func createUDPPacket(src, dst string, sport, dport uint16, payload []byte) *packet.Packet {
+
+	buf := make([]byte, 28+len(payload))
+
+	buf[0] = 0x45
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+	buf[8] = 64
+	buf[9] = packet.IPProtocolUDP
+	copy(buf[12:16], net.ParseIP(src).To4())
+	copy(buf[16:20], net.ParseIP(dst).To4())
+
+	binary.BigEndian.PutUint16(buf[20:22], sport)
+	binary.BigEndian.PutUint16(buf[22:24], dport)
+	binary.BigEndian.PutUint16(buf[24:26], uint16(8+len(payload)))
+	copy(buf[28:], payload)
+
+	p, err := packet.New(0, buf, "0")
+	So(err, ShouldBeNil)
+
+	p.UpdateIPChecksum()
+	p.UpdateUDPChecksum()
+
+	return p
+}
+
+// transmitUDPPacket returns the packet as it will be seen by the network side
+func transmitUDPPacket(p *packet.Packet) *packet.Packet {
+
+	output := make([]byte, len(p.GetBytes()))
+	copy(output, p.GetBytes())
+
+	outPacket, err := packet.New(0, output, "0")
+	So(err, ShouldBeNil)
+
+	return outPacket
+}
+
+func TestUDPFlow(t *testing.T) {
+
+	Convey("Given I create a new enforcer instance and have two processing units", t, func() {
+
+		puInfo1, puInfo2, enforcer, err1, err2 := setupProcessingUnitsInDatapathAndEnforce()
+
+		So(puInfo1, ShouldNotBeNil)
+		So(puInfo2, ShouldNotBeNil)
+		So(err1, ShouldBeNil)
+		So(err2, ShouldBeNil)
+
+		client := "10.1.10.76"
+		server := "164.67.228.152"
+		request := []byte("request")
+		reply := []byte("reply")
+
+		Convey("When the client sends the first packet of a flow", func() {
+
+			appPacket := createUDPPacket(client, server, 5000, 53, request)
+			err := enforcer.processApplicationUDPPackets(appPacket)
+
+			Convey("Then a token should be attached to the packet", func() {
+				So(err, ShouldBeNil)
+				So(appPacket.IPTotalLength, ShouldBeGreaterThan, 28+len(request))
+			})
+
+			netPacket := transmitUDPPacket(appPacket)
+			So(hasUDPToken(netPacket), ShouldBeTrue)
+
+			err = enforcer.processNetworkUDPPackets(netPacket)
+
+			Convey("Then the server should accept the flow and receive the original payload", func() {
+				So(err, ShouldBeNil)
+				So(string(netPacket.ReadUDPData()), ShouldEqual, string(request))
+				So(netPacket.VerifyIPChecksum(), ShouldBeTrue)
+				So(netPacket.VerifyUDPChecksum(), ShouldBeTrue)
+
+				conn, err := enforcer.netUDPConnectionTracker.Get(netPacket.L4FlowHash())
+				So(err, ShouldBeNil)
+				So(conn.(*connection.UDPConnection).GetState(), ShouldEqual, connection.UDPTokenReceived)
+			})
+
+			Convey("When the server replies", func() {
+
+				replyPacket := createUDPPacket(server, client, 53, 5000, reply)
+				err := enforcer.processApplicationUDPPackets(replyPacket)
+				So(err, ShouldBeNil)
+
+				netReply := transmitUDPPacket(replyPacket)
+				So(hasUDPToken(netReply), ShouldBeTrue)
+
+				err = enforcer.processNetworkUDPPackets(netReply)
+
+				Convey("Then the client should accept the reply and stop sending tokens", func() {
+					So(err, ShouldBeNil)
+					So(string(netReply.ReadUDPData()), ShouldEqual, string(reply))
+
+					nextPacket := createUDPPacket(client, server, 5000, 53, request)
+					err := enforcer.processApplicationUDPPackets(nextPacket)
+					So(err, ShouldBeNil)
+					So(nextPacket.IPTotalLength, ShouldEqual, 28+len(request))
+
+					err = enforcer.processNetworkUDPPackets(transmitUDPPacket(nextPacket))
+					So(err, ShouldBeNil)
+
+					conn, err := enforcer.netUDPConnectionTracker.Get(nextPacket.L4FlowHash())
+					So(err, ShouldBeNil)
+					So(conn.(*connection.UDPConnection).GetState(), ShouldEqual, connection.UDPEstablished)
+				})
+			})
+		})
+
+		Convey("When a packet of a new flow arrives without a token", func() {
+
+			netPacket := createUDPPacket(client, server, 5001, 53, request)
+			err := enforcer.processNetworkUDPPackets(netPacket)
+
+			Convey("Then it should be dropped", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When the token of a flow is replayed in a packet of another flow", func() {
+
+			appPacket := createUDPPacket(client, server, 5003, 53, request)
+			So(enforcer.processApplicationUDPPackets(appPacket), ShouldBeNil)
+
+			replayed := createUDPPacket(client, server, 5004, 53, transmitUDPPacket(appPacket).ReadUDPData())
+			err := enforcer.processNetworkUDPPackets(replayed)
+
+			Convey("Then it should be dropped", func() {
+				So(err, ShouldNotBeNil)
+				So(dropReason(err, collector.NoDrop), ShouldEqual, collector.InvalidToken)
+			})
+		})
+
+		Convey("When the client sends a packet that would exceed the MTU with a token", func() {
+
+			appPacket := createUDPPacket(client, server, 5005, 53, make([]byte, DefaultMTU-28))
+			err := enforcer.processApplicationUDPPackets(appPacket)
+
+			Convey("Then the token should not be attached and the packet should be dropped", func() {
+				So(err, ShouldNotBeNil)
+				So(dropReason(err, collector.NoDrop), ShouldEqual, collector.PacketTooLarge)
+				So(appPacket.IPTotalLength, ShouldEqual, DefaultMTU)
+			})
+		})
+
+		Convey("When a packet of a new flow arrives with a corrupted token", func() {
+
+			appPacket := createUDPPacket(client, server, 5002, 53, request)
+			err := enforcer.processApplicationUDPPackets(appPacket)
+			So(err, ShouldBeNil)
+
+			output := appPacket.GetBytes()
+			output[len(output)-UDPAuthenticationTrailerLen-10] ^= 0xff
+
+			netPacket, err := packet.New(0, output, "0")
+			So(err, ShouldBeNil)
+
+			err = enforcer.processNetworkUDPPackets(netPacket)
+
+			Convey("Then it should be dropped", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,51 @@
+package enforcer
+
+import (
+	"fmt"
+
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+)
+
+// dropError is the error of a packet dropped by the enforcer. It carries the
+// reason of the drop that is counted for the PU.
+type dropError struct {
+	reason  collector.DropReason
+	message string
+}
+
+// Error returns the message of the drop
+func (e *dropError) Error() string {
+
+	return e.message
+}
+
+// dropErrorf returns the error of a drop with a formatted message
+func dropErrorf(reason collector.DropReason, format string, args ...interface{}) error {
+
+	return &dropError{
+		reason:  reason,
+		message: fmt.Sprintf(format, args...),
+	}
+}
+
+// dropReason returns the reason of a drop error or the default reason for the
+// errors that do not carry one
+func dropReason(err error, defaultReason collector.DropReason) collector.DropReason {
+
+	if derr, ok := err.(*dropError); ok {
+		return derr.reason
+	}
+
+	return defaultReason
+}
+
+// tokenDropReason returns the reason of a drop caused by a token that cannot be decoded
+func tokenDropReason(err error) collector.DropReason {
+
+	if err == tokens.ErrTokenExpired {
+		return collector.ExpiredToken
+	}
+
+	return collector.InvalidToken
+}
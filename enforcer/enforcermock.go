@@ -23,6 +23,9 @@ type mockedMethodsPolicyEnforcer struct {
 
 	// Stop stops the Supervisor.
 	stopMock func() error
+
+	// Connections returns the connections of a PU tracked during their handshake.
+	connectionsMock func(contextID string) ([]*TrackedConnection, error)
 }
 
 type mockedMethodsPublicKeyAdder struct {
@@ -38,6 +41,7 @@ type TestPolicyEnforcer interface {
 	MockGetFilterQueue(t *testing.T, impl func() *FilterQueue)
 	MockStart(t *testing.T, impl func() error)
 	MockStop(t *testing.T, impl func() error)
+	MockConnections(t *testing.T, impl func(contextID string) ([]*TrackedConnection, error))
 }
 
 // TestPublicKeyAdder vxcv
@@ -101,6 +105,11 @@ func (m *testPolicyEnforcer) MockStop(t *testing.T, impl func() error) {
 	m.currentMocksPolicyEnforcer(t).stopMock = impl
 }
 
+func (m *testPolicyEnforcer) MockConnections(t *testing.T, impl func(contextID string) ([]*TrackedConnection, error)) {
+
+	m.currentMocksPolicyEnforcer(t).connectionsMock = impl
+}
+
 func (m *testPolicyEnforcer) Enforce(contextID string, puInfo *policy.PUInfo) error {
 
 	if mock := m.currentMocksPolicyEnforcer(m.currentTest); mock != nil && mock.enforceMock != nil {
@@ -146,6 +155,15 @@ func (m *testPolicyEnforcer) Stop() error {
 	return nil
 }
 
+func (m *testPolicyEnforcer) Connections(contextID string) ([]*TrackedConnection, error) {
+
+	if mock := m.currentMocksPolicyEnforcer(m.currentTest); mock != nil && mock.connectionsMock != nil {
+		return mock.connectionsMock(contextID)
+	}
+
+	return nil, nil
+}
+
 func (m *testPolicyEnforcer) currentMocksPolicyEnforcer(t *testing.T) *mockedMethodsPolicyEnforcer {
 	m.lock.Lock()
 	defer m.lock.Unlock()
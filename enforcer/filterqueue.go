@@ -16,8 +16,37 @@ type FilterQueue struct {
 	ApplicationQueueSize uint32
 	// NetworkQueueSize is the size of the network queue
 	NetworkQueueSize uint32
+	// MaxTrackedConnections is the maximum number of entries of each connection
+	// tracker. Zero means DefaultMaxTrackedConnections.
+	MaxTrackedConnections int
+	// SynRatePerSource is the number of Syn packets per second from a source
+	// address that are authenticated. Zero means DefaultSynRatePerSource.
+	SynRatePerSource int
+	// SynRatePerPU is the number of Syn packets per second to a PU that are
+	// authenticated. Zero means DefaultSynRatePerPU.
+	SynRatePerPU int
+	// OverloadMode selects how the Syn packets that exceed the rates are handled
+	OverloadMode OverloadMode
+	// MTU is the largest IP packet to which a UDP token is attached. Zero
+	// means DefaultMTU.
+	MTU int
 }
 
+// OverloadMode selects how the Syn packets that exceed the rates of the
+// handshakes are handled
+type OverloadMode int
+
+const (
+	// OverloadDrop drops the Syn packets that exceed the rates
+	OverloadDrop OverloadMode = iota
+	// OverloadAllowKnownSources drops the Syn packets that exceed the rate of
+	// the PU, unless a connection from their source was recently accepted by
+	// the PU. The rate of the source still applies.
+	OverloadAllowKnownSources
+	// OverloadDisabled authenticates all the Syn packets
+	OverloadDisabled
+)
+
 // Default parameters for the NFQUEUE configuration. Parameters can be
 // changed after an isolator has been created and before its started.
 // Change in parameters after the isolator is started has no effect
@@ -32,4 +61,15 @@ const (
 	DefaultQueueSize = 500
 	// DefaultMarkValue is the default Mark for packets in the raw chain
 	DefaultMarkValue = 0x1111
+	// DefaultMaxTrackedConnections is the default maximum number of entries of
+	// each connection tracker
+	DefaultMaxTrackedConnections = 65536
+	// DefaultSynRatePerSource is the default number of Syn packets per second
+	// from a source address that are authenticated
+	DefaultSynRatePerSource = 100
+	// DefaultSynRatePerPU is the default number of Syn packets per second to a
+	// PU that are authenticated
+	DefaultSynRatePerPU = 1000
+	// DefaultMTU is the default largest IP packet to which a UDP token is attached
+	DefaultMTU = 1500
 )
@@ -0,0 +1,207 @@
+package enforcer
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme/cache"
+)
+
+const (
+	// sourceBucketLifetime is the time after which the bucket of a source is forgotten
+	sourceBucketLifetime = time.Second * 60
+	// knownSourceLifetime is the time during which a source that had a
+	// connection accepted by a PU is known
+	knownSourceLifetime = time.Minute * 5
+	// throttledReportInterval is the minimum interval between the reports of
+	// the Syn packets throttled for a PU
+	throttledReportInterval = time.Second * 10
+)
+
+// tokenBucket is a token bucket refilled at a rate of tokens per second, up
+// to one second of tokens
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket creates a full token bucket
+func newTokenBucket(rate int, now time.Time) *tokenBucket {
+
+	return &tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   now,
+	}
+}
+
+// available refills the bucket and returns false if it is empty
+func (b *tokenBucket) available(now time.Time) bool {
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.rate, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+
+	return b.tokens >= 1
+}
+
+// take takes a token from the bucket and returns false if it is empty
+func (b *tokenBucket) take(now time.Time) bool {
+
+	if !b.available(now) {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// throttledSyns counts the Syn packets throttled for a PU since its last report
+type throttledSyns struct {
+	count    int
+	reported time.Time
+}
+
+// handshakeLimiter limits the rates of the Syn packets that are authenticated
+// per source address and per PU, since every verification of a token is
+// expensive. A handshake is charged to its source and its PU only when both
+// allow it, so that a single source cannot exhaust the rate of a PU and the
+// sources are not charged while the PU is throttled.
+type handshakeLimiter struct {
+	sourceRate int
+	puRate     int
+	mode       OverloadMode
+
+	// Key=source address Value=tokenBucket
+	sources cache.DataStore
+	// Key=contextID and source address. Created when a connection from a source is accepted by a PU
+	knownSources cache.DataStore
+	// Key=contextID Value=tokenBucket
+	pus map[string]*tokenBucket
+	// Key=contextID Value=throttledSyns
+	throttled map[string]*throttledSyns
+
+	sync.Mutex
+}
+
+// newHandshakeLimiter creates the limiter of the handshakes from the rates
+// and the mode of the configuration of the queues
+func newHandshakeLimiter(filterQueue *FilterQueue, maxTracked int) *handshakeLimiter {
+
+	l := &handshakeLimiter{
+		sourceRate:   filterQueue.SynRatePerSource,
+		puRate:       filterQueue.SynRatePerPU,
+		mode:         filterQueue.OverloadMode,
+		sources:      newTracker(trackerSynSources, maxTracked, sourceBucketLifetime, nil, nil),
+		knownSources: newTracker(trackerKnownSources, maxTracked, knownSourceLifetime, nil, nil),
+		pus:          map[string]*tokenBucket{},
+		throttled:    map[string]*throttledSyns{},
+	}
+
+	if l.sourceRate <= 0 {
+		l.sourceRate = DefaultSynRatePerSource
+	}
+
+	if l.puRate <= 0 {
+		l.puRate = DefaultSynRatePerPU
+	}
+
+	return l
+}
+
+// allow returns true if the token of a Syn packet from the source to the PU
+// can be verified
+func (l *handshakeLimiter) allow(contextID, source string, now time.Time) bool {
+
+	if l.mode == OverloadDisabled {
+		return true
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	puBucket, ok := l.pus[contextID]
+	if !ok {
+		puBucket = newTokenBucket(l.puRate, now)
+		l.pus[contextID] = puBucket
+	}
+
+	// The known sources exceed the rate of the PU but not their own
+	puAvailable := puBucket.available(now)
+	if !puAvailable {
+		if l.mode != OverloadAllowKnownSources {
+			return false
+		}
+		if _, err := l.knownSources.Get(contextID + "/" + source); err != nil {
+			return false
+		}
+	}
+
+	var sourceBucket *tokenBucket
+	if item, err := l.sources.Get(source); err == nil {
+		sourceBucket = item.(*tokenBucket)
+	} else {
+		sourceBucket = newTokenBucket(l.sourceRate, now)
+		l.sources.AddOrUpdate(source, sourceBucket)
+	}
+
+	if !sourceBucket.take(now) {
+		return false
+	}
+
+	if puAvailable {
+		puBucket.take(now)
+	}
+
+	return true
+}
+
+// authenticated records that a connection from the source was accepted by the PU
+func (l *handshakeLimiter) authenticated(contextID, source string) {
+
+	if l.mode != OverloadAllowKnownSources {
+		return
+	}
+
+	l.knownSources.AddOrUpdate(contextID+"/"+source, true)
+}
+
+// throttle counts a Syn packet throttled for the PU and returns the number of
+// throttled packets to report, or 0 if they are reported later. The first
+// throttled packet is reported at once and the next ones together at most once
+// per throttledReportInterval.
+func (l *handshakeLimiter) throttle(contextID string, now time.Time) int {
+
+	l.Lock()
+	defer l.Unlock()
+
+	t, ok := l.throttled[contextID]
+	if !ok {
+		t = &throttledSyns{}
+		l.throttled[contextID] = t
+	}
+
+	t.count++
+	if !t.reported.IsZero() && now.Sub(t.reported) < throttledReportInterval {
+		return 0
+	}
+
+	count := t.count
+	t.count = 0
+	t.reported = now
+
+	return count
+}
+
+// remove forgets the rate of a PU
+func (l *handshakeLimiter) remove(contextID string) {
+
+	l.Lock()
+	defer l.Unlock()
+
+	delete(l.pus, contextID)
+	delete(l.throttled, contextID)
+}
@@ -0,0 +1,115 @@
+package enforcer
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTokenBucket(t *testing.T) {
+	Convey("Given a token bucket of 2 tokens per second", t, func() {
+		now := time.Now()
+		b := newTokenBucket(2, now)
+
+		Convey("Then I should take 2 tokens and no more", func() {
+			So(b.take(now), ShouldBeTrue)
+			So(b.take(now), ShouldBeTrue)
+			So(b.take(now), ShouldBeFalse)
+
+			Convey("Then the bucket should be refilled with time, up to its rate", func() {
+				So(b.take(now.Add(500*time.Millisecond)), ShouldBeTrue)
+				So(b.take(now.Add(500*time.Millisecond)), ShouldBeFalse)
+				So(b.take(now.Add(time.Hour)), ShouldBeTrue)
+				So(b.take(now.Add(time.Hour)), ShouldBeTrue)
+				So(b.take(now.Add(time.Hour)), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestHandshakeLimiter(t *testing.T) {
+	Convey("Given a handshake limiter of 2 handshakes per source and 3 per PU", t, func() {
+		now := time.Now()
+		fq := &FilterQueue{SynRatePerSource: 2, SynRatePerPU: 3}
+		l := newHandshakeLimiter(fq, 10)
+
+		Convey("Then a source should be limited before the PU", func() {
+			So(l.allow("pu", "10.0.0.1", now), ShouldBeTrue)
+			So(l.allow("pu", "10.0.0.1", now), ShouldBeTrue)
+			So(l.allow("pu", "10.0.0.1", now), ShouldBeFalse)
+
+			Convey("Then the other sources should be limited by the rate of the PU", func() {
+				So(l.allow("pu", "10.0.0.2", now), ShouldBeTrue)
+				So(l.allow("pu", "10.0.0.3", now), ShouldBeFalse)
+				So(l.allow("other", "10.0.0.3", now), ShouldBeTrue)
+			})
+
+			Convey("Then the sources should not be charged while the PU is throttled", func() {
+				l := newHandshakeLimiter(&FilterQueue{SynRatePerSource: 2, SynRatePerPU: 4}, 10)
+				for _, source := range []string{"10.0.0.2", "10.0.0.2", "10.0.0.3", "10.0.0.3"} {
+					So(l.allow("pu", source, now), ShouldBeTrue)
+				}
+				So(l.allow("pu", "10.0.0.4", now), ShouldBeFalse)
+				So(l.allow("pu", "10.0.0.4", now), ShouldBeFalse)
+
+				later := now.Add(500 * time.Millisecond)
+				So(l.allow("pu", "10.0.0.4", later), ShouldBeTrue)
+				So(l.allow("pu", "10.0.0.4", later), ShouldBeTrue)
+				So(l.allow("pu", "10.0.0.4", later), ShouldBeFalse)
+			})
+
+			Convey("Then the rate of a removed PU should start again", func() {
+				So(l.allow("pu", "10.0.0.2", now), ShouldBeTrue)
+				l.remove("pu")
+				So(l.allow("pu", "10.0.0.3", now), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the mode allows the known sources", func() {
+			fq.OverloadMode = OverloadAllowKnownSources
+			l := newHandshakeLimiter(fq, 10)
+			l.authenticated("pu", "10.0.0.1")
+
+			Convey("Then the known sources should exceed the rate of the PU but not their own", func() {
+				So(l.allow("pu", "10.0.0.2", now), ShouldBeTrue)
+				So(l.allow("pu", "10.0.0.3", now), ShouldBeTrue)
+				So(l.allow("pu", "10.0.0.4", now), ShouldBeTrue)
+				So(l.allow("pu", "10.0.0.5", now), ShouldBeFalse)
+				So(l.allow("pu", "10.0.0.1", now), ShouldBeTrue)
+				So(l.allow("pu", "10.0.0.1", now), ShouldBeTrue)
+				So(l.allow("pu", "10.0.0.1", now), ShouldBeFalse)
+			})
+		})
+
+		Convey("When Syn packets are throttled for a PU", func() {
+
+			Convey("Then the first one should be reported at once and the next ones together after the interval", func() {
+				So(l.throttle("pu", now), ShouldEqual, 1)
+				So(l.throttle("pu", now.Add(time.Second)), ShouldEqual, 0)
+				So(l.throttle("pu", now.Add(2*time.Second)), ShouldEqual, 0)
+				So(l.throttle("other", now.Add(2*time.Second)), ShouldEqual, 1)
+				So(l.throttle("pu", now.Add(throttledReportInterval)), ShouldEqual, 3)
+				So(l.throttle("pu", now.Add(throttledReportInterval+time.Second)), ShouldEqual, 0)
+			})
+
+			Convey("Then the throttled packets of a removed PU should be forgotten", func() {
+				So(l.throttle("pu", now), ShouldEqual, 1)
+				So(l.throttle("pu", now), ShouldEqual, 0)
+				l.remove("pu")
+				So(l.throttle("pu", now), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the limits are disabled", func() {
+			fq.OverloadMode = OverloadDisabled
+			l := newHandshakeLimiter(fq, 10)
+
+			Convey("Then all the handshakes should be allowed", func() {
+				for i := 0; i < 10; i++ {
+					So(l.allow("pu", "10.0.0.1", now), ShouldBeTrue)
+				}
+			})
+		})
+	})
+}
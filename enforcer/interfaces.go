@@ -2,8 +2,10 @@ package enforcer
 
 import (
 	"sync"
+	"time"
 
 	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/enforcer/connection"
 	"github.com/aporeto-inc/trireme/enforcer/lookup"
 	"github.com/aporeto-inc/trireme/policy"
 )
@@ -25,6 +27,9 @@ type PolicyEnforcer interface {
 
 	// Stop stops the PolicyEnforcer.
 	Stop() error
+
+	// Connections returns the connections of a PU tracked during their handshake.
+	Connections(contextID string) ([]*TrackedConnection, error)
 }
 
 // PublicKeyAdder register a publicKey for a Node.
@@ -62,8 +67,35 @@ type PUContext struct {
 	RejectRcvRules *lookup.PolicyDB
 	Extension      interface{}
 	IP             string
+	IPv6           string
 	Mark           string
 	Ports          []string
 	PUType         constants.PUType
+	// EncryptionEnabled is set when the policy of the PU has rules that require
+	// encryption. Only these PUs offer an ephemeral key in their Syn packets.
+	EncryptionEnabled bool
+	// Audit is set when the PU is in audit mode. The flows that its policy
+	// rejects are reported with the audit action and accepted.
+	Audit bool
+	// metrics are the handles of the metrics of the PU
+	metrics *puMetrics
 	sync.Mutex
 }
+
+// TrackedConnection is the state of a connection of a PU tracked by the
+// enforcer during its handshake
+type TrackedConnection struct {
+	ContextID string
+	// Application is set for the connections initiated by the PU
+	Application        bool
+	Protocol           string
+	SourceAddress      string
+	DestinationAddress string
+	SourcePort         uint16
+	DestinationPort    uint16
+	State              connection.TCPFlowState
+	RemoteContextID    string
+	Age                time.Duration
+	// Logs are only collected when connection.TraceLogging is set
+	Logs []string
+}
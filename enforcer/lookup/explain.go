@@ -0,0 +1,187 @@
+package lookup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// Explain explains the decision of the tag selectors of a PU for the tags. The
+// selectors are split in reject and accept ones like in the datapath. The
+// selectors without an ID are identified by their index in the list.
+func Explain(tags *policy.TagsMap, selectors *policy.TagSelectorList) *policy.PolicyExplanation {
+
+	acceptRules := NewPolicyDB()
+	rejectRules := NewPolicyDB()
+
+	for i, selector := range selectors.TagSelectors {
+		if selector.ID == "" {
+			selector.ID = strconv.Itoa(i)
+		}
+
+		if selector.Action&policy.Accept != 0 {
+			acceptRules.AddPolicy(selector)
+		} else if selector.Action&policy.Reject != 0 {
+			rejectRules.AddPolicy(selector)
+		}
+	}
+
+	rejected, _ := rejectRules.Search(tags)
+
+	accepted := -1
+	if rejected < 0 {
+		accepted, _ = acceptRules.Search(tags)
+	}
+
+	return ExplainDecision(tags, rejectRules, acceptRules, rejected, accepted)
+}
+
+// ExplainDecision explains the decision of the databases of reject and accept
+// selectors for the tags. The decision is given by the indices returned by the
+// searches of the databases, -1 if no selector matched. The reject selectors
+// are searched first, so the accepted index is ignored if a reject selector
+// matched.
+func ExplainDecision(tags *policy.TagsMap, rejectRules, acceptRules *PolicyDB, rejected, accepted int) *policy.PolicyExplanation {
+
+	rejectSelectors := rejectRules.explain(tags)
+	acceptSelectors := acceptRules.explain(tags)
+
+	e := &policy.PolicyExplanation{
+		Action:    policy.Reject,
+		Selectors: append(rejectSelectors, acceptSelectors...),
+	}
+
+	switch {
+	case rejected >= 0:
+		rejectRules.explainMatch(e, rejected, 0)
+	case accepted >= 0:
+		acceptRules.explainMatch(e, accepted, len(rejectSelectors))
+	}
+
+	return e
+}
+
+// explainMatch sets the selector of the database with the index as the one that
+// matched. The explanations of the selectors of the database start at the offset.
+func (m *PolicyDB) explainMatch(e *policy.PolicyExplanation, index int, offset int) {
+
+	e.Matched = &e.Selectors[offset+m.position(index)]
+	e.DefaultNotExists = m.isDefaultNotExists(index)
+
+	if a, ok := m.policies[index-1].actions.(policy.FlowAction); ok {
+		e.Action = a
+	}
+}
+
+// isDefaultNotExists returns true if the index is the one of the default policy
+// that only requires a key to not exist
+func (m *PolicyDB) isDefaultNotExists(index int) bool {
+
+	return m.defaultNotExistsPolicy != nil && m.defaultNotExistsPolicy.index == index
+}
+
+// position returns the position of the policy with the index among the
+// policies of the database that were not removed
+func (m *PolicyDB) position(index int) int {
+
+	position := 0
+	for _, p := range m.policies[:index-1] {
+		if p != nil {
+			position++
+		}
+	}
+
+	return position
+}
+
+// explain explains every policy of the database in the order they were added
+func (m *PolicyDB) explain(tags *policy.TagsMap) []policy.SelectorExplanation {
+
+	selectors := []policy.SelectorExplanation{}
+
+	for _, p := range m.policies {
+		if p == nil {
+			continue
+		}
+
+		s := policy.SelectorExplanation{
+			ID:      p.id,
+			Matched: true,
+			Clauses: make([]policy.ClauseExplanation, len(p.tags)),
+		}
+
+		if a, ok := p.actions.(policy.FlowAction); ok {
+			s.Action = a
+		}
+
+		for j, kvo := range p.tags {
+			matched, reason := explainClause(kvo, p.matchers[j], tags)
+			s.Clauses[j] = policy.ClauseExplanation{
+				Key:      kvo.Key,
+				Operator: kvo.Operator,
+				Value:    append([]string{}, kvo.Value...),
+				Matched:  matched,
+				Reason:   reason,
+			}
+			s.Matched = s.Matched && matched
+		}
+
+		selectors = append(selectors, s)
+	}
+
+	return selectors
+}
+
+// explainClause returns true if the tags match the clause with the reason. The
+// clauses with regular expressions, ranges or networks are evaluated with the
+// matcher compiled when their policy was added.
+func explainClause(kvo policy.KeyValueOperator, match func(value string) bool, tags *policy.TagsMap) (bool, string) {
+
+	v, exists := tags.Tags[kvo.Key]
+
+	switch kvo.Operator {
+	case policy.KeyExists:
+		if exists {
+			return true, fmt.Sprintf("key %s exists", kvo.Key)
+		}
+		return false, fmt.Sprintf("key %s is missing", kvo.Key)
+
+	case policy.KeyNotExists:
+		if exists {
+			return false, fmt.Sprintf("key %s exists", kvo.Key)
+		}
+		return true, fmt.Sprintf("key %s is missing", kvo.Key)
+	}
+
+	if !exists {
+		return false, fmt.Sprintf("key %s is missing", kvo.Key)
+	}
+
+	values := strings.Join(kvo.Value, ", ")
+
+	switch kvo.Operator {
+	case policy.Equal:
+		for _, value := range kvo.Value {
+			if value == v || (strings.HasSuffix(value, "*") && strings.HasPrefix(v, value[:len(value)-1])) {
+				return true, fmt.Sprintf("%s=%s matches %s", kvo.Key, v, value)
+			}
+		}
+		return false, fmt.Sprintf("%s=%s is not one of %s", kvo.Key, v, values)
+
+	case policy.Matches, policy.GreaterThan, policy.GreaterOrEqual, policy.LessThan, policy.LessOrEqual, policy.InCIDR:
+		if match(v) {
+			return true, fmt.Sprintf("%s=%s is %s %s", kvo.Key, v, kvo.Operator, values)
+		}
+		return false, fmt.Sprintf("%s=%s is not %s %s", kvo.Key, v, kvo.Operator, values)
+
+	default: // policy.NotEqual
+		for _, value := range kvo.Value {
+			if value == v {
+				return false, fmt.Sprintf("%s=%s is one of %s", kvo.Key, v, values)
+			}
+		}
+		return true, fmt.Sprintf("%s=%s is not one of %s", kvo.Key, v, values)
+	}
+}
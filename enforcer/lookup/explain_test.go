@@ -0,0 +1,126 @@
+package lookup
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExplain(t *testing.T) {
+
+	Convey("Given the tag selectors of a PU", t, func() {
+		selectors := policy.NewTagSelectorList([]policy.TagSelector{
+			appEqWebAndenvEqDemo,
+			{
+				Clause: []policy.KeyValueOperator{langNotJava},
+				Action: policy.Reject,
+				ID:     "no-java",
+			},
+			policyEnvDoesNotExist,
+		})
+
+		Convey("When the tags match an accept selector", func() {
+			e := Explain(policy.NewTagsMap(map[string]string{"app": "web", "env": "demo", "lang": "java"}), selectors)
+
+			Convey("Then the accept selector should be reported as the match", func() {
+				So(e.Action, ShouldEqual, policy.Accept)
+				So(e.Matched, ShouldNotBeNil)
+				So(e.Matched.ID, ShouldEqual, "0")
+				So(e.DefaultNotExists, ShouldBeFalse)
+				So(e.String(), ShouldEqual, "accept: selector 0 matched")
+			})
+
+			Convey("Then the reject selectors should be explained first", func() {
+				So(len(e.Selectors), ShouldEqual, 3)
+				So(e.Selectors[0].ID, ShouldEqual, "no-java")
+				So(e.Selectors[0].Matched, ShouldBeFalse)
+				So(e.Selectors[0].Clauses[0].Reason, ShouldEqual, "lang=java is one of java")
+			})
+		})
+
+		Convey("When the tags match a reject selector", func() {
+			e := Explain(policy.NewTagsMap(map[string]string{"app": "web", "env": "demo", "lang": "go"}), selectors)
+
+			Convey("Then the reject selector should decide even if an accept one matches", func() {
+				So(e.Action, ShouldEqual, policy.Reject)
+				So(e.Matched.ID, ShouldEqual, "no-java")
+				So(e.Selectors[1].Matched, ShouldBeTrue)
+			})
+		})
+
+		Convey("When the tags match no selector", func() {
+			e := Explain(policy.NewTagsMap(map[string]string{"app": "db", "env": "qa", "lang": "java"}), selectors)
+
+			Convey("Then the flow should be rejected with the failed clauses", func() {
+				So(e.Action, ShouldEqual, policy.Reject)
+				So(e.Matched, ShouldBeNil)
+				So(e.String(), ShouldEqual, "reject: no selector matched")
+				So(e.Selectors[1].Clauses[0].Matched, ShouldBeFalse)
+				So(e.Selectors[1].Clauses[0].Reason, ShouldEqual, "app=db is not one of web")
+				So(e.Selectors[2].Clauses[0].Reason, ShouldEqual, "key env exists")
+			})
+		})
+
+		Convey("When the tags only match the not-exists selector", func() {
+			e := Explain(policy.NewTagsMap(map[string]string{"lang": "java"}), selectors)
+
+			Convey("Then the default not-exists policy should be reported", func() {
+				So(e.Action, ShouldEqual, policy.Accept)
+				So(e.Matched.ID, ShouldEqual, "2")
+				So(e.DefaultNotExists, ShouldBeTrue)
+				So(e.Selectors[1].Clauses[0].Reason, ShouldEqual, "key app is missing")
+			})
+		})
+	})
+}
+
+func TestExplainDecision(t *testing.T) {
+
+	Convey("Given databases of reject and accept selectors", t, func() {
+		rejectRules := NewPolicyDB()
+		acceptRules := NewPolicyDB()
+
+		rejected := rejectRules.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{{Key: "app", Operator: policy.Matches, Value: []string{"db-.*"}}},
+			Action: policy.Reject,
+			ID:     "no-db",
+		})
+		accepted := acceptRules.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{{Key: "app", Operator: policy.Equal, Value: []string{"db-1"}}},
+			Action: policy.Accept,
+			ID:     "db-1",
+		})
+
+		tags := policy.NewTagsMap(map[string]string{"app": "db-1"})
+
+		Convey("When I explain the decision of the search of the reject selectors", func() {
+			e := ExplainDecision(tags, rejectRules, acceptRules, rejected, -1)
+
+			Convey("Then the reject selector should be reported with its compiled matcher", func() {
+				So(e.Action, ShouldEqual, policy.Reject)
+				So(e.Matched.ID, ShouldEqual, "no-db")
+				So(e.Matched.Clauses[0].Reason, ShouldEqual, "app=db-1 is =~ db-.*")
+			})
+		})
+
+		Convey("When I explain the decision of the search of the accept selectors", func() {
+			e := ExplainDecision(tags, rejectRules, acceptRules, -1, accepted)
+
+			Convey("Then the accept selector should be reported from the given index", func() {
+				So(e.Action, ShouldEqual, policy.Accept)
+				So(e.Matched.ID, ShouldEqual, "db-1")
+			})
+		})
+
+		Convey("When I explain a decision where no selector matched", func() {
+			e := ExplainDecision(tags, rejectRules, acceptRules, -1, -1)
+
+			Convey("Then no selector should be reported as the match", func() {
+				So(e.Action, ShouldEqual, policy.Reject)
+				So(e.Matched, ShouldBeNil)
+				So(e.Selectors, ShouldHaveLength, 2)
+			})
+		})
+	})
+}
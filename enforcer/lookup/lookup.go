@@ -2,6 +2,7 @@ package lookup
 
 import (
 	"fmt"
+	"reflect"
 	"sort"
 
 	"go.uber.org/zap"
@@ -15,23 +16,30 @@ type ForwardingPolicy struct {
 	count   int
 	index   int
 	actions interface{}
+	id      string
+	// matchers are the compiled matchers of the clauses with regular
+	// expressions, ranges or networks, nil for the other clauses
+	matchers []func(value string) bool
 }
 
 // intList is a list of integeres
 type intList []int
 
-//PolicyDB is the structure of a policy
+// PolicyDB is the structure of a policy
 type PolicyDB struct {
 	// rules    []policy
-	numberOfPolicies       int
-	equalPrefixes          map[string]intList
-	equalMapTable          map[string]map[string][]*ForwardingPolicy
-	notEqualMapTable       map[string]map[string][]*ForwardingPolicy
-	notStarTable           map[string][]*ForwardingPolicy
-	defaultNotExistsPolicy *ForwardingPolicy
+	numberOfPolicies        int
+	numberOfRemovedPolicies int
+	equalPrefixes           map[string]intList
+	equalMapTable           map[string]map[string][]*ForwardingPolicy
+	notEqualMapTable        map[string]map[string][]*ForwardingPolicy
+	notStarTable            map[string][]*ForwardingPolicy
+	valueMatchers           map[string][]*valueMatcher
+	defaultNotExistsPolicy  *ForwardingPolicy
+	policies                []*ForwardingPolicy
 }
 
-//NewPolicyDB creates a new PolicyDB for efficient search of policies
+// NewPolicyDB creates a new PolicyDB for efficient search of policies
 func NewPolicyDB() (m *PolicyDB) {
 
 	m = &PolicyDB{
@@ -40,7 +48,9 @@ func NewPolicyDB() (m *PolicyDB) {
 		equalPrefixes:          map[string]intList{},
 		notEqualMapTable:       map[string]map[string][]*ForwardingPolicy{},
 		notStarTable:           map[string][]*ForwardingPolicy{},
+		valueMatchers:          map[string][]*valueMatcher{},
 		defaultNotExistsPolicy: nil,
+		policies:               []*ForwardingPolicy{},
 	}
 
 	return m
@@ -73,19 +83,33 @@ func (array intList) sortedInsert(value int) intList {
 
 }
 
-//AddPolicy adds a policy to the database
+// remove removes one occurrence of the value from the list
+func (array intList) remove(value int) intList {
+
+	for i, v := range array {
+		if v == value {
+			return append(array[:i:i], array[i+1:]...)
+		}
+	}
+
+	return array
+}
+
+// AddPolicy adds a policy to the database
 func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 
 	// Create a new policy object
 	e := ForwardingPolicy{
-		count:   0,
-		tags:    selector.Clause,
-		actions: selector.Action,
+		count:    0,
+		tags:     selector.Clause,
+		actions:  selector.Action,
+		id:       selector.ID,
+		matchers: make([]func(value string) bool, len(selector.Clause)),
 	}
 
 	// For each tag of the incoming policy add a mapping between the map tables
 	// and the structure that represents the policy
-	for _, keyValueOp := range selector.Clause {
+	for i, keyValueOp := range selector.Clause {
 
 		switch keyValueOp.Operator {
 
@@ -117,6 +141,16 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 			}
 			e.count++
 
+		case policy.Matches, policy.GreaterThan, policy.GreaterOrEqual, policy.LessThan, policy.LessOrEqual, policy.InCIDR:
+			// The values cannot be indexed. Only the matchers of the keys of
+			// the incoming tags are evaluated.
+			e.matchers[i] = newMatchFunc(keyValueOp)
+			m.valueMatchers[keyValueOp.Key] = append(m.valueMatchers[keyValueOp.Key], &valueMatcher{
+				policy: &e,
+				match:  e.matchers[i],
+			})
+			e.count++
+
 		default: // policy.NotEqual
 			if _, ok := m.notEqualMapTable[keyValueOp.Key]; !ok {
 				m.notEqualMapTable[keyValueOp.Key] = map[string][]*ForwardingPolicy{}
@@ -133,13 +167,14 @@ func (m *PolicyDB) AddPolicy(selector policy.TagSelector) (policyID int) {
 
 	// Give the policy an index
 	e.index = m.numberOfPolicies
+	m.policies = append(m.policies, &e)
 
 	// Return the ID
 	return e.index
 
 }
 
-//Search searches for a set of tags in the database to find a policy match
+// Search searches for a set of tags in the database to find a policy match
 func (m *PolicyDB) Search(tags *policy.TagsMap) (int, interface{}) {
 
 	count := make([]int, m.numberOfPolicies+1)
@@ -170,6 +205,17 @@ func (m *PolicyDB) Search(tags *policy.TagsMap) (int, interface{}) {
 			}
 		}
 
+		// Evaluate the regular expressions, ranges and networks of the key
+		for _, matcher := range m.valueMatchers[k] {
+			if !matcher.match(v) {
+				continue
+			}
+
+			if index, action := searchInMapTabe([]*ForwardingPolicy{matcher.policy}, count, skip); index >= 0 {
+				return index, action
+			}
+		}
+
 		// Parse all of the policies that have a key that matches the incoming tag key
 		// and a not equal operator and that has a not match rule
 		for value, policies := range m.notEqualMapTable[k] {
@@ -190,6 +236,129 @@ func (m *PolicyDB) Search(tags *policy.TagsMap) (int, interface{}) {
 	return -1, nil
 }
 
+// RemovePolicy removes the first policy of the database that has the clauses,
+// the action and the ID of the selector. The indices of the other policies do
+// not change. It returns false if there is no such policy.
+func (m *PolicyDB) RemovePolicy(selector policy.TagSelector) bool {
+
+	var e *ForwardingPolicy
+	for _, p := range m.policies {
+		if p != nil && p.id == selector.ID && reflect.DeepEqual(p.actions, selector.Action) && reflect.DeepEqual(p.tags, selector.Clause) {
+			e = p
+			break
+		}
+	}
+
+	if e == nil {
+		return false
+	}
+
+	for _, keyValueOp := range e.tags {
+
+		switch keyValueOp.Operator {
+
+		case policy.KeyExists:
+			m.equalPrefixes[keyValueOp.Key] = m.equalPrefixes[keyValueOp.Key].remove(0)
+			m.equalMapTable[keyValueOp.Key][""] = removeForwardingPolicy(m.equalMapTable[keyValueOp.Key][""], e)
+
+		case policy.KeyNotExists:
+			m.notStarTable[keyValueOp.Key] = removeForwardingPolicy(m.notStarTable[keyValueOp.Key], e)
+
+		case policy.Equal:
+			for _, v := range keyValueOp.Value {
+				if v[len(v)-1] == "*"[0] {
+					m.equalPrefixes[keyValueOp.Key] = m.equalPrefixes[keyValueOp.Key].remove(len(v) - 1)
+					v = v[:len(v)-1]
+				}
+				m.equalMapTable[keyValueOp.Key][v] = removeForwardingPolicy(m.equalMapTable[keyValueOp.Key][v], e)
+			}
+
+		case policy.Matches, policy.GreaterThan, policy.GreaterOrEqual, policy.LessThan, policy.LessOrEqual, policy.InCIDR:
+			matchers := m.valueMatchers[keyValueOp.Key][:0]
+			for _, matcher := range m.valueMatchers[keyValueOp.Key] {
+				if matcher.policy != e {
+					matchers = append(matchers, matcher)
+				}
+			}
+			m.valueMatchers[keyValueOp.Key] = matchers
+
+		default: // policy.NotEqual
+			for _, v := range keyValueOp.Value {
+				m.notEqualMapTable[keyValueOp.Key][v] = removeForwardingPolicy(m.notEqualMapTable[keyValueOp.Key][v], e)
+			}
+		}
+	}
+
+	m.policies[e.index-1] = nil
+	m.numberOfRemovedPolicies++
+
+	// The last policy that only requires a key to not exist is the default one
+	if m.defaultNotExistsPolicy == e {
+		m.defaultNotExistsPolicy = nil
+		for _, p := range m.policies {
+			if p != nil && len(p.tags) == 1 && p.tags[0].Operator == policy.KeyNotExists {
+				m.defaultNotExistsPolicy = p
+			}
+		}
+	}
+
+	return true
+}
+
+// Removed returns the number of policies that were removed from the database.
+// They are still accounted for by the searches.
+func (m *PolicyDB) Removed() int {
+
+	return m.numberOfRemovedPolicies
+}
+
+// Selectors returns the selectors of the policies of the database in the order
+// they were added
+func (m *PolicyDB) Selectors() []policy.TagSelector {
+
+	selectors := []policy.TagSelector{}
+
+	for _, p := range m.policies {
+		if p == nil {
+			continue
+		}
+
+		selector := policy.TagSelector{
+			Clause: p.tags,
+			ID:     p.id,
+		}
+		if a, ok := p.actions.(policy.FlowAction); ok {
+			selector.Action = a
+		}
+
+		selectors = append(selectors, selector)
+	}
+
+	return selectors
+}
+
+// PolicyID returns the ID of the policy with the index returned by Search
+func (m *PolicyDB) PolicyID(index int) string {
+
+	if index <= 0 || index > len(m.policies) || m.policies[index-1] == nil {
+		return ""
+	}
+
+	return m.policies[index-1].id
+}
+
+// removeForwardingPolicy returns the table without the policy
+func removeForwardingPolicy(table []*ForwardingPolicy, e *ForwardingPolicy) []*ForwardingPolicy {
+
+	for i, p := range table {
+		if p == e {
+			return append(table[:i:i], table[i+1:]...)
+		}
+	}
+
+	return table
+}
+
 func searchInMapTabe(table []*ForwardingPolicy, count []int, skip []bool) (int, interface{}) {
 	for _, policy := range table {
 
@@ -226,6 +395,17 @@ func (m *PolicyDB) PrintPolicyDB() {
 		}
 	}
 
+	zap.L().Debug("Print Policy DB - value matchers")
+
+	for key, matchers := range m.valueMatchers {
+		for _, matcher := range matchers {
+			zap.L().Debug("Print Policy DB",
+				zap.String("policy", fmt.Sprintf("%#v", matcher.policy)),
+				zap.String("key", key),
+			)
+		}
+	}
+
 	zap.L().Debug("Print Policy DB - not equal table")
 
 	for key, values := range m.notEqualMapTable {
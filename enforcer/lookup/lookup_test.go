@@ -182,6 +182,16 @@ func TestFuncAddPolicy(t *testing.T) {
 			So(policyDB.equalPrefixes[key], ShouldContain, len(value3)-1)
 		})
 
+		Convey("When I add a policy with an ID, I should be able to retrieve the ID from the index", func() {
+			selector := appEqWebAndenvEqDemo
+			selector.ID = "rule-1"
+			index := policyDB.AddPolicy(selector)
+
+			So(policyDB.PolicyID(index), ShouldEqual, "rule-1")
+			So(policyDB.PolicyID(index+1), ShouldEqual, "")
+			So(policyDB.PolicyID(-1), ShouldEqual, "")
+		})
+
 	})
 }
 
@@ -341,6 +351,209 @@ func TestFuncSearch(t *testing.T) {
 }
 
 // TestFuncDumbDB is a mock test for the print function
+func TestFuncSearchValueOperators(t *testing.T) {
+	// policy1: image =~ nginx:1\..*
+	// policy2: tier >= 2 and version < v2.0.0
+	// policy3: ip in (10.0.0.0/8, 192.168.0.0/16)
+	// policy4: tier > 5
+
+	Convey("Given a policyDB with regular expression, range and network operators", t, func() {
+		policyDB := NewPolicyDB()
+
+		index1 := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "image", Value: []string{`nginx:1\..*`}, Operator: policy.Matches},
+			},
+			Action: policy.Accept,
+		})
+		index2 := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "tier", Value: []string{"2"}, Operator: policy.GreaterOrEqual},
+				{Key: "version", Value: []string{"v2.0.0"}, Operator: policy.LessThan},
+			},
+			Action: policy.Accept,
+		})
+		index3 := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "ip", Value: []string{"10.0.0.0/8", "192.168.0.0/16"}, Operator: policy.InCIDR},
+			},
+			Action: policy.Reject,
+		})
+		index4 := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "tier", Value: []string{"5"}, Operator: policy.GreaterThan},
+			},
+			Action: policy.Accept,
+		})
+
+		Convey("When I search for a value that matches the regular expression, it should return the right index", func() {
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"image": "nginx:1.13"}))
+			So(index, ShouldEqual, index1)
+		})
+
+		Convey("When I search for a value that only partially matches the regular expression, it should fail", func() {
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"image": "my/nginx:1.13"}))
+			So(index, ShouldEqual, -1)
+		})
+
+		Convey("When I search for values in both ranges, it should return the right index", func() {
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"tier": "3", "version": "v1.10.2"}))
+			So(index, ShouldEqual, index2)
+		})
+
+		Convey("When I search for a pre-release of the upper version, it should match the range", func() {
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"tier": "2", "version": "2.0.0-rc.1"}))
+			So(index, ShouldEqual, index2)
+		})
+
+		Convey("When I search for a value out of one of the ranges, it should fail", func() {
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"tier": "3", "version": "v2.1.0"}))
+			So(index, ShouldEqual, -1)
+		})
+
+		Convey("When I search for a value that is not a number, it should fail", func() {
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"tier": "high"}))
+			So(index, ShouldEqual, -1)
+		})
+
+		Convey("When I search for a value greater than the higher bound, it should return the right index", func() {
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"tier": "10"}))
+			So(index, ShouldEqual, index4)
+		})
+
+		Convey("When I search for an address of one of the networks, it should return the right index", func() {
+			index, action := policyDB.Search(policy.NewTagsMap(map[string]string{"ip": "192.168.1.10"}))
+			So(index, ShouldEqual, index3)
+			So(action.(policy.FlowAction), ShouldEqual, policy.Reject)
+		})
+
+		Convey("When I search for a subnet of one of the networks, it should return the right index", func() {
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"ip": "10.1.0.0/16"}))
+			So(index, ShouldEqual, index3)
+		})
+
+		Convey("When I search for a network larger than the networks, it should fail", func() {
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"ip": "10.0.0.0/7"}))
+			So(index, ShouldEqual, -1)
+		})
+	})
+
+	Convey("Given a policyDB with an invalid regular expression", t, func() {
+		policyDB := NewPolicyDB()
+		policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "image", Value: []string{"nginx:("}, Operator: policy.Matches},
+			},
+			Action: policy.Accept,
+		})
+
+		Convey("It should never match", func() {
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"image": "nginx:("}))
+			So(index, ShouldEqual, -1)
+		})
+	})
+}
+
+// TestFuncRemovePolicy tests the removal of policies from the database
+func TestFuncRemovePolicy(t *testing.T) {
+
+	Convey("Given a policyDB with several policies", t, func() {
+		policyDB := NewPolicyDB()
+
+		index1 := policyDB.AddPolicy(appEqWebAndenvEqDemo)
+		index2 := policyDB.AddPolicy(policylangNotJava)
+		index3 := policyDB.AddPolicy(dcTagExists)
+		policyDB.AddPolicy(policyDomainParent)
+		policyDB.AddPolicy(policyEnvDoesNotExist)
+		index6 := policyDB.AddPolicy(policy.TagSelector{
+			Clause: []policy.KeyValueOperator{
+				{Key: "image", Value: []string{`nginx:1\..*`}, Operator: policy.Matches},
+			},
+			Action: policy.Accept,
+			ID:     "nginx",
+		})
+
+		Convey("When I remove a policy with equal clauses, it should not match anymore", func() {
+			So(policyDB.RemovePolicy(appEqWebAndenvEqDemo), ShouldBeTrue)
+
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"app": "web", "env": "demo", "lang": "java"}))
+			So(index, ShouldEqual, -1)
+			So(policyDB.equalMapTable["app"]["web"], ShouldBeEmpty)
+			So(policyDB.PolicyID(index1), ShouldEqual, "")
+		})
+
+		Convey("When I remove a policy with the not equal operator, it should not match anymore", func() {
+			So(policyDB.RemovePolicy(policylangNotJava), ShouldBeTrue)
+
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"lang": "go", "env": "demo"}))
+			So(index, ShouldEqual, -1)
+			So(policyDB.Selectors(), ShouldHaveLength, 5)
+			So(policyDB.PolicyID(index2), ShouldEqual, "")
+		})
+
+		Convey("When I remove the policies with KeyExists and prefixes, their prefixes should be removed", func() {
+			So(policyDB.RemovePolicy(dcTagExists), ShouldBeTrue)
+			So(policyDB.RemovePolicy(policyDomainParent), ShouldBeTrue)
+
+			So(policyDB.equalPrefixes["dc"], ShouldBeEmpty)
+			So(policyDB.equalPrefixes["domain"], ShouldBeEmpty)
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"dc": "EAST", "domain": "com.example.web", "env": "demo"}))
+			So(index, ShouldEqual, -1)
+		})
+
+		Convey("When I remove the default policy that requires a key to not exist, it should not match anymore", func() {
+			So(policyDB.RemovePolicy(policyEnvDoesNotExist), ShouldBeTrue)
+
+			So(policyDB.defaultNotExistsPolicy, ShouldBeNil)
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"sometag": "nomatch"}))
+			So(index, ShouldEqual, -1)
+		})
+
+		Convey("When I remove a policy with a regular expression, it should not match anymore", func() {
+			So(policyDB.RemovePolicy(policy.TagSelector{
+				Clause: []policy.KeyValueOperator{
+					{Key: "image", Value: []string{`nginx:1\..*`}, Operator: policy.Matches},
+				},
+				Action: policy.Accept,
+			}), ShouldBeFalse)
+
+			selector := policyDB.Selectors()[5]
+			So(selector.ID, ShouldEqual, "nginx")
+			So(policyDB.RemovePolicy(selector), ShouldBeTrue)
+
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"image": "nginx:1.13", "env": "demo"}))
+			So(index, ShouldEqual, -1)
+			So(policyDB.valueMatchers["image"], ShouldBeEmpty)
+		})
+
+		Convey("When I remove a policy and add it again, the other policies should keep their index", func() {
+			So(policyDB.RemovePolicy(policylangNotJava), ShouldBeTrue)
+			So(policyDB.RemovePolicy(policylangNotJava), ShouldBeFalse)
+			index7 := policyDB.AddPolicy(policylangNotJava)
+
+			So(index7, ShouldEqual, index6+1)
+			index, _ := policyDB.Search(policy.NewTagsMap(map[string]string{"lang": "go", "env": "demo"}))
+			So(index, ShouldEqual, index7)
+			index, _ = policyDB.Search(policy.NewTagsMap(map[string]string{"dc": "EAST", "env": "demo"}))
+			So(index, ShouldEqual, index3)
+			So(policyDB.Selectors(), ShouldHaveLength, 6)
+			So(policyDB.Selectors()[1].Clause, ShouldResemble, dcTagExists.Clause)
+		})
+
+		Convey("When I remove a policy, it should not be explained anymore", func() {
+			So(policyDB.RemovePolicy(appEqWebAndenvEqDemo), ShouldBeTrue)
+			So(policyDB.RemovePolicy(policylangNotJava), ShouldBeTrue)
+
+			tags := policy.NewTagsMap(map[string]string{"domain": "com.example.web", "env": "demo"})
+			index, _ := policyDB.Search(tags)
+			e := ExplainDecision(tags, NewPolicyDB(), policyDB, -1, index)
+			So(e.Selectors, ShouldHaveLength, 4)
+			So(e.Matched, ShouldNotBeNil)
+			So(e.Matched.Clauses[0].Key, ShouldEqual, "domain")
+		})
+	})
+}
+
 func TestFuncDumpDB(t *testing.T) {
 	Convey("Given an empty policy DB", t, func() {
 		policyDB := NewPolicyDB()
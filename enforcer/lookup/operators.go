@@ -0,0 +1,252 @@
+package lookup
+
+import (
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// valueMatcher matches the values of a tag with an operator that cannot be
+// indexed by value, such as a regular expression, a range or a network
+type valueMatcher struct {
+	policy *ForwardingPolicy
+	match  func(value string) bool
+}
+
+// newMatchFunc returns the function that matches a value with the clause. The
+// value matches if it matches any of the values of the clause. The values of
+// the clause that are invalid never match.
+func newMatchFunc(kvo policy.KeyValueOperator) func(value string) bool {
+
+	matchers := []func(value string) bool{}
+
+	for _, v := range kvo.Value {
+		switch kvo.Operator {
+
+		case policy.Matches:
+			re, err := regexp.Compile("^(?:" + v + ")$")
+			if err != nil {
+				zap.L().Warn("Invalid regular expression in tag selector", zap.String("key", kvo.Key), zap.String("value", v), zap.Error(err))
+				continue
+			}
+			matchers = append(matchers, re.MatchString)
+
+		case policy.InCIDR:
+			_, network, err := net.ParseCIDR(v)
+			if err != nil {
+				zap.L().Warn("Invalid network in tag selector", zap.String("key", kvo.Key), zap.String("value", v), zap.Error(err))
+				continue
+			}
+			matchers = append(matchers, func(value string) bool {
+				return inNetwork(network, value)
+			})
+
+		default:
+			bound := v
+			operator := kvo.Operator
+			matchers = append(matchers, func(value string) bool {
+				c, ok := compareValues(value, bound)
+				if !ok {
+					return false
+				}
+
+				switch operator {
+				case policy.GreaterThan:
+					return c > 0
+				case policy.GreaterOrEqual:
+					return c >= 0
+				case policy.LessThan:
+					return c < 0
+				default: // policy.LessOrEqual
+					return c <= 0
+				}
+			})
+		}
+	}
+
+	return func(value string) bool {
+		for _, m := range matchers {
+			if m(value) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// inNetwork returns true if the value is an IP address or a network that is
+// in the network
+func inNetwork(network *net.IPNet, value string) bool {
+
+	if ip := net.ParseIP(value); ip != nil {
+		return network.Contains(ip)
+	}
+
+	ip, subnet, err := net.ParseCIDR(value)
+	if err != nil {
+		return false
+	}
+
+	networkOnes, networkBits := network.Mask.Size()
+	subnetOnes, subnetBits := subnet.Mask.Size()
+
+	return network.Contains(ip) && networkBits == subnetBits && subnetOnes >= networkOnes
+}
+
+// compareValues compares two numbers or two semantic versions. It returns -1, 0
+// or 1 if a is lower, equal or greater than b. The values are compared as numbers
+// if both of them are integers or decimals, for example 2 or 1.5, otherwise as
+// versions, for example v1.2.3 or 1.10.0-rc.1. A decimal is always a number, so
+// 1.10 is lower than 1.5. It returns false if they cannot be compared.
+func compareValues(a, b string) (int, bool) {
+
+	if x, ok := parseNumber(a); ok {
+		if y, ok := parseNumber(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	}
+
+	x, ok := parseVersion(a)
+	if !ok {
+		return 0, false
+	}
+
+	y, ok := parseVersion(b)
+	if !ok {
+		return 0, false
+	}
+
+	return x.compare(y), true
+}
+
+// numberRegexp matches the integers and the decimals. Exponents, hexadecimal
+// numbers and the special values such as NaN or Inf are not numbers.
+var numberRegexp = regexp.MustCompile(`^[-+]?[0-9]+(\.[0-9]+)?$`)
+
+// parseNumber parses an integer or a decimal with a finite value
+func parseNumber(s string) (float64, bool) {
+
+	if !numberRegexp.MatchString(s) {
+		return 0, false
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// version is a semantic version
+type version struct {
+	numbers    [3]int
+	prerelease []string
+}
+
+// parseVersion parses a semantic version with an optional v prefix. The minor
+// and patch numbers are optional. The build metadata is ignored.
+func parseVersion(s string) (*version, bool) {
+
+	s = strings.TrimPrefix(s, "v")
+
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+
+	v := &version{}
+
+	if i := strings.Index(s, "-"); i >= 0 {
+		v.prerelease = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return nil, false
+	}
+
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		v.numbers[i] = n
+	}
+
+	return v, true
+}
+
+// compare returns -1, 0 or 1 if the version is lower, equal or greater than the
+// other one with the precedence of the semantic versions
+func (v *version) compare(o *version) int {
+
+	for i := range v.numbers {
+		if c := compareInts(v.numbers[i], o.numbers[i]); c != 0 {
+			return c
+		}
+	}
+
+	// A pre-release version is lower than the normal version
+	switch {
+	case len(v.prerelease) == 0 && len(o.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(o.prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(o.prerelease); i++ {
+		if c := comparePrerelease(v.prerelease[i], o.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+
+	return compareInts(len(v.prerelease), len(o.prerelease))
+}
+
+// comparePrerelease compares two identifiers of a pre-release. Numeric identifiers
+// are lower than the alphanumeric ones.
+func comparePrerelease(a, b string) int {
+
+	x, xerr := strconv.Atoi(a)
+	y, yerr := strconv.Atoi(b)
+
+	switch {
+	case xerr == nil && yerr == nil:
+		return compareInts(x, y)
+	case xerr == nil:
+		return -1
+	case yerr == nil:
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+// compareInts returns -1, 0 or 1 if a is lower, equal or greater than b
+func compareInts(a, b int) int {
+
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,75 @@
+package lookup
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompareValues(t *testing.T) {
+
+	Convey("When I compare numbers, they should be compared numerically", t, func() {
+		c, ok := compareValues("10", "9")
+		So(ok, ShouldBeTrue)
+		So(c, ShouldEqual, 1)
+
+		c, ok = compareValues("1.5", "1.50")
+		So(ok, ShouldBeTrue)
+		So(c, ShouldEqual, 0)
+	})
+
+	Convey("When I compare decimals and versions with a minor number, the decimals should be compared as numbers", t, func() {
+		tests := []struct {
+			a, b string
+			c    int
+		}{
+			{"1.10", "1.5", -1},
+			{"1.5", "1.10", 1},
+			{"1.10.0", "1.5.0", 1},
+			{"1.10", "1.5.0", 1},
+			{"-2", "1.5", -1},
+		}
+
+		for _, test := range tests {
+			c, ok := compareValues(test.a, test.b)
+			So(ok, ShouldBeTrue)
+			So(c, ShouldEqual, test.c)
+		}
+	})
+
+	Convey("When I compare versions, they should be compared with the semantic version precedence", t, func() {
+		tests := []struct {
+			a, b string
+			c    int
+		}{
+			{"v1.10.0", "v1.9.0", 1},
+			{"1.2", "1.2.0", 0},
+			{"1.0.0-alpha", "1.0.0", -1},
+			{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+			{"1.0.0-beta.11", "1.0.0-beta.2", 1},
+			{"1.0.0-rc.1", "1.0.0-rc.1.1", -1},
+			{"1.0.0+build.5", "1.0.0", 0},
+		}
+
+		for _, test := range tests {
+			c, ok := compareValues(test.a, test.b)
+			So(ok, ShouldBeTrue)
+			So(c, ShouldEqual, test.c)
+		}
+	})
+
+	Convey("When I compare values that are neither numbers nor versions, they should not be comparable", t, func() {
+		_, ok := compareValues("high", "2")
+		So(ok, ShouldBeFalse)
+
+		_, ok = compareValues("1.2.3.4", "1.2.3")
+		So(ok, ShouldBeFalse)
+
+		for _, value := range []string{"NaN", "nan", "Inf", "+Inf", "-Inf", "1e3", "0x10", "1..5"} {
+			_, ok = compareValues(value, "2")
+			So(ok, ShouldBeFalse)
+			_, ok = compareValues("2", value)
+			So(ok, ShouldBeFalse)
+		}
+	})
+}
@@ -0,0 +1,215 @@
+package enforcer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aporeto-inc/trireme/cache"
+	"github.com/aporeto-inc/trireme/collector"
+	"github.com/aporeto-inc/trireme/enforcer/lookup"
+	"github.com/aporeto-inc/trireme/metrics"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// Labels of the metrics of the datapath
+const (
+	metricsNetwork     = "network"
+	metricsApplication = "application"
+
+	tokenSign   = "sign"
+	tokenVerify = "verify"
+
+	trackerContexts              = "contexts"
+	trackerAppConnections        = "app-connections"
+	trackerNetConnections        = "net-connections"
+	trackerSourcePorts           = "source-ports"
+	trackerSourcePortConnections = "source-port-connections"
+	trackerAppUDPConnections     = "app-udp-connections"
+	trackerNetUDPConnections     = "net-udp-connections"
+	trackerAppEncryption         = "app-encryption"
+	trackerNetEncryption         = "net-encryption"
+	trackerSynSources            = "syn-sources"
+	trackerKnownSources          = "known-sources"
+)
+
+// latencyBounds are the buckets of the latency histograms, in seconds
+var latencyBounds = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1}
+
+var (
+	packetsCounter = metrics.DefaultRegistry.NewCounter(
+		"trireme_enforcer_packets_total",
+		"Packets received by the enforcer from each queue",
+		"direction", "queue",
+	)
+
+	droppedPacketsCounter = metrics.DefaultRegistry.NewCounter(
+		"trireme_enforcer_dropped_packets_total",
+		"Packets dropped by the enforcer by reason",
+		"pu", "direction", "protocol", "reason",
+	)
+
+	tokenLatency = metrics.DefaultRegistry.NewHistogram(
+		"trireme_enforcer_token_seconds",
+		"Time to sign and to verify the tokens",
+		latencyBounds,
+		"pu", "operation",
+	)
+
+	policyLookupLatency = metrics.DefaultRegistry.NewHistogram(
+		"trireme_enforcer_policy_lookup_seconds",
+		"Time to search the tags of a flow in the policy of a PU",
+		latencyBounds,
+		"pu",
+	)
+
+	trackerSizeGauge = metrics.DefaultRegistry.NewGauge(
+		"trireme_enforcer_tracked_entries",
+		"Entries of the caches of the enforcer",
+		"tracker",
+	)
+
+	trackerEvictionsCounter = metrics.DefaultRegistry.NewCounter(
+		"trireme_enforcer_tracker_evictions_total",
+		"Entries evicted from the caches of the enforcer because they were full",
+		"tracker",
+	)
+)
+
+// dropKey identifies the counter of the dropped packets of a PU
+type dropKey struct {
+	direction string
+	protocol  string
+	reason    collector.DropReason
+}
+
+// lazyHistogram is the handle of a histogram of a PU. It is created with the
+// first observation, so that the PUs only have the samples they observed.
+type lazyHistogram struct {
+	once   sync.Once
+	create func()
+	handle *metrics.HistogramHandle
+}
+
+// newLazyHistogram returns the handle of the histogram of the label values
+func newLazyHistogram(h *metrics.Histogram, labelValues ...string) *lazyHistogram {
+
+	l := &lazyHistogram{}
+	l.create = func() {
+		l.handle = h.WithLabelValues(labelValues...)
+	}
+
+	return l
+}
+
+// ObserveSince adds the seconds elapsed since start to the histogram. It is
+// meant to be deferred.
+func (l *lazyHistogram) ObserveSince(start time.Time) {
+
+	l.once.Do(l.create)
+	l.handle.ObserveSince(start)
+}
+
+// puMetrics holds the handles of the metrics of a PU, so that the datapath does
+// not look up the samples of the label values of the PU for every packet
+type puMetrics struct {
+	contextID    string
+	tokenSign    *lazyHistogram
+	tokenVerify  *lazyHistogram
+	policyLookup *lazyHistogram
+
+	// Key=dropKey Value=*metrics.CounterHandle
+	drops map[dropKey]*metrics.CounterHandle
+	sync.RWMutex
+}
+
+// newPUMetrics creates the handles of the metrics of a PU. The counters of the
+// dropped packets are created with the first drop of each reason.
+func newPUMetrics(contextID string) *puMetrics {
+
+	return &puMetrics{
+		contextID:    contextID,
+		tokenSign:    newLazyHistogram(tokenLatency, contextID, tokenSign),
+		tokenVerify:  newLazyHistogram(tokenLatency, contextID, tokenVerify),
+		policyLookup: newLazyHistogram(policyLookupLatency, contextID),
+		drops:        map[dropKey]*metrics.CounterHandle{},
+	}
+}
+
+// dropped returns the counter of the packets of the PU dropped for the reason
+func (m *puMetrics) dropped(key dropKey) *metrics.CounterHandle {
+
+	m.RLock()
+	counter, ok := m.drops[key]
+	m.RUnlock()
+
+	if ok {
+		return counter
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if counter, ok = m.drops[key]; !ok {
+		counter = droppedPacketsCounter.WithLabelValues(m.contextID, key.direction, key.protocol, string(key.reason))
+		m.drops[key] = counter
+	}
+
+	return counter
+}
+
+// countDrop counts a packet dropped by the enforcer. The context is nil when
+// the packet could not be associated with a PU.
+func countDrop(context *PUContext, direction, protocol string, reason collector.DropReason) {
+
+	if context == nil {
+		droppedPacketsCounter.Inc("", direction, protocol, string(reason))
+		return
+	}
+
+	context.metrics.dropped(dropKey{direction: direction, protocol: protocol, reason: reason}).Inc()
+}
+
+// searchPolicy searches the tags in rules of the PU and records the time of the lookup
+func searchPolicy(context *PUContext, rules *lookup.PolicyDB, tags *policy.TagsMap) (int, interface{}) {
+
+	defer context.metrics.policyLookup.ObserveSince(time.Now())
+
+	return rules.Search(tags)
+}
+
+// updateTrackerSizes sets the gauges of the sizes of the caches. It is called
+// when the metrics are gathered.
+func (d *Datapath) updateTrackerSizes() {
+
+	trackers := map[string]cache.DataStore{
+		trackerContexts:              d.contextTracker,
+		trackerAppConnections:        d.appConnectionTracker,
+		trackerNetConnections:        d.networkConnectionTracker,
+		trackerSourcePorts:           d.sourcePortCache,
+		trackerSourcePortConnections: d.sourcePortConnectionCache,
+		trackerAppUDPConnections:     d.appUDPConnectionTracker,
+		trackerNetUDPConnections:     d.netUDPConnectionTracker,
+		trackerAppEncryption:         d.appEncryptionTracker,
+		trackerNetEncryption:         d.netEncryptionTracker,
+		trackerSynSources:            d.handshakes.sources,
+		trackerKnownSources:          d.handshakes.knownSources,
+	}
+
+	for name, tracker := range trackers {
+		trackerSizeGauge.Set(float64(tracker.SizeOf()), name)
+	}
+}
+
+// newTracker creates a bounded cache that counts the entries it evicts
+func newTracker(name string, maxSize int, lifetime time.Duration, expirer cache.ExpirationNotifier, evictable func(item interface{}) bool) cache.DataStore {
+
+	return cache.NewBoundedCache(cache.BoundedCacheConfig{
+		MaxSize:   maxSize,
+		Lifetime:  lifetime,
+		Expirer:   expirer,
+		Evictable: evictable,
+		Evicted: func(id interface{}, item interface{}) {
+			trackerEvictionsCounter.Inc(name)
+		},
+	})
+}
@@ -27,6 +27,9 @@ const (
 	//AfInet Address Family Inet
 	AfInet = 2
 
+	//AfInet6 Address Family Inet6
+	AfInet6 = 10
+
 	//NfDrop Net filter verdict
 	NfDrop verdictType = 0
 	//NfAccept Net filter verdict
@@ -43,6 +43,9 @@ const (
 	//AfInet Address Family Inet
 	AfInet = 2
 
+	//AfInet6 Address Family Inet6
+	AfInet6 = 10
+
 	//NfDrop Net filter verdict
 	NfDrop verdictType = 0 // nolint
 	//NfAccept Net filter verdict
@@ -119,6 +122,16 @@ func NewNFQueue(queueID uint16, maxPacketsInQueue uint32, packetSize uint32) (*N
 		return nil, fmt.Errorf("Error binding to AfInet protocol family: %v ", err)
 	}
 
+	// IPv6 packets are queued on the same queues. Kernels without IPv6 support
+	// will refuse the binding but we can still process IPv4 traffic.
+	if ret, err = C.nfq_unbind_pf(nfq.h, AfInet6); err != nil || ret < 0 {
+		zap.L().Debug("Unable to unbind existing NFQ handler from AfInet6 protocol family", zap.Error(err))
+	}
+
+	if ret, err = C.nfq_bind_pf(nfq.h, AfInet6); err != nil || ret < 0 {
+		zap.L().Warn("Unable to bind to AfInet6 protocol family", zap.Error(err))
+	}
+
 	nfq.idx = uint32(time.Now().UnixNano())
 
 	// Create the queue
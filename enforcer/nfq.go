@@ -3,7 +3,9 @@ package enforcer
 // Go libraries
 import (
 	"fmt"
+	"strconv"
 
+	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/enforcer/netfilter"
 	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
 	"go.uber.org/zap"
@@ -30,9 +32,11 @@ func (d *Datapath) startNetworkInterceptor() {
 		}
 
 		go func(j uint16) {
+			packets := packetsCounter.WithLabelValues(metricsNetwork, strconv.Itoa(int(d.filterQueue.NetworkQueue+j)))
 			for {
 				select {
 				case packet := <-nfq[j].Packets:
+					packets.Inc()
 					d.processNetworkPacketsFromNFQ(packet)
 				case <-d.netStop[j]:
 					return
@@ -64,9 +68,11 @@ func (d *Datapath) startApplicationInterceptor() {
 		}
 
 		go func(j uint16) {
+			packets := packetsCounter.WithLabelValues(metricsApplication, strconv.Itoa(int(d.filterQueue.ApplicationQueue+j)))
 			for {
 				select {
 				case packet := <-nfq[j].Packets:
+					packets.Inc()
 					d.processApplicationPacketsFromNFQ(packet)
 				case <-d.appStop[j]:
 					return
@@ -86,11 +92,15 @@ func (d *Datapath) processNetworkPacketsFromNFQ(p *netfilter.NFPacket) {
 
 	if err != nil {
 		d.net.CreateDropPackets++
+		countDrop(nil, metricsNetwork, "", collector.MalformedPacket)
 		netPacket.Print(packet.PacketFailureCreate)
 	} else if netPacket.IPProto == packet.IPProtocolTCP {
 		err = d.processNetworkTCPPackets(netPacket)
+	} else if netPacket.IPProto == packet.IPProtocolUDP {
+		err = d.processNetworkUDPPackets(netPacket)
 	} else {
 		d.net.ProtocolDropPackets++
+		countDrop(nil, metricsNetwork, strconv.Itoa(int(netPacket.IPProto)), collector.InvalidProtocol)
 		err = fmt.Errorf("Invalid IP Protocol %d", netPacket.IPProto)
 	}
 
@@ -131,11 +141,15 @@ func (d *Datapath) processApplicationPacketsFromNFQ(p *netfilter.NFPacket) {
 
 	if err != nil {
 		d.app.CreateDropPackets++
+		countDrop(nil, metricsApplication, "", collector.MalformedPacket)
 		appPacket.Print(packet.PacketFailureCreate)
 	} else if appPacket.IPProto == packet.IPProtocolTCP {
 		err = d.processApplicationTCPPackets(appPacket)
+	} else if appPacket.IPProto == packet.IPProtocolUDP {
+		err = d.processApplicationUDPPackets(appPacket)
 	} else {
 		d.app.ProtocolDropPackets++
+		countDrop(nil, metricsApplication, strconv.Itoa(int(appPacket.IPProto)), collector.InvalidProtocol)
 		err = fmt.Errorf("Invalid IP Protocol %d", appPacket.IPProto)
 	}
 
@@ -16,6 +16,7 @@ import (
 	"github.com/aporeto-inc/trireme/enforcer"
 	"github.com/aporeto-inc/trireme/enforcer/utils/rpcwrapper"
 	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+	"github.com/aporeto-inc/trireme/metrics"
 	"github.com/aporeto-inc/trireme/policy"
 	"github.com/aporeto-inc/trireme/processmon"
 )
@@ -134,6 +135,8 @@ func (s *proxyInfo) Unenforce(contextID string) error {
 
 	delete(s.initDone, contextID)
 
+	metrics.DefaultRegistry.RemoveRemote(contextID)
+
 	return nil
 }
 
@@ -148,10 +151,41 @@ func (s *proxyInfo) GetFilterQueue() *enforcer.FilterQueue {
 		ApplicationQueueSize:      enforcer.DefaultQueueSize,
 		NumberOfApplicationQueues: enforcer.DefaultNumberOfQueues,
 		MarkValue:                 enforcer.DefaultMarkValue,
+		MaxTrackedConnections:     enforcer.DefaultMaxTrackedConnections,
+		SynRatePerSource:          enforcer.DefaultSynRatePerSource,
+		SynRatePerPU:              enforcer.DefaultSynRatePerPU,
+		OverloadMode:              enforcer.OverloadDrop,
+		MTU:                       enforcer.DefaultMTU,
 	}
 	return fqConfig
 }
 
+// Connections returns the connections of a PU tracked by its remote enforcer
+func (s *proxyInfo) Connections(contextID string) ([]*enforcer.TrackedConnection, error) {
+
+	if _, ok := s.initDone[contextID]; !ok {
+		return nil, fmt.Errorf("PU %s is not enforced", contextID)
+	}
+
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.ConnectionsRequestPayload{
+			ContextID: contextID,
+		},
+	}
+
+	response := &rpcwrapper.Response{}
+	if err := s.rpchdl.RemoteCall(contextID, "Server.Connections", request, response); err != nil {
+		return nil, fmt.Errorf("Failed to get the connections: context=%s error=%s", contextID, err)
+	}
+
+	payload, ok := response.Payload.(rpcwrapper.ConnectionsResponsePayload)
+	if !ok {
+		return nil, fmt.Errorf("Invalid connections: context=%s", contextID)
+	}
+
+	return payload.Connections, nil
+}
+
 // Start starts the the remote enforcer proxy.
 func (s *proxyInfo) Start() error {
 	return nil
@@ -225,6 +259,11 @@ func NewDefaultProxyEnforcer(serverID string,
 		ApplicationQueueSize:      enforcer.DefaultQueueSize,
 		NumberOfApplicationQueues: enforcer.DefaultNumberOfQueues,
 		MarkValue:                 enforcer.DefaultMarkValue,
+		MaxTrackedConnections:     enforcer.DefaultMaxTrackedConnections,
+		SynRatePerSource:          enforcer.DefaultSynRatePerSource,
+		SynRatePerPU:              enforcer.DefaultSynRatePerPU,
+		OverloadMode:              enforcer.OverloadDrop,
+		MTU:                       enforcer.DefaultMTU,
 	}
 
 	validity := time.Hour * 8760
@@ -263,5 +302,9 @@ func (r *StatsServer) GetStats(req rpcwrapper.Request, resp *rpcwrapper.Response
 		r.collector.CollectFlowEvent(record)
 	}
 
+	if payload.ContextID != "" && len(payload.Metrics) > 0 {
+		metrics.DefaultRegistry.SetRemote(payload.ContextID, payload.Metrics)
+	}
+
 	return nil
 }
@@ -14,6 +14,7 @@ type mockedMethods struct {
 	GetFilterQueueMock func() *enforcer.FilterQueue
 	StartMock          func() error
 	StopMock           func() error
+	ConnectionsMock    func(contextID string) ([]*enforcer.TrackedConnection, error)
 }
 
 // TestEnforcerLauncher is a mock
@@ -24,6 +25,7 @@ type TestEnforcerLauncher interface {
 	MockGetFilterQueue(t *testing.T, impl func() *enforcer.FilterQueue)
 	MockStart(t *testing.T, impl func() error)
 	MockStop(t *testing.T, impl func() error)
+	MockConnections(t *testing.T, impl func(contextID string) ([]*enforcer.TrackedConnection, error))
 }
 
 type testEnforcerLauncher struct {
@@ -70,6 +72,9 @@ func (m *testEnforcerLauncher) MockStart(t *testing.T, impl func() error) {
 func (m *testEnforcerLauncher) MockStop(t *testing.T, impl func() error) {
 	m.currentMocks(t).StartMock = impl
 }
+func (m *testEnforcerLauncher) MockConnections(t *testing.T, impl func(contextID string) ([]*enforcer.TrackedConnection, error)) {
+	m.currentMocks(t).ConnectionsMock = impl
+}
 
 func (m *testEnforcerLauncher) Enforce(contextID string, puInfo *policy.PUInfo) error {
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.EnforceMock != nil {
@@ -106,3 +111,10 @@ func (m *testEnforcerLauncher) Stop() error {
 	}
 	return nil
 }
+func (m *testEnforcerLauncher) Connections(contextID string) ([]*enforcer.TrackedConnection, error) {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.ConnectionsMock != nil {
+		return mock.ConnectionsMock(contextID)
+
+	}
+	return nil, nil
+}
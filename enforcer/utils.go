@@ -1,6 +1,9 @@
 package enforcer
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/enforcer/connection"
 	"github.com/aporeto-inc/trireme/enforcer/lookup"
@@ -8,50 +11,203 @@ import (
 	"github.com/aporeto-inc/trireme/policy"
 )
 
-func (d *Datapath) reportFlow(p *packet.Packet, connection *connection.TCPConnection, sourceID string, destID string, context *PUContext, action string, mode string) {
+func (d *Datapath) reportFlow(p *packet.Packet, connection *connection.TCPConnection, sourceID string, destID string, context *PUContext, action string, reason collector.DropReason, explanation *policy.PolicyExplanation) {
 
 	if connection != nil {
 		connection.SetReported(true)
 	}
 
-	d.collector.CollectFlowEvent(&collector.FlowRecord{
+	d.collector.CollectFlowEvent(flowRecord(p, sourceID, destID, context, action, reason, explanation))
+}
+
+// flowRecord creates the record of a flow of a packet
+func flowRecord(p *packet.Packet, sourceID string, destID string, context *PUContext, action string, reason collector.DropReason, explanation *policy.PolicyExplanation) *collector.FlowRecord {
+
+	return &collector.FlowRecord{
 		ContextID:       context.ID,
 		DestinationID:   destID,
 		SourceID:        sourceID,
 		Tags:            context.Annotations,
 		Action:          action,
-		Mode:            mode,
+		Mode:            flowMode(reason),
+		DropReason:      reason,
 		SourceIP:        p.SourceAddress.String(),
 		DestinationIP:   p.DestinationAddress.String(),
 		DestinationPort: p.DestinationPort,
-	})
+		Explanation:     explanation,
+	}
 }
 
 func (d *Datapath) reportAcceptedFlow(p *packet.Packet, connection *connection.TCPConnection, sourceID string, destID string, context *PUContext) {
 
-	d.reportFlow(p, connection, sourceID, destID, context, collector.FlowAccept, "NA")
+	d.reportFlow(p, connection, sourceID, destID, context, collector.FlowAccept, collector.NoDrop, nil)
+}
+
+func (d *Datapath) reportRejectedFlow(p *packet.Packet, connection *connection.TCPConnection, sourceID string, destID string, context *PUContext, reason collector.DropReason) {
+
+	d.reportFlow(p, connection, sourceID, destID, context, collector.FlowReject, reason, nil)
+}
+
+// reportThrottledFlow reports the Syn packets throttled for a PU. They are
+// aggregated in a single record with their count, whose addresses and port are
+// the ones of the last packet.
+func (d *Datapath) reportThrottledFlow(p *packet.Packet, connection *connection.TCPConnection, context *PUContext) {
+
+	if connection != nil {
+		connection.SetReported(true)
+	}
+
+	count := d.handshakes.throttle(context.ID, time.Now())
+	if count == 0 {
+		return
+	}
+
+	record := flowRecord(p, "", context.ManagementID, context, collector.FlowReject, collector.Throttled, nil)
+	record.Count = count
+
+	d.collector.CollectFlowEvent(record)
 }
 
-func (d *Datapath) reportRejectedFlow(p *packet.Packet, connection *connection.TCPConnection, sourceID string, destID string, context *PUContext, mode string) {
+// reportPolicyDrop reports a flow rejected by the policy with the explanation of the decision
+func (d *Datapath) reportPolicyDrop(p *packet.Packet, connection *connection.TCPConnection, sourceID string, destID string, context *PUContext, explanation *policy.PolicyExplanation) {
 
-	d.reportFlow(p, connection, sourceID, destID, context, collector.FlowReject, mode)
+	d.reportFlow(p, connection, sourceID, destID, context, policyRejectAction(context), collector.PolicyDrop, explanation)
 }
 
-// createRuleDBs creates the database of rules from the policy
+// flowMode returns the mode of the flow records. The accepted flows have no
+// reason and are reported with NA.
+func flowMode(reason collector.DropReason) string {
+
+	if reason == collector.NoDrop {
+		return "NA"
+	}
+
+	return string(reason)
+}
+
+// policyRejectAction returns the action reported for the flows rejected by the
+// policy of a PU. The flows of a PU in audit mode are accepted and reported
+// with the audit action.
+func policyRejectAction(context *PUContext) string {
+
+	if context.Audit {
+		return collector.FlowAudit
+	}
+
+	return collector.FlowReject
+}
+
+// reportLoggedFlow reports a detailed flow record if the matched rule has the Log action.
+// It returns false if the rule does not log its matches and nothing was reported.
+// The explanation of the decision is only provided for the rejected flows.
+func (d *Datapath) reportLoggedFlow(p *packet.Packet, connection *connection.TCPConnection, sourceID string, destID string, context *PUContext, rules *lookup.PolicyDB, index int, action interface{}, flowAction string, reason collector.DropReason, sourceTags, destTags *policy.TagsMap, explanation *policy.PolicyExplanation) bool {
+
+	if ruleAction, ok := action.(policy.FlowAction); !ok || ruleAction&policy.Log == 0 {
+		return false
+	}
+
+	if connection != nil && flowAction != collector.FlowAccept {
+		connection.SetReported(true)
+	}
+
+	d.collector.CollectFlowEvent(&collector.FlowRecord{
+		ContextID:       context.ID,
+		DestinationID:   destID,
+		SourceID:        sourceID,
+		Tags:            context.Annotations,
+		Action:          flowAction,
+		Mode:            flowMode(reason),
+		DropReason:      reason,
+		SourceIP:        p.SourceAddress.String(),
+		DestinationIP:   p.DestinationAddress.String(),
+		DestinationPort: p.DestinationPort,
+		RuleID:          rules.PolicyID(index),
+		ManagementID:    context.ManagementID,
+		SourceTags:      sourceTags,
+		DestinationTags: destTags,
+		Timestamp:       time.Now(),
+		Explanation:     explanation,
+	})
+
+	return true
+}
+
+// ruleSelectors returns the selectors of the policy that accept or reject the
+// flows. Rules without an ID are identified by their index in the policy.
+func ruleSelectors(policyRules *policy.TagSelectorList) []policy.TagSelector {
+
+	selectors := []policy.TagSelector{}
+
+	for i, rule := range policyRules.TagSelectors {
+		if rule.ID == "" {
+			rule.ID = strconv.Itoa(i)
+		}
+
+		if rule.Action&(policy.Accept|policy.Reject) != 0 {
+			selectors = append(selectors, rule)
+		}
+	}
+
+	return selectors
+}
+
+// createRuleDBs creates the database of rules from the policy. Rules without
+// an ID are identified by their index in the policy.
 func createRuleDBs(policyRules *policy.TagSelectorList) (*lookup.PolicyDB, *lookup.PolicyDB) {
 
 	acceptRules := lookup.NewPolicyDB()
 	rejectRules := lookup.NewPolicyDB()
 
-	for _, rule := range policyRules.TagSelectors {
+	for _, rule := range ruleSelectors(policyRules) {
+		addRule(acceptRules, rejectRules, rule)
+	}
+
+	return acceptRules, rejectRules
+}
+
+// updateRuleDBs changes the databases of rules to the ones of the policy by
+// removing and adding the rules that changed. It returns false without changing
+// the databases if they must be created again because too many rules changed.
+func updateRuleDBs(acceptRules, rejectRules *lookup.PolicyDB, policyRules *policy.TagSelectorList) bool {
+
+	if acceptRules == nil || rejectRules == nil {
+		return false
+	}
+
+	current := append(acceptRules.Selectors(), rejectRules.Selectors()...)
+	added, removed := policy.DiffTagSelectors(current, ruleSelectors(policyRules))
+
+	if len(added)+len(removed) > policy.MaxIncrementalChanges {
+		return false
+	}
+
+	// The removed rules are still searched so the databases are compacted
+	// when they are more than the remaining ones
+	if acceptRules.Removed()+rejectRules.Removed()+len(removed) > len(current)-len(removed) {
+		return false
+	}
+
+	for _, rule := range removed {
 		if rule.Action&policy.Accept != 0 {
-			acceptRules.AddPolicy(rule)
-		} else if rule.Action&policy.Reject != 0 {
-			rejectRules.AddPolicy(rule)
+			acceptRules.RemovePolicy(rule)
 		} else {
-			continue
+			rejectRules.RemovePolicy(rule)
 		}
 	}
 
-	return acceptRules, rejectRules
+	for _, rule := range added {
+		addRule(acceptRules, rejectRules, rule)
+	}
+
+	return true
+}
+
+// addRule adds the rule to the database of its action
+func addRule(acceptRules, rejectRules *lookup.PolicyDB, rule policy.TagSelector) {
+
+	if rule.Action&policy.Accept != 0 {
+		acceptRules.AddPolicy(rule)
+	} else {
+		rejectRules.AddPolicy(rule)
+	}
 }
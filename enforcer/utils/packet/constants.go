@@ -1,13 +1,34 @@
 package packet
 
 const (
-	// minIPPacketLen is the min ip packet size
-	minIPPacketLen = 40
-
 	// minIPHdrSize
 	minIPHdrSize = 20
 
 	minIPHdrWords = (minIPHdrSize / 4)
+
+	// ipv6HdrSize is the size of the fixed IPv6 header
+	ipv6HdrSize = 40
+
+	// maxIPPacketLen is the largest length of a packet that is not a jumbogram
+	maxIPPacketLen = 0xffff
+
+	// maxIPHdrWords is the largest length of the IP headers in 32-bit words
+	maxIPHdrWords = 0xff
+
+	// minTCPHdrSize is the size of a TCP header without options
+	minTCPHdrSize = 20
+
+	// udpHdrSize is the size of the UDP header
+	udpHdrSize = 8
+)
+
+// IP versions
+const (
+	// IPVersion4 is the version number of IPv4 packets
+	IPVersion4 = 4
+
+	// IPVersion6 is the version number of IPv6 packets
+	IPVersion6 = 6
 )
 
 // IP Header field position constants
@@ -34,6 +55,41 @@ const (
 	ipDestAddrPos = 16
 )
 
+// IPv6 Header field position constants
+const (
+	// ipv6PayloadLenPos is the location of the IPv6 payload length
+	ipv6PayloadLenPos = 4
+
+	// ipv6NextHeaderPos is the location of the IPv6 next header
+	ipv6NextHeaderPos = 6
+
+	// ipv6SourceAddrPos is location of source IPv6 address
+	ipv6SourceAddrPos = 8
+
+	// ipv6DestAddrPos is location of destination IPv6 address
+	ipv6DestAddrPos = 24
+)
+
+// IPv6 extension headers that can be skipped to reach the transport header
+const (
+	ipv6HopByHopHeader = 0
+	ipv6RoutingHeader  = 43
+	ipv6DestOptsHeader = 60
+	ipv6FragmentHeader = 44
+)
+
+// IPv6 fragment header constants
+const (
+	// ipv6FragmentHdrSize is the size of the IPv6 fragment header
+	ipv6FragmentHdrSize = 8
+
+	// ipv6FragmentOffsetPos is the location of the fragment offset in the fragment header
+	ipv6FragmentOffsetPos = 2
+
+	// ipv6FragmentOffsetMask is the mask of the fragment offset
+	ipv6FragmentOffsetMask = 0xfff8
+)
+
 // IP Protocol numbers
 const (
 	// IPProtocolTCP defines the constant for UDP protocol number
@@ -46,30 +102,50 @@ const (
 // IP Header masks
 const (
 	ipHdrLenMask = 0xF
+
+	ipVersionShift = 4
 )
 
-// TCP Header field position constants
+// TCP Header field position constants. The positions are relative to the
+// beginning of the TCP header, since the IP header length depends on the
+// IP version.
 const (
 	// tcpSourcePortPos is the location of source port
-	tcpSourcePortPos = 20
+	tcpSourcePortPos = 0
 
 	// tcpDestPortPos is the location of destination port
-	tcpDestPortPos = 22
+	tcpDestPortPos = 2
 
 	// tcpSeqPos is the location of seq
-	tcpSeqPos = 24
+	tcpSeqPos = 4
 
 	// tcpAckPos is the location of seq
-	tcpAckPos = 28
+	tcpAckPos = 8
 
 	// tcpDataOffsetPos is the location of the TCP data offset
-	tcpDataOffsetPos = 32
+	tcpDataOffsetPos = 12
 
 	//tcpFlagsOfsetPos is the location of the TCP flags
-	tcpFlagsOffsetPos = 33
+	tcpFlagsOffsetPos = 13
 
 	// TCPChecksumPos is the location of TCP checksum
-	TCPChecksumPos = 36
+	TCPChecksumPos = 16
+)
+
+// UDP Header field position constants. The positions are relative to the
+// beginning of the UDP header.
+const (
+	// udpSourcePortPos is the location of source port
+	udpSourcePortPos = 0
+
+	// udpDestPortPos is the location of destination port
+	udpDestPortPos = 2
+
+	// udpLengthPos is the location of the UDP length
+	udpLengthPos = 4
+
+	// UDPChecksumPos is the location of UDP checksum
+	UDPChecksumPos = 6
 )
 
 // TCP Header masks
@@ -103,4 +179,23 @@ const (
 
 	// TCPMssOptionLen is the type for MSS option
 	TCPMssOptionLen = uint8(4)
+
+	// TCPEncryptionOption is the option that carries the tag of the encrypted
+	// payload. It is an experimental option number (RFC 4727).
+	TCPEncryptionOption = uint8(253)
+
+	// tcpEndOption is the type of the option that ends the option list
+	tcpEndOption = uint8(0)
+
+	// tcpNopOption is the type of the no operation option
+	tcpNopOption = uint8(1)
+
+	// tcpSackPermittedOption is the type of the Sack permitted option
+	tcpSackPermittedOption = uint8(4)
+
+	// tcpSackPermittedOptionLen is the length of the Sack permitted option
+	tcpSackPermittedOptionLen = uint8(2)
+
+	// maxTCPHdrWords is the maximum size of a TCP header in 32-bit words
+	maxTCPHdrWords = 15
 )
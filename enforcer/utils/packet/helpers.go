@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 // Helpher functions for the package, mainly for debugging and validation
@@ -24,9 +25,13 @@ func (p *Packet) VerifyIPChecksum() bool {
 }
 
 // UpdateIPChecksum computes the IP header checksum and updates the
-// packet with the value.
+// packet with the value. It is a no-op for IPv6 packets.
 func (p *Packet) UpdateIPChecksum() {
 
+	if p.IsIPv6() {
+		return
+	}
+
 	p.ipChecksum = p.computeIPChecksum()
 
 	binary.BigEndian.PutUint16(p.Buffer[ipChecksumPos:ipChecksumPos+2], p.ipChecksum)
@@ -48,7 +53,26 @@ func (p *Packet) UpdateTCPChecksum() {
 
 	p.TCPChecksum = p.computeTCPChecksum()
 
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
+	binary.BigEndian.PutUint16(p.Buffer[p.tcpPos(TCPChecksumPos):p.tcpPos(TCPChecksumPos)+2], p.TCPChecksum)
+}
+
+// VerifyUDPChecksum returns true if the UDP checksum is correct
+// for this packet, false otherwise. Note that the checksum is not
+// modified.
+func (p *Packet) VerifyUDPChecksum() bool {
+
+	sum := p.computeUDPChecksum()
+
+	return sum == p.UDPChecksum
+}
+
+// UpdateUDPChecksum computes the UDP checksum and updates the
+// packet with the value.
+func (p *Packet) UpdateUDPChecksum() {
+
+	p.UDPChecksum = p.computeUDPChecksum()
+
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+UDPChecksumPos:p.l4BeginPos+UDPChecksumPos+2], p.UDPChecksum)
 }
 
 // String returns a string representation of fields contained in this packet.
@@ -57,7 +81,14 @@ func (p *Packet) String() string {
 	var buf bytes.Buffer
 	buf.WriteString("(error)")
 
-	header, err := ipv4.ParseHeader(p.Buffer)
+	var header fmt.Stringer
+	var err error
+
+	if p.IsIPv6() {
+		header, err = ipv6.ParseHeader(p.Buffer)
+	} else {
+		header, err = ipv4.ParseHeader(p.Buffer)
+	}
 
 	if err == nil {
 		buf.Reset()
@@ -74,9 +105,14 @@ func (p *Packet) String() string {
 	return buf.String()
 }
 
-// Computes the IP header checksum. The packet is not modified.
+// Computes the IP header checksum. The packet is not modified. IPv6 has no
+// header checksum and zero is returned.
 func (p *Packet) computeIPChecksum() uint16 {
 
+	if p.IsIPv6() {
+		return 0
+	}
+
 	// IP packet checksum is computed with the checksum value set to zero
 	binary.BigEndian.PutUint16(p.Buffer[ipChecksumPos:ipChecksumPos+2], uint16(0))
 
@@ -89,15 +125,30 @@ func (p *Packet) computeIPChecksum() uint16 {
 	return sum
 }
 
-// Computes the TCP header checksum. The packet is not modified.
-func (p *Packet) computeTCPChecksum() uint16 {
+// pseudoHeader returns the pseudo-header used for the TCP and UDP checksum
+// computation. The l4Length is the size of the transport buffer (real header + payload).
+func (p *Packet) pseudoHeader(protocol uint8, l4Length uint16) []byte {
 
-	var pseudoHeaderLen uint16 = 12
-	tcpSize := uint16(len(p.Buffer)) - p.l4BeginPos
-	bufLen := pseudoHeaderLen + tcpSize
-	buf := make([]byte, bufLen)
+	if p.IsIPv6() {
+		// IPv6 pseudo-header (RFC 2460 section 8.1)
+		buf := make([]byte, 40)
+
+		// bytes 0-15: Source IP address
+		copy(buf[0:16], p.Buffer[ipv6SourceAddrPos:ipv6SourceAddrPos+16])
+
+		// bytes 16-31: Destination IP address
+		copy(buf[16:32], p.Buffer[ipv6DestAddrPos:ipv6DestAddrPos+16])
+
+		// bytes 32-35: Upper layer packet length
+		binary.BigEndian.PutUint32(buf[32:36], uint32(l4Length))
 
-	// Construct the pseudo-header for TCP checksum computation:
+		// bytes 36-38 are zero and byte 39 is the next header (6==TCP, 17==UDP)
+		buf[39] = protocol
+
+		return buf
+	}
+
+	buf := make([]byte, 12)
 
 	// bytes 0-3: Source IP address
 	copy(buf[0:4], p.Buffer[ipSourceAddrPos:ipSourceAddrPos+4])
@@ -108,18 +159,30 @@ func (p *Packet) computeTCPChecksum() uint16 {
 	// byte 8: Constant zero
 	buf[8] = 0
 
-	// byte 9: Protocol (6==TCP)
-	buf[9] = 6
+	// byte 9: Protocol (6==TCP, 17==UDP)
+	buf[9] = protocol
+
+	// bytes 10,11: Transport buffer size (real header + payload)
+	binary.BigEndian.PutUint16(buf[10:12], l4Length)
+
+	return buf
+}
+
+// Computes the TCP header checksum. The packet is not modified.
+func (p *Packet) computeTCPChecksum() uint16 {
+
+	tcpSize := uint16(len(p.Buffer)) - p.l4BeginPos
 
-	// bytes 10,11: TCP buffer size (real header + payload)
-	binary.BigEndian.PutUint16(buf[10:12], tcpSize+uint16(len(p.tcpData)+len(p.tcpOptions)))
+	// Construct the pseudo-header for TCP checksum computation
+	buf := p.pseudoHeader(IPProtocolTCP, tcpSize+uint16(len(p.tcpData)+len(p.tcpOptions)))
+	pseudoHeaderLen := len(buf)
 
-	// bytes 12+: The TCP buffer (real header + payload)
-	copy(buf[12:], p.Buffer[p.l4BeginPos:])
+	// The TCP buffer (real header + payload)
+	buf = append(buf, p.Buffer[p.l4BeginPos:]...)
 
 	// Set current checksum to zero (in buf, not changing packet)
-	buf[pseudoHeaderLen+16] = 0
-	buf[pseudoHeaderLen+17] = 0
+	buf[pseudoHeaderLen+TCPChecksumPos] = 0
+	buf[pseudoHeaderLen+TCPChecksumPos+1] = 0
 
 	buf = append(buf, p.tcpOptions...)
 	buf = append(buf, p.tcpData...)
@@ -127,6 +190,32 @@ func (p *Packet) computeTCPChecksum() uint16 {
 	return checksum(buf)
 }
 
+// Computes the UDP checksum. The packet is not modified.
+func (p *Packet) computeUDPChecksum() uint16 {
+
+	udpSize := uint16(len(p.Buffer)) - p.l4BeginPos
+
+	// Construct the pseudo-header for UDP checksum computation
+	buf := p.pseudoHeader(IPProtocolUDP, udpSize+uint16(len(p.tcpData)))
+	pseudoHeaderLen := len(buf)
+
+	// The UDP buffer (real header + payload)
+	buf = append(buf, p.Buffer[p.l4BeginPos:]...)
+
+	// Set current checksum to zero (in buf, not changing packet)
+	buf[pseudoHeaderLen+UDPChecksumPos] = 0
+	buf[pseudoHeaderLen+UDPChecksumPos+1] = 0
+
+	buf = append(buf, p.tcpData...)
+
+	// A computed checksum of zero is transmitted as all ones (RFC 768)
+	if csum := checksum(buf); csum != 0 {
+		return csum
+	}
+
+	return 0xffff
+}
+
 // incCsum16 implements rfc1624, equation 3.
 func incCsum16(start, old, new uint16) uint16 {
 
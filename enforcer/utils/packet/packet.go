@@ -49,7 +49,7 @@ func init() {
 
 // New returns a pointer to Packet structure built from the
 // provided bytes buffer which is expected to contain valid TCP/IP
-// packet bytes.
+// packet bytes. Both IPv4 and IPv6 packets are supported.
 func New(context uint64, bytes []byte, mark string) (packet *Packet, err error) {
 
 	var p Packet
@@ -64,45 +64,188 @@ func New(context uint64, bytes []byte, mark string) (packet *Packet, err error)
 	p.tcpOptions = []byte{}
 	p.tcpData = []byte{}
 
+	if len(bytes) == 0 {
+		return nil, fmt.Errorf("Empty packet")
+	}
+
 	// IP Header Processing
-	p.ipHeaderLen = bytes[ipHdrLenPos] & ipHdrLenMask
-	p.IPProto = bytes[ipProtoPos]
-	p.IPTotalLength = binary.BigEndian.Uint16(bytes[ipLengthPos : ipLengthPos+2])
-	p.ipID = binary.BigEndian.Uint16(bytes[IPIDPos : IPIDPos+2])
-	p.ipChecksum = binary.BigEndian.Uint16(bytes[ipChecksumPos : ipChecksumPos+2])
-	p.SourceAddress = net.IP(bytes[ipSourceAddrPos : ipSourceAddrPos+4])
-	p.DestinationAddress = net.IP(bytes[ipDestAddrPos : ipDestAddrPos+4])
+	p.IPVersion = bytes[ipHdrLenPos] >> ipVersionShift
+	switch p.IPVersion {
+	case IPVersion4:
+		err = p.parseIPv4Header()
+	case IPVersion6:
+		err = p.parseIPv6Header()
+	default:
+		err = fmt.Errorf("Unsupported IP version %d", p.IPVersion)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// UDP Header Processing
+	if p.IPProto == IPProtocolUDP {
+		p.SourcePort = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+udpSourcePortPos : p.l4BeginPos+udpSourcePortPos+2])
+		p.DestinationPort = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+udpDestPortPos : p.l4BeginPos+udpDestPortPos+2])
+		p.UDPChecksum = binary.BigEndian.Uint16(p.Buffer[p.l4BeginPos+UDPChecksumPos : p.l4BeginPos+UDPChecksumPos+2])
+		p.context = context
+
+		return &p, nil
+	}
+
+	// TCP Header Processing
+	p.TCPChecksum = binary.BigEndian.Uint16(p.Buffer[p.tcpPos(TCPChecksumPos) : p.tcpPos(TCPChecksumPos)+2])
+	p.SourcePort = binary.BigEndian.Uint16(p.Buffer[p.tcpPos(tcpSourcePortPos) : p.tcpPos(tcpSourcePortPos)+2])
+	p.DestinationPort = binary.BigEndian.Uint16(p.Buffer[p.tcpPos(tcpDestPortPos) : p.tcpPos(tcpDestPortPos)+2])
+	p.TCPAck = binary.BigEndian.Uint32(p.Buffer[p.tcpPos(tcpAckPos) : p.tcpPos(tcpAckPos)+4])
+	p.TCPSeq = binary.BigEndian.Uint32(p.Buffer[p.tcpPos(tcpSeqPos) : p.tcpPos(tcpSeqPos)+4])
+	p.tcpDataOffset = (p.Buffer[p.tcpPos(tcpDataOffsetPos)] & tcpDataOffsetMask) >> 4
+	p.TCPFlags = p.Buffer[p.tcpPos(tcpFlagsOffsetPos)]
+
+	p.context = context
+
+	return &p, nil
+}
+
+// parseIPv4Header processes the IPv4 header of the packet
+func (p *Packet) parseIPv4Header() error {
+
+	if len(p.Buffer) < minIPHdrSize {
+		return fmt.Errorf("IP Packet too small (len=%d)", len(p.Buffer))
+	}
+
+	p.ipHeaderLen = p.Buffer[ipHdrLenPos] & ipHdrLenMask
+	p.IPProto = p.Buffer[ipProtoPos]
+	p.IPTotalLength = binary.BigEndian.Uint16(p.Buffer[ipLengthPos : ipLengthPos+2])
+	p.ipID = binary.BigEndian.Uint16(p.Buffer[IPIDPos : IPIDPos+2])
+	p.ipChecksum = binary.BigEndian.Uint16(p.Buffer[ipChecksumPos : ipChecksumPos+2])
+	p.SourceAddress = net.IP(p.Buffer[ipSourceAddrPos : ipSourceAddrPos+4])
+	p.DestinationAddress = net.IP(p.Buffer[ipDestAddrPos : ipDestAddrPos+4])
 
 	// Some sanity checking...
-	if p.IPTotalLength < minIPPacketLen {
-		return nil, fmt.Errorf("IP Packet too small (hdrlen=%d)", p.ipHeaderLen)
+	if p.IPTotalLength < minIPHdrSize+p.minL4HdrSize() {
+		return fmt.Errorf("IP Packet too small (hdrlen=%d)", p.ipHeaderLen)
 	}
 
 	if p.ipHeaderLen != minIPHdrWords {
-		return nil, fmt.Errorf("Packets with IP options not supported (hdrlen=%d)", p.ipHeaderLen)
+		return fmt.Errorf("Packets with IP options not supported (hdrlen=%d)", p.ipHeaderLen)
+	}
+
+	if err := p.trimToTotalLength(); err != nil {
+		return err
+	}
+
+	p.l4BeginPos = minIPHdrSize
+
+	return nil
+}
+
+// parseIPv6Header processes the IPv6 header of the packet. Hop-by-hop,
+// routing, destination options and fragment extension headers are skipped.
+// Only the first fragment of a packet carries the transport header, the
+// other fragments are rejected.
+func (p *Packet) parseIPv6Header() error {
+
+	if len(p.Buffer) < ipv6HdrSize {
+		return fmt.Errorf("IPv6 Packet too small (len=%d)", len(p.Buffer))
+	}
+
+	// The jumbo payloads have a length of zero and the real length in a
+	// hop-by-hop option. They do not fit the length of the packet.
+	payloadLen := int(binary.BigEndian.Uint16(p.Buffer[ipv6PayloadLenPos : ipv6PayloadLenPos+2]))
+	if payloadLen == 0 && len(p.Buffer) > ipv6HdrSize {
+		return fmt.Errorf("IPv6 jumbo payloads not supported")
+	}
+	if payloadLen+ipv6HdrSize > maxIPPacketLen {
+		return fmt.Errorf("IPv6 Packet too large (payload=%d)", payloadLen)
+	}
+
+	p.IPTotalLength = uint16(payloadLen + ipv6HdrSize)
+	p.SourceAddress = net.IP(p.Buffer[ipv6SourceAddrPos : ipv6SourceAddrPos+16])
+	p.DestinationAddress = net.IP(p.Buffer[ipv6DestAddrPos : ipv6DestAddrPos+16])
+
+	if err := p.trimToTotalLength(); err != nil {
+		return err
+	}
+
+	nextHeader := p.Buffer[ipv6NextHeaderPos]
+	totalLength := int(p.IPTotalLength)
+	offset := ipv6HdrSize
+
+extensionHeaders:
+	for {
+		switch nextHeader {
+		case ipv6HopByHopHeader, ipv6RoutingHeader, ipv6DestOptsHeader:
+			if offset+8 > totalLength {
+				return fmt.Errorf("IPv6 extension header exceeds packet length")
+			}
+			nextHeader = p.Buffer[offset]
+			offset = offset + (int(p.Buffer[offset+1])+1)*8
+
+		case ipv6FragmentHeader:
+			if offset+ipv6FragmentHdrSize > totalLength {
+				return fmt.Errorf("IPv6 fragment header exceeds packet length")
+			}
+			fragmentOffset := binary.BigEndian.Uint16(p.Buffer[offset+ipv6FragmentOffsetPos:offset+ipv6FragmentOffsetPos+2]) & ipv6FragmentOffsetMask
+			if fragmentOffset != 0 {
+				return fmt.Errorf("IPv6 non-first fragments are not supported (offset=%d)", fragmentOffset)
+			}
+			nextHeader = p.Buffer[offset]
+			offset = offset + ipv6FragmentHdrSize
+
+		default:
+			break extensionHeaders
+		}
+	}
+
+	p.IPProto = nextHeader
+
+	if offset+int(p.minL4HdrSize()) > totalLength {
+		return fmt.Errorf("IPv6 Packet too small for transport header (offset=%d)", offset)
+	}
+
+	// The length of the headers is kept in words like the IPv4 header length
+	if offset/4 > maxIPHdrWords {
+		return fmt.Errorf("IPv6 extension headers too long (len=%d)", offset)
 	}
 
+	p.ipHeaderLen = uint8(offset / 4)
+	p.l4BeginPos = uint16(offset)
+
+	return nil
+}
+
+// trimToTotalLength makes sure that the buffer matches the stated IP length
+func (p *Packet) trimToTotalLength() error {
+
 	if p.IPTotalLength != uint16(len(p.Buffer)) {
 		if p.IPTotalLength < uint16(len(p.Buffer)) {
 			p.Buffer = p.Buffer[:p.IPTotalLength]
 		} else {
-			return nil, fmt.Errorf("Stated IP packet length (%d) differs from bytes available (%d)", p.IPTotalLength, len(p.Buffer))
+			return fmt.Errorf("Stated IP packet length (%d) differs from bytes available (%d)", p.IPTotalLength, len(p.Buffer))
 		}
 	}
 
-	// TCP Header Processing
-	p.l4BeginPos = minIPHdrSize
-	p.TCPChecksum = binary.BigEndian.Uint16(bytes[TCPChecksumPos : TCPChecksumPos+2])
-	p.SourcePort = binary.BigEndian.Uint16(bytes[tcpSourcePortPos : tcpSourcePortPos+2])
-	p.DestinationPort = binary.BigEndian.Uint16(bytes[tcpDestPortPos : tcpDestPortPos+2])
-	p.TCPAck = binary.BigEndian.Uint32(bytes[tcpAckPos : tcpAckPos+4])
-	p.TCPSeq = binary.BigEndian.Uint32(bytes[tcpSeqPos : tcpSeqPos+4])
-	p.tcpDataOffset = (bytes[tcpDataOffsetPos] & tcpDataOffsetMask) >> 4
-	p.TCPFlags = bytes[tcpFlagsOffsetPos]
+	return nil
+}
 
-	p.context = context
+// minL4HdrSize returns the minimum size of the transport header of the packet
+func (p *Packet) minL4HdrSize() uint16 {
 
-	return &p, nil
+	if p.IPProto == IPProtocolUDP {
+		return udpHdrSize
+	}
+
+	return minTCPHdrSize
+}
+
+// tcpPos returns the absolute position of a TCP header field in the buffer
+func (p *Packet) tcpPos(field uint16) uint16 {
+	return p.l4BeginPos + field
+}
+
+// IsIPv6 returns true if this is an IPv6 packet
+func (p *Packet) IsIPv6() bool {
+	return p.IPVersion == IPVersion6
 }
 
 // GetTCPData returns any additional data in the packet
@@ -178,8 +321,8 @@ func (p *Packet) Print(context uint64) {
 			p.ipID,
 			flagsToDir(p.context|context),
 			flagsToStr(p.context|context),
-			p.SourceAddress.To4().String(), p.SourcePort,
-			p.DestinationAddress.To4().String(), p.DestinationPort,
+			p.SourceAddress.String(), p.SourcePort,
+			p.DestinationAddress.String(), p.DestinationPort,
 			tcpFlagsToStr(p.TCPFlags),
 			p.TCPSeq, p.TCPAck, p.IPTotalLength-p.TCPDataStartBytes(),
 			expAck, expAck, p.tcpDataOffset,
@@ -244,14 +387,99 @@ func (p *Packet) CheckTCPAuthenticationOption(iOptionLength int) (err error) {
 	return
 }
 
+// HasLastTCPOption returns true if the last option of the TCP header has the
+// type and the length provided. Like the authentication option, our options
+// are always added last.
+func (p *Packet) HasLastTCPOption(kind uint8, length uint16) bool {
+
+	start := p.TCPDataStartBytes()
+	if start < p.l4BeginPos+minTCPHdrSize+length || uint16(len(p.Buffer)) < start {
+		return false
+	}
+
+	return p.Buffer[start-length] == kind && uint16(p.Buffer[start-length+1]) == length
+}
+
+// ReduceTCPMSS reduces the maximum segment size advertised in the TCP header
+// so that the peer leaves room for options that we add to its segments
+func (p *Packet) ReduceTCPMSS(delta uint16) {
+
+	pos := p.tcpOptionPosition(TCPMssOption, TCPMssOptionLen)
+	if pos == 0 {
+		return
+	}
+
+	mss := binary.BigEndian.Uint16(p.Buffer[pos+2 : pos+4])
+	if mss <= delta {
+		return
+	}
+
+	binary.BigEndian.PutUint16(p.Buffer[pos+2:pos+4], mss-delta)
+	p.UpdateTCPChecksum()
+}
+
+// RemoveTCPSackPermitted replaces the Sack permitted option of the TCP header
+// with no operation options. The Sack blocks of a connection may otherwise
+// fill the TCP header of its segments.
+func (p *Packet) RemoveTCPSackPermitted() {
+
+	pos := p.tcpOptionPosition(tcpSackPermittedOption, tcpSackPermittedOptionLen)
+	if pos == 0 {
+		return
+	}
+
+	p.Buffer[pos] = tcpNopOption
+	p.Buffer[pos+1] = tcpNopOption
+	p.UpdateTCPChecksum()
+}
+
+// tcpOptionPosition returns the position in the buffer of the option of the
+// TCP header with the type and the length provided, or zero if there is none
+func (p *Packet) tcpOptionPosition(kind uint8, length uint8) uint16 {
+
+	end := p.TCPDataStartBytes()
+	if uint16(len(p.Buffer)) < end {
+		return 0
+	}
+
+	for pos := p.l4BeginPos + minTCPHdrSize; pos < end; {
+		switch p.Buffer[pos] {
+		case tcpEndOption:
+			return 0
+		case tcpNopOption:
+			pos++
+			continue
+		}
+
+		if pos+1 >= end || p.Buffer[pos+1] < 2 || pos+uint16(p.Buffer[pos+1]) > end {
+			return 0
+		}
+
+		if p.Buffer[pos] == kind && p.Buffer[pos+1] == length {
+			return pos
+		}
+
+		pos += uint16(p.Buffer[pos+1])
+	}
+
+	return 0
+}
+
 // FixupIPHdrOnDataModify modifies the IP header fields and checksum
 func (p *Packet) FixupIPHdrOnDataModify(old, new uint16) {
 
+	// Update IP Total Length.
+	p.IPTotalLength = p.IPTotalLength + new - old
+
+	// IPv6 has no header checksum and carries only the payload length
+	if p.IsIPv6() {
+		binary.BigEndian.PutUint16(p.Buffer[ipv6PayloadLenPos:ipv6PayloadLenPos+2], p.IPTotalLength-ipv6HdrSize)
+		return
+	}
+
 	// IP Header Processing
 	// IP chekcsum fixup.
 	p.ipChecksum = incCsum16(p.ipChecksum, old, new)
-	// Update IP Total Length.
-	p.IPTotalLength = p.IPTotalLength + new - old
 
 	binary.BigEndian.PutUint16(p.Buffer[ipLengthPos:ipLengthPos+2], p.IPTotalLength)
 	binary.BigEndian.PutUint16(p.Buffer[ipChecksumPos:ipChecksumPos+2], p.ipChecksum)
@@ -268,7 +496,7 @@ func (p *Packet) FixTCPCsum(old, new uint32) {
 	}
 
 	p.TCPChecksum = -uint16(a)
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
+	binary.BigEndian.PutUint16(p.Buffer[p.tcpPos(TCPChecksumPos):p.tcpPos(TCPChecksumPos)+2], p.TCPChecksum)
 }
 
 // IncreaseTCPSeq increases TCP seq number by incr
@@ -276,7 +504,7 @@ func (p *Packet) IncreaseTCPSeq(incr uint32) {
 
 	oldTCPSeq := p.TCPSeq
 	p.TCPSeq = p.TCPSeq + incr
-	binary.BigEndian.PutUint32(p.Buffer[tcpSeqPos:tcpSeqPos+4], p.TCPSeq)
+	binary.BigEndian.PutUint32(p.Buffer[p.tcpPos(tcpSeqPos):p.tcpPos(tcpSeqPos)+4], p.TCPSeq)
 	p.FixTCPCsum(oldTCPSeq, p.TCPSeq)
 }
 
@@ -285,7 +513,7 @@ func (p *Packet) DecreaseTCPSeq(decr uint32) {
 
 	oldTCPSeq := p.TCPSeq
 	p.TCPSeq = p.TCPSeq - decr
-	binary.BigEndian.PutUint32(p.Buffer[tcpSeqPos:tcpSeqPos+4], p.TCPSeq)
+	binary.BigEndian.PutUint32(p.Buffer[p.tcpPos(tcpSeqPos):p.tcpPos(tcpSeqPos)+4], p.TCPSeq)
 	p.FixTCPCsum(oldTCPSeq, p.TCPSeq)
 }
 
@@ -294,7 +522,7 @@ func (p *Packet) IncreaseTCPAck(incr uint32) {
 
 	oldTCPAck := p.TCPAck
 	p.TCPAck = p.TCPAck + incr
-	binary.BigEndian.PutUint32(p.Buffer[tcpAckPos:tcpAckPos+4], p.TCPAck)
+	binary.BigEndian.PutUint32(p.Buffer[p.tcpPos(tcpAckPos):p.tcpPos(tcpAckPos)+4], p.TCPAck)
 	p.FixTCPCsum(oldTCPAck, p.TCPAck)
 }
 
@@ -303,7 +531,7 @@ func (p *Packet) DecreaseTCPAck(decr uint32) {
 
 	oldTCPAck := p.TCPAck
 	p.TCPAck = p.TCPAck - decr
-	binary.BigEndian.PutUint32(p.Buffer[tcpAckPos:tcpAckPos+4], p.TCPAck)
+	binary.BigEndian.PutUint32(p.Buffer[p.tcpPos(tcpAckPos):p.tcpPos(tcpAckPos)+4], p.TCPAck)
 	p.FixTCPCsum(oldTCPAck, p.TCPAck)
 }
 
@@ -333,11 +561,11 @@ func (p *Packet) FixupTCPHdrOnTCPDataDetach(dataLength uint16, optionLength uint
 	a := uint32(-p.TCPChecksum) - p.computeTCPChecksumDelta(p.tcpOptions[:optionLength], optionLength, p.tcpData[:dataLength], dataLength)
 	a = a + (a >> 16)
 	p.TCPChecksum = -uint16(a)
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
+	binary.BigEndian.PutUint16(p.Buffer[p.tcpPos(TCPChecksumPos):p.tcpPos(TCPChecksumPos)+2], p.TCPChecksum)
 
 	// Update DataOffset
 	p.tcpDataOffset = p.tcpDataOffset - uint8(optionLength/4)
-	p.Buffer[tcpDataOffsetPos] = p.tcpDataOffset << 4
+	p.Buffer[p.tcpPos(tcpDataOffsetPos)] = p.tcpDataOffset << 4
 }
 
 // tcpDataDetach splits the p.Buffer into p.Buffer (header + some options), p.tcpOptions (optionLength) and p.TCPData (dataLength)
@@ -402,8 +630,8 @@ func (p *Packet) FixupTCPHdrOnTCPDataAttach(tcpOptions []byte, tcpData []byte) {
 
 	// Modify the fields
 	p.tcpDataOffset = p.tcpDataOffset + uint8(numberOfOptions)
-	binary.BigEndian.PutUint16(p.Buffer[TCPChecksumPos:TCPChecksumPos+2], p.TCPChecksum)
-	p.Buffer[tcpDataOffsetPos] = p.tcpDataOffset << 4
+	binary.BigEndian.PutUint16(p.Buffer[p.tcpPos(TCPChecksumPos):p.tcpPos(TCPChecksumPos)+2], p.TCPChecksum)
+	p.Buffer[p.tcpPos(tcpDataOffsetPos)] = p.tcpDataOffset << 4
 }
 
 // tcpDataAttach splits the p.Buffer into p.Buffer (header + some options), p.tcpOptions (optionLength) and p.TCPData (dataLength)
@@ -415,6 +643,10 @@ func (p *Packet) tcpDataAttach(options []byte, data []byte) (err error) {
 		return fmt.Errorf("Cannot insert options with existing data: optionLength=%d, IPTotalLength=%d", optionLength, p.IPTotalLength)
 	}
 
+	if int(p.tcpDataOffset)+optionLength/4 > maxTCPHdrWords {
+		return fmt.Errorf("No room for the options in the TCP header: optionLength=%d, tcpDataOffset=%d", optionLength, p.tcpDataOffset)
+	}
+
 	p.tcpOptions = append(p.tcpOptions, options...)
 
 	dataLength := len(data)
@@ -471,3 +703,65 @@ func (p *Packet) DestinationPortHash(stage uint64) string {
 	}
 	return p.L4ReverseFlowHash()
 }
+
+// UDPDataStartBytes provides the UDP data start offset in bytes
+func (p *Packet) UDPDataStartBytes() uint16 {
+	return p.l4BeginPos + udpHdrSize
+}
+
+// ReadUDPData returns the payload of a UDP packet.
+// It does not remove the payload from the packet
+func (p *Packet) ReadUDPData() []byte {
+
+	if uint16(len(p.Buffer)) >= p.IPTotalLength {
+		return p.Buffer[p.UDPDataStartBytes():p.IPTotalLength]
+	}
+
+	return []byte{}
+}
+
+// UDPDataAttach appends data at the end of the UDP payload and updates the
+// IP and UDP headers. Like the TCP data, the attached bytes are kept outside
+// of the buffer until they are consolidated with GetBytes.
+func (p *Packet) UDPDataAttach(data []byte) error {
+
+	if int(p.IPTotalLength)+len(data) > 0xffff {
+		return fmt.Errorf("UDP Data Attach failed: packet too large (len=%d)", int(p.IPTotalLength)+len(data))
+	}
+
+	p.tcpData = append(p.tcpData, data...)
+
+	p.FixupIPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength+uint16(len(data)))
+	p.fixupUDPLength()
+	p.UpdateUDPChecksum()
+
+	return nil
+}
+
+// UDPDataDetach removes the last dataLength bytes of the UDP payload and
+// updates the IP and UDP headers. The bytes are dropped from the packet.
+func (p *Packet) UDPDataDetach(dataLength uint16) error {
+
+	if uint16(len(p.Buffer)) < p.IPTotalLength {
+		return fmt.Errorf("UDP Data Detach failed: attached data must be dropped first")
+	}
+
+	if dataLength > p.IPTotalLength-p.UDPDataStartBytes() {
+		return fmt.Errorf("UDP Data Detach failed: dataLength=%d exceeds the payload", dataLength)
+	}
+
+	p.Buffer = p.Buffer[:p.IPTotalLength-dataLength]
+
+	p.FixupIPHdrOnDataModify(p.IPTotalLength, p.IPTotalLength-dataLength)
+	p.fixupUDPLength()
+	p.UpdateUDPChecksum()
+
+	return nil
+}
+
+// fixupUDPLength updates the UDP length field after the payload is modified
+func (p *Packet) fixupUDPLength() {
+
+	udpLength := p.IPTotalLength - p.l4BeginPos
+	binary.BigEndian.PutUint16(p.Buffer[p.l4BeginPos+udpLengthPos:p.l4BeginPos+udpLengthPos+2], udpLength)
+}
@@ -1,6 +1,9 @@
 package packet
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 type SamplePacketName int
 
@@ -11,6 +14,9 @@ const (
 	synIPLenTooSmall
 	synMissingBytes
 	synBadIPChecksum
+	synIPv6GoodTCPChecksum
+	synIPv6MissingBytes
+	udpGoodChecksum
 )
 
 var testPackets = [][]byte{
@@ -57,7 +63,31 @@ var testPackets = [][]byte{
 		0x00, 0x7f, 0x00, 0x00, 0x01, 0x7f, 0x00, 0x00, 0x01, 0xb2, 0x64, 0x00, 0x63, 0x58, 0xd1,
 		0x24, 0xd9, 0x00, 0x00, 0x00, 0x00, 0xa0, 0x02, 0xaa, 0xaa, 0xfe, 0x30, 0x00, 0x00, 0x02,
 		0x04, 0xff, 0xd7, 0x04, 0x02, 0x08, 0x0a, 0x00, 0xc5, 0x8e, 0xf7, 0x00, 0x00, 0x00, 0x00,
-		0x01, 0x03, 0x03, 0x07}}
+		0x01, 0x03, 0x03, 0x07},
+
+	// IPv6 SYN packet between fd00::1 and fd00::2 port 99.
+	// Everything is correct.
+	[]byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x28, 0x06, 0x40, 0xfd, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xfd, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x8c,
+		0x80, 0x00, 0x63, 0x2c, 0x32, 0xa8, 0xd6, 0x00, 0x00, 0x00, 0x00, 0xa0, 0x02, 0xaa, 0xaa,
+		0x02, 0x9a, 0x00, 0x00, 0x02, 0x04, 0xff, 0xc4, 0x04, 0x02, 0x08, 0x0a, 0xff, 0xff, 0x44,
+		0xba, 0x00, 0x00, 0x00, 0x00, 0x01, 0x03, 0x03, 0x07},
+
+	// IPv6 SYN packet between fd00::1 and fd00::2 port 99.
+	// Packet is too short, missing one byte.
+	[]byte{0x60, 0x00, 0x00, 0x00, 0x00, 0x28, 0x06, 0x40, 0xfd, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0xfd, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x8c,
+		0x80, 0x00, 0x63, 0x2c, 0x32, 0xa8, 0xd6, 0x00, 0x00, 0x00, 0x00, 0xa0, 0x02, 0xaa, 0xaa,
+		0x02, 0x9a, 0x00, 0x00, 0x02, 0x04, 0xff, 0xc4, 0x04, 0x02, 0x08, 0x0a, 0xff, 0xff, 0x44,
+		0xba, 0x00, 0x00, 0x00, 0x00, 0x01, 0x03, 0x03},
+
+	// UDP packet from 127.0.0.1:54321 to 127.0.0.1:53 with payload "hello".
+	// Everything is correct.
+	[]byte{0x45, 0x00, 0x00, 0x21, 0x12, 0x34, 0x40, 0x00, 0x40, 0x11, 0x2a,
+		0x96, 0x7f, 0x00, 0x00, 0x01, 0x7f, 0x00, 0x00, 0x01, 0xd4, 0x31, 0x00, 0x35, 0x00, 0x0d,
+		0xe9, 0x98, 0x68, 0x65, 0x6c, 0x6c, 0x6f}}
 
 func TestGoodPacket(t *testing.T) {
 
@@ -415,6 +445,271 @@ func TestPayloadAddRemove(t *testing.T) {
 	*/
 }
 
+func TestGoodIPv6Packet(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synIPv6GoodTCPChecksum)
+	t.Log(pkt.String())
+
+	if !pkt.IsIPv6() {
+		t.Error("Expected an IPv6 packet")
+	}
+
+	if !pkt.VerifyIPChecksum() {
+		t.Error("IPv6 packets have no IP checksum and should always verify")
+	}
+
+	if !pkt.VerifyTCPChecksum() {
+		t.Error("TCP checksum failed")
+	}
+
+	if pkt.IPTotalLength != 80 {
+		t.Errorf("Unexpected total length %d", pkt.IPTotalLength)
+	}
+
+	if pkt.DestinationPort != 99 {
+		t.Error("Unexpected destination port")
+	}
+
+	if pkt.SourcePort != 35968 {
+		t.Error("Unexpected source port")
+	}
+
+	if pkt.TCPFlags&TCPSynMask == 0 {
+		t.Error("Expected SYN flag")
+	}
+}
+
+func TestIPv6Addresses(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synIPv6GoodTCPChecksum)
+
+	src := pkt.SourceAddress.String()
+	if src != "fd00::1" {
+		t.Errorf("Unexpected source address %s", src)
+	}
+	dest := pkt.DestinationAddress.String()
+	if dest != "fd00::2" {
+		t.Errorf("Unexpected destination address %s", dest)
+	}
+}
+
+func TestIPv6ShortBuffer(t *testing.T) {
+
+	t.Parallel()
+	err := getTestPacketWithError(t, synIPv6MissingBytes)
+	t.Log(err)
+	if err == nil {
+		t.Error("Expected failure given short (truncated) IPv6 packet")
+	}
+}
+
+// ipv6Fragment returns the IPv6 test packet with a fragment header at the offset
+func ipv6Fragment(offset uint16) []byte {
+
+	pkt := testPackets[synIPv6GoodTCPChecksum]
+
+	fragment := append([]byte{}, pkt[:40]...)
+	// Payload length and next header
+	fragment[5] = pkt[5] + 8
+	fragment[6] = ipv6FragmentHeader
+	// Fragment header with the more fragments flag
+	fragment = append(fragment, IPProtocolTCP, 0, byte((offset<<3)>>8), byte(offset<<3)|1, 0, 0, 0, 1)
+
+	return append(fragment, pkt[40:]...)
+}
+
+func TestIPv6Fragments(t *testing.T) {
+
+	t.Parallel()
+
+	pkt, err := New(0, ipv6Fragment(0), "0")
+	if err != nil {
+		t.Fatalf("First fragment should be parsed: %s", err)
+	}
+
+	if pkt.IPProto != IPProtocolTCP || pkt.DestinationPort != 99 || pkt.SourcePort != 35968 {
+		t.Errorf("Unexpected transport header of the first fragment %s", pkt.String())
+	}
+
+	if _, err := New(0, ipv6Fragment(185), "0"); err == nil {
+		t.Error("Expected failure given a non-first IPv6 fragment")
+	}
+}
+
+func TestIPv6Lengths(t *testing.T) {
+
+	t.Parallel()
+
+	pkt := testPackets[synIPv6GoodTCPChecksum]
+
+	// A jumbogram has a payload length of zero
+	jumbo := append([]byte{}, pkt...)
+	jumbo[4], jumbo[5] = 0, 0
+	if _, err := New(0, jumbo, "0"); err == nil {
+		t.Error("Expected failure given an IPv6 jumbogram")
+	}
+
+	// Destination options of 2048 bytes do not fit the header length in words
+	options := make([]byte, 2048)
+	options[0] = IPProtocolTCP
+	options[1] = 255
+
+	long := append([]byte{}, pkt[:40]...)
+	payloadLen := len(pkt) - 40 + len(options)
+	long[4], long[5] = byte(payloadLen>>8), byte(payloadLen)
+	long[6] = ipv6DestOptsHeader
+	long = append(append(long, options...), pkt[40:]...)
+	if _, err := New(0, long, "0"); err == nil {
+		t.Error("Expected failure given IPv6 extension headers longer than 1020 bytes")
+	}
+}
+
+func TestIPv6DataAttachDetach(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synIPv6GoodTCPChecksum)
+
+	options := []byte{TCPAuthenticationOption, 4, 0, 0}
+	data := []byte("Hello, world!")
+
+	if err := pkt.TCPDataAttach(options, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if pkt.IPTotalLength != 80+uint16(len(options)+len(data)) {
+		t.Errorf("Unexpected total length after attach %d", pkt.IPTotalLength)
+	}
+
+	if !pkt.VerifyTCPChecksum() {
+		t.Error("Packet TCP checksum failed after attaching data")
+	}
+
+	attached, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !attached.VerifyTCPChecksum() {
+		t.Error("Reparsed packet TCP checksum failed after attaching data")
+	}
+
+	if err := attached.TCPDataDetach(uint16(len(options))); err != nil {
+		t.Fatal(err)
+	}
+
+	attached.DropDetachedBytes()
+
+	if attached.IPTotalLength != 80 {
+		t.Errorf("Unexpected total length after detach %d", attached.IPTotalLength)
+	}
+
+	if !attached.VerifyTCPChecksum() {
+		t.Error("Packet TCP checksum failed after detaching data")
+	}
+}
+
+func TestGoodUDPPacket(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, udpGoodChecksum)
+	t.Log(pkt.String())
+
+	if pkt.IPProto != IPProtocolUDP {
+		t.Error("Unexpected IP protocol")
+	}
+
+	if !pkt.VerifyIPChecksum() {
+		t.Error("Test packet IP checksum failed")
+	}
+
+	if !pkt.VerifyUDPChecksum() {
+		t.Error("UDP checksum failed")
+	}
+
+	if pkt.SourcePort != 54321 || pkt.DestinationPort != 53 {
+		t.Error("Unexpected ports")
+	}
+
+	if string(pkt.ReadUDPData()) != "hello" {
+		t.Errorf("Unexpected UDP payload %s", string(pkt.ReadUDPData()))
+	}
+}
+
+func TestUDPDataAttachDetach(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, udpGoodChecksum)
+
+	data := []byte(", world!")
+
+	if err := pkt.UDPDataAttach(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if pkt.IPTotalLength != 33+uint16(len(data)) {
+		t.Errorf("Unexpected total length after attach %d", pkt.IPTotalLength)
+	}
+
+	attached, err := New(0, pkt.GetBytes(), "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !attached.VerifyIPChecksum() || !attached.VerifyUDPChecksum() {
+		t.Error("Reparsed packet checksum failed after attaching data")
+	}
+
+	if string(attached.ReadUDPData()) != "hello, world!" {
+		t.Errorf("Unexpected UDP payload after attach %s", string(attached.ReadUDPData()))
+	}
+
+	if err := attached.UDPDataDetach(uint16(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(attached.GetBytes(), testPackets[udpGoodChecksum]) {
+		t.Error("Packet differs from the original after detaching data")
+	}
+
+	if err := attached.UDPDataDetach(100); err == nil {
+		t.Error("Expected failure when detaching more than the payload")
+	}
+}
+
+func TestTCPOptions(t *testing.T) {
+
+	t.Parallel()
+	pkt := getTestPacket(t, synGoodTCPChecksum)
+
+	if !pkt.HasLastTCPOption(3, 3) {
+		t.Error("Expected the window scale option last")
+	}
+
+	if pkt.HasLastTCPOption(TCPEncryptionOption, 16) {
+		t.Error("Unexpected encryption option")
+	}
+
+	pkt.ReduceTCPMSS(16)
+	if mss := pkt.Buffer[42:44]; !reflect.DeepEqual(mss, []byte{0xff, 0xc7}) {
+		t.Errorf("MSS not reduced: %v", mss)
+	}
+
+	pkt.RemoveTCPSackPermitted()
+	if sack := pkt.Buffer[44:46]; !reflect.DeepEqual(sack, []byte{tcpNopOption, tcpNopOption}) {
+		t.Errorf("Sack permitted option not removed: %v", sack)
+	}
+
+	if !pkt.VerifyTCPChecksum() {
+		t.Error("TCP checksum is wrong after changing the options")
+	}
+
+	if err := pkt.TCPDataAttach(make([]byte, 24), nil); err == nil {
+		t.Error("Expected an error when the options do not fit in the TCP header")
+	}
+}
+
 func getTestPacket(t *testing.T, id SamplePacketName) *Packet {
 
 	tmp := make([]byte, len(testPackets[id]))
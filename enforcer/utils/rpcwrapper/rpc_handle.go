@@ -225,4 +225,11 @@ func RegisterTypes() {
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Supervise_Request_Payload", *(&SuperviseRequestPayload{}))
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.UnSupervise_Payload", *(&UnSupervisePayload{}))
 	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Stats_Payload", *(&StatsPayload{}))
+
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Supervisor_State_Request_Payload", *(&SupervisorStateRequestPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Supervisor_State_Response_Payload", *(&SupervisorStateResponsePayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Exclude_IP_Request_Payload", *(&ExcludeIPRequestPayload{}))
+
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Connections_Request_Payload", *(&ConnectionsRequestPayload{}))
+	gob.RegisterName("github.com/aporeto-inc/enforcer/utils/rpcwrapper.Connections_Response_Payload", *(&ConnectionsResponsePayload{}))
 }
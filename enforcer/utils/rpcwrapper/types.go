@@ -6,6 +6,7 @@ import (
 	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/enforcer"
 	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
+	"github.com/aporeto-inc/trireme/metrics"
 	"github.com/aporeto-inc/trireme/policy"
 )
 
@@ -17,6 +18,8 @@ const (
 	IPTables CaptureType = iota
 	// IPSets forces an IPSet implementation
 	IPSets
+	// NFTables forces an nftables implementation
+	NFTables
 )
 
 //Request exported
@@ -34,7 +37,8 @@ const (
 //Response is the response for every RPC call. This is used to carry the status of the actual function call
 //made on the remote end
 type Response struct {
-	Status string
+	Status  string
+	Payload interface{}
 }
 
 //InitRequestPayload Payload for enforcer init request
@@ -120,9 +124,32 @@ type UnEnforceResponsePayload struct {
 //StatsPayload is the payload carries by the stats reporting form the remote enforcer
 type StatsPayload struct {
 	Flows map[string]*collector.FlowRecord `json:",omitempty"`
+	// ContextID and Metrics are the PU of the remote enforcer and a snapshot of its metrics
+	ContextID string            `json:",omitempty"`
+	Metrics   []*metrics.Family `json:",omitempty"`
 }
 
 //ExcludeIPRequestPayload carries the list of excluded ips
 type ExcludeIPRequestPayload struct {
 	IPs []string `json:",omitempty"`
 }
+
+//SupervisorStateRequestPayload requests the state of a PU programmed by the remote supervisor
+type SupervisorStateRequestPayload struct {
+	ContextID string `json:",omitempty"`
+}
+
+//SupervisorStateResponsePayload carries the state of a PU programmed by the remote supervisor
+type SupervisorStateResponsePayload struct {
+	State *policy.SupervisorState `json:",omitempty"`
+}
+
+//ConnectionsRequestPayload requests the connections of a PU tracked by the remote enforcer
+type ConnectionsRequestPayload struct {
+	ContextID string `json:",omitempty"`
+}
+
+//ConnectionsResponsePayload carries the connections of a PU tracked by the remote enforcer
+type ConnectionsResponsePayload struct {
+	Connections []*enforcer.TrackedConnection `json:",omitempty"`
+}
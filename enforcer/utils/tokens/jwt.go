@@ -108,6 +108,19 @@ func (c *JWTConfig) CreateAndSign(isAck bool, claims *ConnectionClaims) []byte {
 // the JWT if the certificate is trusted
 func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (*ConnectionClaims, interface{}) {
 
+	claims, ackCert, err := c.Verify(isAck, data, previousCert)
+	if err != nil {
+		return nil, nil
+	}
+
+	return claims, ackCert
+}
+
+// Verify decodes the JWT token like Decode and returns the reason why the
+// token is rejected. ErrTokenExpired is returned if the token is valid but
+// expired.
+func (c *JWTConfig) Verify(isAck bool, data []byte, previousCert interface{}) (*ConnectionClaims, interface{}, error) {
+
 	var err error
 	var ackCert interface{}
 
@@ -123,13 +136,13 @@ func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (*
 		buffer := bytes.NewBuffer(data)
 		token, err = buffer.ReadBytes([]byte("%")[0])
 		if err != nil {
-			return nil, nil
+			return nil, nil, fmt.Errorf("Invalid token format")
 		}
 
 		if len(token) < len(data) {
 			ackCert, err = c.secrets.VerifyPublicKey(data[len(token):])
 			if err != nil {
-				return nil, nil
+				return nil, nil, fmt.Errorf("Invalid certificate: %s", err)
 			}
 		}
 		token = token[:len(token)-1]
@@ -146,8 +159,11 @@ func (c *JWTConfig) Decode(isAck bool, data []byte, previousCert interface{}) (*
 	// If error is returned or the token is not valid, reject it
 	if err != nil || !jwttoken.Valid {
 		zap.L().Error("ParseWithClaim failed", zap.Error(err))
-		return nil, nil
+		if verr, ok := err.(*jwt.ValidationError); ok && verr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, nil, ErrTokenExpired
+		}
+		return nil, nil, fmt.Errorf("Invalid token: %v", err)
 	}
 
-	return jwtClaims.ConnectionClaims, ackCert
+	return jwtClaims.ConnectionClaims, ackCert, nil
 }
@@ -155,6 +155,24 @@ func TestCreateAndVerifyPSK(t *testing.T) {
 
 		})
 
+		Convey("Given a verification request with a bad packet ", func() {
+			recoveredClaims, _, err := jwtConfig.Verify(false, nil, nil)
+
+			So(recoveredClaims, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+			So(err, ShouldNotEqual, ErrTokenExpired)
+
+		})
+
+		Convey("Given a token that has expired", func() {
+			expiredConfig, _ := NewJWT(-validity, "TRIREME", secrets)
+			token := expiredConfig.CreateAndSign(false, &defaultClaims)
+			recoveredClaims, _, err := jwtConfig.Verify(false, token, nil)
+
+			So(recoveredClaims, ShouldBeNil)
+			So(err, ShouldEqual, ErrTokenExpired)
+		})
+
 	})
 }
 
@@ -1,6 +1,13 @@
 package tokens
 
-import "github.com/aporeto-inc/trireme/policy"
+import (
+	"errors"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// ErrTokenExpired is returned when a token is decoded after its validity period
+var ErrTokenExpired = errors.New("Token expired")
 
 // ConnectionClaims captures all the claim information
 type ConnectionClaims struct {
@@ -8,6 +15,9 @@ type ConnectionClaims struct {
 	LCL []byte
 	RMT []byte
 	EK  []byte
+	// F binds the token to the flow of the packet that carries it, when the
+	// flow has no handshake
+	F string `json:",omitempty"`
 }
 
 // TokenEngine is the interface to the different implementations of tokens
@@ -18,6 +28,13 @@ type TokenEngine interface {
 	Decode(decodeCert bool, buffer []byte, cert interface{}) (*ConnectionClaims, interface{})
 }
 
+// TokenVerifier is implemented by the token engines that report why a token is rejected
+type TokenVerifier interface {
+	// Verify decodes an incoming buffer and returns the claims and the sender certificate,
+	// or the reason why the token is rejected
+	Verify(decodeCert bool, buffer []byte, cert interface{}) (*ConnectionClaims, interface{}, error)
+}
+
 // SecretsType identifies the different secrets that are supported
 type SecretsType int
 
@@ -0,0 +1,343 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Type is the type of a metric
+type Type string
+
+const (
+	// CounterType is a value that only increases
+	CounterType Type = "counter"
+	// GaugeType is a value that can go up and down
+	GaugeType Type = "gauge"
+	// HistogramType counts observations in buckets
+	HistogramType Type = "histogram"
+)
+
+// Label is the name and the value of a label of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is the value of a metric for one set of label values
+type Sample struct {
+	Labels []Label
+	// Value is the value of a counter or a gauge, or the sum of the
+	// observations of a histogram
+	Value float64
+	// Count is the number of observations of a histogram
+	Count uint64
+	// Buckets are the numbers of observations of a histogram that are lower
+	// or equal to each bound
+	Buckets []uint64
+}
+
+// Family is a metric with all its samples. Families are the snapshots that are
+// exported and forwarded by the remote enforcers.
+type Family struct {
+	Name string
+	Help string
+	Type Type
+	// Bounds are the upper bounds of the buckets of a histogram
+	Bounds  []float64
+	Samples []*Sample
+}
+
+// series holds the value of a metric for one set of label values. The values
+// are updated atomically so that the handles of the series do not take the
+// lock of the metric. The 64 bits fields come first to stay aligned for the
+// atomic operations.
+type series struct {
+	// value holds the bits of the float64 value
+	value   uint64
+	count   uint64
+	buckets []uint64
+	labels  []Label
+}
+
+// add adds a value, possibly negative, to the value of the series
+func (s *series) add(value float64) {
+
+	for {
+		old := atomic.LoadUint64(&s.value)
+		next := math.Float64bits(math.Float64frombits(old) + value)
+		if atomic.CompareAndSwapUint64(&s.value, old, next) {
+			return
+		}
+	}
+}
+
+// set sets the value of the series
+func (s *series) set(value float64) {
+
+	atomic.StoreUint64(&s.value, math.Float64bits(value))
+}
+
+// observe adds an observation to the buckets with the bounds
+func (s *series) observe(value float64, bounds []float64) {
+
+	s.add(value)
+	atomic.AddUint64(&s.count, 1)
+	for i, bound := range bounds {
+		if value <= bound {
+			atomic.AddUint64(&s.buckets[i], 1)
+		}
+	}
+}
+
+// sample returns a copy of the series
+func (s *series) sample() *Sample {
+
+	sample := &Sample{
+		Labels: append([]Label(nil), s.labels...),
+		Value:  math.Float64frombits(atomic.LoadUint64(&s.value)),
+		Count:  atomic.LoadUint64(&s.count),
+	}
+
+	if s.buckets != nil {
+		sample.Buckets = make([]uint64, len(s.buckets))
+		for i := range s.buckets {
+			sample.Buckets[i] = atomic.LoadUint64(&s.buckets[i])
+		}
+	}
+
+	return sample
+}
+
+// vec holds the series of a metric per label values
+type vec struct {
+	name       string
+	help       string
+	metricType Type
+	labelNames []string
+	bounds     []float64
+	series     map[string]*series
+	sync.Mutex
+}
+
+func newVec(name, help string, metricType Type, bounds []float64, labelNames []string) *vec {
+
+	return &vec{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		bounds:     bounds,
+		series:     map[string]*series{},
+	}
+}
+
+// withLabelValues returns the series of the label values and creates it if
+// needed. Missing label values are empty and extra label values are ignored.
+func (v *vec) withLabelValues(labelValues []string) *series {
+
+	values := make([]string, len(v.labelNames))
+	copy(values, labelValues)
+
+	key := strings.Join(values, "\xff")
+
+	v.Lock()
+	defer v.Unlock()
+
+	if s, ok := v.series[key]; ok {
+		return s
+	}
+
+	s := &series{
+		labels: make([]Label, len(v.labelNames)),
+	}
+	for i, name := range v.labelNames {
+		s.labels[i] = Label{Name: name, Value: values[i]}
+	}
+	if v.metricType == HistogramType {
+		s.buckets = make([]uint64, len(v.bounds))
+	}
+
+	v.series[key] = s
+	return s
+}
+
+// removeLabelValue removes the series that have the value for the label. The
+// handles of these series are not updated anymore.
+func (v *vec) removeLabelValue(name, value string) {
+
+	v.Lock()
+	defer v.Unlock()
+
+	for key, s := range v.series {
+		for _, l := range s.labels {
+			if l.Name == name && l.Value == value {
+				delete(v.series, key)
+				break
+			}
+		}
+	}
+}
+
+// gather returns a copy of the metric and its samples sorted by label values
+func (v *vec) gather() *Family {
+
+	v.Lock()
+	defer v.Unlock()
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	f := &Family{
+		Name:    v.name,
+		Help:    v.help,
+		Type:    v.metricType,
+		Bounds:  v.bounds,
+		Samples: make([]*Sample, len(keys)),
+	}
+
+	for i, key := range keys {
+		f.Samples[i] = v.series[key].sample()
+	}
+
+	return f
+}
+
+// Counter is a metric that only increases
+type Counter struct {
+	v *vec
+}
+
+// Inc increments the counter of the label values by one
+func (c *Counter) Inc(labelValues ...string) {
+
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter of the label values. Negative values are ignored.
+func (c *Counter) Add(value float64, labelValues ...string) {
+
+	if value < 0 {
+		return
+	}
+
+	c.WithLabelValues(labelValues...).Add(value)
+}
+
+// WithLabelValues returns the handle of the counter of the label values. The
+// handles are kept by the callers that update a counter for every packet.
+func (c *Counter) WithLabelValues(labelValues ...string) *CounterHandle {
+
+	return &CounterHandle{s: c.v.withLabelValues(labelValues)}
+}
+
+// CounterHandle is the counter of one set of label values
+type CounterHandle struct {
+	s *series
+}
+
+// Inc increments the counter by one
+func (h *CounterHandle) Inc() {
+
+	h.s.add(1)
+}
+
+// Add increases the counter. Negative values are ignored.
+func (h *CounterHandle) Add(value float64) {
+
+	if value < 0 {
+		return
+	}
+
+	h.s.add(value)
+}
+
+// Gauge is a metric that can go up and down
+type Gauge struct {
+	v *vec
+}
+
+// Set sets the gauge of the label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+
+	g.WithLabelValues(labelValues...).Set(value)
+}
+
+// Add adds a value, possibly negative, to the gauge of the label values
+func (g *Gauge) Add(value float64, labelValues ...string) {
+
+	g.WithLabelValues(labelValues...).Add(value)
+}
+
+// WithLabelValues returns the handle of the gauge of the label values
+func (g *Gauge) WithLabelValues(labelValues ...string) *GaugeHandle {
+
+	return &GaugeHandle{s: g.v.withLabelValues(labelValues)}
+}
+
+// GaugeHandle is the gauge of one set of label values
+type GaugeHandle struct {
+	s *series
+}
+
+// Set sets the gauge
+func (h *GaugeHandle) Set(value float64) {
+
+	h.s.set(value)
+}
+
+// Add adds a value, possibly negative, to the gauge
+func (h *GaugeHandle) Add(value float64) {
+
+	h.s.add(value)
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	v *vec
+}
+
+// Observe adds an observation to the histogram of the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+
+	h.WithLabelValues(labelValues...).Observe(value)
+}
+
+// ObserveSince adds the seconds elapsed since start to the histogram of the
+// label values. It is meant to be deferred.
+func (h *Histogram) ObserveSince(start time.Time, labelValues ...string) {
+
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// WithLabelValues returns the handle of the histogram of the label values. The
+// handles are kept by the callers that observe a value for every packet.
+func (h *Histogram) WithLabelValues(labelValues ...string) *HistogramHandle {
+
+	return &HistogramHandle{s: h.v.withLabelValues(labelValues), bounds: h.v.bounds}
+}
+
+// HistogramHandle is the histogram of one set of label values
+type HistogramHandle struct {
+	s      *series
+	bounds []float64
+}
+
+// Observe adds an observation to the histogram
+func (h *HistogramHandle) Observe(value float64) {
+
+	h.s.observe(value, h.bounds)
+}
+
+// ObserveSince adds the seconds elapsed since start to the histogram. It is
+// meant to be deferred.
+func (h *HistogramHandle) ObserveSince(start time.Time) {
+
+	h.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCounterAndGauge(t *testing.T) {
+
+	Convey("Given a registry with a counter and a gauge", t, func() {
+
+		r := NewRegistry()
+		c := r.NewCounter("packets_total", "Packets", "pu", "reason")
+		g := r.NewGauge("entries", "Entries", "tracker")
+
+		Convey("When I increment the counter and set the gauge", func() {
+
+			c.Inc("pu1", "auth")
+			c.Add(2, "pu1", "auth")
+			c.Add(-5, "pu1", "auth")
+			c.Inc("pu2")
+			g.Set(10, "app")
+			g.Add(-3, "app")
+
+			families := r.Gather()
+
+			Convey("Then the families should be sorted by name with their samples", func() {
+				So(len(families), ShouldEqual, 2)
+				So(families[0].Name, ShouldEqual, "entries")
+				So(families[0].Type, ShouldEqual, GaugeType)
+				So(families[0].Samples[0].Value, ShouldEqual, 7)
+				So(families[1].Name, ShouldEqual, "packets_total")
+				So(len(families[1].Samples), ShouldEqual, 2)
+				So(families[1].Samples[0].Labels, ShouldResemble, []Label{{"pu", "pu1"}, {"reason", "auth"}})
+				So(families[1].Samples[0].Value, ShouldEqual, 3)
+				So(families[1].Samples[1].Labels, ShouldResemble, []Label{{"pu", "pu2"}, {"reason", ""}})
+			})
+
+			Convey("Then registering the counter again should return the same metric", func() {
+				r.NewCounter("packets_total", "Packets", "pu", "reason").Inc("pu1", "auth")
+				So(r.Gather()[1].Samples[0].Value, ShouldEqual, 4)
+			})
+
+			Convey("Then registering the counter again with another type should panic", func() {
+				So(func() { r.NewGauge("packets_total", "Packets", "pu", "reason") }, ShouldPanic)
+				So(r.Gather()[1].Type, ShouldEqual, CounterType)
+			})
+
+			Convey("Then removing a label value should remove its samples", func() {
+				r.RemoveLabelValue("pu", "pu1")
+				families := r.Gather()
+				So(len(families[1].Samples), ShouldEqual, 1)
+				So(families[1].Samples[0].Labels[0].Value, ShouldEqual, "pu2")
+			})
+		})
+
+		Convey("When I register a hook", func() {
+
+			calls := 0
+			remove := r.OnGather(func() {
+				calls++
+				g.Set(float64(calls), "app")
+			})
+
+			r.Gather()
+			families := r.Gather()
+			remove()
+			r.Gather()
+
+			Convey("Then it should be called before each gathering until it is removed", func() {
+				So(calls, ShouldEqual, 2)
+				So(families[0].Samples[0].Value, ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestHistogram(t *testing.T) {
+
+	Convey("Given a registry with a histogram", t, func() {
+
+		r := NewRegistry()
+		h := r.NewHistogram("latency_seconds", "Latency", []float64{0.1, 0.01}, "pu")
+
+		Convey("When I observe values", func() {
+
+			h.Observe(0.005, "pu1")
+			h.Observe(0.05, "pu1")
+			h.Observe(1, "pu1")
+
+			s := r.Gather()[0].Samples[0]
+
+			Convey("Then the buckets should be cumulative", func() {
+				So(r.Gather()[0].Bounds, ShouldResemble, []float64{0.01, 0.1})
+				So(s.Buckets, ShouldResemble, []uint64{1, 2})
+				So(s.Count, ShouldEqual, 3)
+				So(s.Value, ShouldAlmostEqual, 1.055)
+			})
+
+			Convey("Then the text format should have the buckets, the sum and the count", func() {
+				buffer := &bytes.Buffer{}
+				So(r.WriteText(buffer), ShouldBeNil)
+				So(buffer.String(), ShouldEqual, `# HELP latency_seconds Latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{pu="pu1",le="0.01"} 1
+latency_seconds_bucket{pu="pu1",le="0.1"} 2
+latency_seconds_bucket{pu="pu1",le="+Inf"} 3
+latency_seconds_sum{pu="pu1"} 1.055
+latency_seconds_count{pu="pu1"} 3
+`)
+			})
+		})
+	})
+}
+
+func TestHandles(t *testing.T) {
+
+	Convey("Given a registry with a counter, a gauge and a histogram", t, func() {
+
+		r := NewRegistry()
+		c := r.NewCounter("packets_total", "Packets", "pu")
+		g := r.NewGauge("entries", "Entries", "tracker")
+		h := r.NewHistogram("latency_seconds", "Latency", []float64{0.01, 0.1}, "pu")
+
+		Convey("When I update the handles of label values concurrently", func() {
+
+			packets := c.WithLabelValues("pu1")
+			entries := g.WithLabelValues("app")
+			latency := h.WithLabelValues("pu1")
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						packets.Inc()
+						entries.Add(0.5)
+						latency.Observe(0.05)
+					}
+				}()
+			}
+			wg.Wait()
+
+			packets.Add(-1)
+			c.Inc("pu1")
+
+			families := r.Gather()
+
+			Convey("Then the samples of the label values should have all the updates", func() {
+				So(families[0].Samples[0].Value, ShouldEqual, 500)
+				So(families[1].Samples[0].Count, ShouldEqual, 1000)
+				So(families[1].Samples[0].Buckets, ShouldResemble, []uint64{0, 1000})
+				So(families[1].Samples[0].Value, ShouldAlmostEqual, 50, 0.000001)
+				So(families[2].Samples[0].Value, ShouldEqual, 1001)
+			})
+
+			Convey("Then the gauge handle should set the value", func() {
+				entries.Set(3)
+				So(r.Gather()[0].Samples[0].Value, ShouldEqual, 3)
+			})
+
+			Convey("Then the handles of removed label values should not be gathered", func() {
+				r.RemoveLabelValue("pu", "pu1")
+				packets.Inc()
+				So(r.Gather()[2].Samples, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestRemote(t *testing.T) {
+
+	Convey("Given a registry with a counter and the metrics of a remote enforcer", t, func() {
+
+		r := NewRegistry()
+		r.NewCounter("drops_total", "Drops", "pu").Inc("local")
+
+		r.SetRemote("pu1", []*Family{
+			{
+				Name: "drops_total",
+				Help: "Drops",
+				Type: CounterType,
+				Samples: []*Sample{
+					{Labels: []Label{{"pu", "pu1"}}, Value: 2},
+				},
+			},
+			{
+				Name: "queue_packets_total",
+				Help: "Packets \"received\"",
+				Type: CounterType,
+				Samples: []*Sample{
+					{Labels: []Label{{"queue", "4"}}, Value: 5},
+				},
+			},
+		})
+
+		Convey("When I serve the metrics", func() {
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+			Convey("Then the remote samples should be merged with the enforcer label", func() {
+				So(w.Header().Get("Content-Type"), ShouldStartWith, "text/plain")
+				So(w.Body.String(), ShouldEqual, `# HELP drops_total Drops
+# TYPE drops_total counter
+drops_total{pu="local"} 1
+drops_total{enforcer="pu1",pu="pu1"} 2
+# HELP queue_packets_total Packets "received"
+# TYPE queue_packets_total counter
+queue_packets_total{enforcer="pu1",queue="4"} 5
+`)
+			})
+		})
+
+		Convey("When I remove the remote enforcer", func() {
+
+			r.RemoveRemote("pu1")
+
+			Convey("Then only the local metrics should be left", func() {
+				families := r.GatherAll()
+				So(len(families), ShouldEqual, 1)
+				So(len(families[0].Samples), ShouldEqual, 1)
+			})
+		})
+	})
+}
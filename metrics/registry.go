@@ -0,0 +1,323 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// RemoteLabel is the label added to the samples forwarded by a remote enforcer.
+// Its value is the source given to SetRemote.
+const RemoteLabel = "enforcer"
+
+// DefaultRegistry is the registry of the metrics of the process
+var DefaultRegistry = NewRegistry()
+
+// Registry holds the metrics of a process and the last metrics forwarded by
+// the remote enforcers. It serves them in the Prometheus text format.
+type Registry struct {
+	metrics  map[string]*vec
+	hooks    map[int]func()
+	nextHook int
+	remotes  map[string][]*Family
+	sync.Mutex
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+
+	return &Registry{
+		metrics: map[string]*vec{},
+		hooks:   map[int]func(){},
+		remotes: map[string][]*Family{},
+	}
+}
+
+// register returns the metric of the name and creates it if needed. It panics
+// if the name is already registered with another type.
+func (r *Registry) register(name, help string, metricType Type, bounds []float64, labelNames []string) *vec {
+
+	r.Lock()
+	defer r.Unlock()
+
+	if v, ok := r.metrics[name]; ok {
+		if v.metricType != metricType {
+			panic(fmt.Sprintf("Metric %s is already registered with another type", name))
+		}
+		return v
+	}
+
+	v := newVec(name, help, metricType, bounds, labelNames)
+	r.metrics[name] = v
+
+	return v
+}
+
+// NewCounter registers a counter with the names of its labels
+func (r *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+
+	return &Counter{v: r.register(name, help, CounterType, nil, labelNames)}
+}
+
+// NewGauge registers a gauge with the names of its labels
+func (r *Registry) NewGauge(name, help string, labelNames ...string) *Gauge {
+
+	return &Gauge{v: r.register(name, help, GaugeType, nil, labelNames)}
+}
+
+// NewHistogram registers a histogram with the upper bounds of its buckets and
+// the names of its labels. The bounds are sorted.
+func (r *Registry) NewHistogram(name, help string, bounds []float64, labelNames ...string) *Histogram {
+
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+
+	return &Histogram{v: r.register(name, help, HistogramType, sorted, labelNames)}
+}
+
+// OnGather registers a function called before the metrics are gathered. It
+// is used to set gauges that are expensive to maintain on every change. The
+// returned function removes the hook.
+func (r *Registry) OnGather(hook func()) (remove func()) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	id := r.nextHook
+	r.nextHook++
+	r.hooks[id] = hook
+
+	return func() {
+		r.Lock()
+		defer r.Unlock()
+		delete(r.hooks, id)
+	}
+}
+
+// RemoveLabelValue removes the samples of all the metrics that have the value
+// for the label. It is used to forget the metrics of a PU that is gone.
+func (r *Registry) RemoveLabelValue(name, value string) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	for _, v := range r.metrics {
+		v.removeLabelValue(name, value)
+	}
+}
+
+// SetRemote replaces the metrics forwarded by a remote enforcer
+func (r *Registry) SetRemote(source string, families []*Family) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.remotes[source] = families
+}
+
+// RemoveRemote removes the metrics forwarded by a remote enforcer
+func (r *Registry) RemoveRemote(source string) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.remotes, source)
+}
+
+// Gather returns a snapshot of the metrics of the registry sorted by name. The
+// metrics of the remote enforcers are not included.
+func (r *Registry) Gather() []*Family {
+
+	r.Lock()
+	hooks := make([]func(), 0, len(r.hooks))
+	for _, hook := range r.hooks {
+		hooks = append(hooks, hook)
+	}
+	r.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	families := make([]*Family, 0, len(r.metrics))
+	for _, v := range r.metrics {
+		families = append(families, v.gather())
+	}
+	sort.Sort(byName(families))
+
+	return families
+}
+
+// GatherAll returns a snapshot of the metrics of the registry merged with the
+// metrics of the remote enforcers. The remote samples get the RemoteLabel.
+func (r *Registry) GatherAll() []*Family {
+
+	families := r.Gather()
+
+	index := map[string]*Family{}
+	for _, f := range families {
+		index[f.Name] = f
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	sources := make([]string, 0, len(r.remotes))
+	for source := range r.remotes {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		for _, remote := range r.remotes[source] {
+			f, ok := index[remote.Name]
+			if !ok {
+				f = &Family{Name: remote.Name, Help: remote.Help, Type: remote.Type, Bounds: remote.Bounds}
+				index[f.Name] = f
+				families = append(families, f)
+			}
+
+			if f.Type != remote.Type {
+				zap.L().Warn("Ignoring remote metric of a different type",
+					zap.String("name", remote.Name),
+					zap.String("source", source),
+				)
+				continue
+			}
+
+			for _, s := range remote.Samples {
+				labeled := *s
+				labeled.Labels = append([]Label{{Name: RemoteLabel, Value: source}}, s.Labels...)
+				f.Samples = append(f.Samples, &labeled)
+			}
+		}
+	}
+
+	sort.Sort(byName(families))
+
+	return families
+}
+
+// WriteText writes the metrics of the registry and of the remote enforcers in
+// the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+
+	b := bufio.NewWriter(w)
+
+	for _, f := range r.GatherAll() {
+		writeFamily(b, f)
+	}
+
+	return b.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	if err := r.WriteText(w); err != nil {
+		zap.L().Warn("Unable to write the metrics", zap.Error(err))
+	}
+}
+
+// ListenAndServe serves the metrics of the default registry on /metrics at the
+// address. It blocks until the server fails.
+func ListenAndServe(address string) error {
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", DefaultRegistry)
+
+	return http.ListenAndServe(address, mux)
+}
+
+// writeFamily writes a metric and its samples in the text format
+func writeFamily(w *bufio.Writer, f *Family) {
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.Name, escape(f.Help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.Name, f.Type)
+
+	for _, s := range f.Samples {
+		if f.Type != HistogramType {
+			writeSample(w, f.Name, s.Labels, s.Value)
+			continue
+		}
+
+		for i, bound := range f.Bounds {
+			if i < len(s.Buckets) {
+				writeSample(w, f.Name+"_bucket", withLabel(s.Labels, "le", formatFloat(bound)), float64(s.Buckets[i]))
+			}
+		}
+		writeSample(w, f.Name+"_bucket", withLabel(s.Labels, "le", "+Inf"), float64(s.Count))
+		writeSample(w, f.Name+"_sum", s.Labels, s.Value)
+		writeSample(w, f.Name+"_count", s.Labels, float64(s.Count))
+	}
+}
+
+// writeSample writes one line of the text format
+func writeSample(w *bufio.Writer, name string, labels []Label, value float64) {
+
+	w.WriteString(name) // nolint: errcheck
+
+	if len(labels) > 0 {
+		pairs := make([]string, len(labels))
+		for i, l := range labels {
+			pairs[i] = l.Name + "=\"" + escape(l.Value, true) + "\""
+		}
+		w.WriteString("{" + strings.Join(pairs, ",") + "}") // nolint: errcheck
+	}
+
+	w.WriteString(" " + formatFloat(value) + "\n") // nolint: errcheck
+}
+
+// withLabel returns a copy of the labels with one more label
+func withLabel(labels []Label, name, value string) []Label {
+
+	return append(append([]Label(nil), labels...), Label{Name: name, Value: value})
+}
+
+// escape escapes the backslashes and the new lines, and the double quotes of
+// the label values
+func escape(s string, quotes bool) string {
+
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quotes {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+
+	return s
+}
+
+// formatFloat formats a value of the text format
+func formatFloat(value float64) string {
+
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// byName sorts the metrics by name
+type byName []*Family
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i].Name < b[j].Name }
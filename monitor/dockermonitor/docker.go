@@ -113,6 +113,10 @@ func defaultDockerMetadataExtractor(info *types.ContainerJSON) (*policy.PURuntim
 		"bridge": info.NetworkSettings.IPAddress,
 	})
 
+	if info.NetworkSettings.GlobalIPv6Address != "" {
+		ipa.Add(policy.DefaultIPv6Namespace, info.NetworkSettings.GlobalIPv6Address)
+	}
+
 	return policy.NewPURuntime(info.Name, info.State.Pid, tags, ipa, constants.ContainerPU, nil), nil
 }
 
@@ -0,0 +1,143 @@
+package policy
+
+import (
+	"reflect"
+)
+
+// MaxIncrementalChanges is the number of added and removed rules above which
+// an update of a policy rebuilds the rules of the PU instead of changing them
+// one by one
+const MaxIncrementalChanges = 16
+
+// PolicyDiff is the difference between two versions of the policy of a PU. The
+// rules are compared by value and their order is ignored.
+type PolicyDiff struct {
+	AddedApplicationACLs    []IPRule
+	RemovedApplicationACLs  []IPRule
+	AddedNetworkACLs        []IPRule
+	RemovedNetworkACLs      []IPRule
+	AddedTransmitterRules   []TagSelector
+	RemovedTransmitterRules []TagSelector
+	AddedReceiverRules      []TagSelector
+	RemovedReceiverRules    []TagSelector
+	// IdentityChanged is true if the management ID, the identity or the
+	// annotations changed
+	IdentityChanged bool
+	// SettingsChanged is true if the action, the IP addresses, the networks or
+	// the encryption of the PU changed
+	SettingsChanged bool
+}
+
+// DiffPolicies returns the difference from the previous to the next policy
+func DiffPolicies(previous, next *PUPolicy) *PolicyDiff {
+
+	d := &PolicyDiff{}
+
+	d.AddedApplicationACLs, d.RemovedApplicationACLs = DiffIPRules(previous.ApplicationACLs().Rules, next.ApplicationACLs().Rules)
+	d.AddedNetworkACLs, d.RemovedNetworkACLs = DiffIPRules(previous.NetworkACLs().Rules, next.NetworkACLs().Rules)
+	d.AddedTransmitterRules, d.RemovedTransmitterRules = DiffTagSelectors(previous.TransmitterRules().TagSelectors, next.TransmitterRules().TagSelectors)
+	d.AddedReceiverRules, d.RemovedReceiverRules = DiffTagSelectors(previous.ReceiverRules().TagSelectors, next.ReceiverRules().TagSelectors)
+
+	d.IdentityChanged = previous.ManagementID != next.ManagementID ||
+		!reflect.DeepEqual(previous.Identity(), next.Identity()) ||
+		!reflect.DeepEqual(previous.Annotations(), next.Annotations())
+
+	d.SettingsChanged = previous.TriremeAction != next.TriremeAction ||
+		previous.EncryptionEnabled() != next.EncryptionEnabled() ||
+		!reflect.DeepEqual(previous.IPAddresses(), next.IPAddresses()) ||
+		!equalStrings(previous.TriremeNetworks(), next.TriremeNetworks()) ||
+		!equalStrings(previous.ExcludedNetworks(), next.ExcludedNetworks())
+
+	return d
+}
+
+// Empty returns true if the policies are the same
+func (d *PolicyDiff) Empty() bool {
+
+	return d.ACLChanges() == 0 && d.RuleChanges() == 0 && !d.IdentityChanged && !d.SettingsChanged
+}
+
+// ACLChanges returns the number of ACLs that were added or removed
+func (d *PolicyDiff) ACLChanges() int {
+
+	return len(d.AddedApplicationACLs) + len(d.RemovedApplicationACLs) + len(d.AddedNetworkACLs) + len(d.RemovedNetworkACLs)
+}
+
+// RuleChanges returns the number of tag selectors that were added or removed
+func (d *PolicyDiff) RuleChanges() int {
+
+	return len(d.AddedTransmitterRules) + len(d.RemovedTransmitterRules) + len(d.AddedReceiverRules) + len(d.RemovedReceiverRules)
+}
+
+// DiffIPRules returns the rules of next that are not in previous and the rules
+// of previous that are not in next. A rule that is repeated is added or removed
+// as many times as its number of occurrences changed.
+func DiffIPRules(previous, next []IPRule) (added []IPRule, removed []IPRule) {
+
+	count := map[IPRule]int{}
+	for _, rule := range previous {
+		count[rule]++
+	}
+
+	for _, rule := range next {
+		if count[rule] > 0 {
+			count[rule]--
+			continue
+		}
+		added = append(added, rule)
+	}
+
+	for _, rule := range previous {
+		if count[rule] > 0 {
+			count[rule]--
+			removed = append(removed, rule)
+		}
+	}
+
+	return added, removed
+}
+
+// DiffTagSelectors returns the selectors of next that are not in previous and
+// the selectors of previous that are not in next
+func DiffTagSelectors(previous, next []TagSelector) (added []TagSelector, removed []TagSelector) {
+
+	matched := make([]bool, len(previous))
+
+	for _, selector := range next {
+		found := false
+		for i := range previous {
+			if !matched[i] && reflect.DeepEqual(previous[i], selector) {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			added = append(added, selector)
+		}
+	}
+
+	for i, selector := range previous {
+		if !matched[i] {
+			removed = append(removed, selector)
+		}
+	}
+
+	return added, removed
+}
+
+// equalStrings returns true if the lists have the same strings in the same order
+func equalStrings(a, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,207 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	web = IPRule{Address: "10.0.0.0/8", Port: "80", Protocol: "tcp", Action: Accept}
+	dns = IPRule{Address: "10.0.0.1", Port: "53", Protocol: "udp", Action: Accept}
+	ssh = IPRule{Address: "0.0.0.0/0", Port: "22", Protocol: "tcp", Action: Reject | Log}
+
+	appWeb = TagSelector{Clause: []KeyValueOperator{{Key: "app", Operator: Equal, Value: []string{"web"}}}, Action: Accept}
+	appDB  = TagSelector{Clause: []KeyValueOperator{{Key: "app", Operator: Equal, Value: []string{"db"}}}, Action: Accept}
+	appBad = TagSelector{Clause: []KeyValueOperator{{Key: "app", Operator: Equal, Value: []string{"bad"}}}, Action: Reject}
+)
+
+func TestDiffIPRules(t *testing.T) {
+	Convey("Given lists of ACLs", t, func() {
+
+		Convey("When the rules are the same in another order", func() {
+			added, removed := DiffIPRules([]IPRule{web, dns, ssh}, []IPRule{ssh, web, dns})
+
+			Convey("Then there should be no change", func() {
+				So(added, ShouldBeEmpty)
+				So(removed, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a rule is replaced", func() {
+			added, removed := DiffIPRules([]IPRule{web, dns}, []IPRule{dns, ssh})
+
+			Convey("Then the new rule should be added and the old one removed", func() {
+				So(added, ShouldResemble, []IPRule{ssh})
+				So(removed, ShouldResemble, []IPRule{web})
+			})
+		})
+
+		Convey("When a rule is repeated", func() {
+			added, removed := DiffIPRules([]IPRule{web}, []IPRule{web, dns, web})
+
+			Convey("Then the new occurrence should be added", func() {
+				So(added, ShouldResemble, []IPRule{dns, web})
+				So(removed, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a repeated rule is removed once", func() {
+			added, removed := DiffIPRules([]IPRule{web, dns, web}, []IPRule{dns, web})
+
+			Convey("Then only one occurrence should be removed", func() {
+				So(added, ShouldBeEmpty)
+				So(removed, ShouldResemble, []IPRule{web})
+			})
+		})
+
+		Convey("When all the rules are removed", func() {
+			added, removed := DiffIPRules([]IPRule{web, web}, nil)
+
+			Convey("Then every occurrence should be removed", func() {
+				So(added, ShouldBeEmpty)
+				So(removed, ShouldResemble, []IPRule{web, web})
+			})
+		})
+	})
+}
+
+func TestDiffTagSelectors(t *testing.T) {
+	Convey("Given lists of tag selectors", t, func() {
+
+		Convey("When the selectors are the same in another order", func() {
+			added, removed := DiffTagSelectors([]TagSelector{appWeb, appDB, appBad}, []TagSelector{appBad, *appWeb.Clone(), appDB})
+
+			Convey("Then there should be no change", func() {
+				So(added, ShouldBeEmpty)
+				So(removed, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a selector changes its action", func() {
+			encrypted := *appWeb.Clone()
+			encrypted.Action = Accept | Encrypt
+
+			added, removed := DiffTagSelectors([]TagSelector{appWeb, appDB}, []TagSelector{appDB, encrypted})
+
+			Convey("Then the new selector should be added and the old one removed", func() {
+				So(added, ShouldResemble, []TagSelector{encrypted})
+				So(removed, ShouldResemble, []TagSelector{appWeb})
+			})
+		})
+
+		Convey("When a selector is repeated", func() {
+			added, removed := DiffTagSelectors([]TagSelector{appWeb, appDB}, []TagSelector{appWeb, appDB, appWeb})
+
+			Convey("Then the new occurrence should be added", func() {
+				So(added, ShouldResemble, []TagSelector{appWeb})
+				So(removed, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a repeated selector is removed once", func() {
+			added, removed := DiffTagSelectors([]TagSelector{appDB, appDB, appWeb}, []TagSelector{appWeb, appDB})
+
+			Convey("Then only one occurrence should be removed", func() {
+				So(added, ShouldBeEmpty)
+				So(removed, ShouldResemble, []TagSelector{appDB})
+			})
+		})
+	})
+}
+
+func TestDiffPolicies(t *testing.T) {
+	Convey("Given the policy of a PU", t, func() {
+
+		newPolicy := func(id string, action PUAction, acls []IPRule, rules []TagSelector, identity map[string]string, ip string, networks []string) *PUPolicy {
+			return NewPUPolicy(
+				id,
+				action,
+				NewIPRuleList(acls),
+				NewIPRuleList([]IPRule{dns}),
+				nil,
+				NewTagSelectorList(rules),
+				NewTagsMap(identity),
+				NewTagsMap(map[string]string{"image": "nginx"}),
+				NewIPMap(map[string]string{DefaultNamespace: ip}),
+				networks,
+				[]string{"10.0.0.1"},
+				nil,
+			)
+		}
+
+		previous := newPolicy("pu", Police, []IPRule{web, ssh}, []TagSelector{appWeb, appBad}, map[string]string{"app": "web"}, "172.17.0.2", []string{"172.17.0.0/24"})
+
+		Convey("When the next policy has the same rules in another order", func() {
+			next := newPolicy("pu", Police, []IPRule{ssh, web}, []TagSelector{appBad, appWeb}, map[string]string{"app": "web"}, "172.17.0.2", []string{"172.17.0.0/24"})
+			d := DiffPolicies(previous, next)
+
+			Convey("Then the difference should be empty", func() {
+				So(d.Empty(), ShouldBeTrue)
+				So(d.IdentityChanged, ShouldBeFalse)
+				So(d.SettingsChanged, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the next policy has a new ACL and a repeated selector", func() {
+			next := newPolicy("pu", Police, []IPRule{web, ssh, dns}, []TagSelector{appWeb, appBad, appWeb}, map[string]string{"app": "web"}, "172.17.0.2", []string{"172.17.0.0/24"})
+			d := DiffPolicies(previous, next)
+
+			Convey("Then only the rules should have changed", func() {
+				So(d.Empty(), ShouldBeFalse)
+				So(d.AddedApplicationACLs, ShouldResemble, []IPRule{dns})
+				So(d.AddedReceiverRules, ShouldResemble, []TagSelector{appWeb})
+				So(d.ACLChanges(), ShouldEqual, 1)
+				So(d.RuleChanges(), ShouldEqual, 1)
+				So(d.IdentityChanged, ShouldBeFalse)
+				So(d.SettingsChanged, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the next policy has another management ID or identity", func() {
+			renamed := newPolicy("other", Police, []IPRule{web, ssh}, []TagSelector{appWeb, appBad}, map[string]string{"app": "web"}, "172.17.0.2", []string{"172.17.0.0/24"})
+			retagged := newPolicy("pu", Police, []IPRule{web, ssh}, []TagSelector{appWeb, appBad}, map[string]string{"app": "db"}, "172.17.0.2", []string{"172.17.0.0/24"})
+
+			Convey("Then only the identity should have changed", func() {
+				for _, next := range []*PUPolicy{renamed, retagged} {
+					d := DiffPolicies(previous, next)
+					So(d.Empty(), ShouldBeFalse)
+					So(d.IdentityChanged, ShouldBeTrue)
+					So(d.SettingsChanged, ShouldBeFalse)
+					So(d.ACLChanges()+d.RuleChanges(), ShouldEqual, 0)
+				}
+			})
+		})
+
+		Convey("When the next policy has another action, IP address or networks", func() {
+			audited := newPolicy("pu", Audit, []IPRule{web, ssh}, []TagSelector{appWeb, appBad}, map[string]string{"app": "web"}, "172.17.0.2", []string{"172.17.0.0/24"})
+			moved := newPolicy("pu", Police, []IPRule{web, ssh}, []TagSelector{appWeb, appBad}, map[string]string{"app": "web"}, "172.17.0.3", []string{"172.17.0.0/24"})
+			extended := newPolicy("pu", Police, []IPRule{web, ssh}, []TagSelector{appWeb, appBad}, map[string]string{"app": "web"}, "172.17.0.2", []string{"172.17.0.0/24", "10.0.0.0/8"})
+
+			Convey("Then only the settings should have changed", func() {
+				for _, next := range []*PUPolicy{audited, moved, extended} {
+					d := DiffPolicies(previous, next)
+					So(d.Empty(), ShouldBeFalse)
+					So(d.SettingsChanged, ShouldBeTrue)
+					So(d.IdentityChanged, ShouldBeFalse)
+					So(d.ACLChanges()+d.RuleChanges(), ShouldEqual, 0)
+				}
+			})
+		})
+
+		Convey("When a selector of the next policy requires encryption", func() {
+			encrypted := *appWeb.Clone()
+			encrypted.Action = Accept | Encrypt
+
+			next := newPolicy("pu", Police, []IPRule{web, ssh}, []TagSelector{encrypted, appBad}, map[string]string{"app": "web"}, "172.17.0.2", []string{"172.17.0.0/24"})
+			d := DiffPolicies(previous, next)
+
+			Convey("Then the selector and the settings should have changed", func() {
+				So(d.AddedReceiverRules, ShouldResemble, []TagSelector{encrypted})
+				So(d.RemovedReceiverRules, ShouldResemble, []TagSelector{appWeb})
+				So(d.SettingsChanged, ShouldBeTrue)
+				So(d.IdentityChanged, ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,214 @@
+package fileresolver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/aporeto-inc/trireme/enforcer/lookup"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// Document is a policy document of the directory. The rules of all the
+// documents that select a PU are added to its policy in the order of the names
+// of their files. For example:
+//
+//	selector:
+//	- {key: "@usr:app", operator: "=", value: [backend]}
+//	identity:
+//	  tier: data
+//	receiverRules:
+//	- id: from-frontend
+//	  action: accept
+//	  clause:
+//	  - {key: "@usr:app", operator: "=", value: [frontend]}
+//	networkACLs:
+//	- {address: 10.0.0.0/8, port: "443", protocol: tcp, action: accept}
+//	- {address: 0.0.0.0/0, protocol: icmp, icmpType: "8", action: accept}
+type Document struct {
+	// Selector selects the PUs of the document with the tags of their runtime.
+	// A PU is selected if all the clauses match. An empty selector selects all
+	// the PUs.
+	Selector []policy.KeyValueOperator `json:"selector"`
+	// Identity are the tags that are added to the identity of the PUs
+	Identity map[string]string `json:"identity"`
+	// Annotations are the tags that are added to the annotations of the PUs
+	Annotations map[string]string `json:"annotations"`
+	// TransmitterRules are the rules for the identities of the servers
+	TransmitterRules []Rule `json:"transmitterRules"`
+	// ReceiverRules are the rules for the identities of the clients
+	ReceiverRules []Rule `json:"receiverRules"`
+	// ApplicationACLs are the ACLs of the traffic sent by the PUs
+	ApplicationACLs []ACL `json:"applicationACLs"`
+	// NetworkACLs are the ACLs of the traffic received by the PUs
+	NetworkACLs []ACL `json:"networkACLs"`
+}
+
+// Rule is a tag selector of a document
+type Rule struct {
+	ID     string                    `json:"id"`
+	Action string                    `json:"action"`
+	Log    bool                      `json:"log"`
+	Clause []policy.KeyValueOperator `json:"clause"`
+}
+
+// ACL is an IP rule of a document
+type ACL struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Port     string `json:"port"`
+	Protocol string `json:"protocol"`
+	ICMPType string `json:"icmpType"`
+	ICMPCode string `json:"icmpCode"`
+	Action   string `json:"action"`
+	Log      bool   `json:"log"`
+}
+
+// ParseDocument parses and validates a YAML or JSON policy document
+func ParseDocument(data []byte) (*Document, error) {
+
+	document := &Document{}
+	if err := yaml.Unmarshal(data, document); err != nil {
+		return nil, fmt.Errorf("Unable to parse the document: %s", err)
+	}
+
+	if err := document.Validate(); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+// Validate returns an error if a selector, a rule or an ACL of the document is
+// invalid
+func (d *Document) Validate() error {
+
+	if len(d.Selector) > 0 {
+		selector := policy.NewTagSelectorList([]policy.TagSelector{{Clause: d.Selector, Action: policy.Accept}})
+		if err := validationError("selector", selector.Validate()); err != nil {
+			return err
+		}
+	}
+
+	if err := validateRules("transmitterRules", d.TransmitterRules); err != nil {
+		return err
+	}
+
+	if err := validateRules("receiverRules", d.ReceiverRules); err != nil {
+		return err
+	}
+
+	if err := validateACLs("applicationACLs", d.ApplicationACLs); err != nil {
+		return err
+	}
+
+	return validateACLs("networkACLs", d.NetworkACLs)
+}
+
+// Selects returns true if the selector of the document matches the tags
+func (d *Document) Selects(tags *policy.TagsMap) bool {
+
+	if len(d.Selector) == 0 {
+		return true
+	}
+
+	db := lookup.NewPolicyDB()
+	db.AddPolicy(*policy.NewTagSelector(d.Selector, policy.Accept))
+
+	index, _ := db.Search(tags)
+
+	return index >= 0
+}
+
+// validateRules returns an error if a rule has an invalid action or clause
+func validateRules(name string, rules []Rule) error {
+
+	for i, rule := range rules {
+		if _, err := flowAction(rule.Action, rule.Log); err != nil {
+			return fmt.Errorf("Invalid %s[%d]: %s", name, i, err)
+		}
+	}
+
+	return validationError(name, policy.NewTagSelectorList(tagSelectors(rules)).Validate())
+}
+
+// validateACLs returns an error if an ACL has an invalid action, address,
+// protocol or port
+func validateACLs(name string, acls []ACL) error {
+
+	for i, acl := range acls {
+		if _, err := flowAction(acl.Action, acl.Log); err != nil {
+			return fmt.Errorf("Invalid %s[%d]: %s", name, i, err)
+		}
+	}
+
+	return validationError(name, policy.NewIPRuleList(ipRules(acls)).Validate())
+}
+
+// validationError returns an error with the first error of a validation
+func validationError(name string, v *policy.PolicyValidation) error {
+
+	if len(v.Errors) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("Invalid %s%s: %s", name, v.Errors[0].Field, v.Errors[0].Message)
+}
+
+// flowAction returns the action of a rule or an ACL
+func flowAction(action string, log bool) (policy.FlowAction, error) {
+
+	var flowAction policy.FlowAction
+
+	switch strings.ToLower(action) {
+	case "accept":
+		flowAction = policy.Accept
+	case "reject":
+		flowAction = policy.Reject
+	default:
+		return 0, fmt.Errorf("Invalid action %s", action)
+	}
+
+	if log {
+		flowAction |= policy.Log
+	}
+
+	return flowAction, nil
+}
+
+// tagSelectors returns the tag selectors of validated rules
+func tagSelectors(rules []Rule) []policy.TagSelector {
+
+	selectors := []policy.TagSelector{}
+
+	for _, rule := range rules {
+		action, _ := flowAction(rule.Action, rule.Log)
+		selector := policy.NewTagSelector(rule.Clause, action)
+		selector.ID = rule.ID
+		selectors = append(selectors, *selector)
+	}
+
+	return selectors
+}
+
+// ipRules returns the IP rules of validated ACLs
+func ipRules(acls []ACL) []policy.IPRule {
+
+	rules := []policy.IPRule{}
+
+	for _, acl := range acls {
+		action, _ := flowAction(acl.Action, acl.Log)
+		rules = append(rules, policy.IPRule{
+			Address:  acl.Address,
+			Port:     acl.Port,
+			Protocol: acl.Protocol,
+			ICMPType: acl.ICMPType,
+			ICMPCode: acl.ICMPCode,
+			Action:   action,
+			ID:       acl.ID,
+		})
+	}
+
+	return rules
+}
@@ -0,0 +1,97 @@
+package fileresolver
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const backendYAML = `
+selector:
+- {key: "@usr:app", operator: "=", value: [backend]}
+identity:
+  tier: data
+annotations:
+  owner: storage
+receiverRules:
+- id: from-frontend
+  action: accept
+  log: true
+  clause:
+  - {key: "@usr:app", operator: "=", value: [frontend]}
+networkACLs:
+- {id: https, address: 10.0.0.0/8, port: "443", protocol: tcp, action: accept}
+`
+
+func TestParseDocument(t *testing.T) {
+
+	Convey("When I parse a YAML document", t, func() {
+		d, err := ParseDocument([]byte(backendYAML))
+
+		Convey("Then I should get its selector, tags, rules and ACLs", func() {
+			So(err, ShouldBeNil)
+			So(d.Selector, ShouldResemble, []policy.KeyValueOperator{{Key: "@usr:app", Operator: policy.Equal, Value: []string{"backend"}}})
+			So(d.Identity, ShouldResemble, map[string]string{"tier": "data"})
+			So(d.Annotations, ShouldResemble, map[string]string{"owner": "storage"})
+			So(tagSelectors(d.ReceiverRules), ShouldResemble, []policy.TagSelector{{
+				Clause: []policy.KeyValueOperator{{Key: "@usr:app", Operator: policy.Equal, Value: []string{"frontend"}}},
+				Action: policy.Accept | policy.Log,
+				ID:     "from-frontend",
+			}})
+			So(ipRules(d.NetworkACLs), ShouldResemble, []policy.IPRule{{Address: "10.0.0.0/8", Port: "443", Protocol: "tcp", Action: policy.Accept, ID: "https"}})
+		})
+	})
+
+	Convey("When I parse a JSON document", t, func() {
+		d, err := ParseDocument([]byte(`{"applicationACLs": [{"address": "0.0.0.0/0", "protocol": "udp", "port": "53", "action": "Reject"}]}`))
+
+		Convey("Then I should get a document that selects all the PUs", func() {
+			So(err, ShouldBeNil)
+			So(d.Selects(policy.NewTagsMap(nil)), ShouldBeTrue)
+			So(ipRules(d.ApplicationACLs)[0].Action, ShouldEqual, policy.Reject)
+		})
+	})
+
+	Convey("When I parse a document with an ICMP ACL", t, func() {
+		d, err := ParseDocument([]byte(`networkACLs: [{address: 0.0.0.0/0, protocol: icmp, icmpType: "3", icmpCode: "4", action: accept}]`))
+
+		Convey("Then I should get the type and the code of the ACL", func() {
+			So(err, ShouldBeNil)
+			So(ipRules(d.NetworkACLs), ShouldResemble, []policy.IPRule{{Address: "0.0.0.0/0", Protocol: "icmp", ICMPType: "3", ICMPCode: "4", Action: policy.Accept}})
+		})
+	})
+
+	Convey("When I parse invalid documents, I should get errors", t, func() {
+		for _, data := range []string{
+			`selector: [`,
+			`selector: [{key: app, operator: "~", value: [a]}]`,
+			`selector: [{key: app, operator: "=", value: []}]`,
+			`selector: [{key: app, operator: "=", value: [""]}]`,
+			`selector: [{key: app, operator: "=~", value: ["("]}]`,
+			`selector: [{key: ip, operator: "in", value: [10.0.0.0]}]`,
+			`transmitterRules: [{action: allow, clause: [{key: app, operator: "*"}]}]`,
+			`receiverRules: [{action: accept}]`,
+			`networkACLs: [{address: 10.0.0.0/33, protocol: tcp, action: accept}]`,
+			`applicationACLs: [{address: 10.0.0.1, action: accept}]`,
+			`applicationACLs: [{address: 10.0.0.1, protocol: icmp, icmpType: "300", action: accept}]`,
+		} {
+			_, err := ParseDocument([]byte(data))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestSelects(t *testing.T) {
+
+	Convey("Given a document with a selector", t, func() {
+		d, err := ParseDocument([]byte(`selector: [{key: "@usr:app", operator: "=", value: [backend]}, {key: "@usr:env", operator: "=~", value: ["prod|staging"]}]`))
+		So(err, ShouldBeNil)
+
+		Convey("Then it should select the PUs that match all the clauses", func() {
+			So(d.Selects(policy.NewTagsMap(map[string]string{"@usr:app": "backend", "@usr:env": "prod"})), ShouldBeTrue)
+			So(d.Selects(policy.NewTagsMap(map[string]string{"@usr:app": "backend", "@usr:env": "dev"})), ShouldBeFalse)
+			So(d.Selects(policy.NewTagsMap(map[string]string{"@usr:app": "backend"})), ShouldBeFalse)
+		})
+	})
+}
@@ -0,0 +1,366 @@
+// Package fileresolver implements a policy resolver that builds the policies of
+// the processing units with the YAML or JSON policy documents of a directory.
+// The directory is polled for changes and the policies of the running PUs
+// that are affected by a change are updated.
+//
+// Polling is intended: the directories of policies are often mounted volumes,
+// such as Kubernetes ConfigMaps that are replaced with a symbolic link, or
+// network file systems, where file notifications are missed. A change is
+// applied at most one interval after it is written, and a poll that finds
+// the same contents does not parse the documents.
+package fileresolver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme"
+	"github.com/aporeto-inc/trireme/monitor"
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+// FileResolver resolves the policies of the PUs with the policy documents of a
+// directory. The files with the .yaml, .yml and .json extensions are loaded.
+type FileResolver struct {
+	directory       string
+	triremeNetworks []string
+	files           map[string][]byte
+	documents       []*Document
+	runtimes        map[string]policy.RuntimeReader
+	updater         trireme.PolicyUpdater
+	stop            chan struct{}
+	sync.Mutex
+}
+
+// NewFileResolver creates a resolver with the policy documents of a directory.
+// It returns an error if a document is invalid.
+func NewFileResolver(directory string, triremeNetworks []string) (*FileResolver, error) {
+
+	r := &FileResolver{
+		directory:       directory,
+		triremeNetworks: triremeNetworks,
+		runtimes:        map[string]policy.RuntimeReader{},
+	}
+
+	files, err := r.readFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	documents, err := parseFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	r.files = files
+	r.documents = documents
+
+	return r, nil
+}
+
+// ResolvePolicy implements the trireme.PolicyResolver interface. The policy of
+// the PU has the rules of all the documents that select it, and the tags of its
+// runtime in its identity and annotations.
+func (r *FileResolver) ResolvePolicy(contextID string, runtimeInfo policy.RuntimeReader) (*policy.PUPolicy, error) {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.runtimes[contextID] = runtimeInfo
+
+	return r.resolve(contextID, runtimeInfo, r.documents), nil
+}
+
+// HandlePUEvent implements the trireme.PolicyResolver interface. The policies
+// of the PUs that are stopped are no longer updated.
+func (r *FileResolver) HandlePUEvent(contextID string, eventType monitor.Event) {
+
+	if eventType != monitor.EventStop && eventType != monitor.EventDestroy {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.runtimes, contextID)
+}
+
+// SetPolicyUpdater registers the updater of the policies of the running PUs
+func (r *FileResolver) SetPolicyUpdater(pu trireme.PolicyUpdater) error {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.updater = pu
+
+	return nil
+}
+
+// Start reloads the documents of the directory every interval until Stop is
+// called. The interval is the longest time before a change is applied.
+func (r *FileResolver) Start(interval time.Duration) error {
+
+	if interval <= 0 {
+		return fmt.Errorf("Invalid interval %s to reload the policies of %s", interval, r.directory)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.stop != nil {
+		return fmt.Errorf("The policy resolver of %s is already started", r.directory)
+	}
+
+	r.stop = make(chan struct{})
+	go r.reloadLoop(interval, r.stop)
+
+	return nil
+}
+
+// Stop stops reloading the documents of the directory
+func (r *FileResolver) Stop() {
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
+
+// Reload loads the documents of the directory if they changed and updates the
+// policies of the running PUs that they affect. The documents are not changed
+// if one of them is invalid.
+func (r *FileResolver) Reload() error {
+
+	updates, err := r.reload()
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	updater := r.updater
+	r.Unlock()
+
+	if updater == nil {
+		return nil
+	}
+
+	// The updates are sent without the lock since trireme resolves the
+	// policies of new PUs while it handles them
+	contextIDs := []string{}
+	for contextID := range updates {
+		contextIDs = append(contextIDs, contextID)
+	}
+	sort.Strings(contextIDs)
+
+	for _, contextID := range contextIDs {
+		if err := <-updater.UpdatePolicy(contextID, updates[contextID]); err != nil {
+			zap.L().Warn("Unable to update the policy of the PU",
+				zap.String("contextID", contextID),
+				zap.Error(err),
+			)
+		}
+	}
+
+	return nil
+}
+
+// reload loads the documents of the directory and returns the new policies of
+// the running PUs that are selected by other documents or whose documents
+// changed
+func (r *FileResolver) reload() (map[string]*policy.PUPolicy, error) {
+
+	files, err := r.readFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if equalFiles(files, r.files) {
+		return nil, nil
+	}
+
+	// The files are recorded even if they are invalid so that the error is
+	// reported once
+	r.files = files
+
+	documents, err := parseFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]*policy.PUPolicy{}
+	for contextID, runtimeInfo := range r.runtimes {
+		if reflect.DeepEqual(selected(runtimeInfo, r.documents), selected(runtimeInfo, documents)) {
+			continue
+		}
+		updates[contextID] = r.resolve(contextID, runtimeInfo, documents)
+	}
+
+	r.documents = documents
+
+	return updates, nil
+}
+
+// reloadLoop reloads the documents periodically until it is stopped
+func (r *FileResolver) reloadLoop(interval time.Duration, stop chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				zap.L().Error("Unable to reload the policy documents",
+					zap.String("directory", r.directory),
+					zap.Error(err),
+				)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// resolve returns the policy of a PU with the documents that select it
+func (r *FileResolver) resolve(contextID string, runtimeInfo policy.RuntimeReader, documents []*Document) *policy.PUPolicy {
+
+	identity := runtimeInfo.Tags()
+	annotations := runtimeInfo.Tags()
+	transmitterRules := []policy.TagSelector{}
+	receiverRules := []policy.TagSelector{}
+	applicationACLs := []policy.IPRule{}
+	networkACLs := []policy.IPRule{}
+
+	for _, d := range selected(runtimeInfo, documents) {
+		for k, v := range d.Identity {
+			identity.Add(k, v)
+		}
+		for k, v := range d.Annotations {
+			annotations.Add(k, v)
+		}
+		transmitterRules = append(transmitterRules, tagSelectors(d.TransmitterRules)...)
+		receiverRules = append(receiverRules, tagSelectors(d.ReceiverRules)...)
+		applicationACLs = append(applicationACLs, ipRules(d.ApplicationACLs)...)
+		networkACLs = append(networkACLs, ipRules(d.NetworkACLs)...)
+	}
+
+	ips := policy.NewIPMap(map[string]string{})
+	if ip, ok := runtimeInfo.DefaultIPAddress(); ok {
+		ips.Add(policy.DefaultNamespace, ip)
+	}
+	if ip, ok := runtimeInfo.DefaultIPv6Address(); ok {
+		ips.Add(policy.DefaultIPv6Namespace, ip)
+	}
+
+	return policy.NewPUPolicy(
+		contextID,
+		policy.Police,
+		policy.NewIPRuleList(applicationACLs),
+		policy.NewIPRuleList(networkACLs),
+		policy.NewTagSelectorList(transmitterRules),
+		policy.NewTagSelectorList(receiverRules),
+		identity,
+		annotations,
+		ips,
+		r.triremeNetworks,
+		[]string{},
+		nil,
+	)
+}
+
+// readFiles returns the content of the policy documents of the directory
+func (r *FileResolver) readFiles() (map[string][]byte, error) {
+
+	entries, err := ioutil.ReadDir(r.directory)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read the policy directory %s: %s", r.directory, err)
+	}
+
+	files := map[string][]byte{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(r.directory, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read the policy document %s: %s", entry.Name(), err)
+		}
+		files[entry.Name()] = data
+	}
+
+	return files, nil
+}
+
+// parseFiles returns the documents of the files in the order of their names
+func parseFiles(files map[string][]byte) ([]*Document, error) {
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	documents := []*Document{}
+	for _, name := range names {
+		d, err := ParseDocument(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid policy document %s: %s", name, err)
+		}
+		documents = append(documents, d)
+	}
+
+	return documents, nil
+}
+
+// equalFiles returns true if the files have the same names and contents
+func equalFiles(a, b map[string][]byte) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for name, data := range a {
+		if other, ok := b[name]; !ok || !bytes.Equal(data, other) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// selected returns the documents that select a PU
+func selected(runtimeInfo policy.RuntimeReader, documents []*Document) []*Document {
+
+	tags := runtimeInfo.Tags()
+
+	selected := []*Document{}
+	for _, d := range documents {
+		if d.Selects(tags) {
+			selected = append(selected, d)
+		}
+	}
+
+	return selected
+}
@@ -0,0 +1,198 @@
+package fileresolver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/monitor"
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testUpdater records the policies that are updated
+type testUpdater struct {
+	updates map[string]*policy.PUPolicy
+	sync.Mutex
+}
+
+func (u *testUpdater) UpdatePolicy(contextID string, newPolicy *policy.PUPolicy) <-chan error {
+
+	u.Lock()
+	defer u.Unlock()
+
+	u.updates[contextID] = newPolicy
+
+	c := make(chan error, 1)
+	c <- nil
+
+	return c
+}
+
+func (u *testUpdater) count() int {
+
+	u.Lock()
+	defer u.Unlock()
+
+	return len(u.updates)
+}
+
+func newRuntime(app string) *policy.PURuntime {
+
+	tags := policy.NewTagsMap(map[string]string{"@usr:app": app})
+	ips := policy.NewIPMap(map[string]string{policy.DefaultNamespace: "172.17.0.2"})
+
+	return policy.NewPURuntime(app, 1, tags, ips, constants.ContainerPU, nil)
+}
+
+func TestFileResolver(t *testing.T) {
+
+	Convey("Given a directory of policy documents", t, func() {
+		dir, err := ioutil.TempDir("", "fileresolver")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		write := func(name, data string) {
+			So(ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600), ShouldBeNil)
+		}
+
+		write("10-backend.yaml", backendYAML)
+		write("20-all.json", `{"applicationACLs": [{"address": "0.0.0.0/0", "protocol": "udp", "port": "53", "action": "accept"}]}`)
+		write("README.md", "not a policy")
+
+		r, err := NewFileResolver(dir, []string{"10.0.0.0/8"})
+		So(err, ShouldBeNil)
+
+		updater := &testUpdater{updates: map[string]*policy.PUPolicy{}}
+		So(r.SetPolicyUpdater(updater), ShouldBeNil)
+
+		Convey("When I resolve the policy of a selected PU", func() {
+			p, err := r.ResolvePolicy("backend", newRuntime("backend"))
+
+			Convey("Then it should have the rules of the documents in order", func() {
+				So(err, ShouldBeNil)
+				So(p.ReceiverRules().TagSelectors, ShouldHaveLength, 1)
+				So(p.NetworkACLs().Rules, ShouldHaveLength, 1)
+				So(p.ApplicationACLs().Rules, ShouldHaveLength, 1)
+				So(p.Identity().Tags, ShouldResemble, map[string]string{"@usr:app": "backend", "tier": "data"})
+				So(p.Annotations().Tags["owner"], ShouldEqual, "storage")
+				So(p.TriremeNetworks(), ShouldResemble, []string{"10.0.0.0/8"})
+				ip, _ := p.DefaultIPAddress()
+				So(ip, ShouldEqual, "172.17.0.2")
+			})
+		})
+
+		Convey("When I resolve the policy of another PU", func() {
+			p, err := r.ResolvePolicy("frontend", newRuntime("frontend"))
+
+			Convey("Then it should only have the rules of the documents that select it", func() {
+				So(err, ShouldBeNil)
+				So(p.ReceiverRules().TagSelectors, ShouldBeEmpty)
+				So(p.ApplicationACLs().Rules, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When a document of running PUs changes", func() {
+			_, err := r.ResolvePolicy("backend", newRuntime("backend"))
+			So(err, ShouldBeNil)
+			_, err = r.ResolvePolicy("frontend", newRuntime("frontend"))
+			So(err, ShouldBeNil)
+
+			write("10-backend.yaml", backendYAML+"\ntransmitterRules: [{action: accept, clause: [{key: \"@usr:app\", operator: \"=\", value: [database]}]}]")
+
+			Convey("Then only the policies of the PUs it selects should be updated", func() {
+				So(r.Reload(), ShouldBeNil)
+				So(updater.count(), ShouldEqual, 1)
+				So(updater.updates["backend"].TransmitterRules().TagSelectors, ShouldHaveLength, 1)
+			})
+
+			Convey("Then a stopped PU should not be updated", func() {
+				r.HandlePUEvent("backend", monitor.EventStop)
+				So(r.Reload(), ShouldBeNil)
+				So(updater.count(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a document that selects all the PUs is removed", func() {
+			_, err := r.ResolvePolicy("backend", newRuntime("backend"))
+			So(err, ShouldBeNil)
+			_, err = r.ResolvePolicy("frontend", newRuntime("frontend"))
+			So(err, ShouldBeNil)
+
+			So(os.Remove(filepath.Join(dir, "20-all.json")), ShouldBeNil)
+
+			Convey("Then the policies of all the PUs should be updated", func() {
+				So(r.Reload(), ShouldBeNil)
+				So(updater.count(), ShouldEqual, 2)
+				So(updater.updates["frontend"].ApplicationACLs().Rules, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When nothing changes, no policy should be updated", func() {
+			_, err := r.ResolvePolicy("backend", newRuntime("backend"))
+			So(err, ShouldBeNil)
+			So(r.Reload(), ShouldBeNil)
+			So(updater.count(), ShouldEqual, 0)
+		})
+
+		Convey("When a document becomes invalid", func() {
+			_, err := r.ResolvePolicy("backend", newRuntime("backend"))
+			So(err, ShouldBeNil)
+
+			write("10-backend.yaml", "receiverRules: [{action: accept}]")
+
+			Convey("Then I should get an error and keep the previous documents", func() {
+				So(r.Reload(), ShouldNotBeNil)
+				So(updater.count(), ShouldEqual, 0)
+
+				p, err := r.ResolvePolicy("backend", newRuntime("backend"))
+				So(err, ShouldBeNil)
+				So(p.ReceiverRules().TagSelectors, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When I start the resolver without an interval, I should get an error", func() {
+			So(r.Start(0), ShouldNotBeNil)
+		})
+
+		Convey("When I start the resolver", func() {
+			So(r.Start(10*time.Millisecond), ShouldBeNil)
+			defer r.Stop()
+
+			_, err := r.ResolvePolicy("backend", newRuntime("backend"))
+			So(err, ShouldBeNil)
+
+			write("30-deny.yaml", `receiverRules: [{action: reject, clause: [{key: "@usr:app", operator: "=", value: [bad]}]}]`)
+
+			Convey("Then the change should be detected", func() {
+				So(r.Start(10*time.Millisecond), ShouldNotBeNil)
+
+				deadline := time.Now().Add(2 * time.Second)
+				for updater.count() == 0 && time.Now().Before(deadline) {
+					time.Sleep(10 * time.Millisecond)
+				}
+				So(updater.count(), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("When I create a resolver with an invalid document, I should get an error", t, func() {
+		dir, err := ioutil.TempDir("", "fileresolver")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		So(ioutil.WriteFile(filepath.Join(dir, "bad.yml"), []byte("selector: ["), 0600), ShouldBeNil)
+
+		_, err = NewFileResolver(dir, nil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("When I create a resolver without a directory, I should get an error", t, func() {
+		_, err := NewFileResolver("/nonexistent/fileresolver", nil)
+		So(err, ShouldNotBeNil)
+	})
+}
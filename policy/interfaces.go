@@ -32,6 +32,9 @@ type RuntimeReader interface {
 	// DefaultIPAddress retutns the default IP address.
 	DefaultIPAddress() (string, bool)
 
+	// DefaultIPv6Address returns the IPv6 address if the PU has one.
+	DefaultIPv6Address() (string, bool)
+
 	// IPAddresses returns a copy of all the IP addresses.
 	IPAddresses() *IPMap
 	//Returns the PUType for the PU
@@ -64,4 +67,7 @@ type InfoInteractor interface {
 
 	// DefaultIPAddress returns the default IP address for the processing unit
 	DefaultIPAddress() (string, bool)
+
+	// DefaultIPv6Address returns the IPv6 address for the processing unit
+	DefaultIPv6Address() (string, bool)
 }
@@ -1,6 +1,9 @@
 package policy
 
-import "sync"
+import (
+	"encoding/json"
+	"sync"
+)
 
 // PUPolicy captures all policy information related ot the container
 type PUPolicy struct {
@@ -37,6 +40,33 @@ type PUPolicy struct {
 	Extensions interface{}
 }
 
+// PUPolicyJSON is a Json representation of PUPolicy. The extensions are not
+// part of the representation.
+type PUPolicyJSON struct {
+	// ManagementID is the policy identifier of the implementation
+	ManagementID string
+	// TriremeAction is the level of policy applied to the PU
+	TriremeAction PUAction
+	// ApplicationACLs are the ACLs applied to the traffic of the PU
+	ApplicationACLs *IPRuleList
+	// NetworkACLs are the ACLs applied to the traffic to the PU
+	NetworkACLs *IPRuleList
+	// TransmitterRules are the rules matched at the transmitter
+	TransmitterRules *TagSelectorList
+	// ReceiverRules are the rules matched at the receiver
+	ReceiverRules *TagSelectorList
+	// Identity is the set of key value pairs sent over the wire
+	Identity *TagsMap
+	// Annotations are the key value pairs used for accounting
+	Annotations *TagsMap
+	// IPAddresses are the IP addresses the policy is applied to
+	IPAddresses *IPMap
+	// TriremeNetworks are the networks where the authorization is enforced
+	TriremeNetworks []string
+	// ExcludedNetworks are the networks that are excluded
+	ExcludedNetworks []string
+}
+
 // NewPUPolicy generates a new ContainerPolicyInfo
 func NewPUPolicy(
 	id string,
@@ -117,6 +147,58 @@ func (p *PUPolicy) Clone() *PUPolicy {
 	return np
 }
 
+// MarshalJSON Marshals this struct.
+func (p *PUPolicy) MarshalJSON() ([]byte, error) {
+	p.puPolicyMutex.Lock()
+	defer p.puPolicyMutex.Unlock()
+
+	return json.Marshal(&PUPolicyJSON{
+		ManagementID:     p.ManagementID,
+		TriremeAction:    p.TriremeAction,
+		ApplicationACLs:  p.applicationACLs,
+		NetworkACLs:      p.networkACLs,
+		TransmitterRules: p.transmitterRules,
+		ReceiverRules:    p.receiverRules,
+		Identity:         p.identity,
+		Annotations:      p.annotations,
+		IPAddresses:      p.ips,
+		TriremeNetworks:  p.triremeNetworks,
+		ExcludedNetworks: p.excludedNetworks,
+	})
+}
+
+// UnmarshalJSON Unmarshals this struct. The missing lists and maps are empty.
+func (p *PUPolicy) UnmarshalJSON(param []byte) error {
+	a := &PUPolicyJSON{}
+	if err := json.Unmarshal(param, &a); err != nil {
+		return err
+	}
+
+	if a.TriremeNetworks == nil {
+		a.TriremeNetworks = []string{}
+	}
+	if a.ExcludedNetworks == nil {
+		a.ExcludedNetworks = []string{}
+	}
+
+	*p = *NewPUPolicy(
+		a.ManagementID,
+		a.TriremeAction,
+		a.ApplicationACLs,
+		a.NetworkACLs,
+		a.TransmitterRules,
+		a.ReceiverRules,
+		a.Identity,
+		a.Annotations,
+		a.IPAddresses,
+		a.TriremeNetworks,
+		a.ExcludedNetworks,
+		p.Extensions,
+	)
+
+	return nil
+}
+
 // ApplicationACLs returns a copy of IPRuleList
 func (p *PUPolicy) ApplicationACLs() *IPRuleList {
 	p.puPolicyMutex.Lock()
@@ -165,6 +247,23 @@ func (p *PUPolicy) AddTransmitterRules(t *TagSelector) {
 	p.transmitterRules.TagSelectors = append(p.transmitterRules.TagSelectors, *t.Clone())
 }
 
+// EncryptionEnabled returns true if any of the receiver or transmitter rules
+// requires the traffic to be encrypted
+func (p *PUPolicy) EncryptionEnabled() bool {
+	p.puPolicyMutex.Lock()
+	defer p.puPolicyMutex.Unlock()
+
+	for _, rules := range []*TagSelectorList{p.receiverRules, p.transmitterRules} {
+		for _, rule := range rules.TagSelectors {
+			if rule.Action&Encrypt != 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // Identity returns a copy of the Identity
 func (p *PUPolicy) Identity() *TagsMap {
 	p.puPolicyMutex.Lock()
@@ -216,6 +315,17 @@ func (p *PUPolicy) DefaultIPAddress() (string, bool) {
 	return "0.0.0.0/0", false
 }
 
+// DefaultIPv6Address returns the IPv6 address of the processing unit if any
+func (p *PUPolicy) DefaultIPv6Address() (string, bool) {
+	p.puPolicyMutex.Lock()
+	defer p.puPolicyMutex.Unlock()
+
+	if ip, ok := p.ips.IPs[DefaultIPv6Namespace]; ok && len(ip) > 0 {
+		return ip, true
+	}
+	return "::/0", false
+}
+
 // TriremeNetworks  returns the list of networks that Trireme must be applied
 func (p *PUPolicy) TriremeNetworks() []string {
 	return p.triremeNetworks
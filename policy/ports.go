@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortRange is a range of ports. A single port is a range with equal bounds.
+type PortRange struct {
+	First uint16
+	Last  uint16
+}
+
+// IsSingle returns true if the range is a single port
+func (r PortRange) IsSingle() bool {
+
+	return r.First == r.Last
+}
+
+// String returns the port or the range written as first:last
+func (r PortRange) String() string {
+
+	if r.IsSingle() {
+		return strconv.Itoa(int(r.First))
+	}
+
+	return strconv.Itoa(int(r.First)) + ":" + strconv.Itoa(int(r.Last))
+}
+
+// PortSpec is a list of ports and ranges of ports
+type PortSpec []PortRange
+
+// ParsePortSpec parses a list of ports and ranges of ports separated by commas,
+// for example 80,443,8000:8080. The bounds of a range are separated by a colon
+// or a dash.
+func ParsePortSpec(ports string) (PortSpec, error) {
+
+	if strings.TrimSpace(ports) == "" {
+		return nil, fmt.Errorf("No port")
+	}
+
+	spec := PortSpec{}
+
+	for _, element := range strings.Split(ports, ",") {
+		element = strings.TrimSpace(element)
+
+		bounds := strings.SplitN(element, ":", 2)
+		if len(bounds) == 1 {
+			bounds = strings.SplitN(element, "-", 2)
+		}
+
+		first, err := parsePort(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid port %q: %s", element, err)
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			if last, err = parsePort(bounds[1]); err != nil {
+				return nil, fmt.Errorf("Invalid port %q: %s", element, err)
+			}
+		}
+
+		if last < first {
+			return nil, fmt.Errorf("Invalid port %q: the range is reversed", element)
+		}
+
+		spec = append(spec, PortRange{First: first, Last: last})
+	}
+
+	return spec, nil
+}
+
+// parsePort parses a port number
+func parsePort(port string) (uint16, error) {
+
+	n, err := strconv.ParseUint(strings.TrimSpace(port), 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("not a port number")
+	}
+
+	return uint16(n), nil
+}
+
+// String returns the ports and ranges separated by commas
+func (s PortSpec) String() string {
+
+	elements := make([]string, len(s))
+	for i, r := range s {
+		elements[i] = r.String()
+	}
+
+	return strings.Join(elements, ",")
+}
+
+// IsSingle returns true if the list has a single port or range
+func (s PortSpec) IsSingle() bool {
+
+	return len(s) == 1
+}
+
+// Contains returns true if the port is in one of the ranges
+func (s PortSpec) Contains(port uint16) bool {
+
+	for _, r := range s {
+		if port >= r.First && port <= r.Last {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Overlaps returns true if a port is in both lists
+func (s PortSpec) Overlaps(o PortSpec) bool {
+
+	for _, a := range s {
+		for _, b := range o {
+			if a.First <= b.Last && b.First <= a.Last {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Ports returns the ports of the rule
+func (r IPRule) Ports() (PortSpec, error) {
+
+	return ParsePortSpec(r.Port)
+}
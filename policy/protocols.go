@@ -0,0 +1,69 @@
+package policy
+
+import (
+	"strconv"
+	"strings"
+)
+
+// namedProtocols are the IP protocols that can be named in the rules besides
+// the protocols with ports and ICMP. Other protocols are given by their number.
+var namedProtocols = map[string]bool{
+	"ah":      true,
+	"esp":     true,
+	"gre":     true,
+	"igmp":    true,
+	"ipip":    true,
+	"udplite": true,
+}
+
+// HasPorts returns true if the protocol of the rule has ports: TCP, UDP or SCTP
+func (r IPRule) HasPorts() bool {
+
+	switch strings.ToLower(r.Protocol) {
+	case "tcp", "udp", "sctp":
+		return true
+	}
+
+	return false
+}
+
+// IsICMP returns true if the protocol of the rule is ICMP or ICMPv6
+func (r IPRule) IsICMP() bool {
+
+	switch strings.ToLower(r.Protocol) {
+	case "icmp", "icmpv6":
+		return true
+	}
+
+	return false
+}
+
+// ICMPTypeCode returns the type and the code of an ICMP rule written as
+// type/code, or only the type if the rule matches all the codes. It is empty
+// if the rule matches all the types.
+func (r IPRule) ICMPTypeCode() string {
+
+	if !r.IsICMP() || r.ICMPType == "" {
+		return ""
+	}
+
+	if r.ICMPCode == "" {
+		return r.ICMPType
+	}
+
+	return r.ICMPType + "/" + r.ICMPCode
+}
+
+// isNamedProtocol returns true if the protocol is all or a named IP protocol
+func isNamedProtocol(protocol string) bool {
+
+	return protocol == "all" || namedProtocols[protocol]
+}
+
+// isICMPValue returns true if the value is a valid ICMP type or code
+func isICMPValue(value string) bool {
+
+	n, err := strconv.Atoi(value)
+
+	return err == nil && n >= 0 && n <= 255
+}
@@ -96,18 +96,13 @@ func (r *PURuntime) MarshalJSON() ([]byte, error) {
 	})
 }
 
-// UnmarshalJSON Unmarshals this struct.
+// UnmarshalJSON Unmarshals this struct. The missing maps are empty.
 func (r *PURuntime) UnmarshalJSON(param []byte) error {
 	a := &PURuntimeJSON{}
 	if err := json.Unmarshal(param, &a); err != nil {
 		return err
 	}
-	r.pid = a.Pid
-	r.name = a.Name
-	r.ips = a.IPAddresses
-	r.tags = a.Tags
-	r.options = a.Options
-	r.puType = a.PUType
+	*r = *NewPURuntime(a.Name, a.Pid, a.Tags, a.IPAddresses, a.PUType, a.Options)
 	return nil
 }
 
@@ -146,6 +141,16 @@ func (r *PURuntime) DefaultIPAddress() (string, bool) {
 	return ip, ok
 }
 
+// DefaultIPv6Address returns the IPv6 address of the processing unit if any
+func (r *PURuntime) DefaultIPv6Address() (string, bool) {
+	r.puRuntimeMutex.Lock()
+	defer r.puRuntimeMutex.Unlock()
+
+	ip, ok := r.ips.Get(DefaultIPv6Namespace)
+
+	return ip, ok && len(ip) > 0
+}
+
 // IPAddresses returns all the IP addresses for the processing unit
 func (r *PURuntime) IPAddresses() *IPMap {
 	r.puRuntimeMutex.Lock()
@@ -1,5 +1,7 @@
 package policy
 
+import "net"
+
 // This file defines types and accessor methods for these types
 
 // Operator defines the operation between your key and value.
@@ -14,6 +16,18 @@ const (
 	KeyExists = "*"
 	// KeyNotExists means that the key doesnt exist in the incoming tags
 	KeyNotExists = "!*"
+	// Matches is the operator that matches the whole value with a regular expression
+	Matches = "=~"
+	// GreaterThan compares the value with a number or a semantic version
+	GreaterThan = ">"
+	// GreaterOrEqual compares the value with a number or a semantic version
+	GreaterOrEqual = ">="
+	// LessThan compares the value with a number or a semantic version
+	LessThan = "<"
+	// LessOrEqual compares the value with a number or a semantic version
+	LessOrEqual = "<="
+	// InCIDR means that the value is an IP address or a network in one of the networks
+	InCIDR = "in"
 )
 
 // FlowAction is the action that can be applied to a flow.
@@ -33,6 +47,8 @@ const (
 const (
 	// DefaultNamespace is the default namespace for applying policy
 	DefaultNamespace = "bridge"
+	// DefaultIPv6Namespace is the namespace holding the IPv6 address of a PU
+	DefaultIPv6Namespace = "bridge-ipv6"
 )
 
 // PUAction defines the action types that applies for a specific PU as a whole.
@@ -43,6 +59,10 @@ const (
 	AllowAll = 0x1
 	// Police filters on the PU based on the PolicyRules.
 	Police = 0x2
+	// Audit evaluates the PolicyRules of the PU and reports the flows they
+	// reject, but lets them through. The ipset implementation does not
+	// support it.
+	Audit = 0x4
 )
 
 // IPRule holds IP rules to external services
@@ -51,6 +71,29 @@ type IPRule struct {
 	Port     string
 	Protocol string
 	Action   FlowAction
+	// ID is an optional identifier of the rule used when its matches are logged
+	ID string
+	// ICMPType and ICMPCode restrict an ICMP or ICMPv6 rule to a message type
+	// and a code. They are numbers and an empty value matches any type or code.
+	ICMPType string
+	ICMPCode string
+}
+
+// IsIPv6 returns true if the address of the rule is an IPv6 address or network
+func (r IPRule) IsIPv6() bool {
+	return IsIPv6Address(r.Address)
+}
+
+// IsIPv6Address returns true if the provided address or CIDR is an IPv6 one.
+// IPv4-mapped IPv6 addresses are considered as IPv4.
+func IsIPv6Address(address string) bool {
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		ip, _, _ = net.ParseCIDR(address)
+	}
+
+	return ip != nil && ip.To4() == nil
 }
 
 // IPRuleList is a list of IP rules
@@ -168,6 +211,8 @@ func (k *KeyValueOperator) Clone() *KeyValueOperator {
 type TagSelector struct {
 	Clause []KeyValueOperator
 	Action FlowAction
+	// ID is an optional identifier of the rule used when its matches are logged
+	ID string
 }
 
 // NewTagSelector return a new TagSelector
@@ -184,7 +229,9 @@ func NewTagSelector(clauses []KeyValueOperator, a FlowAction) *TagSelector {
 
 // Clone returns a copy of the TagSelector
 func (t *TagSelector) Clone() *TagSelector {
-	return NewTagSelector(t.Clause, t.Action)
+	ts := NewTagSelector(t.Clause, t.Action)
+	ts.ID = t.ID
+	return ts
 }
 
 // TagSelectorList defines a list of TagSelector
@@ -207,3 +254,68 @@ func NewTagSelectorList(tss []TagSelector) *TagSelectorList {
 func (t *TagSelectorList) Clone() *TagSelectorList {
 	return NewTagSelectorList(t.TagSelectors)
 }
+
+// PolicyExplanation explains the decision of the tag selectors of a PU for a set
+// of tags. The reject selectors are evaluated before the accept ones.
+type PolicyExplanation struct {
+	// Action is the action of the decision. It is Reject if no selector matched.
+	Action FlowAction
+	// Matched is the selector that decided the action, nil if none matched
+	Matched *SelectorExplanation
+	// DefaultNotExists is true if the decision was made by the default policy
+	// that only requires a key to not exist
+	DefaultNotExists bool
+	// Selectors explains every selector, the reject ones first
+	Selectors []SelectorExplanation
+}
+
+// String returns a summary of the decision
+func (e *PolicyExplanation) String() string {
+
+	action := "accept"
+	if e.Action&Accept == 0 {
+		action = "reject"
+	}
+
+	switch {
+	case e.Matched == nil:
+		return action + ": no selector matched"
+	case e.DefaultNotExists:
+		return action + ": selector " + e.Matched.ID + " matched as the default not-exists policy"
+	default:
+		return action + ": selector " + e.Matched.ID + " matched"
+	}
+}
+
+// SelectorExplanation explains why a tag selector matched a set of tags or not
+type SelectorExplanation struct {
+	ID      string
+	Action  FlowAction
+	Matched bool
+	Clauses []ClauseExplanation
+}
+
+// ClauseExplanation explains why a clause of a tag selector matched a set of
+// tags or not
+type ClauseExplanation struct {
+	Key      string
+	Operator Operator
+	Value    []string
+	Matched  bool
+	Reason   string
+}
+
+// SupervisorState is the state of a PU as it was programmed by a supervisor
+type SupervisorState struct {
+	ContextID        string
+	ManagementID     string
+	Version          int
+	Chains           []string
+	Mark             string
+	Port             string
+	IPs              *IPMap
+	ApplicationACLs  *IPRuleList
+	NetworkACLs      *IPRuleList
+	ExcludedNetworks []string
+	TriremeNetworks  []string
+}
@@ -0,0 +1,480 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PolicyIssue is a problem found in a part of a policy
+type PolicyIssue struct {
+	// Field is the part of the policy, for example NetworkACLs[2] or
+	// ReceiverRules[0].Clause[1]
+	Field string
+	// Message describes the problem
+	Message string
+}
+
+// String returns the field and the message of the issue
+func (i PolicyIssue) String() string {
+	return i.Field + ": " + i.Message
+}
+
+// PolicyValidation is the result of the validation of a policy. The errors
+// prevent the policy from being enforced. The warnings are rules that are
+// likely mistakes, such as rules that never decide a flow.
+type PolicyValidation struct {
+	Errors   []PolicyIssue
+	Warnings []PolicyIssue
+}
+
+// Err returns an error that lists the errors of the validation, or nil if the
+// policy is valid
+func (v *PolicyValidation) Err() error {
+
+	if len(v.Errors) == 0 {
+		return nil
+	}
+
+	issues := []string{}
+	for _, e := range v.Errors {
+		issues = append(issues, e.String())
+	}
+
+	return fmt.Errorf("Invalid policy: %s", strings.Join(issues, "; "))
+}
+
+// errorf adds an error for a field
+func (v *PolicyValidation) errorf(field string, format string, args ...interface{}) {
+	v.Errors = append(v.Errors, PolicyIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// warnf adds a warning for a field
+func (v *PolicyValidation) warnf(field string, format string, args ...interface{}) {
+	v.Warnings = append(v.Warnings, PolicyIssue{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// merge adds the issues of the validation of a part of the policy
+func (v *PolicyValidation) merge(prefix string, o *PolicyValidation) {
+
+	for _, e := range o.Errors {
+		v.Errors = append(v.Errors, PolicyIssue{Field: prefix + e.Field, Message: e.Message})
+	}
+
+	for _, w := range o.Warnings {
+		v.Warnings = append(v.Warnings, PolicyIssue{Field: prefix + w.Field, Message: w.Message})
+	}
+}
+
+// Validate checks the ACLs, the tag selectors and the networks of the policy
+func (p *PUPolicy) Validate() *PolicyValidation {
+
+	p.puPolicyMutex.Lock()
+	defer p.puPolicyMutex.Unlock()
+
+	v := &PolicyValidation{}
+
+	v.merge("ApplicationACLs", p.applicationACLs.Validate())
+	v.merge("NetworkACLs", p.networkACLs.Validate())
+	v.merge("TransmitterRules", p.transmitterRules.Validate())
+	v.merge("ReceiverRules", p.receiverRules.Validate())
+
+	for i, network := range p.triremeNetworks {
+		if !isNetwork(network) {
+			v.errorf(fmt.Sprintf("TriremeNetworks[%d]", i), "invalid network %s", network)
+		}
+	}
+
+	for i, network := range p.excludedNetworks {
+		if !isNetwork(network) {
+			v.errorf(fmt.Sprintf("ExcludedNetworks[%d]", i), "invalid network %s", network)
+		}
+	}
+
+	return v
+}
+
+// Validate checks the addresses, the protocols, the ports and the actions of
+// the rules. The rules that overlap with a conflicting action are reported as
+// warnings since the decision depends on the order of the rules.
+func (l *IPRuleList) Validate() *PolicyValidation {
+
+	v := &PolicyValidation{}
+	valid := make([]bool, len(l.Rules))
+
+	for i, rule := range l.Rules {
+		field := fmt.Sprintf("[%d]", i)
+		errors := len(v.Errors)
+
+		if !isNetwork(rule.Address) {
+			v.errorf(field, "invalid address %s", rule.Address)
+		}
+
+		if err := validateFlowAction(rule.Action); err != "" {
+			v.errorf(field, "%s", err)
+		}
+
+		switch protocol := strings.ToLower(rule.Protocol); {
+		case rule.HasPorts():
+			if ports, err := rule.Ports(); err != nil || ports.Contains(0) {
+				v.errorf(field, "invalid port %q for protocol %s", rule.Port, rule.Protocol)
+			}
+		case rule.IsICMP():
+			if rule.Port != "" {
+				v.warnf(field, "port %s is ignored for protocol %s", rule.Port, rule.Protocol)
+			}
+			if rule.ICMPType != "" && !isICMPValue(rule.ICMPType) {
+				v.errorf(field, "invalid ICMP type %q", rule.ICMPType)
+			}
+			if rule.ICMPCode != "" && (rule.ICMPType == "" || !isICMPValue(rule.ICMPCode)) {
+				v.errorf(field, "invalid ICMP code %q", rule.ICMPCode)
+			}
+			if protocol == "icmpv6" && !rule.IsIPv6() {
+				v.errorf(field, "protocol %s needs an IPv6 address", rule.Protocol)
+			}
+		case isNamedProtocol(protocol) || isProtocolNumber(protocol):
+			if rule.Port != "" {
+				v.warnf(field, "port %s is ignored for protocol %s", rule.Port, rule.Protocol)
+			}
+		default:
+			v.errorf(field, "invalid protocol %q", rule.Protocol)
+		}
+
+		if !rule.IsICMP() && (rule.ICMPType != "" || rule.ICMPCode != "") {
+			v.warnf(field, "ICMP type and code are ignored for protocol %s", rule.Protocol)
+		}
+
+		valid[i] = len(v.Errors) == errors
+	}
+
+	for i := range l.Rules {
+		for j := 0; j < i; j++ {
+			if !valid[i] || !valid[j] || !aclsOverlap(l.Rules[j], l.Rules[i]) {
+				continue
+			}
+			if l.Rules[i].Action&(Accept|Reject) != l.Rules[j].Action&(Accept|Reject) {
+				v.warnf(fmt.Sprintf("[%d]", i), "overlaps with [%d] with a conflicting action", j)
+			}
+		}
+	}
+
+	return v
+}
+
+// Validate checks the clauses and the actions of the tag selectors. The
+// selectors that never match, the duplicates and the accept selectors that are
+// shadowed by a reject selector are reported as warnings.
+func (t *TagSelectorList) Validate() *PolicyValidation {
+
+	v := &PolicyValidation{}
+	valid := make([]bool, len(t.TagSelectors))
+
+	for i, selector := range t.TagSelectors {
+		field := fmt.Sprintf("[%d]", i)
+		errors := len(v.Errors)
+
+		if err := validateFlowAction(selector.Action); err != "" {
+			v.errorf(field, "%s", err)
+		}
+
+		if len(selector.Clause) == 0 {
+			v.errorf(field, "empty selector")
+		}
+
+		for j, clause := range selector.Clause {
+			if err := validateClause(clause); err != "" {
+				v.errorf(fmt.Sprintf("%s.Clause[%d]", field, j), "%s", err)
+			}
+		}
+
+		valid[i] = len(v.Errors) == errors
+		if !valid[i] {
+			continue
+		}
+
+		if reason := contradiction(selector.Clause); reason != "" {
+			v.warnf(field, "never matches: %s", reason)
+		}
+	}
+
+	for i, selector := range t.TagSelectors {
+		if !valid[i] {
+			continue
+		}
+
+		for j, other := range t.TagSelectors {
+			if j == i || !valid[j] {
+				continue
+			}
+
+			switch {
+			case j < i && selector.Action&(Accept|Reject) == other.Action&(Accept|Reject) && reflect.DeepEqual(selector.Clause, other.Clause):
+				v.warnf(fmt.Sprintf("[%d]", i), "duplicate of [%d]", j)
+
+			case selector.Action&Accept != 0 && other.Action&Reject != 0 && covers(other.Clause, selector.Clause):
+				v.warnf(fmt.Sprintf("[%d]", i), "shadowed by the reject selector [%d]", j)
+			}
+		}
+	}
+
+	return v
+}
+
+// validateFlowAction returns why the action of a rule is invalid. It must
+// either accept or reject.
+func validateFlowAction(action FlowAction) string {
+
+	switch action & (Accept | Reject) {
+	case Accept, Reject:
+		return ""
+	case 0:
+		return "no accept or reject action"
+	default:
+		return "both accept and reject actions"
+	}
+}
+
+// validateClause returns why a clause is invalid
+func validateClause(c KeyValueOperator) string {
+
+	if c.Key == "" {
+		return "empty key"
+	}
+
+	switch c.Operator {
+	case KeyExists, KeyNotExists:
+		return ""
+	case Equal, NotEqual, GreaterThan, GreaterOrEqual, LessThan, LessOrEqual, Matches, InCIDR:
+	default:
+		return fmt.Sprintf("unknown operator %q", c.Operator)
+	}
+
+	if len(c.Value) == 0 {
+		return fmt.Sprintf("no value for %s", c.Key)
+	}
+
+	for _, value := range c.Value {
+		if value == "" {
+			return fmt.Sprintf("empty value for %s", c.Key)
+		}
+
+		switch c.Operator {
+		case Matches:
+			if _, err := regexp.Compile(value); err != nil {
+				return fmt.Sprintf("invalid regular expression %s", value)
+			}
+		case InCIDR:
+			if _, _, err := net.ParseCIDR(value); err != nil {
+				return fmt.Sprintf("invalid network %s", value)
+			}
+		}
+	}
+
+	return ""
+}
+
+// contradiction returns why the clauses of a selector can never match all
+// together, or an empty string if they can
+func contradiction(clauses []KeyValueOperator) string {
+
+	for i, a := range clauses {
+		for _, b := range clauses[i+1:] {
+			if a.Key != b.Key {
+				continue
+			}
+
+			if (a.Operator == KeyNotExists) != (b.Operator == KeyNotExists) {
+				return fmt.Sprintf("%s must exist and not exist", a.Key)
+			}
+
+			if a.Operator == Equal && b.Operator == Equal && !intersects(a.Value, b.Value) {
+				return fmt.Sprintf("%s cannot have the values of two clauses", a.Key)
+			}
+		}
+	}
+
+	return ""
+}
+
+// intersects returns true if the exact values have a common value. The values
+// with a wildcard may always match.
+func intersects(a, b []string) bool {
+
+	for _, x := range a {
+		for _, y := range b {
+			if x == y || strings.HasSuffix(x, "*") || strings.HasSuffix(y, "*") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// covers returns true if all the tags that match the clauses of a selector also
+// match the clauses of a more generic one
+func covers(generic, specific []KeyValueOperator) bool {
+
+	for _, g := range generic {
+		implied := false
+		for _, s := range specific {
+			if implies(s, g) {
+				implied = true
+				break
+			}
+		}
+		if !implied {
+			return false
+		}
+	}
+
+	return true
+}
+
+// implies returns true if the tags that match the clause a always match the
+// clause b
+func implies(a, b KeyValueOperator) bool {
+
+	if a.Key != b.Key {
+		return false
+	}
+
+	switch b.Operator {
+	case KeyExists:
+		// Every other operator requires the key
+		return a.Operator != KeyNotExists
+	case KeyNotExists:
+		return a.Operator == KeyNotExists
+	}
+
+	if a.Operator != b.Operator {
+		return false
+	}
+
+	switch b.Operator {
+	case NotEqual:
+		// The tag must differ from all the values
+		return subset(b.Value, a.Value, equalValue)
+	case Equal:
+		return subset(a.Value, b.Value, matchesValue)
+	default:
+		// The tag must match any of the values
+		return subset(a.Value, b.Value, equalValue)
+	}
+}
+
+// subset returns true if all the values of a match a value of b
+func subset(a, b []string, match func(value, pattern string) bool) bool {
+
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if match(x, y) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// equalValue returns true if the values are equal
+func equalValue(value, pattern string) bool {
+	return value == pattern
+}
+
+// matchesValue returns true if all the tags that match the value of an Equal
+// clause match the pattern. A pattern ending with a wildcard matches a prefix.
+func matchesValue(value, pattern string) bool {
+
+	if !strings.HasSuffix(pattern, "*") {
+		return value == pattern
+	}
+
+	return strings.HasPrefix(strings.TrimSuffix(value, "*"), strings.TrimSuffix(pattern, "*"))
+}
+
+// aclsOverlap returns true if some packets match both rules
+func aclsOverlap(a, b IPRule) bool {
+
+	protocolA, protocolB := strings.ToLower(a.Protocol), strings.ToLower(b.Protocol)
+	if protocolA != protocolB && protocolA != "all" && protocolB != "all" {
+		return false
+	}
+
+	networkA, networkB := toNetwork(a.Address), toNetwork(b.Address)
+	if !networkA.Contains(networkB.IP) && !networkB.Contains(networkA.IP) {
+		return false
+	}
+
+	if protocolA != protocolB {
+		return true
+	}
+
+	if a.IsICMP() {
+		return icmpOverlaps(a, b)
+	}
+
+	portsA, errA := a.Ports()
+	portsB, errB := b.Ports()
+	if errA != nil || errB != nil {
+		// The rules do not match ports
+		return true
+	}
+
+	return portsA.Overlaps(portsB)
+}
+
+// icmpOverlaps returns true if two ICMP rules match a common type and code
+func icmpOverlaps(a, b IPRule) bool {
+
+	if a.ICMPType != "" && b.ICMPType != "" && a.ICMPType != b.ICMPType {
+		return false
+	}
+
+	return a.ICMPCode == "" || b.ICMPCode == "" || a.ICMPCode == b.ICMPCode
+}
+
+// isProtocolNumber returns true if the protocol is an IP protocol number
+func isProtocolNumber(protocol string) bool {
+
+	n, err := strconv.Atoi(protocol)
+
+	return err == nil && n >= 0 && n <= 255
+}
+
+// isNetwork returns true if the value is an IP address or a network
+func isNetwork(value string) bool {
+
+	if net.ParseIP(value) != nil {
+		return true
+	}
+
+	_, _, err := net.ParseCIDR(value)
+
+	return err == nil
+}
+
+// toNetwork returns the network of a valid IP address or network
+func toNetwork(value string) *net.IPNet {
+
+	if ip := net.ParseIP(value); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+
+	_, network, _ := net.ParseCIDR(value)
+
+	return network
+}
@@ -0,0 +1,206 @@
+package policy
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidatePolicy(t *testing.T) {
+
+	accept := func(clauses ...KeyValueOperator) TagSelector {
+		return TagSelector{Clause: clauses, Action: Accept}
+	}
+	reject := func(clauses ...KeyValueOperator) TagSelector {
+		return TagSelector{Clause: clauses, Action: Reject}
+	}
+	equal := func(key string, values ...string) KeyValueOperator {
+		return KeyValueOperator{Key: key, Operator: Equal, Value: values}
+	}
+
+	tests := []struct {
+		name     string
+		acls     []IPRule
+		rules    []TagSelector
+		networks []string
+		errors   []PolicyIssue
+		warnings []PolicyIssue
+	}{
+		{
+			name: "a valid policy",
+			acls: []IPRule{
+				{Address: "192.30.253.0/24", Port: "443", Protocol: "TCP", Action: Accept},
+				{Address: "0.0.0.0/0", Protocol: "icmp", Action: Accept},
+				{Address: "10.0.0.1", Port: "1000:2000", Protocol: "udp", Action: Reject | Log},
+			},
+			rules: []TagSelector{accept(equal("app", "web")), reject(equal("app", "bad"))},
+		},
+		{
+			name: "invalid ACLs",
+			acls: []IPRule{
+				{Address: "10.0.0.0/33", Port: "80", Protocol: "tcp", Action: Accept},
+				{Address: "10.0.0.0/8", Port: "70000", Protocol: "tcp", Action: Accept},
+				{Address: "10.0.0.0/8", Port: "80", Protocol: "tcpp", Action: Accept},
+				{Address: "10.0.0.0/8", Port: "80", Protocol: "tcp"},
+			},
+			errors: []PolicyIssue{
+				{Field: "ApplicationACLs[0]", Message: "invalid address 10.0.0.0/33"},
+				{Field: "ApplicationACLs[1]", Message: `invalid port "70000" for protocol tcp`},
+				{Field: "ApplicationACLs[2]", Message: `invalid protocol "tcpp"`},
+				{Field: "ApplicationACLs[3]", Message: "no accept or reject action"},
+			},
+		},
+		{
+			name: "ACLs with conflicting actions",
+			acls: []IPRule{
+				{Address: "10.0.0.0/8", Port: "80:90", Protocol: "tcp", Action: Accept},
+				{Address: "10.1.0.0/16", Port: "85", Protocol: "TCP", Action: Reject},
+				{Address: "10.1.0.0/16", Port: "100", Protocol: "tcp", Action: Reject},
+				{Address: "11.0.0.0/8", Port: "80", Protocol: "tcp", Action: Reject},
+				{Address: "0.0.0.0/0", Protocol: "all", Action: Reject},
+			},
+			warnings: []PolicyIssue{
+				{Field: "ApplicationACLs[1]", Message: "overlaps with [0] with a conflicting action"},
+				{Field: "ApplicationACLs[4]", Message: "overlaps with [0] with a conflicting action"},
+			},
+		},
+		{
+			name: "ACLs with lists and ranges of ports",
+			acls: []IPRule{
+				{Address: "10.0.0.0/8", Port: "80,443,8000-8080", Protocol: "tcp", Action: Accept},
+				{Address: "10.1.0.0/16", Port: "22,8042", Protocol: "tcp", Action: Reject},
+				{Address: "10.0.0.0/8", Port: "80,", Protocol: "tcp", Action: Accept},
+				{Address: "10.0.0.0/8", Port: "90:85", Protocol: "udp", Action: Accept},
+				{Address: "10.0.0.0/8", Port: "0,53", Protocol: "udp", Action: Accept},
+			},
+			errors: []PolicyIssue{
+				{Field: "ApplicationACLs[2]", Message: `invalid port "80," for protocol tcp`},
+				{Field: "ApplicationACLs[3]", Message: `invalid port "90:85" for protocol udp`},
+				{Field: "ApplicationACLs[4]", Message: `invalid port "0,53" for protocol udp`},
+			},
+			warnings: []PolicyIssue{
+				{Field: "ApplicationACLs[1]", Message: "overlaps with [0] with a conflicting action"},
+			},
+		},
+		{
+			name: "ACLs for ICMP, SCTP and other protocols",
+			acls: []IPRule{
+				{Address: "0.0.0.0/0", Protocol: "icmp", ICMPType: "8", ICMPCode: "0", Action: Accept},
+				{Address: "0.0.0.0/0", Protocol: "ICMP", ICMPType: "5", Action: Reject},
+				{Address: "fd00::/64", Protocol: "icmpv6", ICMPType: "128", Action: Accept},
+				{Address: "10.0.0.0/8", Port: "3868,9900", Protocol: "sctp", Action: Accept},
+				{Address: "10.0.0.0/8", Protocol: "gre", Action: Accept},
+				{Address: "10.0.0.0/8", Protocol: "47", ICMPType: "8", Action: Accept},
+				{Address: "0.0.0.0/0", Protocol: "icmp", ICMPType: "256", Action: Accept},
+				{Address: "0.0.0.0/0", Protocol: "icmp", ICMPCode: "4", Action: Accept},
+				{Address: "10.0.0.0/8", Protocol: "icmpv6", Action: Accept},
+				{Address: "10.0.0.0/8", Protocol: "sctp", Action: Accept},
+			},
+			errors: []PolicyIssue{
+				{Field: "ApplicationACLs[6]", Message: `invalid ICMP type "256"`},
+				{Field: "ApplicationACLs[7]", Message: `invalid ICMP code "4"`},
+				{Field: "ApplicationACLs[8]", Message: "protocol icmpv6 needs an IPv6 address"},
+				{Field: "ApplicationACLs[9]", Message: `invalid port "" for protocol sctp`},
+			},
+			warnings: []PolicyIssue{
+				{Field: "ApplicationACLs[5]", Message: "ICMP type and code are ignored for protocol 47"},
+			},
+		},
+		{
+			name: "invalid selectors",
+			rules: []TagSelector{
+				accept(),
+				accept(equal("app")),
+				accept(equal("app", "")),
+				accept(KeyValueOperator{Key: "app", Operator: "~", Value: []string{"a"}}),
+				accept(KeyValueOperator{Key: "app", Operator: Matches, Value: []string{"("}}),
+				{Clause: []KeyValueOperator{equal("app", "web")}},
+			},
+			errors: []PolicyIssue{
+				{Field: "ReceiverRules[0]", Message: "empty selector"},
+				{Field: "ReceiverRules[1].Clause[0]", Message: "no value for app"},
+				{Field: "ReceiverRules[2].Clause[0]", Message: "empty value for app"},
+				{Field: "ReceiverRules[3].Clause[0]", Message: `unknown operator "~"`},
+				{Field: "ReceiverRules[4].Clause[0]", Message: "invalid regular expression ("},
+				{Field: "ReceiverRules[5]", Message: "no accept or reject action"},
+			},
+		},
+		{
+			name: "shadowed, unreachable and duplicate selectors",
+			rules: []TagSelector{
+				accept(equal("app", "web"), equal("env", "prod")),
+				reject(equal("app", "web", "db")),
+				accept(equal("app", "api"), KeyValueOperator{Key: "app", Operator: KeyNotExists}),
+				accept(equal("app", "api"), equal("app", "ui")),
+				accept(equal("app", "ui")),
+				accept(equal("app", "ui")),
+				accept(equal("app", "frontend")),
+				reject(equal("app", "front*")),
+			},
+			warnings: []PolicyIssue{
+				{Field: "ReceiverRules[2]", Message: "never matches: app must exist and not exist"},
+				{Field: "ReceiverRules[3]", Message: "never matches: app cannot have the values of two clauses"},
+				{Field: "ReceiverRules[0]", Message: "shadowed by the reject selector [1]"},
+				{Field: "ReceiverRules[5]", Message: "duplicate of [4]"},
+				{Field: "ReceiverRules[6]", Message: "shadowed by the reject selector [7]"},
+			},
+		},
+		{
+			name:     "invalid networks",
+			networks: []string{"172.17.0.0/24", "172.17.0.0/33"},
+			errors: []PolicyIssue{
+				{Field: "TriremeNetworks[1]", Message: "invalid network 172.17.0.0/33"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		Convey("Given a policy with "+test.name, t, func() {
+			networks := test.networks
+			if networks == nil {
+				networks = []string{"172.17.0.0/24"}
+			}
+			p := NewPUPolicy("", Police, NewIPRuleList(test.acls), nil, nil, NewTagSelectorList(test.rules), nil, nil, nil, networks, []string{"10.0.0.1"}, nil)
+
+			Convey("When I validate it", func() {
+				v := p.Validate()
+
+				Convey("Then I should get its errors and warnings", func() {
+					So(v.Errors, ShouldResemble, test.errors)
+					So(v.Warnings, ShouldResemble, test.warnings)
+				})
+
+				Convey("Then the error of the validation should list the errors", func() {
+					if len(test.errors) == 0 {
+						So(v.Err(), ShouldBeNil)
+						return
+					}
+					So(v.Err(), ShouldNotBeNil)
+					So(v.Err().Error(), ShouldStartWith, "Invalid policy: "+test.errors[0].String())
+				})
+			})
+		})
+	}
+}
+
+func TestPolicyIssue(t *testing.T) {
+	Convey("Given the errors of the validation of a policy", t, func() {
+		v := &PolicyValidation{}
+		v.errorf("[0]", "invalid address %s", "10.0.0.0/33")
+		v.warnf("[1]", "duplicate of [%d]", 0)
+
+		o := &PolicyValidation{}
+		o.merge("NetworkACLs", v)
+
+		Convey("Then the issues should be merged with the prefix of their field", func() {
+			So(o.Errors, ShouldResemble, []PolicyIssue{{Field: "NetworkACLs[0]", Message: "invalid address 10.0.0.0/33"}})
+			So(o.Warnings, ShouldResemble, []PolicyIssue{{Field: "NetworkACLs[1]", Message: "duplicate of [0]"}})
+		})
+
+		Convey("Then the error should list the field and the message of the errors", func() {
+			So(o.Err().Error(), ShouldEqual, "Invalid policy: NetworkACLs[0]: invalid address 10.0.0.0/33")
+		})
+	})
+}
@@ -16,6 +16,12 @@ type Supervisor interface {
 
 	// Stop stops the Supervisor.
 	Stop() error
+
+	// AddExcludedIPs excludes the IP addresses or networks from the enforcement on the whole node
+	AddExcludedIPs(ips []string) error
+
+	// RemoveExcludedIPs removes the exclusion of the IP addresses or networks
+	RemoveExcludedIPs(ips []string) error
 }
 
 // Implementor is the interface of the implementation based on iptables, ipsets, remote etc
@@ -35,4 +41,45 @@ type Implementor interface {
 
 	// Stop cleans up state
 	Stop() error
+
+	// AddExcludedIP excludes the IP addresses or networks on the whole node
+	AddExcludedIP(ip []string) error
+
+	// RemoveExcludedIP removes the exclusion of the IP addresses or networks
+	RemoveExcludedIP(ip []string) error
+}
+
+// A Reconciler is an Implementor that can detect the rules of a PU that were
+// removed or altered after they were configured
+type Reconciler interface {
+
+	// CheckRules returns the differences between the expected and the live rules of a PU
+	CheckRules(version int, contextID string, containerInfo *policy.PUInfo) ([]string, error)
+}
+
+// An ACLUpdater is an Implementor that can change the ACLs of the current
+// version of the rules of a PU without configuring a new version
+type ACLUpdater interface {
+
+	// UpdateACLs changes the ACLs of the rules of a PU from the previous to the new policy
+	UpdateACLs(version int, contextID string, previous *policy.PUInfo, containerInfo *policy.PUInfo) error
+}
+
+// An Introspector is a Supervisor that reports the state of the PUs as it
+// programmed them
+type Introspector interface {
+
+	// PUState returns the state of a supervised PU
+	PUState(contextID string) (*policy.SupervisorState, error)
+
+	// PUStates returns the state of all the supervised PUs
+	PUStates() ([]*policy.SupervisorState, error)
+}
+
+// A ChainNamer is an Implementor that can name the chains and sets that hold the
+// rules of a version of a PU
+type ChainNamer interface {
+
+	// ChainNames returns the names of the chains and sets of a version of a PU
+	ChainNames(version int, contextID string) []string
 }
@@ -2,10 +2,13 @@ package ipsetctrl
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 
 	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
 	"github.com/bvandewalle/go-ipset/ipset"
 )
 
@@ -14,39 +17,181 @@ const (
 	netChainPrefix = "TRIREME-Net-"
 	allowPrefix    = "A-"
 	rejectPrefix   = "R-"
+
+	appChainPrefixIPv6 = "TRIREME-App6-"
+	netChainPrefixIPv6 = "TRIREME-Net6-"
+
+	// maxICMPCode is the highest code of the ICMP types
+	maxICMPCode = 15
 )
 
 // createACLSets creates the sets for a given PU
 func (i *Instance) createACLSets(version string, set string, rules *policy.IPRuleList) error {
 
-	allowSet, err := i.ips.NewIpset(set+allowPrefix+version, "hash:net,port", &ipset.Params{})
+	allowSet, err := i.ips.NewIpset(set+allowPrefix+version, "hash:net,port", &ipset.Params{HashFamily: i.hashFamily()})
 	if err != nil {
 		return fmt.Errorf("Couldn't create IPSet for Trireme: %s", err.Error())
 	}
 
-	rejectSet, err := i.ips.NewIpset(set+rejectPrefix+version, "hash:net,port", &ipset.Params{})
+	rejectSet, err := i.ips.NewIpset(set+rejectPrefix+version, "hash:net,port", &ipset.Params{HashFamily: i.hashFamily()})
 	if err != nil {
 		return fmt.Errorf("Couldn't create IPSet for Trireme: %s", err.Error())
 	}
 
 	for _, rule := range rules.Rules {
-		var err error
-		switch rule.Action {
-		case policy.Accept:
-			err = allowSet.Add(rule.Address+","+rule.Port, 0)
-		case policy.Reject:
-			err = rejectSet.Add(rule.Address+","+rule.Port, 0)
+		// Rules of the other IP family go to the sets of the other instance
+		if rule.IsIPv6() != i.ipv6 {
+			continue
+		}
+
+		var target provider.Ipset
+		switch {
+		case rule.Action&policy.Accept != 0:
+			target = allowSet
+		case rule.Action&policy.Reject != 0:
+			target = rejectSet
 		default:
 			continue
 		}
+
+		entries, err := aclSetEntries(rule, true)
 		if err != nil {
-			return fmt.Errorf("Couldn't create IPSet for Trireme: %s", err.Error())
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := target.Add(entry, 0); err != nil {
+				return fmt.Errorf("Couldn't create IPSet for Trireme: %s", err.Error())
+			}
 		}
 	}
 
 	return nil
 }
 
+// aclSetEntries returns the entries of the hash:net,port sets for an ACL, one
+// per port or range of ports of the rule. The ranges cannot be tested so they
+// are replaced by their bounds if withRanges is false. The rules for all the
+// protocols have no entry and return an error.
+func aclSetEntries(rule policy.IPRule, withRanges bool) ([]string, error) {
+
+	protocol := strings.ToLower(rule.Protocol)
+
+	switch {
+	case rule.IsICMP():
+		return icmpSetEntries(rule)
+	case rule.HasPorts():
+	case protocol == "all":
+		// The entries of the sets always match a protocol
+		return nil, fmt.Errorf("Invalid acl rule for %s: rules for all the protocols are not supported with ipsets", rule.Address)
+	default:
+		// The other protocols have no port
+		return []string{rule.Address + "," + protocol + ":0"}, nil
+	}
+
+	ports, err := rule.Ports()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ports of acl rule for %s: %s", rule.Address, err)
+	}
+
+	prefix := rule.Address + ","
+	if protocol != "tcp" {
+		prefix = prefix + protocol + ":"
+	}
+
+	entries := []string{}
+	for _, r := range ports {
+		first, last := strconv.Itoa(int(r.First)), strconv.Itoa(int(r.Last))
+
+		switch {
+		case r.IsSingle():
+			entries = append(entries, prefix+first)
+		case withRanges:
+			entries = append(entries, prefix+first+"-"+last)
+		default:
+			entries = append(entries, prefix+first, prefix+last)
+		}
+	}
+
+	return entries, nil
+}
+
+// icmpSetEntries returns the entries of an ICMP ACL. The sets only match a type
+// and a code, so a rule without a code has an entry for each code.
+func icmpSetEntries(rule policy.IPRule) ([]string, error) {
+
+	if rule.ICMPType == "" {
+		return nil, fmt.Errorf("Invalid acl rule for %s: ICMP rules need a type with ipsets", rule.Address)
+	}
+
+	prefix := rule.Address + ",icmp:"
+	if rule.IsIPv6() {
+		prefix = rule.Address + ",icmpv6:"
+	}
+
+	if rule.ICMPCode != "" {
+		return []string{prefix + rule.ICMPType + "/" + rule.ICMPCode}, nil
+	}
+
+	entries := []string{}
+	for code := 0; code <= maxICMPCode; code++ {
+		entries = append(entries, prefix+rule.ICMPType+"/"+strconv.Itoa(code))
+	}
+
+	return entries, nil
+}
+
+// checkACLSets returns the entries of the ACL sets of a PU that are missing
+func (i *Instance) checkACLSets(version string, set string, rules *policy.IPRuleList) ([]string, error) {
+
+	allowSet, err := i.ips.NewIpset(set+allowPrefix+version, "hash:net,port", &ipset.Params{HashFamily: i.hashFamily()})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create IPSet for Trireme: %s", err.Error())
+	}
+
+	rejectSet, err := i.ips.NewIpset(set+rejectPrefix+version, "hash:net,port", &ipset.Params{HashFamily: i.hashFamily()})
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create IPSet for Trireme: %s", err.Error())
+	}
+
+	drift := []string{}
+
+	for _, rule := range rules.Rules {
+		if rule.IsIPv6() != i.ipv6 {
+			continue
+		}
+
+		var target provider.Ipset
+		var name string
+		switch {
+		case rule.Action&policy.Accept != 0:
+			target, name = allowSet, set+allowPrefix+version
+		case rule.Action&policy.Reject != 0:
+			target, name = rejectSet, set+rejectPrefix+version
+		default:
+			continue
+		}
+
+		entries, err := aclSetEntries(rule, false)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			ok, err := target.Test(entry)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to test entry %s of set %s: %s", entry, name, err)
+			}
+
+			if !ok {
+				drift = append(drift, fmt.Sprintf("Entry %s is missing from set %s", entry, name))
+			}
+		}
+	}
+
+	return drift, nil
+}
+
 // AddAppSetRule adds an ACL rule to the Set
 func (i *Instance) addAppSetRules(version, setPrefix, ip string) error {
 
@@ -182,10 +327,60 @@ func (i *Instance) deleteNetSetRules(version, setPrefix, ip string) error {
 	return nil
 }
 
+// checkSetRules returns the rules that match the ACL sets of a PU that are missing
+func (i *Instance) checkSetRules(version, appSetPrefix, netSetPrefix, ip string) ([]string, error) {
+
+	rules := [][]string{
+		{
+			i.appAckPacketIPTableContext, i.appPacketIPTableSection,
+			"-m", "state", "--state", "NEW",
+			"-m", "set", "--match-set", appSetPrefix + rejectPrefix + version, "dst",
+			"-s", ip,
+			"-j", "DROP",
+		},
+		{
+			i.appAckPacketIPTableContext, i.appPacketIPTableSection,
+			"-m", "state", "--state", "NEW",
+			"-m", "set", "--match-set", appSetPrefix + allowPrefix + version, "dst",
+			"-s", ip,
+			"-j", "ACCEPT",
+		},
+		{
+			i.netPacketIPTableContext, i.netPacketIPTableSection,
+			"-m", "state", "--state", "NEW",
+			"-m", "set", "--match-set", netSetPrefix + rejectPrefix + version, "src",
+			"-d", ip,
+			"-j", "DROP",
+		},
+		{
+			i.netPacketIPTableContext, i.netPacketIPTableSection,
+			"-m", "state", "--state", "NEW",
+			"-m", "set", "--match-set", netSetPrefix + allowPrefix + version, "src",
+			"-d", ip,
+			"-j", "ACCEPT",
+		},
+	}
+
+	drift := []string{}
+
+	for _, r := range rules {
+		exists, err := i.ipt.Exists(r[0], r[1], r[2:]...)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to check rule in chain %s of table %s: %s", r[1], r[0], err)
+		}
+
+		if !exists {
+			drift = append(drift, fmt.Sprintf("Rule %s is missing from chain %s of table %s", strings.Join(r[2:], " "), r[1], r[0]))
+		}
+	}
+
+	return drift, nil
+}
+
 //deleteSet deletes the ipset
 func (i *Instance) deleteSet(set string) error {
 
-	ipSet, err := i.ips.NewIpset(set, "hash:net,port", &ipset.Params{})
+	ipSet, err := i.ips.NewIpset(set, "hash:net,port", &ipset.Params{HashFamily: i.hashFamily()})
 	if err != nil {
 		return fmt.Errorf("Couldn't create IPSet for Trireme: %s", err)
 	}
@@ -196,14 +391,14 @@ func (i *Instance) deleteSet(set string) error {
 // setupIpset sets up an ipset
 func (i *Instance) setupIpset(target, container string) error {
 
-	ips, err := i.ips.NewIpset(target, "hash:net", &ipset.Params{})
+	ips, err := i.ips.NewIpset(target, "hash:net", &ipset.Params{HashFamily: i.hashFamily()})
 	if err != nil {
 		return fmt.Errorf("Couldn't create IPSet for %s: %s", target, err)
 	}
 
 	i.targetSet = ips
 
-	cSet, err := i.ips.NewIpset(container, "hash:ip", &ipset.Params{})
+	cSet, err := i.ips.NewIpset(container, "hash:ip", &ipset.Params{HashFamily: i.hashFamily()})
 	if err != nil {
 		return fmt.Errorf("Failed to create container set: %s", err)
 	}
@@ -220,7 +415,7 @@ func (i *Instance) addTargetNets(networks []string) error {
 		return fmt.Errorf("Target set not configured")
 	}
 
-	for _, net := range networks {
+	for _, net := range i.filterNetworks(networks) {
 		if err := i.targetSet.Add(net, 0); err != nil {
 			return fmt.Errorf("Error adding ip %s to target networks IPSet: %s", net, err)
 		}
@@ -228,6 +423,40 @@ func (i *Instance) addTargetNets(networks []string) error {
 	return nil
 }
 
+// checkContainerSets returns the address of the PU and the Trireme networks
+// that are missing from the global sets
+func (i *Instance) checkContainerSets(ip string, networks []string) ([]string, error) {
+
+	if i.containerSet == nil || i.targetSet == nil {
+		return nil, fmt.Errorf("Container and target sets are not configured")
+	}
+
+	target, container := i.setNames()
+	drift := []string{}
+
+	ok, err := i.containerSet.Test(ip)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to test ip %s in container set: %s", ip, err)
+	}
+
+	if !ok {
+		drift = append(drift, fmt.Sprintf("Entry %s is missing from set %s", ip, container))
+	}
+
+	for _, net := range i.filterNetworks(networks) {
+		ok, err := i.targetSet.Test(net)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to test network %s in target networks IPSet: %s", net, err)
+		}
+
+		if !ok {
+			drift = append(drift, fmt.Sprintf("Entry %s is missing from set %s", net, target))
+		}
+	}
+
+	return drift, nil
+}
+
 func (i *Instance) addContainerToSet(ip string) error {
 
 	if i.containerSet == nil {
@@ -274,6 +503,8 @@ func (i *Instance) deleteIpsetOption(ip string) error {
 // setupTrapRules
 func (i *Instance) setupTrapRules(set string) error {
 
+	_, containerSet := i.setNames()
+
 	rules := [][]string{
 		// Application Syn and Syn/Ack in RAW
 		{
@@ -303,6 +534,24 @@ func (i *Instance) setupTrapRules(set string) error {
 			"-j", "NFQUEUE", "--queue-balance", i.applicationQueues,
 		},
 
+		// Application UDP packets matching Trireme SRC and DST. New flows and first packets.
+		{
+			i.appAckPacketIPTableContext, i.appPacketIPTableSection,
+			"-m", "set", "--match-set", containerSet, "src",
+			"-m", "set", "--match-set", set, "dst",
+			"-p", "udp", "-m", "state", "--state", "NEW",
+			"-j", "NFQUEUE", "--queue-balance", i.applicationQueues,
+		},
+
+		{
+			i.appAckPacketIPTableContext, i.appPacketIPTableSection,
+			"-m", "set", "--match-set", containerSet, "src",
+			"-m", "set", "--match-set", set, "dst",
+			"-p", "udp",
+			"-m", "connbytes", "--connbytes", ":3", "--connbytes-dir", "original", "--connbytes-mode", "packets",
+			"-j", "NFQUEUE", "--queue-balance", i.applicationQueues,
+		},
+
 		// Default Drop from Trireme to Network
 		{
 			i.appAckPacketIPTableContext, i.appPacketIPTableSection,
@@ -321,6 +570,24 @@ func (i *Instance) setupTrapRules(set string) error {
 			"-j", "NFQUEUE", "--queue-balance", i.networkQueues,
 		},
 
+		// Network UDP packets matching Trireme SRC and DST. New flows and first packets.
+		{
+			i.netPacketIPTableContext, i.netPacketIPTableSection,
+			"-m", "set", "--match-set", set, "src",
+			"-m", "set", "--match-set", containerSet, "dst",
+			"-p", "udp", "-m", "state", "--state", "NEW",
+			"-j", "NFQUEUE", "--queue-balance", i.networkQueues,
+		},
+
+		{
+			i.netPacketIPTableContext, i.netPacketIPTableSection,
+			"-m", "set", "--match-set", set, "src",
+			"-m", "set", "--match-set", containerSet, "dst",
+			"-p", "udp",
+			"-m", "connbytes", "--connbytes", ":3", "--connbytes-dir", "original", "--connbytes-mode", "packets",
+			"-j", "NFQUEUE", "--queue-balance", i.networkQueues,
+		},
+
 		// Default Drop from Network to Trireme.
 		{
 			i.netPacketIPTableContext, i.netPacketIPTableSection,
@@ -342,6 +609,14 @@ func (i *Instance) setupTrapRules(set string) error {
 // cleanIPSets cleans all the ipsets
 func (i *Instance) cleanIPSets() error {
 
+	i.cleanChains()
+
+	return i.ips.DestroyAll()
+}
+
+// cleanChains cleans the chains where the ipset rules are installed
+func (i *Instance) cleanChains() {
+
 	if err := i.ipt.ClearChain(i.appPacketIPTableContext, i.appPacketIPTableSection); err != nil {
 		zap.L().Warn("Failed to cleanup app packet chain", zap.Error(err))
 	}
@@ -353,6 +628,4 @@ func (i *Instance) cleanIPSets() error {
 	if err := i.ipt.ClearChain(i.netPacketIPTableContext, i.netPacketIPTableSection); err != nil {
 		zap.L().Warn("Failed to cleanup net packet chain", zap.Error(err))
 	}
-
-	return i.ips.DestroyAll()
 }
@@ -65,6 +65,179 @@ func TestCreateACLSets(t *testing.T) {
 
 		})
 
+		Convey("When I create the ACL sets for APP1 with lists and ranges of ports", func() {
+			entries := []string{}
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				testset := provider.NewTestIpset()
+				testset.MockAdd(t, func(entry string, timeout int) error {
+					entries = append(entries, entry)
+					return nil
+				})
+				return testset, nil
+			})
+
+			rules := policy.NewIPRuleList([]policy.IPRule{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "80,8000:8080",
+					Protocol: "TCP",
+					Action:   policy.Accept,
+				},
+
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "53",
+					Protocol: "UDP",
+					Action:   policy.Reject,
+				},
+			})
+
+			err := i.createACLSets("0", "APP1-", rules)
+
+			Convey("I should get an entry per port or range", func() {
+				So(err, ShouldBeNil)
+				So(entries, ShouldResemble, []string{
+					"192.30.253.0/24,80",
+					"192.30.253.0/24,8000-8080",
+					"192.30.253.0/24,udp:53",
+				})
+			})
+		})
+
+		Convey("When I create the ACL sets for APP1 with ICMP, SCTP and GRE rules", func() {
+			entries := []string{}
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				testset := provider.NewTestIpset()
+				testset.MockAdd(t, func(entry string, timeout int) error {
+					entries = append(entries, entry)
+					return nil
+				})
+				return testset, nil
+			})
+
+			rules := policy.NewIPRuleList([]policy.IPRule{
+				policy.IPRule{
+					Address:  "0.0.0.0/0",
+					Protocol: "icmp",
+					ICMPType: "3",
+					ICMPCode: "4",
+					Action:   policy.Accept,
+				},
+
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Port:     "3868",
+					Protocol: "SCTP",
+					Action:   policy.Accept,
+				},
+
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Protocol: "gre",
+					Action:   policy.Accept,
+				},
+
+				policy.IPRule{
+					Address:  "0.0.0.0/0",
+					Protocol: "icmp",
+					ICMPType: "5",
+					Action:   policy.Reject,
+				},
+			})
+
+			err := i.createACLSets("0", "APP1-", rules)
+
+			Convey("I should get the entries of the protocols and an entry per code of the ICMP type", func() {
+				So(err, ShouldBeNil)
+				So(entries[:4], ShouldResemble, []string{
+					"0.0.0.0/0,icmp:3/4",
+					"10.0.0.0/8,sctp:3868",
+					"10.0.0.0/8,gre:0",
+					"0.0.0.0/0,icmp:5/0",
+				})
+				So(entries, ShouldHaveLength, 4+maxICMPCode)
+			})
+		})
+
+		Convey("When I create the ACL sets for APP1 with an ICMP rule without a type", func() {
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				testset := provider.NewTestIpset()
+				testset.MockAdd(t, func(entry string, timeout int) error {
+					return nil
+				})
+				return testset, nil
+			})
+
+			rules := policy.NewIPRuleList([]policy.IPRule{
+				policy.IPRule{
+					Address:  "0.0.0.0/0",
+					Protocol: "icmp",
+					Action:   policy.Accept,
+				},
+			})
+
+			err := i.createACLSets("0", "APP1-", rules)
+			Convey("I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create the ACL sets for APP1 with rules for all the protocols", func() {
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				testset := provider.NewTestIpset()
+				testset.MockAdd(t, func(entry string, timeout int) error {
+					return nil
+				})
+				return testset, nil
+			})
+
+			withPort := policy.NewIPRuleList([]policy.IPRule{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "80",
+					Protocol: "all",
+					Action:   policy.Accept,
+				},
+			})
+
+			withoutPort := policy.NewIPRuleList([]policy.IPRule{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Protocol: "ALL",
+					Action:   policy.Reject,
+				},
+			})
+
+			Convey("I should get an error with or without a port", func() {
+				So(i.createACLSets("0", "APP1-", withPort), ShouldNotBeNil)
+				So(i.createACLSets("0", "APP1-", withoutPort), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When I create the ACL sets for APP1 with invalid ports", func() {
+			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+				testset := provider.NewTestIpset()
+				testset.MockAdd(t, func(entry string, timeout int) error {
+					return nil
+				})
+				return testset, nil
+			})
+
+			rules := policy.NewIPRuleList([]policy.IPRule{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "8080:8000",
+					Protocol: "TCP",
+					Action:   policy.Accept,
+				},
+			})
+
+			err := i.createACLSets("0", "APP1-", rules)
+			Convey("I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
 		Convey("When I create the ACL sets for APP1 with version 1 and set create fails", func() {
 			ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
 				return nil, fmt.Errorf("Error")
@@ -14,6 +14,9 @@ import (
 const (
 	triremeSet   = "TriremeSet"
 	containerSet = "ContainerSet"
+
+	triremeSetIPv6   = "TriremeSet6"
+	containerSetIPv6 = "ContainerSet6"
 )
 
 // Instance  is the structure holding all information about a implementation
@@ -31,6 +34,10 @@ type Instance struct {
 	netPacketIPTableContext    string
 	netPacketIPTableSection    string
 	mode                       constants.ModeType
+	ip6t                       provider.IptablesProvider
+	targetSet6                 provider.Ipset
+	containerSet6              provider.Ipset
+	ipv6                       bool
 }
 
 // NewInstance creates a new iptables controller instance
@@ -41,13 +48,36 @@ func NewInstance(networkQueues, applicationQueues string, mark int, remote bool,
 		return nil, fmt.Errorf("Cannot initialize IPtables provider")
 	}
 
+	ip6t, err := provider.NewGoIP6TablesProvider()
+	if err != nil {
+		zap.L().Warn("Cannot initialize IP6tables provider. IPv6 traffic will not be enforced", zap.Error(err))
+	}
+
 	ips := provider.NewGoIPsetProvider()
 
+	return newInstance(networkQueues, applicationQueues, mark, remote, mode, ipt, ip6t, ips), nil
+}
+
+// NewRenderer creates an ipset controller instance that records the commands
+// instead of changing the rules and sets of the host. The commands of every
+// call are kept in the returned recorder in order. The global sets must be
+// created with Start before the rules of a PU are configured.
+func NewRenderer(networkQueues, applicationQueues string, mark int, remote bool, mode constants.ModeType) (*Instance, *provider.Recorder) {
+
+	recorder := provider.NewRecorder()
+
+	return newInstance(networkQueues, applicationQueues, mark, remote, mode, recorder.IptablesProvider("iptables"), recorder.IptablesProvider("ip6tables"), recorder.IpsetProvider()), recorder
+}
+
+// newInstance creates an instance that programs the rules and sets with the given providers
+func newInstance(networkQueues, applicationQueues string, mark int, remote bool, mode constants.ModeType, ipt, ip6t provider.IptablesProvider, ips provider.IpsetProvider) *Instance {
+
 	i := &Instance{
 		networkQueues:     networkQueues,
 		applicationQueues: applicationQueues,
 		mark:              mark,
 		ipt:               ipt,
+		ip6t:              ip6t,
 		ips:               ips,
 		appPacketIPTableContext:    "raw",
 		appAckPacketIPTableContext: "mangle",
@@ -63,7 +93,7 @@ func NewInstance(networkQueues, applicationQueues string, mark int, remote bool,
 		i.netPacketIPTableSection = "POSTROUTING"
 	}
 
-	return i, nil
+	return i
 }
 
 // DefaultIPAddress returns the default IP address for the processing unit
@@ -76,13 +106,93 @@ func (i *Instance) defaultIP(addresslist map[string]string) (string, bool) {
 	return "0.0.0.0/0", false
 }
 
+// defaultIPv6 returns the IPv6 address for the processing unit if any
+func (i *Instance) defaultIPv6(addresslist map[string]string) (string, bool) {
+
+	if ip, ok := addresslist[policy.DefaultIPv6Namespace]; ok && len(ip) > 0 {
+		return ip, true
+	}
+
+	return "::/0", false
+}
+
+// ipv6Instance returns a copy of the instance that programs ip6tables and
+// inet6 ipsets. It returns nil if ip6tables is not available.
+func (i *Instance) ipv6Instance() *Instance {
+
+	if i.ip6t == nil || i.ipv6 {
+		return nil
+	}
+
+	v6 := *i
+	v6.ipt = i.ip6t
+	v6.targetSet = i.targetSet6
+	v6.containerSet = i.containerSet6
+	v6.ipv6 = true
+
+	return &v6
+}
+
+// setNames returns the names of the global Trireme and container sets
+func (i *Instance) setNames() (target, container string) {
+
+	if i.ipv6 {
+		return triremeSetIPv6, containerSetIPv6
+	}
+
+	return triremeSet, containerSet
+}
+
+// hashFamily returns the ipset family of the instance
+func (i *Instance) hashFamily() string {
+
+	if i.ipv6 {
+		return "inet6"
+	}
+
+	return "inet"
+}
+
+// filterNetworks returns the networks that belong to the IP family programmed
+// by this instance
+func (i *Instance) filterNetworks(networks []string) []string {
+
+	filtered := []string{}
+	for _, network := range networks {
+		if policy.IsIPv6Address(network) == i.ipv6 {
+			filtered = append(filtered, network)
+		}
+	}
+
+	return filtered
+}
+
 // chainPrefix returns the chain name for the specific PU
 func (i *Instance) setPrefix(contextID string) (app, net string) {
+
+	if i.ipv6 {
+		return appChainPrefixIPv6 + contextID + "-", netChainPrefixIPv6 + contextID + "-"
+	}
+
 	app = appChainPrefix + contextID + "-"
 	net = netChainPrefix + contextID + "-"
 	return app, net
 }
 
+// ChainNames returns the names of the IPv4 ACL sets of a version of the PU
+func (i *Instance) ChainNames(version int, contextID string) []string {
+
+	appSetPrefix, netSetPrefix := i.setPrefix(contextID)
+	v := strconv.Itoa(version)
+
+	return []string{
+		appSetPrefix + allowPrefix + v,
+		appSetPrefix + rejectPrefix + v,
+		netSetPrefix + allowPrefix + v,
+		netSetPrefix + rejectPrefix + v,
+	}
+}
+
 // ConfigureRules implmenets the ConfigureRules interface
 func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
 
@@ -91,7 +201,6 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 	}
 
 	policyrules := containerInfo.Policy
-	appSetPrefix, netSetPrefix := i.setPrefix(contextID)
 
 	if policyrules == nil {
 		return fmt.Errorf("No policy rules provided -nil ")
@@ -103,6 +212,76 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 		return fmt.Errorf("No ip address found")
 	}
 
+	if err := checkAction(policyrules); err != nil {
+		return err
+	}
+
+	// The rules and sets of both IP families are removed if any of them fails
+	return i.withRollback(func(j *Instance) error {
+
+		if err := j.configureRules(version, contextID, containerInfo, ipAddress); err != nil {
+			return err
+		}
+
+		if v6 := j.ipv6Instance(); v6 != nil {
+			if ipv6Address, ok := v6.defaultIPv6(policyrules.IPAddresses().IPs); ok {
+				if err := v6.configureRules(version, contextID, containerInfo, ipv6Address); err != nil {
+					return fmt.Errorf("Failed to configure IPv6 rules: %s", err)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// checkAction returns an error for the policies in audit mode. The connections
+// that no set accepts are dropped by rules shared by all the PUs, that cannot
+// log and accept the connections of a single PU.
+func checkAction(policyrules *policy.PUPolicy) error {
+
+	if policyrules.TriremeAction == policy.Audit {
+		return fmt.Errorf("Audit mode is not supported by the ipset implementation")
+	}
+
+	return nil
+}
+
+// withRollback calls the function with a copy of the instance that records
+// every change applied to the rules and sets. All the changes are reverted if
+// the function fails.
+func (i *Instance) withRollback(apply func(*Instance) error) error {
+
+	journal := provider.NewJournal()
+
+	j := *i
+	j.ipt = journal.IptablesProvider(i.ipt)
+	j.ips = journal.IpsetProvider(i.ips)
+	j.targetSet = journal.Ipset(triremeSet, i.targetSet)
+	j.containerSet = journal.Ipset(containerSet, i.containerSet)
+
+	if i.ip6t != nil {
+		j.ip6t = journal.IptablesProvider(i.ip6t)
+		j.targetSet6 = journal.Ipset(triremeSetIPv6, i.targetSet6)
+		j.containerSet6 = journal.Ipset(containerSetIPv6, i.containerSet6)
+	}
+
+	if err := apply(&j); err != nil {
+		if rerr := journal.Rollback(); rerr != nil {
+			zap.L().Warn("Failed to revert some changes to the rules", zap.Error(rerr))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// configureRules configures the rules of a PU for the IP family of the instance
+func (i *Instance) configureRules(version int, contextID string, containerInfo *policy.PUInfo, ipAddress string) error {
+
+	policyrules := containerInfo.Policy
+	appSetPrefix, netSetPrefix := i.setPrefix(contextID)
+
 	if err := i.addAllRules(version, appSetPrefix, netSetPrefix, policyrules.ApplicationACLs(), policyrules.NetworkACLs(), ipAddress); err != nil {
 		return err
 	}
@@ -117,14 +296,28 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 // DeleteRules implements the DeleteRules interface
 func (i *Instance) DeleteRules(version int, contextID string, ipAddresses *policy.IPMap, port string, mark string) error {
 
-	appSetPrefix, netSetPrefix := i.setPrefix(contextID)
-
 	// Currently processing only containers with one IP address
 	ipAddress, ok := i.defaultIP(ipAddresses.IPs)
 	if !ok {
 		return fmt.Errorf("No ip address found")
 	}
 
+	i.deleteRules(version, contextID, ipAddress)
+
+	if v6 := i.ipv6Instance(); v6 != nil {
+		if ipv6Address, ok := v6.defaultIPv6(ipAddresses.IPs); ok {
+			v6.deleteRules(version, contextID, ipv6Address)
+		}
+	}
+
+	return nil
+}
+
+// deleteRules deletes the rules of a PU for the IP family of the instance
+func (i *Instance) deleteRules(version int, contextID string, ipAddress string) {
+
+	appSetPrefix, netSetPrefix := i.setPrefix(contextID)
+
 	var errvector [8]error
 
 	errvector[0] = i.delContainerFromSet(ipAddress)
@@ -142,14 +335,15 @@ func (i *Instance) DeleteRules(version int, contextID string, ipAddresses *polic
 			zap.L().Warn("Error while deleting rules", zap.Error(errvector[i]))
 		}
 	}
-	return nil
-
 }
 
 // UpdateRules implements the update part of the interface
 func (i *Instance) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo) error {
 	policyrules := containerInfo.Policy
-	appSetPrefix, netSetPrefix := i.setPrefix(contextID)
+
+	if err := checkAction(policyrules); err != nil {
+		return err
+	}
 
 	// Currently processing only containers with one IP address
 	ipAddress, ok := i.defaultIP(policyrules.IPAddresses().IPs)
@@ -157,30 +351,143 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return fmt.Errorf("No ip address found")
 	}
 
+	// The rules and sets of the new version are added for both IP families
+	// before the previous version is removed. The rules and sets that were
+	// added are removed if any of them fails so that the previous version stays
+	// in place.
+	if err := i.withRollback(func(j *Instance) error {
+
+		if err := j.updateRules(version, contextID, containerInfo, ipAddress); err != nil {
+			return err
+		}
+
+		if v6 := j.ipv6Instance(); v6 != nil {
+			if ipv6Address, ok := v6.defaultIPv6(policyrules.IPAddresses().IPs); ok {
+				if err := v6.updateRules(version, contextID, containerInfo, ipv6Address); err != nil {
+					return fmt.Errorf("Failed to update IPv6 rules: %s", err)
+				}
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	i.deleteVersion(version-1, contextID, ipAddress)
+
+	if v6 := i.ipv6Instance(); v6 != nil {
+		if ipv6Address, ok := v6.defaultIPv6(policyrules.IPAddresses().IPs); ok {
+			v6.deleteVersion(version-1, contextID, ipv6Address)
+		}
+	}
+
+	return nil
+}
+
+// updateRules adds the rules and sets of a new version of the PU policy for the
+// IP family of the instance
+func (i *Instance) updateRules(version int, contextID string, containerInfo *policy.PUInfo, ipAddress string) error {
+
+	policyrules := containerInfo.Policy
+	appSetPrefix, netSetPrefix := i.setPrefix(contextID)
+
 	if err := i.addAllRules(version, appSetPrefix, netSetPrefix, policyrules.ApplicationACLs(), policyrules.NetworkACLs(), ipAddress); err != nil {
 		return fmt.Errorf("Unable to add all rules: %s", err)
 	}
 
-	previousVersion := strconv.Itoa(version - 1)
+	return nil
+}
+
+// deleteVersion deletes the rules and sets of a version of the PU policy for the
+// IP family of the instance. The PU stays in the container set.
+func (i *Instance) deleteVersion(version int, contextID string, ipAddress string) {
+
+	appSetPrefix, netSetPrefix := i.setPrefix(contextID)
+	versionstring := strconv.Itoa(version)
 
 	var errvector [6]error
 
-	errvector[0] = i.deleteAppSetRules(previousVersion, appSetPrefix, ipAddress)
-	errvector[1] = i.deleteNetSetRules(previousVersion, netSetPrefix, ipAddress)
+	errvector[0] = i.deleteAppSetRules(versionstring, appSetPrefix, ipAddress)
+	errvector[1] = i.deleteNetSetRules(versionstring, netSetPrefix, ipAddress)
 
-	errvector[2] = i.deleteSet(appSetPrefix + allowPrefix + previousVersion)
-	errvector[3] = i.deleteSet(appSetPrefix + rejectPrefix + previousVersion)
-	errvector[4] = i.deleteSet(netSetPrefix + allowPrefix + previousVersion)
-	errvector[5] = i.deleteSet(netSetPrefix + rejectPrefix + previousVersion)
+	errvector[2] = i.deleteSet(appSetPrefix + allowPrefix + versionstring)
+	errvector[3] = i.deleteSet(appSetPrefix + rejectPrefix + versionstring)
+	errvector[4] = i.deleteSet(netSetPrefix + allowPrefix + versionstring)
+	errvector[5] = i.deleteSet(netSetPrefix + rejectPrefix + versionstring)
 
 	for i := 0; i < 6; i++ {
 		if errvector[i] != nil {
 			zap.L().Warn("Error while deleting rules", zap.Error(errvector[i]))
 		}
 	}
+}
 
-	return nil
+// CheckRules implements the Reconciler interface. It returns the set entries and
+// rules of the PU that are missing.
+func (i *Instance) CheckRules(version int, contextID string, containerInfo *policy.PUInfo) ([]string, error) {
 
+	if containerInfo == nil || containerInfo.Policy == nil {
+		return nil, fmt.Errorf("Container info cannot be nil")
+	}
+
+	policyrules := containerInfo.Policy
+
+	// Currently processing only containers with one IP address
+	ipAddress, ok := i.defaultIP(policyrules.IPAddresses().IPs)
+	if !ok {
+		return nil, fmt.Errorf("No ip address found")
+	}
+
+	drift, err := i.checkRules(version, contextID, containerInfo, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if v6 := i.ipv6Instance(); v6 != nil {
+		if ipv6Address, ok := v6.defaultIPv6(policyrules.IPAddresses().IPs); ok {
+			v6drift, err := v6.checkRules(version, contextID, containerInfo, ipv6Address)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to check IPv6 rules: %s", err)
+			}
+			drift = append(drift, v6drift...)
+		}
+	}
+
+	return drift, nil
+}
+
+// checkRules checks the sets and rules of a PU for the IP family of the instance
+func (i *Instance) checkRules(version int, contextID string, containerInfo *policy.PUInfo, ipAddress string) ([]string, error) {
+
+	policyrules := containerInfo.Policy
+	appSetPrefix, netSetPrefix := i.setPrefix(contextID)
+	versionstring := strconv.Itoa(version)
+
+	drift, err := i.checkContainerSets(ipAddress, policyrules.TriremeNetworks())
+	if err != nil {
+		return nil, err
+	}
+
+	appDrift, err := i.checkACLSets(versionstring, appSetPrefix, policyrules.ApplicationACLs())
+	if err != nil {
+		return nil, err
+	}
+
+	netDrift, err := i.checkACLSets(versionstring, netSetPrefix, policyrules.NetworkACLs())
+	if err != nil {
+		return nil, err
+	}
+
+	ruleDrift, err := i.checkSetRules(versionstring, appSetPrefix, netSetPrefix, ipAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	drift = append(drift, appDrift...)
+	drift = append(drift, netDrift...)
+
+	return append(drift, ruleDrift...), nil
 }
 
 func (i *Instance) addAllRules(version int, appSetPrefix, netSetPrefix string, appACLs *policy.IPRuleList, netACLs *policy.IPRuleList, ip string) error {
@@ -212,11 +519,33 @@ func (i *Instance) addAllRules(version int, appSetPrefix, netSetPrefix string, a
 // Start implements the start of the interface
 func (i *Instance) Start() error {
 
-	if err := i.setupIpset(triremeSet, containerSet); err != nil {
+	if err := i.start(); err != nil {
+		return err
+	}
+
+	// IPv6 is best effort since the host might not support it
+	if v6 := i.ipv6Instance(); v6 != nil {
+		if err := v6.start(); err != nil {
+			zap.L().Warn("Failed to setup the IPv6 sets and rules", zap.Error(err))
+			return nil
+		}
+		i.targetSet6 = v6.targetSet
+		i.containerSet6 = v6.containerSet
+	}
+
+	return nil
+}
+
+// start sets up the global sets and rules for the IP family of the instance
+func (i *Instance) start() error {
+
+	target, container := i.setNames()
+
+	if err := i.setupIpset(target, container); err != nil {
 		return err
 	}
 
-	if err := i.setupTrapRules(triremeSet); err != nil {
+	if err := i.setupTrapRules(target); err != nil {
 		return err
 	}
 	return nil
@@ -225,6 +554,10 @@ func (i *Instance) Start() error {
 // Stop implements the stop interface
 func (i *Instance) Stop() error {
 
+	if v6 := i.ipv6Instance(); v6 != nil {
+		v6.cleanChains()
+	}
+
 	return i.cleanACLs()
 }
 
@@ -241,16 +574,36 @@ func (i *Instance) cleanACLs() error {
 
 // AddExcludedIP implements the interface
 func (i *Instance) AddExcludedIP(ipList []string) error {
+
 	for _, ip := range ipList {
-		return i.addIpsetOption(ip)
+		target := i
+		if policy.IsIPv6Address(ip) {
+			if target = i.ipv6Instance(); target == nil {
+				return fmt.Errorf("IPv6 is not supported. Cannot exclude %s", ip)
+			}
+		}
+
+		if err := target.addIpsetOption(ip); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 // RemoveExcludedIP implements the interface
 func (i *Instance) RemoveExcludedIP(ipList []string) error {
+
 	for _, ip := range ipList {
-		return i.deleteIpsetOption(ip)
+		target := i
+		if policy.IsIPv6Address(ip) {
+			if target = i.ipv6Instance(); target == nil {
+				return fmt.Errorf("IPv6 is not supported. Cannot remove exclusion %s", ip)
+			}
+		}
+
+		if err := target.deleteIpsetOption(ip); err != nil {
+			return err
+		}
 	}
 	return nil
 
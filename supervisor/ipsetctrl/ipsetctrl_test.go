@@ -86,6 +86,55 @@ func TestSetPrefix(t *testing.T) {
 				So(net, ShouldResemble, "TRIREME-Net-Context-")
 			})
 		})
+
+		Convey("When I get the names of the sets of a version of the PU", func() {
+			sets := i.ChainNames(1, "Context")
+			Convey("I should get the allow and reject sets", func() {
+				So(sets, ShouldResemble, []string{
+					"TRIREME-App-Context-A-1",
+					"TRIREME-App-Context-R-1",
+					"TRIREME-Net-Context-A-1",
+					"TRIREME-Net-Context-R-1",
+				})
+			})
+		})
+	})
+}
+
+func TestIPv6Instance(t *testing.T) {
+	Convey("Given an ipset controller", t, func() {
+		i, _ := NewInstance("0:1", "2:3", 0x1000, true, constants.LocalContainer)
+
+		Convey("If there is no ip6tables provider", func() {
+			i.ip6t = nil
+			Convey("I should not get an IPv6 instance", func() {
+				So(i.ipv6Instance(), ShouldBeNil)
+			})
+		})
+
+		Convey("If there is an ip6tables provider", func() {
+			ip6tables := provider.NewTestIptablesProvider()
+			i.ip6t = ip6tables
+			v6 := i.ipv6Instance()
+
+			Convey("I should get an instance with the IPv6 names and family", func() {
+				So(v6, ShouldNotBeNil)
+				So(v6.ipt, ShouldEqual, ip6tables)
+				So(v6.hashFamily(), ShouldEqual, "inet6")
+				So(i.hashFamily(), ShouldEqual, "inet")
+
+				target, container := v6.setNames()
+				So(target, ShouldEqual, "TriremeSet6")
+				So(container, ShouldEqual, "ContainerSet6")
+
+				app, net := v6.setPrefix("Context")
+				So(app, ShouldEqual, "TRIREME-App6-Context-")
+				So(net, ShouldEqual, "TRIREME-Net6-Context-")
+
+				So(v6.filterNetworks([]string{"172.17.0.0/24", "fd00::/64"}), ShouldResemble, []string{"fd00::/64"})
+				So(i.filterNetworks([]string{"172.17.0.0/24", "fd00::/64"}), ShouldResemble, []string{"172.17.0.0/24"})
+			})
+		})
 	})
 }
 
@@ -199,6 +248,29 @@ func TestConfigureRules(t *testing.T) {
 			})
 		})
 
+		Convey("When I try to configure rules of a PU in audit mode", func() {
+			auditrules := policy.NewPUPolicy("Context",
+				policy.Audit,
+				rules,
+				rules,
+				nil,
+				nil,
+				nil,
+				nil,
+				ipl,
+				[]string{},
+				[]string{},
+				nil)
+			auditinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			auditinfo.Policy = auditrules
+			auditinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+			err := i.ConfigureRules(0, "context", auditinfo)
+			Convey("I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
 		Convey("When I try to configure rules and iptables fails", func() {
 			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
 				return fmt.Errorf("Error")
@@ -338,6 +410,25 @@ func TestUpdateRules(t *testing.T) {
 			})
 		})
 
+		Convey("When I update the rules of a PU to audit mode", func() {
+			auditrules := policy.NewPUPolicy("Context",
+				policy.Audit,
+				rules,
+				rules,
+				nil,
+				nil,
+				nil,
+				nil, ipl, []string{"172.17.0.0/24"}, []string{}, nil)
+			auditinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			auditinfo.Policy = auditrules
+			auditinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+			err := i.UpdateRules(1, "context", auditinfo)
+			Convey("It should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
 	})
 }
 
@@ -361,3 +452,97 @@ func TestAddExcludedIP(t *testing.T) {
 
 func TestRemoveExcludedIP(t *testing.T) {
 }
+
+func TestCheckRules(t *testing.T) {
+	Convey("Given an ipset controller with the sets and rules of a PU", t, func() {
+
+		i, _ := NewInstance("0:1", "2:3", 0x1000, true, constants.LocalContainer)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+		i.ip6t = nil
+		ipsets := provider.NewTestIpsetProvider()
+		i.ips = ipsets
+
+		// The entries of the sets are kept by set name
+		entries := map[string]map[string]bool{}
+		ipsets.MockNewIpset(t, func(name string, hasht string, p *ipset.Params) (provider.Ipset, error) {
+			if _, ok := entries[name]; !ok {
+				entries[name] = map[string]bool{}
+			}
+			testset := provider.NewTestIpset()
+			testset.MockAdd(t, func(entry string, timeout int) error {
+				entries[name][entry] = true
+				return nil
+			})
+			testset.MockTest(t, func(entry string) (bool, error) {
+				return entries[name][entry], nil
+			})
+			return testset, nil
+		})
+
+		So(i.Start(), ShouldBeNil)
+
+		rules := policy.NewIPRuleList([]policy.IPRule{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Action:   policy.Reject,
+			},
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "443",
+				Protocol: "TCP",
+				Action:   policy.Accept,
+			},
+		})
+
+		ipl := policy.NewIPMap(map[string]string{})
+		ipl.IPs[policy.DefaultNamespace] = "172.17.0.1"
+		policyrules := policy.NewPUPolicy("Context", policy.Police, rules, rules, nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{}, nil)
+
+		containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+		containerinfo.Policy = policyrules
+		containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+		So(i.ConfigureRules(0, "Context", containerinfo), ShouldBeNil)
+
+		Convey("When the sets and rules are intact, I should get no drift", func() {
+			drift, err := i.CheckRules(0, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldBeEmpty)
+		})
+
+		Convey("When the sets were flushed, I should get the drift", func() {
+			entries[containerSet] = map[string]bool{}
+			entries["TRIREME-App-Context-A-0"] = map[string]bool{}
+
+			drift, err := i.CheckRules(0, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldResemble, []string{
+				"Entry 172.17.0.1 is missing from set ContainerSet",
+				"Entry 192.30.253.0/24,443 is missing from set TRIREME-App-Context-A-0",
+			})
+		})
+
+		Convey("When a rule was deleted, I should get the drift", func() {
+			iptables.MockExists(t, func(table, chain string, rulespec ...string) (bool, error) {
+				return rulespec[len(rulespec)-1] != "DROP", nil
+			})
+
+			drift, err := i.CheckRules(0, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(len(drift), ShouldEqual, 2)
+			So(drift[0], ShouldContainSubstring, "TRIREME-App-Context-R-0 dst -s 172.17.0.1 -j DROP is missing from chain OUTPUT of table mangle")
+		})
+
+		Convey("When the rules cannot be checked, I should get an error", func() {
+			iptables.MockExists(t, func(table, chain string, rulespec ...string) (bool, error) {
+				return false, fmt.Errorf("error")
+			})
+
+			_, err := i.CheckRules(0, "Context", containerinfo)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
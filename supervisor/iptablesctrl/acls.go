@@ -9,6 +9,7 @@ import (
 
 	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/nflog"
 )
 
 func (i *Instance) cgroupChainRules(appChain string, netChain string, mark string, port string) [][]string {
@@ -28,21 +29,37 @@ func (i *Instance) cgroupChainRules(appChain string, netChain string, mark strin
 			"-m", "comment", "--comment", "Server-specific-chain",
 			"-j", appChain,
 		},
+	}
 
-		{
-			i.netPacketIPTableContext,
-			i.netPacketIPTableSection,
-			"-p", "tcp",
-			"-m", "multiport",
-			"--destination-ports", port,
-			"-m", "comment", "--comment", "Container-specific-chain",
-			"-j", netChain,
-		},
+	for _, ports := range processPorts(port) {
+		for _, proto := range []string{"tcp", "udp"} {
+			str = append(str, []string{
+				i.netPacketIPTableContext,
+				i.netPacketIPTableSection,
+				"-p", proto,
+				"-m", "multiport",
+				"--destination-ports", ports,
+				"-m", "comment", "--comment", "Container-specific-chain",
+				"-j", netChain,
+			})
+		}
 	}
 
 	return str
 }
 
+// processPorts returns the lists of ports of a Linux process for the multiport
+// match. Ports that cannot be parsed are passed as they are.
+func processPorts(port string) []string {
+
+	ports, err := policy.ParsePortSpec(port)
+	if err != nil {
+		return []string{port}
+	}
+
+	return multiportLists(ports)
+}
+
 // chainRules provides the list of rules that are used to send traffic to
 // a particular chain
 func (i *Instance) chainRules(appChain string, netChain string, ip string) [][]string {
@@ -79,6 +96,66 @@ func (i *Instance) chainRules(appChain string, netChain string, ip string) [][]s
 
 }
 
+// exclusionRules provides the global rules that accept the traffic from and to
+// an IP address or network that is excluded on the whole node. The cgroup rules
+// of the Linux processes are in the same chain as the application rule, so the
+// exclusion is inserted before them too.
+func (i *Instance) exclusionRules(ip string) [][]string {
+
+	rules := [][]string{}
+
+	if i.mode == constants.LocalContainer {
+		rules = append(rules, []string{
+			i.appPacketIPTableContext,
+			i.appPacketIPTableSection,
+			"-d", ip,
+			"-m", "comment", "--comment", "Excluded-IP",
+			"-j", "ACCEPT",
+		})
+	}
+
+	rules = append(rules, []string{
+		i.appAckPacketIPTableContext,
+		i.appPacketIPTableSection,
+		"-d", ip,
+		"-m", "comment", "--comment", "Excluded-IP",
+		"-j", "ACCEPT",
+	})
+
+	rules = append(rules, []string{
+		i.netPacketIPTableContext,
+		i.netPacketIPTableSection,
+		"-s", ip,
+		"-m", "comment", "--comment", "Excluded-IP",
+		"-j", "ACCEPT",
+	})
+
+	return rules
+}
+
+// deleteExclusionRules deletes the rules of an excluded IP address or network.
+// The rules that are already gone are skipped so that a removal that failed
+// halfway can be retried.
+func (i *Instance) deleteExclusionRules(ip string) error {
+
+	for _, r := range i.exclusionRules(ip) {
+		exists, err := i.ipt.Exists(r[0], r[1], r[2:]...)
+		if err != nil {
+			return fmt.Errorf("Unable to check rule in chain %s of table %s: %s", r[1], r[0], err)
+		}
+
+		if !exists {
+			continue
+		}
+
+		if err := i.ipt.Delete(r[0], r[1], r[2:]...); err != nil {
+			return fmt.Errorf("Unable to delete rule from chain %s of table %s: %s", r[1], r[0], err)
+		}
+	}
+
+	return nil
+}
+
 //trapRules provides the packet trap rules to add/delete
 func (i *Instance) trapRules(appChain string, netChain string, network string, appQueue string, netQueue string) [][]string {
 
@@ -143,10 +220,62 @@ func (i *Instance) trapRules(appChain string, netChain string, network string, a
 		})
 	}
 
+	// Capture UDP packets until the flow is established and the first
+	// packets of established flows that may still carry tokens
+	rules = append(rules, []string{
+		i.appAckPacketIPTableContext, appChain,
+		"-d", network,
+		"-p", "udp", "-m", "state", "--state", "NEW",
+		"-j", "NFQUEUE", "--queue-balance", appQueue,
+	})
+
+	rules = append(rules, []string{
+		i.appAckPacketIPTableContext, appChain,
+		"-d", network,
+		"-p", "udp",
+		"-m", "connbytes", "--connbytes", ":3", "--connbytes-dir", "original", "--connbytes-mode", "packets",
+		"-j", "NFQUEUE", "--queue-balance", appQueue,
+	})
+
+	rules = append(rules, []string{
+		i.netPacketIPTableContext, netChain,
+		"-s", network,
+		"-p", "udp", "-m", "state", "--state", "NEW",
+		"-j", "NFQUEUE", "--queue-balance", netQueue,
+	})
+
+	rules = append(rules, []string{
+		i.netPacketIPTableContext, netChain,
+		"-s", network,
+		"-p", "udp",
+		"-m", "connbytes", "--connbytes", ":3", "--connbytes-dir", "original", "--connbytes-mode", "packets",
+		"-j", "NFQUEUE", "--queue-balance", netQueue,
+	})
+
 	return rules
 
 }
 
+// encryptionTrapRules provides the rules that capture all TCP packets. The payload
+// of encrypted connections is processed by the datapath for the whole connection
+func (i *Instance) encryptionTrapRules(appChain string, netChain string, network string, appQueue string, netQueue string) [][]string {
+
+	return [][]string{
+		{
+			i.appAckPacketIPTableContext, appChain,
+			"-d", network,
+			"-p", "tcp",
+			"-j", "NFQUEUE", "--queue-balance", appQueue,
+		},
+		{
+			i.netPacketIPTableContext, netChain,
+			"-s", network,
+			"-p", "tcp",
+			"-j", "NFQUEUE", "--queue-balance", netQueue,
+		},
+	}
+}
+
 // addContainerChain adds a chain for the specific container and redirects traffic there
 // This simplifies significantly the management and makes the iptable rules more readable
 // All rules related to a container are contained within the dedicated chain
@@ -205,7 +334,7 @@ func (i *Instance) addChainRules(appChain string, netChain string, ip string, po
 // addPacketTrap adds the necessary iptables rules to capture control packets to user space
 func (i *Instance) addPacketTrap(appChain string, netChain string, ip string, networks []string) error {
 
-	for _, network := range networks {
+	for _, network := range i.filterNetworks(networks) {
 
 		err := i.processRulesFromList(i.trapRules(appChain, netChain, network, i.applicationQueues, i.networkQueues), "Append")
 		if err != nil {
@@ -216,58 +345,40 @@ func (i *Instance) addPacketTrap(appChain string, netChain string, ip string, ne
 	return nil
 }
 
+// addEncryptionTrap adds the iptables rules that send all the TCP packets of
+// a PU with encryption rules to user space
+func (i *Instance) addEncryptionTrap(appChain string, netChain string, networks []string) error {
+
+	for _, network := range i.filterNetworks(networks) {
+
+		err := i.processRulesFromList(i.encryptionTrapRules(appChain, netChain, network, i.applicationQueues, i.networkQueues), "Append")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // addAppACLs adds a set of rules to the external services that are initiated
-// by an application. The allow rules are inserted with highest priority.
-func (i *Instance) addAppACLs(chain string, ip string, rules *policy.IPRuleList) error {
-
-	for _, rule := range rules.Rules {
-		if rule.Protocol == "UDP" || rule.Protocol == "TCP" {
-			switch rule.Action {
-			case policy.Accept:
-				if err := i.ipt.Append(
-					i.appAckPacketIPTableContext, chain,
-					"-p", rule.Protocol, "-m", "state", "--state", "NEW",
-					"-d", rule.Address,
-					"--dport", rule.Port,
-					"-j", "ACCEPT",
-				); err != nil {
-					return fmt.Errorf("Failed to add acl rule for table %s, chain %s, with  %s", i.appAckPacketIPTableContext, chain, err.Error())
-				}
-			case policy.Reject:
-				if err := i.ipt.Insert(
-					i.appAckPacketIPTableContext, chain, 1,
-					"-p", rule.Protocol, "-m", "state", "--state", "NEW",
-					"-d", rule.Address,
-					"--dport", rule.Port,
-					"-j", "DROP",
-				); err != nil {
-					return fmt.Errorf("Failed to add acl rule for table %s, chain %s, with  %s", i.appAckPacketIPTableContext, chain, err.Error())
-				}
-			default:
-				continue
-			}
-		} else {
-			switch rule.Action {
-			case policy.Accept:
-				if err := i.ipt.Append(
-					i.appAckPacketIPTableContext, chain,
-					"-p", rule.Protocol,
-					"-d", rule.Address,
-					"-j", "ACCEPT",
-				); err != nil {
-					return fmt.Errorf("Failed to add acl rule for table %s, chain %s, with  %s", i.appAckPacketIPTableContext, chain, err.Error())
-				}
-			case policy.Reject:
-				if err := i.ipt.Insert(
-					i.appAckPacketIPTableContext, chain, 1,
-					"-p", rule.Protocol,
-					"-d", rule.Address,
-					"-j", "DROP",
-				); err != nil {
-					return fmt.Errorf("Failed to add acl rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, chain, err.Error())
-				}
-			default:
-				continue
+// by an application. The allow rules are inserted with highest priority. The
+// packets that the rules of a PU in audit mode reject are logged and accepted.
+func (i *Instance) addAppACLs(contextID, chain, ip string, rules *policy.IPRuleList, audit bool) error {
+
+	for idx, rule := range rules.Rules {
+		// Rules of the other IP family are programmed by the other instance
+		if rule.IsIPv6() != i.ipv6 {
+			continue
+		}
+
+		matches, err := i.appACLMatches(rule)
+		if err != nil {
+			return err
+		}
+
+		for _, match := range matches {
+			if err := i.addACLRule(i.appAckPacketIPTableContext, chain, contextID, aclRuleID(idx, rule), rule.Action, match, audit); err != nil {
+				return err
 			}
 		}
 	}
@@ -275,7 +386,7 @@ func (i *Instance) addAppACLs(chain string, ip string, rules *policy.IPRuleList)
 	// Accept established connections
 	if err := i.ipt.Append(
 		i.appAckPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.anyNetwork(),
 		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT"); err != nil {
 
@@ -284,7 +395,7 @@ func (i *Instance) addAppACLs(chain string, ip string, rules *policy.IPRuleList)
 
 	if err := i.ipt.Append(
 		i.appAckPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
+		"-d", i.anyNetwork(),
 		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT"); err != nil {
 
@@ -292,12 +403,10 @@ func (i *Instance) addAppACLs(chain string, ip string, rules *policy.IPRuleList)
 	}
 
 	// Drop everything else
-	if err := i.ipt.Append(
-		i.appAckPacketIPTableContext, chain,
-		"-d", "0.0.0.0/0",
-		"-j", "DROP"); err != nil {
-
-		return fmt.Errorf("Failed to add default drop acl rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, chain, err.Error())
+	for _, rule := range i.dropRules(contextID, audit, "-d", i.anyNetwork()) {
+		if err := i.ipt.Append(i.appAckPacketIPTableContext, chain, rule...); err != nil {
+			return fmt.Errorf("Failed to add default drop acl rule for table %s, chain %s, with error: %s", i.appAckPacketIPTableContext, chain, err.Error())
+		}
 	}
 
 	return nil
@@ -305,61 +414,24 @@ func (i *Instance) addAppACLs(chain string, ip string, rules *policy.IPRuleList)
 
 // addNetACLs adds iptables rules that manage traffic from external services. The
 // explicit rules are added with the highest priority since they are direct allows.
-func (i *Instance) addNetACLs(chain, ip string, rules *policy.IPRuleList) error {
-
-	for _, rule := range rules.Rules {
-
-		if rule.Protocol == "UDP" || rule.Protocol == "TCP" {
-			switch rule.Action {
-			case policy.Accept:
-				if err := i.ipt.Append(
-					i.netPacketIPTableContext, chain,
-					"-p", rule.Protocol,
-					"-s", rule.Address,
-					"--dport", rule.Port,
-					"-j", "ACCEPT",
-				); err != nil {
-
-					return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
-				}
-			case policy.Reject:
-				if err := i.ipt.Insert(
-					i.netPacketIPTableContext, chain, 1,
-					"-p", rule.Protocol,
-					"-s", rule.Address,
-					"--dport", rule.Port,
-					"-j", "DROP",
-				); err != nil {
-
-					return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
-				}
-			default:
-				continue
-			}
-		} else {
-			switch rule.Action {
-			case policy.Accept:
-				if err := i.ipt.Append(
-					i.netPacketIPTableContext, chain,
-					"-p", rule.Protocol,
-					"-s", rule.Address,
-					"-j", "ACCEPT",
-				); err != nil {
-
-					return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
-				}
-			case policy.Reject:
-				if err := i.ipt.Insert(
-					i.netPacketIPTableContext, chain, 1,
-					"-p", rule.Protocol,
-					"-s", rule.Address,
-					"-j", "DROP",
-				); err != nil {
-
-					return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
-				}
-			default:
-				continue
+// The packets that the rules of a PU in audit mode reject are logged and accepted.
+func (i *Instance) addNetACLs(contextID, chain, ip string, rules *policy.IPRuleList, audit bool) error {
+
+	for idx, rule := range rules.Rules {
+
+		// Rules of the other IP family are programmed by the other instance
+		if rule.IsIPv6() != i.ipv6 {
+			continue
+		}
+
+		matches, err := i.netACLMatches(rule)
+		if err != nil {
+			return err
+		}
+
+		for _, match := range matches {
+			if err := i.addACLRule(i.netPacketIPTableContext, chain, contextID, aclRuleID(idx, rule), rule.Action, match, audit); err != nil {
+				return err
 			}
 		}
 	}
@@ -367,7 +439,7 @@ func (i *Instance) addNetACLs(chain, ip string, rules *policy.IPRuleList) error
 	// Accept established connections
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.anyNetwork(),
 		"-p", "tcp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT",
 	); err != nil {
@@ -377,7 +449,7 @@ func (i *Instance) addNetACLs(chain, ip string, rules *policy.IPRuleList) error
 
 	if err := i.ipt.Append(
 		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
+		"-s", i.anyNetwork(),
 		"-p", "udp", "-m", "state", "--state", "ESTABLISHED",
 		"-j", "ACCEPT",
 	); err != nil {
@@ -386,18 +458,336 @@ func (i *Instance) addNetACLs(chain, ip string, rules *policy.IPRuleList) error
 	}
 
 	// Drop everything else
-	if err := i.ipt.Append(
-		i.netPacketIPTableContext, chain,
-		"-s", "0.0.0.0/0",
-		"-j", "DROP",
-	); err != nil {
+	for _, rule := range i.dropRules(contextID, audit, "-s", i.anyNetwork()) {
+		if err := i.ipt.Append(i.netPacketIPTableContext, chain, rule...); err != nil {
+			return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
+		}
+	}
 
-		return fmt.Errorf("Failed to add net acl rule for table %s, chain %s, with error: %s", i.netPacketIPTableContext, chain, err.Error())
+	return nil
+}
+
+// appACLMatches returns the matches of the rules of an application ACL. A
+// rule with a long list of ports needs several matches.
+func (i *Instance) appACLMatches(rule policy.IPRule) ([][]string, error) {
+
+	if rule.HasPorts() {
+		ports, err := portMatches(rule.Port)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ports of acl rule for %s: %s", rule.Address, err)
+		}
+
+		matches := make([][]string, len(ports))
+		for k, port := range ports {
+			matches[k] = append([]string{
+				"-p", i.protocol(rule.Protocol), "-m", "state", "--state", "NEW",
+				"-d", rule.Address,
+			}, port...)
+		}
+
+		return matches, nil
+	}
+
+	return [][]string{append([]string{
+		"-p", i.protocol(rule.Protocol),
+		"-d", rule.Address,
+	}, i.icmpMatch(rule)...)}, nil
+}
+
+// netACLMatches returns the matches of the rules of a network ACL. A rule with
+// a long list of ports needs several matches.
+func (i *Instance) netACLMatches(rule policy.IPRule) ([][]string, error) {
+
+	if rule.HasPorts() {
+		ports, err := portMatches(rule.Port)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ports of acl rule for %s: %s", rule.Address, err)
+		}
+
+		matches := make([][]string, len(ports))
+		for k, port := range ports {
+			matches[k] = append([]string{
+				"-p", i.protocol(rule.Protocol),
+				"-s", rule.Address,
+			}, port...)
+		}
+
+		return matches, nil
+	}
+
+	return [][]string{append([]string{
+		"-p", i.protocol(rule.Protocol),
+		"-s", rule.Address,
+	}, i.icmpMatch(rule)...)}, nil
+}
+
+// icmpMatch returns the match of the type and the code of an ICMP rule. It is
+// empty if the rule matches all the ICMP messages.
+func (i *Instance) icmpMatch(rule policy.IPRule) []string {
+
+	typeCode := rule.ICMPTypeCode()
+	if typeCode == "" {
+		return nil
+	}
+
+	if strings.ToLower(i.protocol(rule.Protocol)) == "icmpv6" {
+		return []string{"--icmpv6-type", typeCode}
+	}
+
+	return []string{"--icmp-type", typeCode}
+}
+
+// portMatches returns the matches of the destination ports of an ACL. A single
+// port or range is matched with --dport and a list with the multiport match.
+func portMatches(port string) ([][]string, error) {
+
+	ports, err := policy.ParsePortSpec(port)
+	if err != nil {
+		return nil, err
+	}
+
+	if ports.IsSingle() {
+		return [][]string{{"--dport", ports.String()}}, nil
+	}
+
+	matches := [][]string{}
+	for _, list := range multiportLists(ports) {
+		matches = append(matches, []string{"-m", "multiport", "--dports", list})
+	}
+
+	return matches, nil
+}
+
+// multiportLists splits the ports in the lists accepted by the multiport match,
+// that has at most 15 ports. A range counts as two ports.
+func multiportLists(ports policy.PortSpec) []string {
+
+	lists := []string{}
+	list := policy.PortSpec{}
+	count := 0
+
+	for _, r := range ports {
+		n := 1
+		if !r.IsSingle() {
+			n = 2
+		}
+
+		if count+n > maxMultiportPorts {
+			lists = append(lists, list.String())
+			list, count = policy.PortSpec{}, 0
+		}
+
+		list = append(list, r)
+		count += n
+	}
+
+	return append(lists, list.String())
+}
+
+// dropRules returns the rules that drop the packets that no ACL accepts. The
+// packets of a PU in audit mode are logged and accepted instead.
+func (i *Instance) dropRules(contextID string, audit bool, match ...string) [][]string {
+
+	if !audit {
+		return [][]string{append(match, "-j", "DROP")}
+	}
+
+	return [][]string{
+		auditRule(contextID, nflog.DefaultRuleID, match),
+		append(append([]string{}, match...), "-j", "ACCEPT"),
+	}
+}
+
+// auditRule returns the rule that logs the connections that a rule of a PU in
+// audit mode rejects
+func auditRule(contextID, ruleID string, match []string) []string {
+
+	return append(newConnectionMatch(match),
+		"-j", "NFLOG",
+		"--nflog-group", nflog.GroupString(),
+		"--nflog-prefix", nflog.AuditPrefix(contextID, ruleID),
+	)
+}
+
+// newConnectionMatch returns a copy of the match restricted to the first packet
+// of the connections, so that a connection is logged once
+func newConnectionMatch(match []string) []string {
+
+	for _, arg := range match {
+		if arg == "--state" {
+			return append([]string{}, match...)
+		}
+	}
+
+	return append(append([]string{}, match...), "-m", "state", "--state", "NEW")
+}
+
+// addACLRule adds an ACL rule with the given match to the chain. Accept rules are
+// appended and reject rules are inserted at the top of the chain.
+func (i *Instance) addACLRule(table, chain, contextID, ruleID string, action policy.FlowAction, match []string, audit bool) error {
+
+	rules, insert := aclRules(contextID, ruleID, action, match, audit)
+
+	if insert {
+		return i.insertACLRules(table, chain, 1, rules)
+	}
+
+	for _, rule := range rules {
+		if err := i.ipt.Append(table, chain, rule...); err != nil {
+			return fmt.Errorf("Failed to add acl rule for table %s, chain %s, with error: %s", table, chain, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// insertACLRules inserts the rules of an ACL at the given position of the chain
+func (i *Instance) insertACLRules(table, chain string, position int, rules [][]string) error {
+
+	// Insert in reverse order so that the log rule ends up first
+	for j := len(rules) - 1; j >= 0; j-- {
+		if err := i.ipt.Insert(table, chain, position, rules[j]...); err != nil {
+			return fmt.Errorf("Failed to add acl rule for table %s, chain %s, with error: %s", table, chain, err.Error())
+		}
 	}
 
 	return nil
 }
 
+// aclRules returns the rules of an ACL with the given match and whether they
+// must be inserted before the other rules of the chain, which is the case of
+// reject rules. Rules with the Log action are preceded by an NFLOG rule that
+// identifies the PU and the rule and logs the new connections. The reject rules
+// of a PU in audit mode only log the connections, whose packets go on through
+// the rest of the chain.
+func aclRules(contextID, ruleID string, action policy.FlowAction, match []string, audit bool) ([][]string, bool) {
+
+	var target []string
+	var insert bool
+
+	switch {
+	case action&policy.Accept != 0:
+		target = []string{"-j", "ACCEPT"}
+	case action&policy.Reject != 0:
+		target = []string{"-j", "DROP"}
+		insert = true
+	default:
+		return nil, false
+	}
+
+	rules := [][]string{append(append([]string{}, match...), target...)}
+	if action&policy.Log != 0 {
+		logRule := append(newConnectionMatch(match),
+			"-j", "NFLOG",
+			"--nflog-group", nflog.GroupString(),
+			"--nflog-prefix", nflog.Prefix(contextID, ruleID, action),
+		)
+		rules = [][]string{logRule, rules[0]}
+	}
+
+	if audit && insert {
+		rules = [][]string{auditRule(contextID, ruleID, match)}
+	}
+
+	return rules, insert
+}
+
+// updateACLs changes the ACL rules of the chain from the removed to the added
+// ACLs. The reject rules are inserted after the exclusions and the accept rules
+// before the rules that accept the established connections and drop the rest.
+// The rules are deleted last since a rule that is deleted is appended to the
+// end of the chain if the changes are reverted.
+func (i *Instance) updateACLs(table, chain, contextID string, added, removed []policy.IPRule, matches func(policy.IPRule) ([][]string, error), exclusions int, audit bool) error {
+
+	live, err := i.ipt.List(table, chain)
+	if err != nil {
+		return fmt.Errorf("Unable to list chain %s of table %s: %s", chain, table, err)
+	}
+
+	count := 0
+	for _, rule := range live {
+		if strings.HasPrefix(rule, "-A ") {
+			count++
+		}
+	}
+
+	rejectPosition := exclusions + 1
+	acceptPosition := count - 2 - len(i.dropRules(contextID, audit)) + 1
+	if acceptPosition < rejectPosition {
+		return fmt.Errorf("Chain %s of table %s has only %d rules", chain, table, count)
+	}
+
+	for _, rule := range added {
+		ruleMatches, err := matches(rule)
+		if err != nil {
+			return err
+		}
+
+		for _, match := range ruleMatches {
+			rules, insert := aclRules(contextID, rule.ID, rule.Action, match, audit)
+
+			position := acceptPosition
+			if insert {
+				position = rejectPosition
+			}
+
+			if err := i.insertACLRules(table, chain, position, rules); err != nil {
+				return err
+			}
+
+			// The accept rules are after the reject ones
+			acceptPosition += len(rules)
+		}
+	}
+
+	for _, rule := range removed {
+		ruleMatches, err := matches(rule)
+		if err != nil {
+			return err
+		}
+
+		for _, match := range ruleMatches {
+			rules, _ := aclRules(contextID, rule.ID, rule.Action, match, audit)
+
+			for _, r := range rules {
+				if err := i.ipt.Delete(table, chain, r...); err != nil {
+					return fmt.Errorf("Failed to delete acl rule for table %s, chain %s, with error: %s", table, chain, err.Error())
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// identifiedACLs returns the ACLs of the IP family of the instance with their
+// identifier used in the NFLOG prefix
+func (i *Instance) identifiedACLs(rules *policy.IPRuleList) []policy.IPRule {
+
+	identified := []policy.IPRule{}
+
+	for idx, rule := range rules.Rules {
+		if rule.IsIPv6() != i.ipv6 {
+			continue
+		}
+
+		rule.ID = aclRuleID(idx, rule)
+		identified = append(identified, rule)
+	}
+
+	return identified
+}
+
+// aclRuleID returns the identifier of an ACL rule used in the NFLOG prefix
+func aclRuleID(index int, rule policy.IPRule) string {
+
+	if rule.ID != "" {
+		return rule.ID
+	}
+
+	return strconv.Itoa(index)
+}
+
 // deleteChainRules deletes the rules that send traffic to our chain
 func (i *Instance) deleteChainRules(appChain, netChain, ip string, port string, mark string) error {
 
@@ -635,7 +1025,7 @@ func (i *Instance) cleanACLSection(context, netSection, appSection, chainPrefix
 // addExclusionACLs adds the set of IP addresses that must be excluded
 func (i *Instance) addExclusionACLs(appchain, netchain string, ip string, exclusions []string) error {
 
-	for _, e := range exclusions {
+	for _, e := range i.filterNetworks(exclusions) {
 		if err := i.ipt.Insert(
 			i.appAckPacketIPTableContext, appchain, 1,
 			"-s", ip,
@@ -283,6 +283,43 @@ func TestAddPacketTrap(t *testing.T) {
 	})
 }
 
+func TestAddEncryptionTrap(t *testing.T) {
+
+	Convey("Given an iptables controller, when I test addEncryptionTrap", t, func() {
+		i, _ := NewInstance("0:1", "2:3", 0x1000, constants.LocalContainer)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+
+		Convey("When I add the encryption trap rules and they succeed", func() {
+			rules := [][]string{}
+			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+				rules = append(rules, append([]string{table, chain}, rulespec...))
+				return nil
+			})
+			err := i.addEncryptionTrap("appchain", "netchain", []string{"172.17.0.0/24"})
+			Convey("I should get no error and all TCP packets should be captured", func() {
+				So(err, ShouldBeNil)
+				So(len(rules), ShouldEqual, 2)
+				So(rules[0], ShouldResemble, []string{i.appAckPacketIPTableContext, "appchain", "-d", "172.17.0.0/24", "-p", "tcp", "-j", "NFQUEUE", "--queue-balance", "2:3"})
+				So(rules[1], ShouldResemble, []string{i.netPacketIPTableContext, "netchain", "-s", "172.17.0.0/24", "-p", "tcp", "-j", "NFQUEUE", "--queue-balance", "0:1"})
+			})
+		})
+
+		Convey("When I add the encryption trap rules and the netPacketIPtableContext fails ", func() {
+			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+				if chain == "netchain" {
+					return fmt.Errorf("Error")
+				}
+				return nil
+			})
+			err := i.addEncryptionTrap("appchain", "netchain", []string{"172.17.0.0/24"})
+			Convey("I should get  error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
 func TestAddAppACLs(t *testing.T) {
 
 	Convey("Given an iptables controller ", t, func() {
@@ -303,7 +340,7 @@ func TestAddAppACLs(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.addAppACLs("chain", "", &policy.IPRuleList{})
+			err := i.addAppACLs("context", "chain", "", &policy.IPRuleList{}, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -317,7 +354,7 @@ func TestAddAppACLs(t *testing.T) {
 				return nil
 			})
 
-			err := i.addAppACLs("chain", "", &policy.IPRuleList{})
+			err := i.addAppACLs("context", "chain", "", &policy.IPRuleList{}, false)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -353,7 +390,7 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addAppACLs("chain", "", rules)
+			err := i.addAppACLs("context", "chain", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -386,7 +423,7 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addAppACLs("chain", "", rules)
+			err := i.addAppACLs("context", "chain", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -419,12 +456,98 @@ func TestAddAppACLs(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addAppACLs("chain", "", rules)
+			err := i.addAppACLs("context", "chain", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
 		})
 
+		Convey("When I add app ACLs with the log action", func() {
+
+			rules := policy.NewIPRuleList([]policy.IPRule{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "80",
+					Protocol: "TCP",
+					Action:   policy.Reject | policy.Log,
+				},
+
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "443",
+					Protocol: "TCP",
+					Action:   policy.Accept | policy.Log,
+					ID:       "web",
+				},
+			})
+
+			appended := [][]string{}
+			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+				appended = append(appended, rulespec)
+				return nil
+			})
+
+			inserted := [][]string{}
+			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				inserted = append([][]string{rulespec}, inserted...)
+				return nil
+			})
+
+			err := i.addAppACLs("context", "chain", "", rules, false)
+			Convey("I should get no error and the log rules before the rules they log", func() {
+				So(err, ShouldBeNil)
+				So(len(inserted), ShouldEqual, 2)
+				So(matchSpec("NFLOG", inserted[0]), ShouldBeNil)
+				So(matchSpec("context:0:R", inserted[0]), ShouldBeNil)
+				So(matchSpec("DROP", inserted[1]), ShouldBeNil)
+				So(len(appended), ShouldBeGreaterThan, 2)
+				So(matchSpec("NFLOG", appended[0]), ShouldBeNil)
+				So(matchSpec("context:web:A", appended[0]), ShouldBeNil)
+				So(matchSpec("ACCEPT", appended[1]), ShouldBeNil)
+			})
+		})
+
+		Convey("When I add app ACLs of a PU in audit mode", func() {
+
+			rules := policy.NewIPRuleList([]policy.IPRule{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "80",
+					Protocol: "TCP",
+					Action:   policy.Reject | policy.Log,
+				},
+			})
+
+			appended := [][]string{}
+			iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+				appended = append(appended, rulespec)
+				return nil
+			})
+
+			inserted := [][]string{}
+			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				inserted = append([][]string{rulespec}, inserted...)
+				return nil
+			})
+
+			err := i.addAppACLs("context", "chain", "", rules, true)
+			Convey("I should get no error and the rejected packets should be logged and accepted", func() {
+				So(err, ShouldBeNil)
+				So(len(inserted), ShouldEqual, 1)
+				So(matchSpec("NFLOG", inserted[0]), ShouldBeNil)
+				So(matchSpec("context:0:U", inserted[0]), ShouldBeNil)
+
+				last := len(appended) - 1
+				So(matchSpec("NFLOG", appended[last-1]), ShouldBeNil)
+				So(matchSpec("context:default:U", appended[last-1]), ShouldBeNil)
+				So(matchSpec("ACCEPT", appended[last]), ShouldBeNil)
+
+				for _, rule := range append(inserted, appended...) {
+					So(matchSpec("DROP", rule), ShouldNotBeNil)
+				}
+			})
+		})
+
 	})
 }
 
@@ -448,7 +571,7 @@ func TestAddNetAcls(t *testing.T) {
 				return fmt.Errorf("Error")
 			})
 
-			err := i.addNetACLs("chain", "", &policy.IPRuleList{})
+			err := i.addNetACLs("context", "chain", "", &policy.IPRuleList{}, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -462,7 +585,7 @@ func TestAddNetAcls(t *testing.T) {
 				return nil
 			})
 
-			err := i.addNetACLs("chain", "", &policy.IPRuleList{})
+			err := i.addNetACLs("context", "chain", "", &policy.IPRuleList{}, false)
 			Convey("I should get  error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -498,7 +621,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addNetACLs("chain", "", rules)
+			err := i.addNetACLs("context", "chain", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -531,7 +654,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addNetACLs("chain", "", rules)
+			err := i.addNetACLs("context", "chain", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldNotBeNil)
 			})
@@ -564,7 +687,7 @@ func TestAddNetAcls(t *testing.T) {
 				}
 				return fmt.Errorf("error %s ", rulespec)
 			})
-			err := i.addNetACLs("chain", "", rules)
+			err := i.addNetACLs("context", "chain", "", rules, false)
 			Convey("I should get no error", func() {
 				So(err, ShouldBeNil)
 			})
@@ -830,3 +953,108 @@ func TestClearCaptureSynAckPackets(t *testing.T) {
 		})
 	})
 }
+
+func TestPortMatches(t *testing.T) {
+
+	Convey("Given a list of ports", t, func() {
+
+		Convey("When I match a single port", func() {
+			matches, err := portMatches("80")
+			Convey("I should get a dport match", func() {
+				So(err, ShouldBeNil)
+				So(matches, ShouldResemble, [][]string{{"--dport", "80"}})
+			})
+		})
+
+		Convey("When I match a range of ports", func() {
+			matches, err := portMatches("8000-8080")
+			Convey("I should get a dport match with the range", func() {
+				So(err, ShouldBeNil)
+				So(matches, ShouldResemble, [][]string{{"--dport", "8000:8080"}})
+			})
+		})
+
+		Convey("When I match a list of ports and ranges", func() {
+			matches, err := portMatches("80,443,8000:8080")
+			Convey("I should get a multiport match", func() {
+				So(err, ShouldBeNil)
+				So(matches, ShouldResemble, [][]string{{"-m", "multiport", "--dports", "80,443,8000:8080"}})
+			})
+		})
+
+		Convey("When I match more ports than a multiport match accepts", func() {
+			matches, err := portMatches("1,2,3,4,5,6,7,8,9,10,11,12,13,14,20:30,40")
+			Convey("I should get several multiport matches", func() {
+				So(err, ShouldBeNil)
+				So(matches, ShouldResemble, [][]string{
+					{"-m", "multiport", "--dports", "1,2,3,4,5,6,7,8,9,10,11,12,13,14"},
+					{"-m", "multiport", "--dports", "20:30,40"},
+				})
+			})
+		})
+
+		Convey("When I match invalid ports", func() {
+			_, err := portMatches("80,http")
+			Convey("I should get an error", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestACLMatches(t *testing.T) {
+
+	Convey("Given an iptables controller", t, func() {
+		i, _ := NewInstance("0:1", "2:3", 0x1000, constants.LocalContainer)
+
+		Convey("When I match an application ACL for an ICMP type and code", func() {
+			matches, err := i.appACLMatches(policy.IPRule{Address: "0.0.0.0/0", Protocol: "icmp", ICMPType: "3", ICMPCode: "4"})
+			Convey("I should get an icmp-type match", func() {
+				So(err, ShouldBeNil)
+				So(matches, ShouldResemble, [][]string{{"-p", "icmp", "-d", "0.0.0.0/0", "--icmp-type", "3/4"}})
+			})
+		})
+
+		Convey("When I match a network ACL for an ICMP type", func() {
+			matches, err := i.netACLMatches(policy.IPRule{Address: "0.0.0.0/0", Protocol: "icmp", ICMPType: "8"})
+			Convey("I should get an icmp-type match without a code", func() {
+				So(err, ShouldBeNil)
+				So(matches, ShouldResemble, [][]string{{"-p", "icmp", "-s", "0.0.0.0/0", "--icmp-type", "8"}})
+			})
+		})
+
+		Convey("When I match an ICMP ACL with the IPv6 instance", func() {
+			i.ipv6 = true
+			matches, err := i.netACLMatches(policy.IPRule{Address: "fd00::/64", Protocol: "icmp", ICMPType: "128", ICMPCode: "0"})
+			Convey("I should get an icmpv6-type match", func() {
+				So(err, ShouldBeNil)
+				So(matches, ShouldResemble, [][]string{{"-p", "icmpv6", "-s", "fd00::/64", "--icmpv6-type", "128/0"}})
+			})
+		})
+
+		Convey("When I match an application ACL for ports with the IPv6 instance", func() {
+			i.ipv6 = true
+			matches, err := i.appACLMatches(policy.IPRule{Address: "fd00::/64", Protocol: "TCP", Port: "80"})
+			Convey("I should get the protocol of the IPv6 instance", func() {
+				So(err, ShouldBeNil)
+				So(matches, ShouldResemble, [][]string{{"-p", "TCP", "-m", "state", "--state", "NEW", "-d", "fd00::/64", "--dport", "80"}})
+			})
+		})
+
+		Convey("When I match an ACL for SCTP ports", func() {
+			matches, err := i.netACLMatches(policy.IPRule{Address: "10.0.0.0/8", Protocol: "sctp", Port: "3868,9900"})
+			Convey("I should get a multiport match", func() {
+				So(err, ShouldBeNil)
+				So(matches, ShouldResemble, [][]string{{"-p", "sctp", "-s", "10.0.0.0/8", "-m", "multiport", "--dports", "3868,9900"}})
+			})
+		})
+
+		Convey("When I match an ACL for a protocol number", func() {
+			matches, err := i.appACLMatches(policy.IPRule{Address: "10.0.0.0/8", Protocol: "47"})
+			Convey("I should get a protocol match", func() {
+				So(err, ShouldBeNil)
+				So(matches, ShouldResemble, [][]string{{"-p", "47", "-d", "10.0.0.0/8"}})
+			})
+		})
+	})
+}
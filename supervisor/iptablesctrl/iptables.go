@@ -3,6 +3,7 @@ package iptablesctrl
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 
@@ -17,6 +18,12 @@ const (
 	chainPrefix    = "TRIREME-"
 	appChainPrefix = chainPrefix + "App-"
 	netChainPrefix = chainPrefix + "Net-"
+
+	ipv4AnyNetwork = "0.0.0.0/0"
+	ipv6AnyNetwork = "::/0"
+
+	// maxMultiportPorts is the number of ports of a multiport match
+	maxMultiportPorts = 15
 )
 
 // Instance  is the structure holding all information about a implementation
@@ -33,6 +40,9 @@ type Instance struct {
 	appCgroupIPTableSection    string
 	appSynAckIPTableSection    string
 	mode                       constants.ModeType
+	ip6t                       provider.IptablesProvider
+	ipv6                       bool
+	excludedIPs                []string
 }
 
 // NewInstance creates a new iptables controller instance
@@ -43,11 +53,33 @@ func NewInstance(networkQueues, applicationQueues string, mark int, mode constan
 		return nil, fmt.Errorf("Cannot initialize IPtables provider")
 	}
 
+	ip6t, err := provider.NewGoIP6TablesProvider()
+	if err != nil {
+		zap.L().Warn("Cannot initialize IP6tables provider. IPv6 traffic will not be enforced", zap.Error(err))
+	}
+
+	return newInstance(networkQueues, applicationQueues, mark, mode, ipt, ip6t), nil
+}
+
+// NewRenderer creates an iptables controller instance that records the commands
+// instead of changing the rules of the host. The commands of every call, for
+// example ConfigureRules, are kept in the returned recorder in order.
+func NewRenderer(networkQueues, applicationQueues string, mark int, mode constants.ModeType) (*Instance, *provider.Recorder) {
+
+	recorder := provider.NewRecorder()
+
+	return newInstance(networkQueues, applicationQueues, mark, mode, recorder.IptablesProvider("iptables"), recorder.IptablesProvider("ip6tables")), recorder
+}
+
+// newInstance creates an instance that programs the rules with the given providers
+func newInstance(networkQueues, applicationQueues string, mark int, mode constants.ModeType, ipt, ip6t provider.IptablesProvider) *Instance {
+
 	i := &Instance{
 		networkQueues:     networkQueues,
 		applicationQueues: applicationQueues,
 		mark:              mark,
 		ipt:               ipt,
+		ip6t:              ip6t,
 		appPacketIPTableContext:    "raw",
 		appAckPacketIPTableContext: "mangle",
 		netPacketIPTableContext:    "mangle",
@@ -66,8 +98,7 @@ func NewInstance(networkQueues, applicationQueues string, mark int, mode constan
 		i.appSynAckIPTableSection = "INPUT"       //nolint
 	}
 
-	return i, nil
-
+	return i
 }
 
 // chainPrefix returns the chain name for the specific PU
@@ -77,6 +108,14 @@ func (i *Instance) chainName(contextID string, version int) (app, net string) {
 	return app, net
 }
 
+// ChainNames returns the names of the application and network chains of a
+// version of the PU. The IPv6 chains have the same names.
+func (i *Instance) ChainNames(version int, contextID string) []string {
+
+	app, net := i.chainName(contextID, version)
+	return []string{app, net}
+}
+
 // DefaultIPAddress returns the default IP address for the processing unit
 func (i *Instance) defaultIP(addresslist map[string]string) (string, bool) {
 
@@ -85,25 +124,216 @@ func (i *Instance) defaultIP(addresslist map[string]string) (string, bool) {
 	}
 
 	if i.mode == constants.LocalContainer {
-		return "0.0.0.0/0", false
+		return ipv4AnyNetwork, false
+	}
+
+	return ipv4AnyNetwork, true
+}
+
+// defaultIPv6 returns the IPv6 address for the processing unit. Like defaultIP,
+// a PU that is not a local container matches all the addresses.
+func (i *Instance) defaultIPv6(addresslist map[string]string) (string, bool) {
+
+	if ip, ok := addresslist[policy.DefaultIPv6Namespace]; ok && len(ip) > 0 {
+		return ip, true
+	}
+
+	if i.mode == constants.LocalContainer {
+		return ipv6AnyNetwork, false
+	}
+
+	return ipv6AnyNetwork, true
+}
+
+// ipv6Instance returns a copy of the instance that programs ip6tables instead
+// of iptables. It returns nil if ip6tables is not available.
+func (i *Instance) ipv6Instance() *Instance {
+
+	if i.ip6t == nil || i.ipv6 {
+		return nil
+	}
+
+	v6 := *i
+	v6.ipt = i.ip6t
+	v6.ipv6 = true
+
+	return &v6
+}
+
+// ipv6Target returns the IPv6 instance and the IPv6 address to use for a PU if
+// IPv6 rules must be programmed. This is the case when the PU has an IPv6 address
+// or, for PUs that are not local containers, when any of the Trireme networks is
+// an IPv6 one.
+func (i *Instance) ipv6Target(containerInfo *policy.PUInfo) (*Instance, string, bool) {
+
+	v6 := i.ipv6Instance()
+	if v6 == nil {
+		return nil, "", false
+	}
+
+	addresses := containerInfo.Policy.IPAddresses().IPs
+	if ip, ok := addresses[policy.DefaultIPv6Namespace]; ok && len(ip) > 0 {
+		return v6, ip, true
+	}
+
+	if i.mode == constants.LocalContainer {
+		return nil, "", false
+	}
+
+	if len(v6.filterNetworks(containerInfo.Policy.TriremeNetworks())) == 0 {
+		return nil, "", false
+	}
+
+	ip, _ := v6.defaultIPv6(addresses)
+
+	return v6, ip, true
+}
+
+// anyNetwork returns the network that matches every address of the IP family
+// programmed by this instance
+func (i *Instance) anyNetwork() string {
+
+	if i.ipv6 {
+		return ipv6AnyNetwork
+	}
+
+	return ipv4AnyNetwork
+}
+
+// filterNetworks returns the networks that belong to the IP family programmed
+// by this instance
+func (i *Instance) filterNetworks(networks []string) []string {
+
+	filtered := []string{}
+	for _, network := range networks {
+		if policy.IsIPv6Address(network) == i.ipv6 {
+			filtered = append(filtered, network)
+		}
+	}
+
+	return filtered
+}
+
+// protocol returns the protocol name understood by iptables for the IP family
+// programmed by this instance
+func (i *Instance) protocol(proto string) string {
+
+	if i.ipv6 && strings.ToLower(proto) == "icmp" {
+		return "icmpv6"
 	}
 
-	return "0.0.0.0/0", true
+	return proto
+}
+
+// chainExists returns true if the chain is present in the given table
+func (i *Instance) chainExists(table, chain string) bool {
+
+	chains, err := i.ipt.ListChains(table)
+	if err != nil {
+		return false
+	}
+
+	for _, c := range chains {
+		if c == chain {
+			return true
+		}
+	}
+
+	return false
 }
 
 // ConfigureRules implmenets the ConfigureRules interface
 func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
-	policyrules := containerInfo.Policy
-
-	appChain, netChain := i.chainName(contextID, version)
-	// policyrules.DefaultIPAddress()
 
 	// Supporting only one ip
-	ipAddress, ok := i.defaultIP(policyrules.IPAddresses().IPs)
+	ipAddress, ok := i.defaultIP(containerInfo.Policy.IPAddresses().IPs)
 	if !ok {
 		return fmt.Errorf("No ip address found ")
 	}
 
+	// The rules of both IP families are removed if any of them fails
+	return i.withRollback(func(j *Instance) error {
+		return j.addVersion(version, contextID, containerInfo, ipAddress)
+	})
+}
+
+// addVersion adds the chains of a version of the PU policy for both IP families
+func (i *Instance) addVersion(version int, contextID string, containerInfo *policy.PUInfo, ipAddress string) error {
+
+	if err := i.configureRules(version, contextID, containerInfo, ipAddress); err != nil {
+		return err
+	}
+
+	if v6, ipv6Address, ok := i.ipv6Target(containerInfo); ok {
+		if err := v6.configureRules(version, contextID, containerInfo, ipv6Address); err != nil {
+			return fmt.Errorf("Failed to configure IPv6 rules: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// configureRules configures the rules of a PU for the IP family of the instance.
+// All the chains and rules of the PU are added in one transaction.
+func (i *Instance) configureRules(version int, contextID string, containerInfo *policy.PUInfo, ipAddress string) error {
+
+	return i.transaction(func(b *Instance) error {
+		return b.addChains(version, contextID, containerInfo, ipAddress)
+	})
+}
+
+// withRollback calls the function with a copy of the instance that records
+// every change applied to the rules. All the changes are reverted if the
+// function fails.
+func (i *Instance) withRollback(apply func(*Instance) error) error {
+
+	journal := provider.NewJournal()
+
+	j := *i
+	j.ipt = journal.IptablesProvider(i.ipt)
+	if i.ip6t != nil {
+		j.ip6t = journal.IptablesProvider(i.ip6t)
+	}
+
+	if err := apply(&j); err != nil {
+		if rerr := journal.Rollback(); rerr != nil {
+			zap.L().Warn("Failed to revert some changes to the rules", zap.Error(rerr))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// transaction calls the function with a copy of the instance that records the
+// changes to the rules. The changes are applied with one iptables-restore if the
+// function succeeds, or one per table in a journal so that the tables that were
+// committed are known. The rules of each table are committed atomically.
+func (i *Instance) transaction(apply func(*Instance) error) error {
+
+	batch := provider.NewIptablesBatch(i.ipt)
+
+	b := *i
+	b.ipt = batch
+
+	if err := apply(&b); err != nil {
+		return err
+	}
+
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("Failed to apply the rules: %s", err)
+	}
+
+	return nil
+}
+
+// addChains adds the chains of a version of the PU policy with all their rules
+// and the rules that send the traffic of the PU to them
+func (i *Instance) addChains(version int, contextID string, containerInfo *policy.PUInfo, ipAddress string) error {
+	policyrules := containerInfo.Policy
+
+	appChain, netChain := i.chainName(contextID, version)
+
 	// Configure all the ACLs
 	if err := i.addContainerChain(appChain, netChain); err != nil {
 		return err
@@ -134,11 +364,19 @@ func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *
 		return err
 	}
 
-	if err := i.addAppACLs(appChain, ipAddress, policyrules.ApplicationACLs()); err != nil {
+	if policyrules.EncryptionEnabled() {
+		if err := i.addEncryptionTrap(appChain, netChain, policyrules.TriremeNetworks()); err != nil {
+			return err
+		}
+	}
+
+	audit := policyrules.TriremeAction == policy.Audit
+
+	if err := i.addAppACLs(contextID, appChain, ipAddress, policyrules.ApplicationACLs(), audit); err != nil {
 		return err
 	}
 
-	if err := i.addNetACLs(netChain, ipAddress, policyrules.NetworkACLs()); err != nil {
+	if err := i.addNetACLs(contextID, netChain, ipAddress, policyrules.NetworkACLs(), audit); err != nil {
 		return err
 	}
 
@@ -166,6 +404,29 @@ func (i *Instance) DeleteRules(version int, contextID string, ipAddresses *polic
 		}
 	}
 
+	i.deleteRules(version, contextID, ipAddress, port, mark)
+
+	// IPv6 rules are only present if the IPv6 chains were created
+	if v6 := i.ipv6Instance(); v6 != nil {
+		var ipv6Address string
+		if i.mode != constants.LocalServer {
+			if ipv6Address, ok = v6.defaultIPv6(ipAddresses.IPs); !ok {
+				return nil
+			}
+		}
+
+		_, netChain := v6.chainName(contextID, version)
+		if v6.chainExists(v6.netPacketIPTableContext, netChain) {
+			v6.deleteRules(version, contextID, ipv6Address, port, mark)
+		}
+	}
+
+	return nil
+}
+
+// deleteRules deletes the rules of a PU for the IP family of the instance
+func (i *Instance) deleteRules(version int, contextID string, ipAddress string, port string, mark string) {
+
 	appChain, netChain := i.chainName(contextID, version)
 
 	if derr := i.deleteChainRules(appChain, netChain, ipAddress, port, mark); derr != nil {
@@ -175,8 +436,6 @@ func (i *Instance) DeleteRules(version int, contextID string, ipAddresses *polic
 	if err := i.deleteAllContainerChains(appChain, netChain); err != nil {
 		zap.L().Warn("Failed to clean container chains while deleting the rules", zap.Error(err))
 	}
-
-	return nil
 }
 
 // UpdateRules implements the update part of the interface
@@ -197,78 +456,220 @@ func (i *Instance) UpdateRules(version int, contextID string, containerInfo *pol
 		return fmt.Errorf("No ip address found ")
 	}
 
-	appChain, netChain := i.chainName(contextID, version)
+	// The chains of the new version are added for both IP families before the
+	// previous version is removed. The chains that were added are removed if
+	// any of them fails so that the previous version stays in place.
+	if err := i.withRollback(func(j *Instance) error {
+		return j.addVersion(version, contextID, containerInfo, ipAddress)
+	}); err != nil {
+		return err
+	}
+
+	mark, _ := containerInfo.Runtime.Options().Get(cgnetcls.CgroupMarkTag)
+	port, ok := containerInfo.Runtime.Options().Get(cgnetcls.PortTag)
+	if !ok {
+		port = "0"
+	}
 
-	oldAppChain, oldNetChain := i.chainName(contextID, version-1)
+	i.deleteRules(version-1, contextID, ipAddress, port, mark)
 
-	//Add a new chain for this update and map all rules there
-	if err := i.addContainerChain(appChain, netChain); err != nil {
-		return err
+	// The IPv6 chains of the previous version might not exist, for example if
+	// the PU was not enforced for IPv6
+	if v6 := i.ipv6Instance(); v6 != nil {
+		_, oldNetChain := v6.chainName(contextID, version-1)
+		if v6.chainExists(v6.netPacketIPTableContext, oldNetChain) {
+			ipv6Address, _ := v6.defaultIPv6(policyrules.IPAddresses().IPs)
+			v6.deleteRules(version-1, contextID, ipv6Address, port, mark)
+		}
 	}
 
-	if err := i.addPacketTrap(appChain, netChain, ipAddress, containerInfo.Policy.TriremeNetworks()); err != nil {
-		return err
+	return nil
+}
+
+// UpdateACLs implements the ACLUpdater interface. The rules of the ACLs that
+// changed are inserted in and deleted from the chains of the current version
+// for both IP families. All the changes are reverted if any of them fails.
+func (i *Instance) UpdateACLs(version int, contextID string, previous *policy.PUInfo, containerInfo *policy.PUInfo) error {
+
+	if previous == nil || previous.Policy == nil || containerInfo == nil || containerInfo.Policy == nil {
+		return fmt.Errorf("Container info cannot be nil")
 	}
 
-	if err := i.addAppACLs(appChain, ipAddress, policyrules.ApplicationACLs()); err != nil {
-		return err
+	return i.withRollback(func(j *Instance) error {
+		if err := j.changeACLs(version, contextID, previous.Policy, containerInfo.Policy); err != nil {
+			return err
+		}
+
+		if v6, _, ok := j.ipv6Target(containerInfo); ok {
+			if err := v6.changeACLs(version, contextID, previous.Policy, containerInfo.Policy); err != nil {
+				return fmt.Errorf("Failed to update IPv6 ACLs: %s", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// changeACLs changes the ACL rules of a version of the PU for the IP family of
+// the instance from the previous to the new policy
+func (i *Instance) changeACLs(version int, contextID string, previous, policyrules *policy.PUPolicy) error {
+
+	appAdded, appRemoved := policy.DiffIPRules(i.identifiedACLs(previous.ApplicationACLs()), i.identifiedACLs(policyrules.ApplicationACLs()))
+	netAdded, netRemoved := policy.DiffIPRules(i.identifiedACLs(previous.NetworkACLs()), i.identifiedACLs(policyrules.NetworkACLs()))
+
+	// The identifiers of the rules without an ID change with their index
+	if changes := len(appAdded) + len(appRemoved) + len(netAdded) + len(netRemoved); changes > policy.MaxIncrementalChanges {
+		return fmt.Errorf("Too many ACL rules changed: %d", changes)
 	}
 
-	if err := i.addNetACLs(netChain, ipAddress, policyrules.NetworkACLs()); err != nil {
+	appChain, netChain := i.chainName(contextID, version)
+	exclusions := len(i.filterNetworks(policyrules.ExcludedNetworks()))
+	audit := policyrules.TriremeAction == policy.Audit
+
+	if err := i.updateACLs(i.appAckPacketIPTableContext, appChain, contextID, appAdded, appRemoved, i.appACLMatches, exclusions, audit); err != nil {
 		return err
 	}
 
-	if err := i.addExclusionACLs(appChain, netChain, ipAddress, policyrules.ExcludedNetworks()); err != nil {
-		return err
+	return i.updateACLs(i.netPacketIPTableContext, netChain, contextID, netAdded, netRemoved, i.netACLMatches, exclusions, audit)
+}
+
+// CheckRules implements the Reconciler interface. It returns the chains and rules
+// of the PU that are missing or altered in the live tables.
+func (i *Instance) CheckRules(version int, contextID string, containerInfo *policy.PUInfo) ([]string, error) {
+
+	if containerInfo == nil || containerInfo.Policy == nil || containerInfo.Runtime == nil {
+		return nil, fmt.Errorf("Container info cannot be nil")
 	}
 
-	// Add mapping to new chain
-	if i.mode != constants.LocalServer {
+	// Supporting only one ip
+	ipAddress, ok := i.defaultIP(containerInfo.Policy.IPAddresses().IPs)
+	if !ok {
+		return nil, fmt.Errorf("No ip address found ")
+	}
 
-		if err := i.addChainRules(appChain, netChain, ipAddress, "", ""); err != nil {
-			return err
-		}
-	} else {
-		mark, ok := containerInfo.Runtime.Options().Get(cgnetcls.CgroupMarkTag)
-		if !ok {
-			return fmt.Errorf("No Mark value found")
-		}
-		portlist, ok := containerInfo.Runtime.Options().Get(cgnetcls.PortTag)
-		if !ok {
-			portlist = "0"
-		}
+	drift, err := i.checkRules(version, contextID, containerInfo, ipAddress)
+	if err != nil {
+		return nil, err
+	}
 
-		if err := i.addChainRules(appChain, netChain, ipAddress, portlist, mark); err != nil {
-			return err
+	if v6, ipv6Address, ok := i.ipv6Target(containerInfo); ok {
+		v6drift, err := v6.checkRules(version, contextID, containerInfo, ipv6Address)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to check IPv6 rules: %s", err)
 		}
+		drift = append(drift, v6drift...)
 	}
 
-	//Remove mapping from old chain
-	if i.mode != constants.LocalServer {
-		if err := i.deleteChainRules(oldAppChain, oldNetChain, ipAddress, "", ""); err != nil {
-			return err
+	return drift, nil
+}
+
+// checkRules compares the rules that configureRules adds for the PU with the
+// live rules of the IP family of the instance. The chains of the PU must have
+// exactly the expected rules. The rules that send the traffic to the chains of
+// the PU must be present in the other chains.
+func (i *Instance) checkRules(version int, contextID string, containerInfo *policy.PUInfo, ipAddress string) ([]string, error) {
+
+	batch := provider.NewIptablesBatch(i.ipt)
+
+	b := *i
+	b.ipt = batch
+
+	if err := b.addChains(version, contextID, containerInfo, ipAddress); err != nil {
+		return nil, err
+	}
+
+	drift := []string{}
+
+	for _, table := range batch.Tables() {
+
+		chains := []string{}
+		expected := map[string]int{}
+		rules := [][]string{}
+
+		for _, args := range batch.Commands(table) {
+			switch args[0] {
+			case "-N":
+				chains = append(chains, args[1])
+				expected[args[1]] = 0
+			case "-A":
+				rules = append(rules, args[1:])
+			case "-I":
+				rules = append(rules, append([]string{args[1]}, args[3:]...))
+			}
 		}
-	} else {
-		mark, _ := containerInfo.Runtime.Options().Get(cgnetcls.CgroupMarkTag)
-		port, ok := containerInfo.Runtime.Options().Get(cgnetcls.PortTag)
-		if !ok {
-			port = "0"
+
+		missing := map[string]bool{}
+
+		for _, rule := range rules {
+			if _, ok := expected[rule[0]]; ok {
+				expected[rule[0]]++
+			}
 		}
-		if err := i.deleteChainRules(oldAppChain, oldNetChain, ipAddress, port, mark); err != nil {
-			return err
+
+		for _, chain := range chains {
+			if !i.chainExists(table, chain) {
+				drift = append(drift, fmt.Sprintf("Chain %s is missing from table %s", chain, table))
+				missing[chain] = true
+				continue
+			}
+
+			live, err := i.ipt.List(table, chain)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to list chain %s of table %s: %s", chain, table, err)
+			}
+
+			count := 0
+			for _, rule := range live {
+				if strings.HasPrefix(rule, "-A ") {
+					count++
+				}
+			}
+
+			if count != expected[chain] {
+				drift = append(drift, fmt.Sprintf("Chain %s of table %s has %d rules instead of %d", chain, table, count, expected[chain]))
+			}
+		}
+
+		for _, rule := range rules {
+			if missing[rule[0]] {
+				continue
+			}
+
+			exists, err := i.ipt.Exists(table, rule[0], rule[1:]...)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to check rule in chain %s of table %s: %s", rule[0], table, err)
+			}
+
+			if !exists {
+				drift = append(drift, fmt.Sprintf("Rule %s is missing from chain %s of table %s", strings.Join(rule[1:], " "), rule[0], table))
+			}
 		}
 	}
 
-	// Delete the old chain to clean up
-	if err := i.deleteAllContainerChains(oldAppChain, oldNetChain); err != nil {
+	return drift, nil
+}
+
+// Start starts the iptables controller
+func (i *Instance) Start() error {
+
+	if err := i.start(); err != nil {
 		return err
 	}
 
+	// IPv6 is best effort since the host might not support it
+	if v6 := i.ipv6Instance(); v6 != nil {
+		if err := v6.start(); err != nil {
+			zap.L().Warn("Failed to start the ip6tables controller", zap.Error(err))
+		}
+	}
+
+	zap.L().Debug("Started the iptables controller")
+
 	return nil
 }
 
-// Start starts the iptables controller
-func (i *Instance) Start() error {
+// start configures the global rules for the IP family of the instance
+func (i *Instance) start() error {
 
 	// Clean any previous ACLs
 	if err := i.cleanACLs(); err != nil {
@@ -288,11 +689,90 @@ func (i *Instance) Start() error {
 		}
 	}
 
-	zap.L().Debug("Started the iptables controller")
+	// The exclusions added before the start were removed with the previous ACLs
+	for _, ip := range i.filterNetworks(i.excludedIPs) {
+		if err := i.processRulesFromList(i.exclusionRules(ip), "Insert"); err != nil {
+			return fmt.Errorf("Cannot install the exclusion of %s: %s", ip, err)
+		}
+	}
 
 	return nil
 }
 
+// AddExcludedIP excludes the IP addresses or networks from the enforcement on the
+// whole node. Their traffic is accepted before it reaches the chains of the PUs.
+func (i *Instance) AddExcludedIP(ipList []string) error {
+
+	for _, ip := range ipList {
+		if i.isExcluded(ip) {
+			continue
+		}
+
+		target := i
+		if policy.IsIPv6Address(ip) {
+			if target = i.ipv6Instance(); target == nil {
+				return fmt.Errorf("IPv6 is not supported. Cannot exclude %s", ip)
+			}
+		}
+
+		if err := target.processRulesFromList(target.exclusionRules(ip), "Insert"); err != nil {
+			if derr := target.deleteExclusionRules(ip); derr != nil {
+				zap.L().Warn("Failed to clean the exclusion rules", zap.String("ip", ip), zap.Error(derr))
+			}
+			return fmt.Errorf("Failed to exclude %s: %s", ip, err)
+		}
+
+		i.excludedIPs = append(i.excludedIPs, ip)
+	}
+
+	return nil
+}
+
+// RemoveExcludedIP removes the exclusion of the IP addresses or networks. An
+// address stays excluded until all its rules are deleted, so that the removal
+// can be retried.
+func (i *Instance) RemoveExcludedIP(ipList []string) error {
+
+	for _, ip := range ipList {
+		if !i.isExcluded(ip) {
+			return fmt.Errorf("%s is not excluded", ip)
+		}
+
+		target := i
+		if policy.IsIPv6Address(ip) {
+			if target = i.ipv6Instance(); target == nil {
+				return fmt.Errorf("IPv6 is not supported. Cannot remove exclusion %s", ip)
+			}
+		}
+
+		if err := target.deleteExclusionRules(ip); err != nil {
+			return fmt.Errorf("Failed to remove the exclusion of %s: %s", ip, err)
+		}
+
+		excluded := []string{}
+		for _, e := range i.excludedIPs {
+			if e != ip {
+				excluded = append(excluded, e)
+			}
+		}
+		i.excludedIPs = excluded
+	}
+
+	return nil
+}
+
+// isExcluded returns true if the IP address or network is excluded
+func (i *Instance) isExcluded(ip string) bool {
+
+	for _, e := range i.excludedIPs {
+		if e == ip {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Stop stops the supervisor
 func (i *Instance) Stop() error {
 
@@ -303,5 +783,11 @@ func (i *Instance) Stop() error {
 		zap.L().Error("Failed to clean acls while stopping the supervisor", zap.Error(err))
 	}
 
+	if v6 := i.ipv6Instance(); v6 != nil {
+		if err := v6.cleanACLs(); err != nil {
+			zap.L().Error("Failed to clean ipv6 acls while stopping the supervisor", zap.Error(err))
+		}
+	}
+
 	return nil
 }
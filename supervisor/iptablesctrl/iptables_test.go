@@ -2,6 +2,7 @@ package iptablesctrl
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/aporeto-inc/trireme/constants"
@@ -55,6 +56,13 @@ func TestChainName(t *testing.T) {
 				So(net, ShouldResemble, "TRIREME-Net-Context-1")
 			})
 		})
+
+		Convey("When I get the names of the chains of a version of the PU", func() {
+			chains := i.ChainNames(1, "Context")
+			Convey("I should get the application and network chains", func() {
+				So(chains, ShouldResemble, []string{"TRIREME-App-Context-1", "TRIREME-Net-Context-1"})
+			})
+		})
 	})
 }
 
@@ -247,6 +255,168 @@ func TestConfigureRules(t *testing.T) {
 	})
 }
 
+func TestConfigureRulesIPv6(t *testing.T) {
+	Convey("Given an iptables controller with an ip6tables provider", t, func() {
+		i, _ := NewInstance("0:1", "2:3", 0x1000, constants.LocalContainer)
+		iptables := provider.NewTestIptablesProvider()
+		ip6tables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+		i.ip6t = ip6tables
+
+		rules := policy.NewIPRuleList([]policy.IPRule{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Action:   policy.Accept,
+			},
+
+			policy.IPRule{
+				Address:  "2001:db8::/32",
+				Port:     "443",
+				Protocol: "TCP",
+				Action:   policy.Accept,
+			},
+		})
+
+		ipv4Rules := []string{}
+		ipv6Rules := []string{}
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			ipv4Rules = append(ipv4Rules, rulespec...)
+			return nil
+		})
+		iptables.MockNewChain(t, func(table string, chain string) error {
+			return nil
+		})
+		ip6tables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			ipv6Rules = append(ipv6Rules, rulespec...)
+			return nil
+		})
+		ip6tables.MockNewChain(t, func(table string, chain string) error {
+			return nil
+		})
+
+		Convey("With a PU that has both an IPv4 and an IPv6 address", func() {
+			ipl := policy.NewIPMap(map[string]string{})
+			ipl.IPs[policy.DefaultNamespace] = "172.17.0.1"
+			ipl.IPs[policy.DefaultIPv6Namespace] = "fd00::2"
+			policyrules := policy.NewPUPolicy("Context",
+				policy.Police,
+				rules,
+				rules,
+				nil,
+				nil,
+				nil,
+				nil, ipl, []string{"172.17.0.0/24", "fd00::/64"}, []string{}, nil)
+
+			containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			containerinfo.Policy = policyrules
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+			err := i.ConfigureRules(1, "Context", containerinfo)
+			Convey("It should program each family with its own rules", func() {
+				So(err, ShouldBeNil)
+				So(ipv4Rules, ShouldContain, "192.30.253.0/24")
+				So(ipv4Rules, ShouldContain, "0.0.0.0/0")
+				So(ipv4Rules, ShouldNotContain, "2001:db8::/32")
+				So(ipv4Rules, ShouldNotContain, "::/0")
+				So(ipv6Rules, ShouldContain, "2001:db8::/32")
+				So(ipv6Rules, ShouldContain, "::/0")
+				So(ipv6Rules, ShouldNotContain, "192.30.253.0/24")
+				So(ipv6Rules, ShouldNotContain, "0.0.0.0/0")
+			})
+		})
+
+		Convey("With a PU that has only an IPv4 address", func() {
+			ipl := policy.NewIPMap(map[string]string{})
+			ipl.IPs[policy.DefaultNamespace] = "172.17.0.1"
+			policyrules := policy.NewPUPolicy("Context",
+				policy.Police,
+				rules,
+				rules,
+				nil,
+				nil,
+				nil,
+				nil, ipl, []string{"172.17.0.0/24", "fd00::/64"}, []string{}, nil)
+
+			containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			containerinfo.Policy = policyrules
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+			err := i.ConfigureRules(1, "Context", containerinfo)
+			Convey("It should not program ip6tables", func() {
+				So(err, ShouldBeNil)
+				So(len(ipv4Rules), ShouldBeGreaterThan, 0)
+				So(len(ipv6Rules), ShouldEqual, 0)
+			})
+		})
+
+		Convey("With a PU that has both addresses, where the IPv6 configuration fails", func() {
+			ip6tables.MockNewChain(t, func(table string, chain string) error {
+				return fmt.Errorf("Error")
+			})
+			deletedChains := []string{}
+			iptables.MockDeleteChain(t, func(table string, chain string) error {
+				deletedChains = append(deletedChains, chain)
+				return nil
+			})
+			iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
+				return nil
+			})
+
+			ipl := policy.NewIPMap(map[string]string{})
+			ipl.IPs[policy.DefaultNamespace] = "172.17.0.1"
+			ipl.IPs[policy.DefaultIPv6Namespace] = "fd00::2"
+			policyrules := policy.NewPUPolicy("Context",
+				policy.Police,
+				rules,
+				rules,
+				nil,
+				nil,
+				nil,
+				nil, ipl, []string{"172.17.0.0/24", "fd00::/64"}, []string{}, nil)
+
+			containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			containerinfo.Policy = policyrules
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+			err := i.ConfigureRules(1, "Context", containerinfo)
+			Convey("It should fail and remove the IPv4 chains that were added", func() {
+				So(err, ShouldNotBeNil)
+				So(len(ipv4Rules), ShouldBeGreaterThan, 0)
+				So(deletedChains, ShouldContain, i.ChainNames(1, "Context")[0])
+				So(deletedChains, ShouldContain, i.ChainNames(1, "Context")[1])
+			})
+		})
+
+		Convey("With a PU that has an IPv6 address and no ip6tables provider", func() {
+			i.ip6t = nil
+			ipl := policy.NewIPMap(map[string]string{})
+			ipl.IPs[policy.DefaultNamespace] = "172.17.0.1"
+			ipl.IPs[policy.DefaultIPv6Namespace] = "fd00::2"
+			policyrules := policy.NewPUPolicy("Context",
+				policy.Police,
+				rules,
+				rules,
+				nil,
+				nil,
+				nil,
+				nil, ipl, []string{"172.17.0.0/24", "fd00::/64"}, []string{}, nil)
+
+			containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			containerinfo.Policy = policyrules
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+			err := i.ConfigureRules(1, "Context", containerinfo)
+			Convey("It should only program the IPv4 rules", func() {
+				So(err, ShouldBeNil)
+				So(len(ipv4Rules), ShouldBeGreaterThan, 0)
+				So(len(ipv6Rules), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
 func TestDeleteRules(t *testing.T) {
 	Convey("Given an iptables controllers", t, func() {
 		i, _ := NewInstance("0:1", "2:3", 0x1000, constants.LocalContainer)
@@ -466,3 +636,452 @@ func TestStop(t *testing.T) {
 		})
 	})
 }
+
+func TestExcludedIP(t *testing.T) {
+	Convey("Given an iptables controller for containers", t, func() {
+		i, _ := NewInstance("0:1", "2:3", 0x1000, constants.LocalContainer)
+		iptables := provider.NewTestIptablesProvider()
+		ip6tables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+		i.ip6t = ip6tables
+
+		inserted := map[string][]string{}
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			inserted[table+" "+chain] = append(inserted[table+" "+chain], strings.Join(rulespec, " "))
+			return nil
+		})
+		ip6tables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			inserted["ip6 "+table+" "+chain] = append(inserted["ip6 "+table+" "+chain], strings.Join(rulespec, " "))
+			return nil
+		})
+		deleted := []string{}
+		iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
+			deleted = append(deleted, table+" "+chain+" "+strings.Join(rulespec, " "))
+			return nil
+		})
+
+		Convey("When I exclude an IPv4 and an IPv6 network", func() {
+			err := i.AddExcludedIP([]string{"10.0.0.0/8", "fd00::/64"})
+
+			Convey("The traffic of each network should be accepted before the chains of the PUs", func() {
+				So(err, ShouldBeNil)
+				So(inserted["raw PREROUTING"], ShouldResemble, []string{"-d 10.0.0.0/8 -m comment --comment Excluded-IP -j ACCEPT"})
+				So(inserted["mangle PREROUTING"], ShouldResemble, []string{"-d 10.0.0.0/8 -m comment --comment Excluded-IP -j ACCEPT"})
+				So(inserted["mangle POSTROUTING"], ShouldResemble, []string{"-s 10.0.0.0/8 -m comment --comment Excluded-IP -j ACCEPT"})
+				So(inserted["ip6 mangle POSTROUTING"], ShouldResemble, []string{"-s fd00::/64 -m comment --comment Excluded-IP -j ACCEPT"})
+			})
+
+			Convey("When I exclude the same network again, no rule should be added", func() {
+				inserted = map[string][]string{}
+				So(i.AddExcludedIP([]string{"10.0.0.0/8"}), ShouldBeNil)
+				So(inserted, ShouldBeEmpty)
+			})
+
+			Convey("When I remove the exclusion, the rules should be deleted", func() {
+				So(i.RemoveExcludedIP([]string{"10.0.0.0/8"}), ShouldBeNil)
+				So(deleted, ShouldContain, "mangle POSTROUTING -s 10.0.0.0/8 -m comment --comment Excluded-IP -j ACCEPT")
+				So(i.excludedIPs, ShouldResemble, []string{"fd00::/64"})
+			})
+
+			Convey("When I restart the controller, the exclusions should be added again", func() {
+				iptables.MockClearChain(t, func(table string, chain string) error {
+					return nil
+				})
+				iptables.MockListChains(t, func(table string) ([]string, error) {
+					return []string{}, nil
+				})
+				inserted = map[string][]string{}
+				So(i.Start(), ShouldBeNil)
+				So(inserted["mangle POSTROUTING"], ShouldContain, "-s 10.0.0.0/8 -m comment --comment Excluded-IP -j ACCEPT")
+				So(inserted["mangle POSTROUTING"], ShouldNotContain, "-s fd00::/64 -m comment --comment Excluded-IP -j ACCEPT")
+			})
+		})
+
+		Convey("When I remove the exclusion of a network that is not excluded, I should get an error", func() {
+			So(i.RemoveExcludedIP([]string{"10.0.0.0/8"}), ShouldNotBeNil)
+		})
+
+		Convey("When the rules of an exclusion cannot be deleted", func() {
+			So(i.AddExcludedIP([]string{"10.0.0.0/8"}), ShouldBeNil)
+			iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
+				if chain == "POSTROUTING" {
+					return fmt.Errorf("Error")
+				}
+				deleted = append(deleted, table+" "+chain+" "+strings.Join(rulespec, " "))
+				return nil
+			})
+
+			Convey("I should get an error and the network should stay excluded", func() {
+				So(i.RemoveExcludedIP([]string{"10.0.0.0/8"}), ShouldNotBeNil)
+				So(i.excludedIPs, ShouldResemble, []string{"10.0.0.0/8"})
+
+				Convey("When I retry, only the rules that are left should be deleted", func() {
+					iptables.MockExists(t, func(table string, chain string, rulespec ...string) (bool, error) {
+						return chain == "POSTROUTING", nil
+					})
+					iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
+						deleted = append(deleted, table+" "+chain+" "+strings.Join(rulespec, " "))
+						return nil
+					})
+					deleted = []string{}
+
+					So(i.RemoveExcludedIP([]string{"10.0.0.0/8"}), ShouldBeNil)
+					So(deleted, ShouldResemble, []string{"mangle POSTROUTING -s 10.0.0.0/8 -m comment --comment Excluded-IP -j ACCEPT"})
+					So(i.excludedIPs, ShouldBeEmpty)
+				})
+			})
+		})
+
+		Convey("When the rules cannot be inserted, I should get an error", func() {
+			iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+				return fmt.Errorf("Error")
+			})
+			So(i.AddExcludedIP([]string{"10.0.0.0/8"}), ShouldNotBeNil)
+			So(i.excludedIPs, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestExcludedIPLinuxProcesses(t *testing.T) {
+	Convey("Given an iptables controller for Linux processes", t, func() {
+		i, _ := NewInstance("0:1", "2:3", 0x1000, constants.LocalServer)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+		i.ip6t = nil
+
+		inserted := map[string][]string{}
+		iptables.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+			So(pos, ShouldEqual, 1)
+			inserted[table+" "+chain] = append(inserted[table+" "+chain], strings.Join(rulespec, " "))
+			return nil
+		})
+
+		Convey("When I exclude a network", func() {
+			So(i.AddExcludedIP([]string{"10.0.0.0/8"}), ShouldBeNil)
+
+			Convey("The exclusion should be inserted first in the chains of the cgroup rules", func() {
+				for _, rule := range i.cgroupChainRules("app", "net", "100", "80") {
+					So(inserted[rule[0]+" "+rule[1]], ShouldNotBeEmpty)
+				}
+			})
+		})
+	})
+}
+
+func TestRulesTransaction(t *testing.T) {
+	Convey("Given an iptables controller", t, func() {
+		i, _ := NewInstance("0:1", "2:3", 0x1000, constants.LocalContainer)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+		i.ip6t = nil
+
+		ipl := policy.NewIPMap(map[string]string{})
+		ipl.IPs[policy.DefaultNamespace] = "172.17.0.1"
+		policyrules := policy.NewPUPolicy("Context",
+			policy.Police,
+			policy.NewIPRuleList(nil),
+			policy.NewIPRuleList(nil),
+			nil,
+			nil,
+			nil,
+			nil, ipl, []string{"172.17.0.0/24"}, []string{}, nil)
+
+		containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+		containerinfo.Policy = policyrules
+		containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+		individualCalls := 0
+		iptables.MockAppend(t, func(table string, chain string, rulespec ...string) error {
+			individualCalls++
+			return nil
+		})
+		iptables.MockNewChain(t, func(table string, chain string) error {
+			individualCalls++
+			return nil
+		})
+		iptables.MockDelete(t, func(table string, chain string, rulespec ...string) error {
+			individualCalls++
+			return nil
+		})
+
+		Convey("When I configure the rules of a PU", func() {
+			restores := [][]byte{}
+			iptables.MockRestore(t, func(data []byte) error {
+				restores = append(restores, data)
+				return nil
+			})
+
+			err := i.ConfigureRules(1, "Context", containerinfo)
+
+			Convey("All the rules should be applied with one restore per table", func() {
+				So(err, ShouldBeNil)
+				So(individualCalls, ShouldEqual, 0)
+				So(len(restores), ShouldBeGreaterThan, 0)
+
+				all := ""
+				for _, data := range restores {
+					So(strings.Count(string(data), "COMMIT\n"), ShouldEqual, 1)
+					all += string(data)
+				}
+				So(all, ShouldContainSubstring, "*raw\n-N TRIREME-App-Context-1\n")
+				So(all, ShouldContainSubstring, "-A POSTROUTING -d 172.17.0.1 -m comment --comment Container-specific-chain -j TRIREME-Net-Context-1\n")
+			})
+		})
+
+		Convey("When I update the rules and the restore fails", func() {
+			iptables.MockRestore(t, func(data []byte) error {
+				return fmt.Errorf("error")
+			})
+
+			err := i.UpdateRules(1, "Context", containerinfo)
+
+			Convey("I should get an error and the previous version should not be touched", func() {
+				So(err, ShouldNotBeNil)
+				So(individualCalls, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestCheckRules(t *testing.T) {
+	Convey("Given an iptables controller with the rules of a PU", t, func() {
+		i, _ := NewInstance("0:1", "2:3", 0x1000, constants.LocalContainer)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+		i.ip6t = nil
+
+		ipl := policy.NewIPMap(map[string]string{})
+		ipl.IPs[policy.DefaultNamespace] = "172.17.0.1"
+		policyrules := policy.NewPUPolicy("Context",
+			policy.Police,
+			policy.NewIPRuleList([]policy.IPRule{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "443",
+					Protocol: "TCP",
+					Action:   policy.Accept,
+				},
+			}),
+			policy.NewIPRuleList(nil),
+			nil,
+			nil,
+			nil,
+			nil, ipl, []string{"172.17.0.0/24"}, []string{}, nil)
+
+		containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+		containerinfo.Policy = policyrules
+		containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+
+		// The live tables keep the rules that were restored
+		chains := map[string][]string{}
+		live := map[string][]string{}
+		iptables.MockRestore(t, func(data []byte) error {
+			table := ""
+			for _, line := range strings.Split(string(data), "\n") {
+				fields := strings.Fields(line)
+				switch {
+				case strings.HasPrefix(line, "*"):
+					table = line[1:]
+				case len(fields) > 1 && fields[0] == "-N":
+					chains[table] = append(chains[table], fields[1])
+					live[table+"/"+fields[1]] = []string{"-N " + fields[1]}
+				case len(fields) > 1 && (fields[0] == "-A" || fields[0] == "-I"):
+					live[table+"/"+fields[1]] = append(live[table+"/"+fields[1]], "-A "+fields[1])
+				}
+			}
+			return nil
+		})
+		iptables.MockListChains(t, func(table string) ([]string, error) {
+			return chains[table], nil
+		})
+		iptables.MockList(t, func(table, chain string) ([]string, error) {
+			return live[table+"/"+chain], nil
+		})
+
+		So(i.ConfigureRules(1, "Context", containerinfo), ShouldBeNil)
+
+		Convey("When the rules are intact, I should get no drift", func() {
+			drift, err := i.CheckRules(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldBeEmpty)
+		})
+
+		Convey("When a chain of the PU was flushed, I should get the drift", func() {
+			live["mangle/TRIREME-App-Context-1"] = live["mangle/TRIREME-App-Context-1"][:1]
+
+			drift, err := i.CheckRules(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(len(drift), ShouldEqual, 1)
+			So(drift[0], ShouldStartWith, "Chain TRIREME-App-Context-1 of table mangle has 0 rules")
+		})
+
+		Convey("When a chain of the PU was deleted, I should get the drift", func() {
+			chains["raw"] = []string{}
+
+			drift, err := i.CheckRules(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(drift, ShouldContain, "Chain TRIREME-App-Context-1 is missing from table raw")
+		})
+
+		Convey("When a rule that sends traffic to the PU chains was deleted, I should get the drift", func() {
+			iptables.MockExists(t, func(table, chain string, rulespec ...string) (bool, error) {
+				return chain != "POSTROUTING", nil
+			})
+
+			drift, err := i.CheckRules(1, "Context", containerinfo)
+			So(err, ShouldBeNil)
+			So(len(drift), ShouldEqual, 1)
+			So(drift[0], ShouldContainSubstring, "-j TRIREME-Net-Context-1 is missing from chain POSTROUTING of table mangle")
+		})
+
+		Convey("When the rules cannot be checked, I should get an error", func() {
+			iptables.MockExists(t, func(table, chain string, rulespec ...string) (bool, error) {
+				return false, fmt.Errorf("error")
+			})
+
+			_, err := i.CheckRules(1, "Context", containerinfo)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+// liveTables returns the rules of the chains of a test provider, that keeps the
+// rules that are restored, inserted and deleted
+func liveTables(t *testing.T, iptables provider.TestIptablesProvider) map[string][]string {
+
+	live := map[string][]string{}
+
+	insert := func(key string, pos int, rule string) {
+		rules := append([]string{}, live[key][:pos-1]...)
+		live[key] = append(append(rules, rule), live[key][pos-1:]...)
+	}
+
+	iptables.MockRestore(t, func(data []byte) error {
+		table := ""
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			switch {
+			case strings.HasPrefix(line, "*"):
+				table = line[1:]
+			case len(fields) > 1 && fields[0] == "-N":
+				live[table+"/"+fields[1]] = []string{}
+			case len(fields) > 1 && fields[0] == "-A":
+				live[table+"/"+fields[1]] = append(live[table+"/"+fields[1]], strings.Join(fields[2:], " "))
+			case len(fields) > 2 && fields[0] == "-I":
+				pos := 0
+				fmt.Sscanf(fields[2], "%d", &pos) // nolint
+				insert(table+"/"+fields[1], pos, strings.Join(fields[3:], " "))
+			}
+		}
+		return nil
+	})
+	iptables.MockInsert(t, func(table, chain string, pos int, rulespec ...string) error {
+		insert(table+"/"+chain, pos, strings.Join(rulespec, " "))
+		return nil
+	})
+	iptables.MockAppend(t, func(table, chain string, rulespec ...string) error {
+		live[table+"/"+chain] = append(live[table+"/"+chain], strings.Join(rulespec, " "))
+		return nil
+	})
+	iptables.MockDelete(t, func(table, chain string, rulespec ...string) error {
+		key := table + "/" + chain
+		for k, rule := range live[key] {
+			if rule == strings.Join(rulespec, " ") {
+				live[key] = append(live[key][:k:k], live[key][k+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("rule not found")
+	})
+	iptables.MockList(t, func(table, chain string) ([]string, error) {
+		rules := []string{"-N " + chain}
+		for _, rule := range live[table+"/"+chain] {
+			rules = append(rules, "-A "+chain+" "+rule)
+		}
+		return rules, nil
+	})
+
+	return live
+}
+
+func TestUpdateACLs(t *testing.T) {
+	Convey("Given an iptables controller with the rules of a PU", t, func() {
+		i, _ := NewInstance("0:1", "2:3", 0x1000, constants.LocalContainer)
+		iptables := provider.NewTestIptablesProvider()
+		i.ipt = iptables
+		i.ip6t = nil
+		live := liveTables(t, iptables)
+
+		ipl := policy.NewIPMap(map[string]string{policy.DefaultNamespace: "172.17.0.1"})
+		puInfo := func(appACLs []policy.IPRule) *policy.PUInfo {
+			containerinfo := policy.NewPUInfo("Context", constants.ContainerPU)
+			containerinfo.Policy = policy.NewPUPolicy("Context",
+				policy.Police,
+				policy.NewIPRuleList(appACLs),
+				policy.NewIPRuleList([]policy.IPRule{
+					{Address: "10.0.0.0/8", Port: "22", Protocol: "TCP", Action: policy.Accept, ID: "ssh"},
+				}),
+				nil, nil, nil, nil, ipl, []string{"172.17.0.0/24"}, []string{"10.1.0.0/16"}, nil)
+			containerinfo.Runtime = policy.NewPURuntimeWithDefaults()
+			return containerinfo
+		}
+
+		https := policy.IPRule{Address: "192.30.253.0/24", Port: "443", Protocol: "TCP", Action: policy.Accept, ID: "https"}
+		http := policy.IPRule{Address: "192.30.253.0/24", Port: "80", Protocol: "TCP", Action: policy.Reject, ID: "http"}
+		alt := policy.IPRule{Address: "192.30.253.0/24", Port: "8080", Protocol: "TCP", Action: policy.Accept | policy.Log, ID: "alt"}
+		smtp := policy.IPRule{Address: "0.0.0.0/0", Port: "25", Protocol: "TCP", Action: policy.Reject, ID: "smtp"}
+
+		previous := puInfo([]policy.IPRule{https, http})
+		So(i.ConfigureRules(1, "Context", previous), ShouldBeNil)
+		configured := append([]string{}, live["mangle/TRIREME-App-Context-1"]...)
+
+		Convey("When I update a few ACLs", func() {
+			updated := puInfo([]policy.IPRule{https, alt, smtp})
+			err := i.UpdateACLs(1, "Context", previous, updated)
+
+			Convey("Then the chains should have the rules of the new policy", func() {
+				So(err, ShouldBeNil)
+
+				expected, _ := NewInstance("0:1", "2:3", 0x1000, constants.LocalContainer)
+				expectedTables := provider.NewTestIptablesProvider()
+				expected.ipt = expectedTables
+				expected.ip6t = nil
+				expectedLive := liveTables(t, expectedTables)
+				So(expected.ConfigureRules(1, "Context", updated), ShouldBeNil)
+
+				So(live["mangle/TRIREME-App-Context-1"], ShouldResemble, expectedLive["mangle/TRIREME-App-Context-1"])
+				So(live["mangle/TRIREME-Net-Context-1"], ShouldResemble, expectedLive["mangle/TRIREME-Net-Context-1"])
+			})
+		})
+
+		Convey("When a rule cannot be deleted", func() {
+			live["mangle/TRIREME-App-Context-1"] = append([]string{}, configured...)
+			for k, rule := range live["mangle/TRIREME-App-Context-1"] {
+				if strings.Contains(rule, "--dport 80 ") {
+					live["mangle/TRIREME-App-Context-1"][k] = "-j DROP"
+				}
+			}
+			altered := append([]string{}, live["mangle/TRIREME-App-Context-1"]...)
+
+			err := i.UpdateACLs(1, "Context", previous, puInfo([]policy.IPRule{https, alt}))
+
+			Convey("Then I should get an error and the rules that were added should be removed", func() {
+				So(err, ShouldNotBeNil)
+				So(live["mangle/TRIREME-App-Context-1"], ShouldResemble, altered)
+			})
+		})
+
+		Convey("When too many ACLs change", func() {
+			rules := []policy.IPRule{}
+			for k := 0; k <= policy.MaxIncrementalChanges; k++ {
+				rules = append(rules, policy.IPRule{Address: "10.0.0.0/8", Port: fmt.Sprintf("%d", 1000+k), Protocol: "TCP", Action: policy.Accept})
+			}
+			err := i.UpdateACLs(1, "Context", previous, puInfo(rules))
+
+			Convey("Then I should get an error without any change", func() {
+				So(err, ShouldNotBeNil)
+				So(live["mangle/TRIREME-App-Context-1"], ShouldResemble, configured)
+			})
+		})
+	})
+}
@@ -70,6 +70,26 @@ func (_mr *_MockSupervisorRecorder) Stop() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Stop")
 }
 
+func (_m *MockSupervisor) AddExcludedIPs(ips []string) error {
+	ret := _m.ctrl.Call(_m, "AddExcludedIPs", ips)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSupervisorRecorder) AddExcludedIPs(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddExcludedIPs", arg0)
+}
+
+func (_m *MockSupervisor) RemoveExcludedIPs(ips []string) error {
+	ret := _m.ctrl.Call(_m, "RemoveExcludedIPs", ips)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockSupervisorRecorder) RemoveExcludedIPs(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoveExcludedIPs", arg0)
+}
+
 // Mock of Implementor interface
 type MockImplementor struct {
 	ctrl     *gomock.Controller
@@ -160,3 +180,140 @@ func (_m *MockImplementor) RemoveExcludedIP(ip []string) error {
 func (_mr *_MockImplementorRecorder) RemoveExcludedIP(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoveExcludedIP", arg0)
 }
+
+// Mock of Reconciler interface
+type MockReconciler struct {
+	ctrl     *gomock.Controller
+	recorder *_MockReconcilerRecorder
+}
+
+// Recorder for MockReconciler (not exported)
+type _MockReconcilerRecorder struct {
+	mock *MockReconciler
+}
+
+func NewMockReconciler(ctrl *gomock.Controller) *MockReconciler {
+	mock := &MockReconciler{ctrl: ctrl}
+	mock.recorder = &_MockReconcilerRecorder{mock}
+	return mock
+}
+
+func (_m *MockReconciler) EXPECT() *_MockReconcilerRecorder {
+	return _m.recorder
+}
+
+func (_m *MockReconciler) CheckRules(version int, contextID string, containerInfo *policy.PUInfo) ([]string, error) {
+	ret := _m.ctrl.Call(_m, "CheckRules", version, contextID, containerInfo)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockReconcilerRecorder) CheckRules(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CheckRules", arg0, arg1, arg2)
+}
+
+// Mock of ACLUpdater interface
+type MockACLUpdater struct {
+	ctrl     *gomock.Controller
+	recorder *_MockACLUpdaterRecorder
+}
+
+// Recorder for MockACLUpdater (not exported)
+type _MockACLUpdaterRecorder struct {
+	mock *MockACLUpdater
+}
+
+func NewMockACLUpdater(ctrl *gomock.Controller) *MockACLUpdater {
+	mock := &MockACLUpdater{ctrl: ctrl}
+	mock.recorder = &_MockACLUpdaterRecorder{mock}
+	return mock
+}
+
+func (_m *MockACLUpdater) EXPECT() *_MockACLUpdaterRecorder {
+	return _m.recorder
+}
+
+func (_m *MockACLUpdater) UpdateACLs(version int, contextID string, previous *policy.PUInfo, containerInfo *policy.PUInfo) error {
+	ret := _m.ctrl.Call(_m, "UpdateACLs", version, contextID, previous, containerInfo)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockACLUpdaterRecorder) UpdateACLs(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateACLs", arg0, arg1, arg2, arg3)
+}
+
+// Mock of Introspector interface
+type MockIntrospector struct {
+	ctrl     *gomock.Controller
+	recorder *_MockIntrospectorRecorder
+}
+
+// Recorder for MockIntrospector (not exported)
+type _MockIntrospectorRecorder struct {
+	mock *MockIntrospector
+}
+
+func NewMockIntrospector(ctrl *gomock.Controller) *MockIntrospector {
+	mock := &MockIntrospector{ctrl: ctrl}
+	mock.recorder = &_MockIntrospectorRecorder{mock}
+	return mock
+}
+
+func (_m *MockIntrospector) EXPECT() *_MockIntrospectorRecorder {
+	return _m.recorder
+}
+
+func (_m *MockIntrospector) PUState(contextID string) (*policy.SupervisorState, error) {
+	ret := _m.ctrl.Call(_m, "PUState", contextID)
+	ret0, _ := ret[0].(*policy.SupervisorState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockIntrospectorRecorder) PUState(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PUState", arg0)
+}
+
+func (_m *MockIntrospector) PUStates() ([]*policy.SupervisorState, error) {
+	ret := _m.ctrl.Call(_m, "PUStates")
+	ret0, _ := ret[0].([]*policy.SupervisorState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockIntrospectorRecorder) PUStates() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PUStates")
+}
+
+// Mock of ChainNamer interface
+type MockChainNamer struct {
+	ctrl     *gomock.Controller
+	recorder *_MockChainNamerRecorder
+}
+
+// Recorder for MockChainNamer (not exported)
+type _MockChainNamerRecorder struct {
+	mock *MockChainNamer
+}
+
+func NewMockChainNamer(ctrl *gomock.Controller) *MockChainNamer {
+	mock := &MockChainNamer{ctrl: ctrl}
+	mock.recorder = &_MockChainNamerRecorder{mock}
+	return mock
+}
+
+func (_m *MockChainNamer) EXPECT() *_MockChainNamerRecorder {
+	return _m.recorder
+}
+
+func (_m *MockChainNamer) ChainNames(version int, contextID string) []string {
+	ret := _m.ctrl.Call(_m, "ChainNames", version, contextID)
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+func (_mr *_MockChainNamerRecorder) ChainNames(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ChainNames", arg0, arg1)
+}
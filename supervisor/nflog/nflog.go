@@ -0,0 +1,23 @@
+// +build !linux
+
+package nflog
+
+import "fmt"
+
+// NFLogger receives the packets of an NFLOG group. It is only available on Linux.
+type NFLogger struct{}
+
+// NewNFLogger returns an NFLogger that calls the handler for every packet of the group
+func NewNFLogger(group uint16, handler Handler) *NFLogger {
+	return &NFLogger{}
+}
+
+// Start is not supported on this platform
+func (n *NFLogger) Start() error {
+	return fmt.Errorf("NFLOG is only supported on Linux")
+}
+
+// Stop is not supported on this platform
+func (n *NFLogger) Stop() error {
+	return nil
+}
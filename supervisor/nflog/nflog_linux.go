@@ -0,0 +1,282 @@
+// +build linux
+
+package nflog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Netfilter netlink subsystem and message types of NFLOG
+	nfnlSubsysULOG  = 4
+	nfulnlMsgPacket = 0
+	nfulnlMsgConfig = 1
+
+	// Configuration attributes and commands
+	nfulaCfgCmd       = 1
+	nfulaCfgMode      = 2
+	nfulnlCfgCmdBind  = 1
+	nfulnlCopyPacket  = 2
+	nfulnlCopyRange   = 0xffff
+	nfgenMsgLen       = 4
+	netlinkAttrHdrLen = 4
+
+	// Packet attributes
+	nfulaPayload = 9
+	nfulaPrefix  = 10
+
+	// nlaTypeMask removes the nested and byte order flags from the attribute type
+	nlaTypeMask = 0x3fff
+
+	// receiveRetryInterval is the time to wait after an unexpected error of the socket
+	receiveRetryInterval = 100 * time.Millisecond
+)
+
+// nativeEndian is the byte order of the netlink headers
+var nativeEndian binary.ByteOrder
+
+func init() {
+	i := uint16(1)
+	if *(*byte)(unsafe.Pointer(&i)) == 1 {
+		nativeEndian = binary.LittleEndian
+	} else {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// NFLogger receives the packets of an NFLOG group through a netlink socket
+type NFLogger struct {
+	group   uint16
+	handler Handler
+	fd      int
+	seq     uint32
+	stopped bool
+	sync.Mutex
+}
+
+// NewNFLogger returns an NFLogger that calls the handler for every packet of the group
+func NewNFLogger(group uint16, handler Handler) *NFLogger {
+
+	return &NFLogger{
+		group:   group,
+		handler: handler,
+		fd:      -1,
+	}
+}
+
+// Start binds to the NFLOG group and starts processing packets
+func (n *NFLogger) Start() error {
+
+	n.Lock()
+	defer n.Unlock()
+
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER)
+	if err != nil {
+		return fmt.Errorf("Unable to open netlink socket: %s", err)
+	}
+
+	n.fd = fd
+	n.stopped = false
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		n.close()
+		return fmt.Errorf("Unable to bind netlink socket: %s", err)
+	}
+
+	if err := n.configure(nfulaCfgCmd, []byte{nfulnlCfgCmdBind}); err != nil {
+		n.close()
+		return fmt.Errorf("Unable to bind to nflog group %d: %s", n.group, err)
+	}
+
+	mode := make([]byte, 6)
+	binary.BigEndian.PutUint32(mode, nfulnlCopyRange)
+	mode[4] = nfulnlCopyPacket
+	if err := n.configure(nfulaCfgMode, mode); err != nil {
+		n.close()
+		return fmt.Errorf("Unable to set the copy mode of nflog group %d: %s", n.group, err)
+	}
+
+	go n.receive(fd)
+
+	return nil
+}
+
+// Stop closes the netlink socket
+func (n *NFLogger) Stop() error {
+
+	n.Lock()
+	defer n.Unlock()
+
+	n.stopped = true
+	n.close()
+
+	return nil
+}
+
+// close closes the socket. It must be called with the lock held
+func (n *NFLogger) close() {
+
+	if n.fd < 0 {
+		return
+	}
+
+	if err := syscall.Close(n.fd); err != nil {
+		zap.L().Warn("Failed to close nflog socket", zap.Error(err))
+	}
+
+	n.fd = -1
+}
+
+// configure sends a configuration attribute for the group and waits for the acknowledgement
+func (n *NFLogger) configure(attrType uint16, value []byte) error {
+
+	n.seq++
+
+	attrLen := netlinkAttrHdrLen + len(value)
+	msgLen := syscall.NLMSG_HDRLEN + nfgenMsgLen + align(attrLen)
+	msg := make([]byte, msgLen)
+
+	nativeEndian.PutUint32(msg[0:4], uint32(msgLen))
+	nativeEndian.PutUint16(msg[4:6], nfnlSubsysULOG<<8|nfulnlMsgConfig)
+	nativeEndian.PutUint16(msg[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	nativeEndian.PutUint32(msg[8:12], n.seq)
+
+	// nfgenmsg: family, version and the group as resource ID
+	msg[syscall.NLMSG_HDRLEN] = syscall.AF_UNSPEC
+	binary.BigEndian.PutUint16(msg[syscall.NLMSG_HDRLEN+2:], n.group)
+
+	attr := msg[syscall.NLMSG_HDRLEN+nfgenMsgLen:]
+	nativeEndian.PutUint16(attr[0:2], uint16(attrLen))
+	nativeEndian.PutUint16(attr[2:4], attrType)
+	copy(attr[netlinkAttrHdrLen:], value)
+
+	if err := syscall.Sendto(n.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, syscall.Getpagesize())
+	size, _, err := syscall.Recvfrom(n.fd, buf, 0)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(buf[:size])
+	if err != nil {
+		return err
+	}
+
+	for _, m := range msgs {
+		if m.Header.Type != syscall.NLMSG_ERROR || len(m.Data) < 4 {
+			continue
+		}
+		if errno := int32(nativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+			return syscall.Errno(-errno)
+		}
+	}
+
+	return nil
+}
+
+// receive processes the packets of the group until the logger is stopped.
+// The kernel drops the packets that do not fit in the buffer of the socket
+// and reports it with ENOBUFS, after which the socket is still usable.
+func (n *NFLogger) receive(fd int) {
+
+	buf := make([]byte, 65536)
+
+	for {
+		size, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if n.closed(fd) {
+				return
+			}
+
+			switch err {
+			case syscall.EINTR:
+			case syscall.ENOBUFS:
+				zap.L().Warn("Lost nflog packets", zap.Uint16("group", n.group))
+			default:
+				zap.L().Error("Failed to receive nflog packets", zap.Error(err))
+				time.Sleep(receiveRetryInterval)
+			}
+			continue
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:size])
+		if err != nil {
+			zap.L().Debug("Invalid nflog message", zap.Error(err))
+			continue
+		}
+
+		for _, m := range msgs {
+			if m.Header.Type != nfnlSubsysULOG<<8|nfulnlMsgPacket || len(m.Data) < nfgenMsgLen {
+				continue
+			}
+
+			prefix, payload := parseAttributes(m.Data[nfgenMsgLen:])
+			n.handler(prefix, payload)
+		}
+	}
+}
+
+// closed returns true if the socket was closed by Stop, including when the
+// logger was started again with a new socket
+func (n *NFLogger) closed(fd int) bool {
+
+	n.Lock()
+	defer n.Unlock()
+
+	return n.stopped || n.fd != fd
+}
+
+// parseAttributes returns the prefix and the payload attributes of a packet message
+func parseAttributes(data []byte) (prefix string, payload []byte) {
+
+	for len(data) >= netlinkAttrHdrLen {
+		attrLen := int(nativeEndian.Uint16(data[0:2]))
+		attrType := nativeEndian.Uint16(data[2:4]) & nlaTypeMask
+		if attrLen < netlinkAttrHdrLen || attrLen > len(data) {
+			return
+		}
+
+		value := data[netlinkAttrHdrLen:attrLen]
+		switch attrType {
+		case nfulaPrefix:
+			prefix = cString(value)
+		case nfulaPayload:
+			payload = make([]byte, len(value))
+			copy(payload, value)
+		}
+
+		if align(attrLen) >= len(data) {
+			return
+		}
+		data = data[align(attrLen):]
+	}
+
+	return
+}
+
+// cString converts a null terminated buffer to a string
+func cString(b []byte) string {
+
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+
+	return string(b)
+}
+
+// align rounds the length of a netlink attribute to 4 bytes
+func align(length int) int {
+	return (length + syscall.NLMSG_ALIGNTO - 1) & ^(syscall.NLMSG_ALIGNTO - 1)
+}
@@ -0,0 +1,42 @@
+// +build linux
+
+package nflog
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// attribute encodes a netlink attribute with its padding
+func attribute(attrType uint16, value []byte) []byte {
+
+	b := make([]byte, align(netlinkAttrHdrLen+len(value)))
+	nativeEndian.PutUint16(b[0:2], uint16(netlinkAttrHdrLen+len(value)))
+	nativeEndian.PutUint16(b[2:4], attrType)
+	copy(b[netlinkAttrHdrLen:], value)
+
+	return b
+}
+
+func TestParseAttributes(t *testing.T) {
+
+	Convey("Given the attributes of an nflog packet message", t, func() {
+
+		data := attribute(1, []byte{0x08, 0x00, 0x01, 0x00})
+		data = append(data, attribute(nfulaPrefix, []byte("context:rule:A\x00"))...)
+		data = append(data, attribute(nfulaPayload, []byte{0x45, 0x00, 0x00})...)
+
+		Convey("I should get the prefix and the payload", func() {
+			prefix, payload := parseAttributes(data)
+			So(prefix, ShouldEqual, "context:rule:A")
+			So(payload, ShouldResemble, []byte{0x45, 0x00, 0x00})
+		})
+
+		Convey("A truncated message should not return the attributes after the truncation", func() {
+			prefix, payload := parseAttributes(data[:len(data)-4])
+			So(prefix, ShouldEqual, "context:rule:A")
+			So(payload, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,98 @@
+// Package nflog captures the packets logged by the NFLOG targets of the ACL
+// rules and decodes the prefix that identifies the PU and the rule.
+package nflog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/policy"
+)
+
+const (
+	// Group is the NFLOG group used by the ACL rules with the Log action
+	Group = 10
+
+	// DefaultRuleID identifies the rule that drops the packets that no ACL accepts
+	DefaultRuleID = "default"
+
+	// maxPrefixLen is the maximum length of an NFLOG prefix without the terminating null
+	maxPrefixLen = 63
+
+	// acceptPrefix identifies logged packets of accept rules
+	acceptPrefix = "A"
+	// rejectPrefix identifies logged packets of reject rules
+	rejectPrefix = "R"
+	// auditPrefix identifies logged packets of reject rules of PUs in audit mode
+	auditPrefix = "U"
+)
+
+// Handler is called for every packet received from the NFLOG group
+type Handler func(prefix string, payload []byte)
+
+// GroupString returns the NFLOG group as an iptables argument
+func GroupString() string {
+	return strconv.Itoa(Group)
+}
+
+// Prefix returns the NFLOG prefix of a rule in the format contextID:ruleID:action.
+// The rule ID is truncated if the prefix exceeds the maximum length.
+func Prefix(contextID string, ruleID string, action policy.FlowAction) string {
+
+	actionPrefix := acceptPrefix
+	if action&policy.Accept == 0 {
+		actionPrefix = rejectPrefix
+	}
+
+	return prefix(contextID, ruleID, actionPrefix)
+}
+
+// AuditPrefix returns the NFLOG prefix of a reject rule of a PU in audit mode.
+// The packets are accepted after they are logged.
+func AuditPrefix(contextID string, ruleID string) string {
+
+	return prefix(contextID, ruleID, auditPrefix)
+}
+
+// prefix returns the NFLOG prefix with the given action prefix
+func prefix(contextID string, ruleID string, actionPrefix string) string {
+
+	if available := maxPrefixLen - len(contextID) - len(actionPrefix) - 2; len(ruleID) > available {
+		if available < 0 {
+			available = 0
+		}
+		ruleID = ruleID[:available]
+	}
+
+	return contextID + ":" + ruleID + ":" + actionPrefix
+}
+
+// ParsePrefix returns the context ID, the rule ID and the action encoded in a
+// prefix. The action of an audit prefix is Reject.
+func ParsePrefix(prefix string) (contextID string, ruleID string, action policy.FlowAction, err error) {
+
+	first := strings.Index(prefix, ":")
+	last := strings.LastIndex(prefix, ":")
+	if first <= 0 || first == last {
+		return "", "", 0, fmt.Errorf("Invalid prefix %s", prefix)
+	}
+
+	switch prefix[last+1:] {
+	case acceptPrefix:
+		action = policy.Accept
+	case rejectPrefix, auditPrefix:
+		action = policy.Reject
+	default:
+		return "", "", 0, fmt.Errorf("Invalid action in prefix %s", prefix)
+	}
+
+	return prefix[:first], prefix[first+1 : last], action, nil
+}
+
+// IsAuditPrefix returns true if the prefix is the one of a reject rule of a PU
+// in audit mode
+func IsAuditPrefix(prefix string) bool {
+
+	return strings.HasSuffix(prefix, ":"+auditPrefix)
+}
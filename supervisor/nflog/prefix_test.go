@@ -0,0 +1,76 @@
+package nflog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/policy"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPrefix(t *testing.T) {
+
+	Convey("Given a context ID and a rule ID", t, func() {
+
+		Convey("When I create the prefix of an accept rule", func() {
+			prefix := Prefix("context", "rule:1", policy.Accept|policy.Log)
+
+			Convey("I should be able to parse it back", func() {
+				So(prefix, ShouldEqual, "context:rule:1:A")
+
+				contextID, ruleID, action, err := ParsePrefix(prefix)
+				So(err, ShouldBeNil)
+				So(contextID, ShouldEqual, "context")
+				So(ruleID, ShouldEqual, "rule:1")
+				So(action, ShouldEqual, policy.Accept)
+			})
+		})
+
+		Convey("When I create the prefix of a reject rule", func() {
+			prefix := Prefix("context", "2", policy.Reject|policy.Log)
+
+			Convey("I should get the reject action back", func() {
+				_, ruleID, action, err := ParsePrefix(prefix)
+				So(err, ShouldBeNil)
+				So(ruleID, ShouldEqual, "2")
+				So(action, ShouldEqual, policy.Reject)
+			})
+		})
+
+		Convey("When I create the audit prefix of a reject rule", func() {
+			prefix := AuditPrefix("context", "3")
+
+			Convey("I should get the reject action back and recognize the audit prefix", func() {
+				So(prefix, ShouldEqual, "context:3:U")
+				So(IsAuditPrefix(prefix), ShouldBeTrue)
+				So(IsAuditPrefix(Prefix("context", "3", policy.Reject)), ShouldBeFalse)
+
+				contextID, ruleID, action, err := ParsePrefix(prefix)
+				So(err, ShouldBeNil)
+				So(contextID, ShouldEqual, "context")
+				So(ruleID, ShouldEqual, "3")
+				So(action, ShouldEqual, policy.Reject)
+			})
+		})
+
+		Convey("When the rule ID is too long", func() {
+			prefix := Prefix("context", strings.Repeat("x", 100), policy.Accept)
+
+			Convey("It should be truncated to fit in the prefix", func() {
+				So(len(prefix), ShouldEqual, maxPrefixLen)
+
+				contextID, _, action, err := ParsePrefix(prefix)
+				So(err, ShouldBeNil)
+				So(contextID, ShouldEqual, "context")
+				So(action, ShouldEqual, policy.Accept)
+			})
+		})
+	})
+
+	Convey("Given invalid prefixes, I should get errors", t, func() {
+		for _, prefix := range []string{"", "context", "context:A", ":rule:A", "context:rule:X"} {
+			_, _, _, err := ParsePrefix(prefix)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,424 @@
+// Package nftablesctrl implements the supervisor with nftables. All the rules are
+// programmed in one table with a chain for each PU. Every change of the rules is
+// applied as one atomic transaction.
+package nftablesctrl
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+)
+
+const (
+	table = "inet trireme"
+
+	// Base chains and the chains of the PUs
+	appChain = "app"
+	netChain = "net"
+	rawChain = "app-raw"
+
+	// Priorities of the base chains, the same as the mangle and raw tables
+	manglePriority = -150
+	rawPriority    = -300
+
+	// Dispatch maps of the Linux processes
+	appCgroupMap = "app-cgroups"
+	netPortMap   = "net-ports"
+
+	// Set of the networks excluded on the whole node
+	nodeExcludedSet = "node-excluded"
+
+	// Sets and maps of the PUs
+	networkSet   = "nets"
+	excludedSet  = "excluded"
+	appAcceptMap = "app-accept"
+	appRejectMap = "app-reject"
+	netAcceptMap = "net-accept"
+	netRejectMap = "net-reject"
+
+	ipv4AnyNetwork = "0.0.0.0/0"
+	ipv6AnyNetwork = "::/0"
+)
+
+var aclMaps = []string{appAcceptMap, appRejectMap, netAcceptMap, netRejectMap}
+
+// dispatchElement is an element of a map that sends the packets of a PU from a
+// base chain to the chain of the PU
+type dispatchElement struct {
+	dispatchMap string
+	key         string
+	chain       string
+}
+
+// Instance is the structure holding all information about a implementation
+type Instance struct {
+	networkQueues     string
+	applicationQueues string
+	mark              int
+	nft               provider.NftablesProvider
+	appHook           string
+	netHook           string
+	mode              constants.ModeType
+
+	// elements are the dispatch elements of the active version of the PUs
+	elements map[string][]dispatchElement
+
+	// excludedIPs are the networks excluded on the whole node
+	excludedIPs []string
+	sync.Mutex
+}
+
+// NewInstance creates a new nftables controller instance
+func NewInstance(networkQueues, applicationQueues string, mark int, mode constants.ModeType) (*Instance, error) {
+
+	nft, err := provider.NewNftProvider()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot initialize nftables provider: %s", err)
+	}
+
+	return newInstance(nft, networkQueues, applicationQueues, mark, mode), nil
+}
+
+// newInstance creates an instance that programs the rules with the given provider
+func newInstance(nft provider.NftablesProvider, networkQueues, applicationQueues string, mark int, mode constants.ModeType) *Instance {
+
+	i := &Instance{
+		networkQueues:     nftRange(networkQueues),
+		applicationQueues: nftRange(applicationQueues),
+		mark:              mark,
+		nft:               nft,
+		mode:              mode,
+		elements:          map[string][]dispatchElement{},
+	}
+
+	if mode == constants.LocalContainer {
+		i.appHook = "prerouting"
+		i.netHook = "postrouting"
+	} else {
+		i.appHook = "output"
+		i.netHook = "input"
+	}
+
+	return i
+}
+
+// dispatchElements returns the elements that send the packets of a PU to the
+// chains of a version of its policy
+func (i *Instance) dispatchElements(contextID string, version int, ipAddresses *policy.IPMap, networks []string, port string, mark string) ([]dispatchElement, error) {
+
+	app := objectName(appChain, contextID, version)
+	net := objectName(netChain, contextID, version)
+
+	elements := []dispatchElement{}
+
+	if i.mode == constants.LocalServer {
+		if mark != "" {
+			elements = append(elements, dispatchElement{dispatchMap: appCgroupMap, key: mark, chain: app})
+		}
+
+		if port != "" {
+			for _, p := range nftPorts(port) {
+				if p == "0" {
+					continue
+				}
+				elements = append(elements, dispatchElement{dispatchMap: netPortMap, key: p, chain: net})
+			}
+		}
+
+		return elements, nil
+	}
+
+	addresses := map[family]string{}
+
+	if ip, ok := ipAddresses.IPs[policy.DefaultNamespace]; ok && len(ip) > 0 {
+		addresses[ipv4Family] = ip
+	} else if i.mode != constants.LocalContainer {
+		addresses[ipv4Family] = ipv4AnyNetwork
+	} else {
+		return nil, fmt.Errorf("No ip address found")
+	}
+
+	// PUs that are not local containers match all the IPv6 addresses if any
+	// of the Trireme networks is an IPv6 one
+	if ip, ok := ipAddresses.IPs[policy.DefaultIPv6Namespace]; ok && len(ip) > 0 {
+		addresses[ipv6Family] = ip
+	} else if i.mode != constants.LocalContainer && len(ipv6Family.filter(networks)) > 0 {
+		addresses[ipv6Family] = ipv6AnyNetwork
+	}
+
+	for _, f := range families {
+		ip, ok := addresses[f]
+		if !ok {
+			continue
+		}
+
+		elements = append(elements,
+			dispatchElement{dispatchMap: f.dispatchMap(appChain), key: ip, chain: app},
+			dispatchElement{dispatchMap: f.dispatchMap(netChain), key: ip, chain: net},
+		)
+
+		if i.mode == constants.LocalContainer {
+			elements = append(elements, dispatchElement{dispatchMap: f.dispatchMap(rawChain), key: ip, chain: objectName(rawChain, contextID, version)})
+		}
+	}
+
+	return elements, nil
+}
+
+// puElements returns the dispatch elements of the PU for a version of the policy
+func (i *Instance) puElements(contextID string, version int, containerInfo *policy.PUInfo) ([]dispatchElement, error) {
+
+	mark, _ := containerInfo.Runtime.Options().Get(cgnetcls.CgroupMarkTag)
+	port, ok := containerInfo.Runtime.Options().Get(cgnetcls.PortTag)
+	if !ok {
+		port = "0"
+	}
+
+	return i.dispatchElements(contextID, version, containerInfo.Policy.IPAddresses(), containerInfo.Policy.TriremeNetworks(), port, mark)
+}
+
+// ChainNames returns the names of the chains of a version of the PU
+func (i *Instance) ChainNames(version int, contextID string) []string {
+
+	chains := []string{objectName(appChain, contextID, version), objectName(netChain, contextID, version)}
+
+	if i.mode == constants.LocalContainer {
+		chains = append(chains, objectName(rawChain, contextID, version))
+	}
+
+	return chains
+}
+
+// addDispatch adds the commands that add the dispatch elements
+func addDispatch(s *script, elements []dispatchElement) {
+
+	for _, e := range elements {
+		s.add("add element %s %s { %s : jump %s }", table, e.dispatchMap, e.key, e.chain)
+	}
+}
+
+// removeDispatch adds the commands that remove the dispatch elements
+func removeDispatch(s *script, elements []dispatchElement) {
+
+	for _, e := range elements {
+		s.add("delete element %s %s { %s }", table, e.dispatchMap, e.key)
+	}
+}
+
+// ConfigureRules implmenets the ConfigureRules interface
+func (i *Instance) ConfigureRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	if containerInfo == nil || containerInfo.Policy == nil || containerInfo.Runtime == nil {
+		return fmt.Errorf("Container info cannot be nil")
+	}
+
+	elements, err := i.puElements(contextID, version, containerInfo)
+	if err != nil {
+		return err
+	}
+
+	s := &script{}
+	i.addPUObjects(s, contextID, version, containerInfo)
+	addDispatch(s, elements)
+
+	if err := i.nft.Apply(s.String()); err != nil {
+		return fmt.Errorf("Failed to configure rules of %s: %s", contextID, err)
+	}
+
+	i.Lock()
+	i.elements[contextID] = elements
+	i.Unlock()
+
+	return nil
+}
+
+// UpdateRules implements the update part of the interface. The chains of the new
+// version replace the chains of the previous version in the same transaction.
+func (i *Instance) UpdateRules(version int, contextID string, containerInfo *policy.PUInfo) error {
+
+	if containerInfo == nil || containerInfo.Policy == nil || containerInfo.Runtime == nil {
+		return fmt.Errorf("Container info cannot be nil")
+	}
+
+	elements, err := i.puElements(contextID, version, containerInfo)
+	if err != nil {
+		return err
+	}
+
+	i.Lock()
+	previous, ok := i.elements[contextID]
+	i.Unlock()
+
+	if !ok {
+		if previous, err = i.puElements(contextID, version-1, containerInfo); err != nil {
+			return err
+		}
+	}
+
+	s := &script{}
+	i.addPUObjects(s, contextID, version, containerInfo)
+	removeDispatch(s, previous)
+	addDispatch(s, elements)
+	i.removePUObjects(s, contextID, version-1)
+
+	if err := i.nft.Apply(s.String()); err != nil {
+		return fmt.Errorf("Failed to update rules of %s: %s", contextID, err)
+	}
+
+	i.Lock()
+	i.elements[contextID] = elements
+	i.Unlock()
+
+	return nil
+}
+
+// DeleteRules implements the DeleteRules interface
+func (i *Instance) DeleteRules(version int, contextID string, ipAddresses *policy.IPMap, port string, mark string) error {
+
+	i.Lock()
+	elements, ok := i.elements[contextID]
+	delete(i.elements, contextID)
+	i.Unlock()
+
+	if !ok {
+		var err error
+		if elements, err = i.dispatchElements(contextID, version, ipAddresses, []string{}, port, mark); err != nil {
+			return err
+		}
+	}
+
+	s := &script{}
+	removeDispatch(s, elements)
+	i.removePUObjects(s, contextID, version)
+
+	if err := i.nft.Apply(s.String()); err != nil {
+		return fmt.Errorf("Failed to delete rules of %s: %s", contextID, err)
+	}
+
+	return nil
+}
+
+// AddExcludedIP excludes the IP addresses or networks from the enforcement on the
+// whole node. They are added to the set that the base chains accept first.
+func (i *Instance) AddExcludedIP(ipList []string) error {
+
+	i.Lock()
+	defer i.Unlock()
+
+	added := []string{}
+	for _, ip := range ipList {
+		if !contains(i.excludedIPs, ip) && !contains(added, ip) {
+			added = append(added, ip)
+		}
+	}
+
+	s := &script{}
+	for _, f := range families {
+		s.addElements(f.globalName(nodeExcludedSet), f.filter(added))
+	}
+
+	if len(s.commands) == 0 {
+		return nil
+	}
+
+	if err := i.nft.Apply(s.String()); err != nil {
+		return fmt.Errorf("Failed to exclude %s: %s", strings.Join(added, ", "), err)
+	}
+
+	i.excludedIPs = append(i.excludedIPs, added...)
+
+	return nil
+}
+
+// RemoveExcludedIP removes the exclusion of the IP addresses or networks
+func (i *Instance) RemoveExcludedIP(ipList []string) error {
+
+	i.Lock()
+	defer i.Unlock()
+
+	for _, ip := range ipList {
+		if !contains(i.excludedIPs, ip) {
+			return fmt.Errorf("%s is not excluded", ip)
+		}
+	}
+
+	s := &script{}
+	for _, f := range families {
+		if elements := f.filter(ipList); len(elements) > 0 {
+			s.add("delete element %s %s { %s }", table, f.globalName(nodeExcludedSet), strings.Join(elements, ", "))
+		}
+	}
+
+	if len(s.commands) == 0 {
+		return nil
+	}
+
+	if err := i.nft.Apply(s.String()); err != nil {
+		return fmt.Errorf("Failed to remove the exclusion of %s: %s", strings.Join(ipList, ", "), err)
+	}
+
+	excluded := []string{}
+	for _, ip := range i.excludedIPs {
+		if !contains(ipList, ip) {
+			excluded = append(excluded, ip)
+		}
+	}
+	i.excludedIPs = excluded
+
+	return nil
+}
+
+// contains returns true if the list has the value
+func contains(list []string, value string) bool {
+
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Start creates the table with the base chains. Any rules of a previous
+// instance are removed.
+func (i *Instance) Start() error {
+
+	i.Lock()
+	defer i.Unlock()
+
+	if err := i.nft.Apply(i.baseChains().String()); err != nil {
+		return fmt.Errorf("Failed to create the nftables table: %s", err)
+	}
+
+	zap.L().Debug("Started the nftables controller")
+
+	return nil
+}
+
+// Stop removes the table with all the rules
+func (i *Instance) Stop() error {
+
+	zap.L().Debug("Stop the nftables controller")
+
+	s := &script{}
+	s.add("add table %s", table)
+	s.add("delete table %s", table)
+
+	if err := i.nft.Apply(s.String()); err != nil {
+		zap.L().Error("Failed to clean the nftables table while stopping the supervisor", zap.Error(err))
+	}
+
+	i.Lock()
+	i.elements = map[string][]dispatchElement{}
+	i.Unlock()
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package nftablesctrl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func createPUInfo(ips map[string]string) *policy.PUInfo {
+
+	rules := policy.NewIPRuleList([]policy.IPRule{
+		policy.IPRule{
+			Address:  "192.30.253.0/24",
+			Port:     "80",
+			Protocol: "TCP",
+			Action:   policy.Reject,
+		},
+
+		policy.IPRule{
+			Address:  "192.30.253.0/24",
+			Port:     "443",
+			Protocol: "TCP",
+			Action:   policy.Accept,
+		},
+	})
+
+	ipMap := policy.NewIPMap(ips)
+
+	runtime := policy.NewPURuntimeWithDefaults()
+	runtime.SetIPAddresses(ipMap)
+	plc := policy.NewPUPolicy("context", policy.Police, rules, rules, nil, nil, nil, nil, ipMap, []string{"172.17.0.0/24", "fd00::/64"}, []string{"10.10.10.0/24"}, nil)
+
+	return policy.PUInfoFromPolicyAndRuntime("context", plc, runtime)
+}
+
+func TestNewInstance(t *testing.T) {
+
+	Convey("When I create a new instance for containers", t, func() {
+		i := newInstance(provider.NewTestNftablesProvider(), "0:1", "2:3", 0x1000, constants.LocalContainer)
+
+		Convey("It should hook the chains of the container traffic", func() {
+			So(i.networkQueues, ShouldEqual, "0-1")
+			So(i.applicationQueues, ShouldEqual, "2-3")
+			So(i.appHook, ShouldEqual, "prerouting")
+			So(i.netHook, ShouldEqual, "postrouting")
+		})
+
+		Convey("It should name the raw chain of the PUs", func() {
+			So(i.ChainNames(1, "pu1"), ShouldResemble, []string{"app-pu1-1", "net-pu1-1", "app-raw-pu1-1"})
+		})
+	})
+
+	Convey("When I create a new instance for Linux processes", t, func() {
+		i := newInstance(provider.NewTestNftablesProvider(), "0:1", "2:3", 0x1000, constants.LocalServer)
+
+		Convey("It should hook the chains of the local traffic", func() {
+			So(i.appHook, ShouldEqual, "output")
+			So(i.netHook, ShouldEqual, "input")
+		})
+
+		Convey("It should not name a raw chain for the PUs", func() {
+			So(i.ChainNames(1, "pu1"), ShouldResemble, []string{"app-pu1-1", "net-pu1-1"})
+		})
+	})
+}
+
+func TestStartStop(t *testing.T) {
+
+	Convey("Given an nftables controller", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(nft, "0:1", "2:3", 0x1000, constants.LocalContainer)
+
+		var scripts []string
+		nft.MockApply(t, func(script string) error {
+			scripts = append(scripts, script)
+			return nil
+		})
+
+		Convey("When I start it, the table should be recreated in one transaction", func() {
+			So(i.Start(), ShouldBeNil)
+			So(len(scripts), ShouldEqual, 1)
+			So(scripts[0], ShouldStartWith, "add table inet trireme\ndelete table inet trireme\nadd table inet trireme\n")
+			So(scripts[0], ShouldContainSubstring, "add chain inet trireme app { type filter hook prerouting priority -150; policy accept; }")
+			So(scripts[0], ShouldContainSubstring, "add chain inet trireme app-raw { type filter hook prerouting priority -300; policy accept; }")
+			So(scripts[0], ShouldContainSubstring, "add rule inet trireme app meta mark 4096 accept")
+			So(scripts[0], ShouldContainSubstring, "add rule inet trireme app ip6 saddr vmap @app-dispatch6")
+		})
+
+		Convey("When I stop it, the table should be deleted", func() {
+			So(i.Stop(), ShouldBeNil)
+			So(scripts, ShouldResemble, []string{"add table inet trireme\ndelete table inet trireme\n"})
+		})
+
+		Convey("When the table cannot be created, I should get an error", func() {
+			nft.MockApply(t, func(script string) error {
+				return fmt.Errorf("error")
+			})
+			So(i.Start(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestExcludedIP(t *testing.T) {
+
+	Convey("Given an nftables controller", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(nft, "0:1", "2:3", 0x1000, constants.LocalContainer)
+
+		var scripts []string
+		nft.MockApply(t, func(script string) error {
+			scripts = append(scripts, script)
+			return nil
+		})
+
+		Convey("When I exclude an IPv4 and an IPv6 network", func() {
+			So(i.AddExcludedIP([]string{"10.0.0.0/8", "fd00::/64"}), ShouldBeNil)
+
+			Convey("They should be added to the sets of their family in one transaction", func() {
+				So(scripts, ShouldResemble, []string{
+					"add element inet trireme node-excluded { 10.0.0.0/8 }\nadd element inet trireme node-excluded6 { fd00::/64 }\n",
+				})
+			})
+
+			Convey("When I exclude the same network again, nothing should be applied", func() {
+				scripts = nil
+				So(i.AddExcludedIP([]string{"10.0.0.0/8"}), ShouldBeNil)
+				So(scripts, ShouldBeEmpty)
+			})
+
+			Convey("When I remove the exclusion, it should be deleted from its set", func() {
+				scripts = nil
+				So(i.RemoveExcludedIP([]string{"fd00::/64"}), ShouldBeNil)
+				So(scripts, ShouldResemble, []string{"delete element inet trireme node-excluded6 { fd00::/64 }\n"})
+				So(i.excludedIPs, ShouldResemble, []string{"10.0.0.0/8"})
+			})
+
+			Convey("When I restart the controller, the sets should be created with the exclusions", func() {
+				scripts = nil
+				So(i.Start(), ShouldBeNil)
+				So(scripts[0], ShouldContainSubstring, "add set inet trireme node-excluded { type ipv4_addr; flags interval; }")
+				So(scripts[0], ShouldContainSubstring, "add element inet trireme node-excluded { 10.0.0.0/8 }")
+				So(scripts[0], ShouldContainSubstring, "add rule inet trireme app-raw ip daddr @node-excluded accept")
+				So(scripts[0], ShouldContainSubstring, "add rule inet trireme net ip6 saddr @node-excluded6 accept")
+			})
+		})
+
+		Convey("When I remove the exclusion of a network that is not excluded, I should get an error", func() {
+			So(i.RemoveExcludedIP([]string{"10.0.0.0/8"}), ShouldNotBeNil)
+			So(scripts, ShouldBeEmpty)
+		})
+
+		Convey("When the exclusion cannot be applied, I should get an error", func() {
+			nft.MockApply(t, func(script string) error {
+				return fmt.Errorf("error")
+			})
+			So(i.AddExcludedIP([]string{"10.0.0.0/8"}), ShouldNotBeNil)
+			So(i.excludedIPs, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestConfigureRules(t *testing.T) {
+
+	Convey("Given an nftables controller for containers", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(nft, "0:1", "2:3", 0x1000, constants.LocalContainer)
+
+		var scripts []string
+		nft.MockApply(t, func(script string) error {
+			scripts = append(scripts, script)
+			return nil
+		})
+
+		Convey("When I configure the rules of a PU without IP address, I should get an error", func() {
+			err := i.ConfigureRules(0, "context", createPUInfo(map[string]string{}))
+			So(err, ShouldNotBeNil)
+			So(len(scripts), ShouldEqual, 0)
+		})
+
+		Convey("When I configure the rules of a PU with an IPv4 address", func() {
+			err := i.ConfigureRules(0, "context", createPUInfo(map[string]string{policy.DefaultNamespace: "172.17.0.2"}))
+
+			Convey("The chains and the dispatch elements should be added in one transaction", func() {
+				So(err, ShouldBeNil)
+				So(len(scripts), ShouldEqual, 1)
+				So(scripts[0], ShouldContainSubstring, "add chain inet trireme app-context-0\n")
+				So(scripts[0], ShouldContainSubstring, "add chain inet trireme app-raw-context-0\n")
+				So(scripts[0], ShouldContainSubstring, "add element inet trireme nets-context-0 { 172.17.0.0/24 }")
+				So(scripts[0], ShouldContainSubstring, "add element inet trireme nets6-context-0 { fd00::/64 }")
+				So(scripts[0], ShouldContainSubstring, "add element inet trireme excluded-context-0 { 10.10.10.0/24 }")
+				So(scripts[0], ShouldContainSubstring, "add element inet trireme app-dispatch { 172.17.0.2 : jump app-context-0 }")
+				So(scripts[0], ShouldContainSubstring, "add element inet trireme net-dispatch { 172.17.0.2 : jump net-context-0 }")
+				So(scripts[0], ShouldContainSubstring, "add element inet trireme app-raw-dispatch { 172.17.0.2 : jump app-raw-context-0 }")
+				So(scripts[0], ShouldNotContainSubstring, "app-dispatch6 {")
+			})
+
+			Convey("When I update the rules, the new version should replace the previous one", func() {
+				scripts = nil
+				err := i.UpdateRules(1, "context", createPUInfo(map[string]string{policy.DefaultNamespace: "172.17.0.2"}))
+				So(err, ShouldBeNil)
+				So(len(scripts), ShouldEqual, 1)
+
+				deleteElement := strings.Index(scripts[0], "delete element inet trireme app-dispatch { 172.17.0.2 }")
+				addElement := strings.Index(scripts[0], "add element inet trireme app-dispatch { 172.17.0.2 : jump app-context-1 }")
+				flushChain := strings.Index(scripts[0], "flush chain inet trireme app-context-0")
+				So(deleteElement, ShouldBeGreaterThan, 0)
+				So(addElement, ShouldBeGreaterThan, deleteElement)
+				So(flushChain, ShouldBeGreaterThan, addElement)
+				So(scripts[0], ShouldContainSubstring, "delete chain inet trireme app-raw-context-0")
+				So(scripts[0], ShouldContainSubstring, "delete map inet trireme net-reject6-context-0")
+			})
+
+			Convey("When I delete the rules, the dispatch elements and the chains should be removed", func() {
+				scripts = nil
+				err := i.DeleteRules(0, "context", policy.NewIPMap(map[string]string{policy.DefaultNamespace: "172.17.0.2"}), "0", "")
+				So(err, ShouldBeNil)
+				So(len(scripts), ShouldEqual, 1)
+				So(scripts[0], ShouldStartWith, "delete element inet trireme app-dispatch { 172.17.0.2 }\n")
+				So(scripts[0], ShouldContainSubstring, "delete chain inet trireme net-context-0")
+				So(scripts[0], ShouldContainSubstring, "delete set inet trireme excluded6-context-0")
+				So(i.elements, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I configure the rules of a PU with an IPv6 address", func() {
+			err := i.ConfigureRules(0, "context", createPUInfo(map[string]string{
+				policy.DefaultNamespace:     "172.17.0.2",
+				policy.DefaultIPv6Namespace: "fd00::2",
+			}))
+
+			Convey("The IPv6 dispatch elements should be added", func() {
+				So(err, ShouldBeNil)
+				So(scripts[0], ShouldContainSubstring, "add element inet trireme app-dispatch6 { fd00::2 : jump app-context-0 }")
+			})
+		})
+
+		Convey("When the transaction fails, I should get an error and no state", func() {
+			nft.MockApply(t, func(script string) error {
+				return fmt.Errorf("error")
+			})
+			err := i.ConfigureRules(0, "context", createPUInfo(map[string]string{policy.DefaultNamespace: "172.17.0.2"}))
+			So(err, ShouldNotBeNil)
+			So(i.elements, ShouldBeEmpty)
+		})
+	})
+
+	Convey("Given an nftables controller for Linux processes", t, func() {
+		nft := provider.NewTestNftablesProvider()
+		i := newInstance(nft, "0:1", "2:3", 0x1000, constants.LocalServer)
+
+		var scripts []string
+		nft.MockApply(t, func(script string) error {
+			scripts = append(scripts, script)
+			return nil
+		})
+
+		Convey("When I configure the rules of a process", func() {
+			puInfo := createPUInfo(map[string]string{})
+			puInfo.Runtime.SetOptions(policy.NewTagsMap(map[string]string{
+				cgnetcls.CgroupMarkTag: "100",
+				cgnetcls.PortTag:       "80,8000:8080",
+			}))
+			err := i.ConfigureRules(0, "context", puInfo)
+
+			Convey("The packets should be dispatched by cgroup and port", func() {
+				So(err, ShouldBeNil)
+				So(scripts[0], ShouldContainSubstring, "add rule inet trireme app-context-0 meta mark set 100")
+				So(scripts[0], ShouldContainSubstring, "add element inet trireme app-cgroups { 100 : jump app-context-0 }")
+				So(scripts[0], ShouldContainSubstring, "add element inet trireme net-ports { 80 : jump net-context-0 }")
+				So(scripts[0], ShouldContainSubstring, "add element inet trireme net-ports { 8000-8080 : jump net-context-0 }")
+				So(scripts[0], ShouldNotContainSubstring, "app-raw")
+			})
+		})
+	})
+}
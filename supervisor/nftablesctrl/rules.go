@@ -0,0 +1,474 @@
+package nftablesctrl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/nflog"
+)
+
+// script accumulates the commands of an nftables transaction
+type script struct {
+	commands []string
+}
+
+// add adds a command to the script
+func (s *script) add(format string, args ...interface{}) {
+	s.commands = append(s.commands, fmt.Sprintf(format, args...))
+}
+
+// addElements adds the elements to a set or a map. Nothing is added for an empty list.
+func (s *script) addElements(name string, elements []string) {
+
+	if len(elements) == 0 {
+		return
+	}
+
+	s.add("add element %s %s { %s }", table, name, strings.Join(elements, ", "))
+}
+
+// addRule appends a rule to a chain
+func (s *script) addRule(chain string, rule string) {
+	s.add("add rule %s %s %s", table, chain, rule)
+}
+
+// String returns the script in the format of nft -f
+func (s *script) String() string {
+	return strings.Join(s.commands, "\n") + "\n"
+}
+
+// family describes the matches of an IP family
+type family struct {
+	ipv6     bool
+	keyword  string
+	addrType string
+}
+
+var (
+	ipv4Family = family{ipv6: false, keyword: "ip", addrType: "ipv4_addr"}
+	ipv6Family = family{ipv6: true, keyword: "ip6", addrType: "ipv6_addr"}
+
+	families = []family{ipv4Family, ipv6Family}
+)
+
+// name returns the name of a set or a map of the family
+func (f family) name(kind, contextID string, version int) string {
+
+	if f.ipv6 {
+		return objectName(kind+"6", contextID, version)
+	}
+
+	return objectName(kind, contextID, version)
+}
+
+// filter returns the networks that belong to the family
+func (f family) filter(networks []string) []string {
+
+	filtered := []string{}
+	for _, network := range networks {
+		if policy.IsIPv6Address(network) == f.ipv6 {
+			filtered = append(filtered, network)
+		}
+	}
+
+	return filtered
+}
+
+// objectName returns the name of a chain, set or map of a version of the PU policy
+func objectName(kind, contextID string, version int) string {
+	return kind + "-" + contextID + "-" + strconv.Itoa(version)
+}
+
+// nftRange converts an iptables range to the nftables format
+func nftRange(r string) string {
+	return strings.Replace(r, ":", "-", -1)
+}
+
+// nftPorts returns the ports and ranges of ports in the nftables format. Ports
+// that cannot be parsed are returned as they are so that nft reports them.
+func nftPorts(port string) []string {
+
+	ports, err := policy.ParsePortSpec(port)
+	if err != nil {
+		return []string{nftRange(port)}
+	}
+
+	elements := make([]string, len(ports))
+	for i, r := range ports {
+		elements[i] = nftRange(r.String())
+	}
+
+	return elements
+}
+
+// nftICMP returns the match of the type and the code of an ICMP rule
+func nftICMP(rule policy.IPRule, f family) string {
+
+	keyword := "icmp"
+	if f.ipv6 {
+		keyword = "icmpv6"
+	}
+
+	match := keyword + " type " + rule.ICMPType
+	if rule.ICMPCode != "" {
+		match = match + " " + keyword + " code " + rule.ICMPCode
+	}
+
+	return match
+}
+
+// nftProtocol returns the protocol name understood by nftables for the family.
+// An empty name matches all the protocols.
+func nftProtocol(proto string, f family) string {
+
+	proto = strings.ToLower(proto)
+
+	switch proto {
+	case "all":
+		return ""
+	case "icmp":
+		if f.ipv6 {
+			return "ipv6-icmp"
+		}
+	}
+
+	return proto
+}
+
+// baseChains returns the commands that create the table with the base chains,
+// the dispatch maps and the global rules. Any previous table is removed in the
+// same transaction.
+func (i *Instance) baseChains() *script {
+
+	s := &script{}
+
+	// Adding the table first makes the delete succeed if it does not exist
+	s.add("add table %s", table)
+	s.add("delete table %s", table)
+	s.add("add table %s", table)
+
+	s.add("add chain %s %s { type filter hook %s priority %d; policy accept; }", table, appChain, i.appHook, manglePriority)
+	s.add("add chain %s %s { type filter hook %s priority %d; policy accept; }", table, netChain, i.netHook, manglePriority)
+
+	if i.mode == constants.LocalServer {
+		s.add("add map %s %s { typeof meta cgroup : verdict; }", table, appCgroupMap)
+		s.add("add map %s %s { type inet_service : verdict; flags interval; }", table, netPortMap)
+	} else {
+		for _, f := range families {
+			s.add("add map %s %s { type %s : verdict; flags interval; }", table, f.dispatchMap(appChain), f.addrType)
+			s.add("add map %s %s { type %s : verdict; flags interval; }", table, f.dispatchMap(netChain), f.addrType)
+		}
+	}
+
+	// The traffic of the networks excluded on the whole node is accepted first
+	for _, f := range families {
+		s.add("add set %s %s { type %s; flags interval; }", table, f.globalName(nodeExcludedSet), f.addrType)
+		s.addElements(f.globalName(nodeExcludedSet), f.filter(i.excludedIPs))
+		s.addRule(appChain, fmt.Sprintf("%s daddr @%s accept", f.keyword, f.globalName(nodeExcludedSet)))
+		s.addRule(netChain, fmt.Sprintf("%s saddr @%s accept", f.keyword, f.globalName(nodeExcludedSet)))
+	}
+
+	if i.mode == constants.LocalContainer {
+		s.add("add chain %s %s { type filter hook prerouting priority %d; policy accept; }", table, rawChain, rawPriority)
+		for _, f := range families {
+			s.add("add map %s %s { type %s : verdict; flags interval; }", table, f.dispatchMap(rawChain), f.addrType)
+			s.addRule(rawChain, fmt.Sprintf("%s daddr @%s accept", f.keyword, f.globalName(nodeExcludedSet)))
+			s.addRule(rawChain, fmt.Sprintf("%s saddr vmap @%s", f.keyword, f.dispatchMap(rawChain)))
+		}
+
+		// Packets that the enforcer has already processed are accepted
+		s.addRule(appChain, fmt.Sprintf("meta mark %d accept", i.mark))
+	} else {
+		// Explicit rules to capture all SynAck packets
+		s.addRule(appChain, "tcp flags & (syn|ack) == syn|ack queue num "+i.applicationQueues+" bypass")
+		s.addRule(netChain, "tcp flags & (syn|ack) == syn|ack queue num "+i.networkQueues+" bypass")
+	}
+
+	if i.mode == constants.LocalServer {
+		s.addRule(appChain, "meta cgroup vmap @"+appCgroupMap)
+		s.addRule(netChain, "meta l4proto { tcp, udp } th dport vmap @"+netPortMap)
+	} else {
+		for _, f := range families {
+			s.addRule(appChain, fmt.Sprintf("%s saddr vmap @%s", f.keyword, f.dispatchMap(appChain)))
+			s.addRule(netChain, fmt.Sprintf("%s daddr vmap @%s", f.keyword, f.dispatchMap(netChain)))
+		}
+	}
+
+	return s
+}
+
+// globalName returns the name of a set of the family that is shared by all the PUs
+func (f family) globalName(kind string) string {
+
+	if f.ipv6 {
+		return kind + "6"
+	}
+
+	return kind
+}
+
+// dispatchMap returns the name of the map that sends the packets of the family
+// from a base chain to the chain of a PU
+func (f family) dispatchMap(chain string) string {
+
+	if f.ipv6 {
+		return chain + "-dispatch6"
+	}
+
+	return chain + "-dispatch"
+}
+
+// addPUObjects adds the commands that create the chains, sets and maps of a
+// version of the PU policy
+func (i *Instance) addPUObjects(s *script, contextID string, version int, containerInfo *policy.PUInfo) {
+
+	policyrules := containerInfo.Policy
+
+	app := objectName(appChain, contextID, version)
+	net := objectName(netChain, contextID, version)
+
+	s.add("add chain %s %s", table, app)
+	s.add("add chain %s %s", table, net)
+
+	for _, f := range families {
+		s.add("add set %s %s { type %s; flags interval; }", table, f.name(networkSet, contextID, version), f.addrType)
+		s.add("add set %s %s { type %s; flags interval; }", table, f.name(excludedSet, contextID, version), f.addrType)
+		for _, acl := range aclMaps {
+			s.add("add map %s %s { type %s . inet_proto . inet_service : verdict; flags interval; }", table, f.name(acl, contextID, version), f.addrType)
+		}
+
+		s.addElements(f.name(networkSet, contextID, version), f.filter(policyrules.TriremeNetworks()))
+		s.addElements(f.name(excludedSet, contextID, version), f.filter(policyrules.ExcludedNetworks()))
+	}
+
+	if i.mode == constants.LocalContainer {
+		raw := objectName(rawChain, contextID, version)
+		s.add("add chain %s %s", table, raw)
+		for _, f := range families {
+			s.addRule(raw, fmt.Sprintf("%s daddr @%s tcp flags & (fin|syn|rst|psh|urg) == syn queue num %s", f.keyword, f.name(networkSet, contextID, version), i.applicationQueues))
+		}
+	}
+
+	if i.mode == constants.LocalServer {
+		if mark, ok := containerInfo.Runtime.Options().Get(cgnetcls.CgroupMarkTag); ok && mark != "" {
+			s.addRule(app, "meta mark set "+mark)
+		}
+	}
+
+	audit := policyrules.TriremeAction == policy.Audit
+
+	i.addChainRules(s, app, contextID, version, "daddr", appRejectMap, appAcceptMap, policyrules.ApplicationACLs(), policyrules.EncryptionEnabled(), audit)
+	i.addChainRules(s, net, contextID, version, "saddr", netRejectMap, netAcceptMap, policyrules.NetworkACLs(), policyrules.EncryptionEnabled(), audit)
+}
+
+// addChainRules adds the rules of the application or the network chain of a PU.
+// The direction is the address that identifies the remote end point of the packets.
+// The packets that the rules of a PU in audit mode reject are logged and accepted.
+func (i *Instance) addChainRules(s *script, chain, contextID string, version int, direction, rejectMap, acceptMap string, rules *policy.IPRuleList, encryption bool, audit bool) {
+
+	app := direction == "daddr"
+
+	// Excluded networks are accepted first
+	for _, f := range families {
+		s.addRule(chain, fmt.Sprintf("%s %s @%s accept", f.keyword, direction, f.name(excludedSet, contextID, version)))
+	}
+
+	// Reject ACLs have priority over the packet trap
+	i.addACLs(s, chain, contextID, version, direction, rejectMap, rules, policy.Reject, audit)
+
+	for _, f := range families {
+		for _, rule := range i.trapRules(app, f) {
+			s.addRule(chain, fmt.Sprintf("%s %s @%s %s", f.keyword, direction, f.name(networkSet, contextID, version), rule))
+		}
+
+		if encryption {
+			s.addRule(chain, fmt.Sprintf("%s %s @%s meta l4proto tcp queue num %s", f.keyword, direction, f.name(networkSet, contextID, version), i.queues(app)))
+		}
+	}
+
+	i.addACLs(s, chain, contextID, version, direction, acceptMap, rules, policy.Accept, audit)
+
+	// Accept established connections and drop everything else
+	s.addRule(chain, "meta l4proto { tcp, udp } ct state established accept")
+	if audit {
+		s.addRule(chain, "ct state new log prefix \""+nflog.AuditPrefix(contextID, nflog.DefaultRuleID)+"\" group "+nflog.GroupString())
+		s.addRule(chain, "accept")
+	} else {
+		s.addRule(chain, "drop")
+	}
+}
+
+// queues returns the application or the network queues
+func (i *Instance) queues(app bool) string {
+
+	if app {
+		return i.applicationQueues
+	}
+
+	return i.networkQueues
+}
+
+// trapRules returns the matches and the statements of the rules that send the
+// control packets to user space
+func (i *Instance) trapRules(app bool, f family) []string {
+
+	queue := "queue num " + i.queues(app)
+	firstPackets := "ct original packets < 4"
+
+	rules := []string{}
+
+	switch {
+	case app && i.mode == constants.LocalContainer:
+		// The Syn packets are captured in the raw chain
+		rules = append(rules, "tcp flags & (syn|ack) == ack "+firstPackets+" "+queue)
+	case app:
+		rules = append(rules,
+			"tcp flags & (syn|ack) == ack "+firstPackets+" "+queue,
+			"tcp flags & (fin|syn|rst|psh|urg) == syn "+firstPackets+" "+queue,
+		)
+	case i.mode == constants.LocalContainer:
+		rules = append(rules, "meta l4proto tcp "+firstPackets+" "+queue)
+	default:
+		rules = append(rules,
+			"tcp flags & (syn|ack) == syn "+queue,
+			"tcp flags & (syn|ack|psh) == ack "+firstPackets+" "+queue,
+		)
+	}
+
+	// Capture UDP packets until the flow is established and the first
+	// packets of established flows that may still carry tokens
+	return append(rules,
+		"meta l4proto udp ct state new "+queue,
+		"meta l4proto udp "+firstPackets+" "+queue,
+	)
+}
+
+// addACLs adds the ACLs with the given action. ACLs with a protocol and a port
+// are elements of the ACL map of the family. ACLs without a port and ACLs with the
+// Log action need their own rules, and the Log action only logs the new connections.
+// The reject ACLs of a PU in audit mode only log the new connections, whose packets
+// go on through the rest of the chain.
+func (i *Instance) addACLs(s *script, chain, contextID string, version int, direction, aclMap string, rules *policy.IPRuleList, action policy.FlowAction, audit bool) {
+
+	app := direction == "daddr"
+	audit = audit && action == policy.Reject
+
+	verdict := "accept"
+	if action == policy.Reject {
+		verdict = "drop"
+	}
+
+	// The state is only matched for the application ACLs
+	state := ""
+	if app {
+		state = "ct state new "
+	}
+
+	elements := map[family][]string{}
+
+	for idx, rule := range rules.Rules {
+
+		// Accept has priority if a rule has both actions
+		if rule.Action&policy.Accept != 0 && action != policy.Accept {
+			continue
+		}
+		if rule.Action&action == 0 {
+			continue
+		}
+
+		f := ipv4Family
+		if rule.IsIPv6() {
+			f = ipv6Family
+		}
+
+		proto := nftProtocol(rule.Protocol, f)
+		hasPort := rule.HasPorts() && rule.Port != ""
+
+		if hasPort && rule.Action&policy.Log == 0 && !audit {
+			for _, port := range nftPorts(rule.Port) {
+				elements[f] = append(elements[f], rule.Address+" . "+proto+" . "+port+" : "+verdict)
+			}
+			continue
+		}
+
+		match := f.keyword + " " + direction + " " + rule.Address
+		switch {
+		case hasPort:
+			ports := nftPorts(rule.Port)
+			if len(ports) == 1 {
+				match = match + " " + proto + " dport " + ports[0]
+			} else {
+				match = match + " " + proto + " dport { " + strings.Join(ports, ", ") + " }"
+			}
+		case rule.ICMPTypeCode() != "":
+			match = match + " " + nftICMP(rule, f)
+		case proto != "":
+			match = match + " meta l4proto " + proto
+		}
+
+		// Only the new connections are logged
+		logMatch := "ct state new " + match
+
+		if hasPort {
+			match = state + match
+		}
+
+		if audit {
+			s.addRule(chain, logMatch+" log prefix \""+nflog.AuditPrefix(contextID, aclRuleID(idx, rule))+"\" group "+nflog.GroupString())
+			continue
+		}
+
+		if rule.Action&policy.Log != 0 {
+			s.addRule(chain, logMatch+" log prefix \""+nflog.Prefix(contextID, aclRuleID(idx, rule), rule.Action)+"\" group "+nflog.GroupString())
+		}
+
+		s.addRule(chain, match+" "+verdict)
+	}
+
+	for _, f := range families {
+		name := f.name(aclMap, contextID, version)
+		s.addElements(name, elements[f])
+		s.addRule(chain, fmt.Sprintf("%s%s %s . meta l4proto . th dport vmap @%s", state, f.keyword, direction, name))
+	}
+}
+
+// aclRuleID returns the identifier of an ACL rule used in the log prefix
+func aclRuleID(index int, rule policy.IPRule) string {
+
+	if rule.ID != "" {
+		return rule.ID
+	}
+
+	return strconv.Itoa(index)
+}
+
+// removePUObjects adds the commands that remove the chains, sets and maps of a
+// version of the PU policy. The chains are flushed first to remove the references
+// to the sets and the maps.
+func (i *Instance) removePUObjects(s *script, contextID string, version int) {
+
+	chains := []string{objectName(appChain, contextID, version), objectName(netChain, contextID, version)}
+	if i.mode == constants.LocalContainer {
+		chains = append(chains, objectName(rawChain, contextID, version))
+	}
+
+	for _, chain := range chains {
+		s.add("flush chain %s %s", table, chain)
+	}
+
+	for _, chain := range chains {
+		s.add("delete chain %s %s", table, chain)
+	}
+
+	for _, f := range families {
+		s.add("delete set %s %s", table, f.name(networkSet, contextID, version))
+		s.add("delete set %s %s", table, f.name(excludedSet, contextID, version))
+		for _, acl := range aclMaps {
+			s.add("delete map %s %s", table, f.name(acl, contextID, version))
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package nftablesctrl
+
+import (
+	"testing"
+
+	"github.com/aporeto-inc/trireme/constants"
+	"github.com/aporeto-inc/trireme/policy"
+	"github.com/aporeto-inc/trireme/supervisor/provider"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAddACLs(t *testing.T) {
+
+	Convey("Given an nftables controller", t, func() {
+		i := newInstance(provider.NewTestNftablesProvider(), "0:1", "2:3", 0x1000, constants.LocalContainer)
+
+		rules := policy.NewIPRuleList([]policy.IPRule{
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "80",
+				Protocol: "TCP",
+				Action:   policy.Reject,
+			},
+			policy.IPRule{
+				Address:  "192.30.253.0/24",
+				Port:     "443:445",
+				Protocol: "TCP",
+				Action:   policy.Accept,
+			},
+			policy.IPRule{
+				Address:  "fd00::/64",
+				Port:     "53",
+				Protocol: "UDP",
+				Action:   policy.Accept | policy.Log,
+				ID:       "dns",
+			},
+			policy.IPRule{
+				Address:  "fd00::/64",
+				Protocol: "icmp",
+				Action:   policy.Accept,
+			},
+			policy.IPRule{
+				Address:  "10.0.0.0/8",
+				Protocol: "ALL",
+				Action:   policy.Reject | policy.Log,
+			},
+		})
+
+		Convey("When I add the reject application ACLs", func() {
+			s := &script{}
+			i.addACLs(s, "app-context-0", "context", 0, "daddr", appRejectMap, rules, policy.Reject, false)
+
+			Convey("I should get the map elements and the rules of the reject ACLs", func() {
+				So(s.commands, ShouldResemble, []string{
+					`add rule inet trireme app-context-0 ct state new ip daddr 10.0.0.0/8 log prefix "context:4:R" group 10`,
+					"add rule inet trireme app-context-0 ip daddr 10.0.0.0/8 drop",
+					"add element inet trireme app-reject-context-0 { 192.30.253.0/24 . tcp . 80 : drop }",
+					"add rule inet trireme app-context-0 ct state new ip daddr . meta l4proto . th dport vmap @app-reject-context-0",
+					"add rule inet trireme app-context-0 ct state new ip6 daddr . meta l4proto . th dport vmap @app-reject6-context-0",
+				})
+			})
+		})
+
+		Convey("When I add the accept network ACLs", func() {
+			s := &script{}
+			i.addACLs(s, "net-context-0", "context", 0, "saddr", netAcceptMap, rules, policy.Accept, false)
+
+			Convey("I should get the map elements and the rules of the accept ACLs", func() {
+				So(s.commands, ShouldResemble, []string{
+					`add rule inet trireme net-context-0 ct state new ip6 saddr fd00::/64 udp dport 53 log prefix "context:dns:A" group 10`,
+					"add rule inet trireme net-context-0 ip6 saddr fd00::/64 udp dport 53 accept",
+					"add rule inet trireme net-context-0 ip6 saddr fd00::/64 meta l4proto ipv6-icmp accept",
+					"add element inet trireme net-accept-context-0 { 192.30.253.0/24 . tcp . 443-445 : accept }",
+					"add rule inet trireme net-context-0 ip saddr . meta l4proto . th dport vmap @net-accept-context-0",
+					"add rule inet trireme net-context-0 ip6 saddr . meta l4proto . th dport vmap @net-accept6-context-0",
+				})
+			})
+		})
+
+		Convey("When I add network ACLs with lists of ports", func() {
+			lists := policy.NewIPRuleList([]policy.IPRule{
+				policy.IPRule{
+					Address:  "192.30.253.0/24",
+					Port:     "80,8000:8080",
+					Protocol: "TCP",
+					Action:   policy.Accept,
+				},
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Port:     "53,5353",
+					Protocol: "UDP",
+					Action:   policy.Accept | policy.Log,
+					ID:       "dns",
+				},
+			})
+
+			s := &script{}
+			i.addACLs(s, "net-context-0", "context", 0, "saddr", netAcceptMap, lists, policy.Accept, false)
+
+			Convey("I should get an element per port or range and a set of ports in the rules", func() {
+				So(s.commands, ShouldResemble, []string{
+					`add rule inet trireme net-context-0 ct state new ip saddr 10.0.0.0/8 udp dport { 53, 5353 } log prefix "context:dns:A" group 10`,
+					"add rule inet trireme net-context-0 ip saddr 10.0.0.0/8 udp dport { 53, 5353 } accept",
+					"add element inet trireme net-accept-context-0 { 192.30.253.0/24 . tcp . 80 : accept, 192.30.253.0/24 . tcp . 8000-8080 : accept }",
+					"add rule inet trireme net-context-0 ip saddr . meta l4proto . th dport vmap @net-accept-context-0",
+					"add rule inet trireme net-context-0 ip6 saddr . meta l4proto . th dport vmap @net-accept6-context-0",
+				})
+			})
+		})
+
+		Convey("When I add network ACLs for ICMP types and SCTP ports", func() {
+			protocols := policy.NewIPRuleList([]policy.IPRule{
+				policy.IPRule{
+					Address:  "0.0.0.0/0",
+					Protocol: "icmp",
+					ICMPType: "3",
+					ICMPCode: "4",
+					Action:   policy.Accept,
+				},
+				policy.IPRule{
+					Address:  "fd00::/64",
+					Protocol: "icmp",
+					ICMPType: "128",
+					Action:   policy.Accept,
+				},
+				policy.IPRule{
+					Address:  "10.0.0.0/8",
+					Port:     "3868",
+					Protocol: "SCTP",
+					Action:   policy.Accept,
+				},
+			})
+
+			s := &script{}
+			i.addACLs(s, "net-context-0", "context", 0, "saddr", netAcceptMap, protocols, policy.Accept, false)
+
+			Convey("I should get rules that match the ICMP types and an element for the SCTP port", func() {
+				So(s.commands, ShouldResemble, []string{
+					"add rule inet trireme net-context-0 ip saddr 0.0.0.0/0 icmp type 3 icmp code 4 accept",
+					"add rule inet trireme net-context-0 ip6 saddr fd00::/64 icmpv6 type 128 accept",
+					"add element inet trireme net-accept-context-0 { 10.0.0.0/8 . sctp . 3868 : accept }",
+					"add rule inet trireme net-context-0 ip saddr . meta l4proto . th dport vmap @net-accept-context-0",
+					"add rule inet trireme net-context-0 ip6 saddr . meta l4proto . th dport vmap @net-accept6-context-0",
+				})
+			})
+		})
+
+		Convey("When I add the reject application ACLs of a PU in audit mode", func() {
+			s := &script{}
+			i.addACLs(s, "app-context-0", "context", 0, "daddr", appRejectMap, rules, policy.Reject, true)
+
+			Convey("I should get rules that only log the new connections", func() {
+				So(s.commands, ShouldResemble, []string{
+					`add rule inet trireme app-context-0 ct state new ip daddr 192.30.253.0/24 tcp dport 80 log prefix "context:0:U" group 10`,
+					`add rule inet trireme app-context-0 ct state new ip daddr 10.0.0.0/8 log prefix "context:4:U" group 10`,
+					"add rule inet trireme app-context-0 ct state new ip daddr . meta l4proto . th dport vmap @app-reject-context-0",
+					"add rule inet trireme app-context-0 ct state new ip6 daddr . meta l4proto . th dport vmap @app-reject6-context-0",
+				})
+			})
+		})
+
+		Convey("When I add the accept application ACLs of a PU in audit mode, they should not change", func() {
+			audited := &script{}
+			i.addACLs(audited, "app-context-0", "context", 0, "daddr", appAcceptMap, rules, policy.Accept, true)
+
+			s := &script{}
+			i.addACLs(s, "app-context-0", "context", 0, "daddr", appAcceptMap, rules, policy.Accept, false)
+
+			So(audited.commands, ShouldResemble, s.commands)
+		})
+
+		Convey("When I add the rules of the application chain of a PU in audit mode", func() {
+			s := &script{}
+			i.addChainRules(s, "app-context-0", "context", 0, "daddr", appRejectMap, appAcceptMap, rules, false, true)
+
+			Convey("The connections that no ACL accepts should be logged and accepted", func() {
+				So(s.commands[len(s.commands)-2], ShouldEqual, `add rule inet trireme app-context-0 ct state new log prefix "context:default:U" group 10`)
+				So(s.commands[len(s.commands)-1], ShouldEqual, "add rule inet trireme app-context-0 accept")
+				So(s.commands, ShouldNotContain, "add rule inet trireme app-context-0 drop")
+			})
+		})
+	})
+}
+
+func TestTrapRules(t *testing.T) {
+
+	Convey("Given an nftables controller for containers", t, func() {
+		i := newInstance(provider.NewTestNftablesProvider(), "0:1", "2:3", 0x1000, constants.LocalContainer)
+
+		Convey("The application trap should not capture the Syn packets captured in the raw chain", func() {
+			So(i.trapRules(true, ipv4Family), ShouldResemble, []string{
+				"tcp flags & (syn|ack) == ack ct original packets < 4 queue num 2-3",
+				"meta l4proto udp ct state new queue num 2-3",
+				"meta l4proto udp ct original packets < 4 queue num 2-3",
+			})
+		})
+	})
+
+	Convey("Given an nftables controller for Linux processes", t, func() {
+		i := newInstance(provider.NewTestNftablesProvider(), "0:1", "2:3", 0x1000, constants.LocalServer)
+
+		Convey("The network trap should capture the Syn packets", func() {
+			So(i.trapRules(false, ipv4Family), ShouldResemble, []string{
+				"tcp flags & (syn|ack) == syn queue num 0-1",
+				"tcp flags & (syn|ack|psh) == ack ct original packets < 4 queue num 0-1",
+				"meta l4proto udp ct state new queue num 0-1",
+				"meta l4proto udp ct original packets < 4 queue num 0-1",
+			})
+		})
+	})
+}
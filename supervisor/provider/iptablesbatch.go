@@ -0,0 +1,192 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IptablesBatch is an IptablesProvider that records the changes to the rules
+// instead of applying them. The changes are applied with one restore of the
+// underlying provider when the batch is committed. Chains are listed from the
+// underlying provider.
+type IptablesBatch struct {
+	ipt      IptablesProvider
+	tables   []string
+	commands map[string][][]string
+}
+
+// NewIptablesBatch returns a new batch for the provider
+func NewIptablesBatch(ipt IptablesProvider) *IptablesBatch {
+
+	return &IptablesBatch{
+		ipt:      ipt,
+		tables:   []string{},
+		commands: map[string][][]string{},
+	}
+}
+
+// add records a command for the table
+func (b *IptablesBatch) add(table string, args ...string) {
+
+	if _, ok := b.commands[table]; !ok {
+		b.tables = append(b.tables, table)
+	}
+
+	b.commands[table] = append(b.commands[table], args)
+}
+
+// Append records a rule appended to the chain
+func (b *IptablesBatch) Append(table, chain string, rulespec ...string) error {
+
+	b.add(table, append([]string{"-A", chain}, rulespec...)...)
+	return nil
+}
+
+// Insert records a rule inserted in the chain
+func (b *IptablesBatch) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	b.add(table, append([]string{"-I", chain, strconv.Itoa(pos)}, rulespec...)...)
+	return nil
+}
+
+// Delete records the deletion of a rule
+func (b *IptablesBatch) Delete(table, chain string, rulespec ...string) error {
+
+	b.add(table, append([]string{"-D", chain}, rulespec...)...)
+	return nil
+}
+
+// ListChains lists the chains of the underlying provider
+func (b *IptablesBatch) ListChains(table string) ([]string, error) {
+
+	return b.ipt.ListChains(table)
+}
+
+// List lists the rules of a chain of the underlying provider
+func (b *IptablesBatch) List(table, chain string) ([]string, error) {
+
+	return b.ipt.List(table, chain)
+}
+
+// Exists checks if the rule exists with the underlying provider
+func (b *IptablesBatch) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	return b.ipt.Exists(table, chain, rulespec...)
+}
+
+// ClearChain records the flush of a chain
+func (b *IptablesBatch) ClearChain(table, chain string) error {
+
+	b.add(table, "-F", chain)
+	return nil
+}
+
+// DeleteChain records the deletion of a chain
+func (b *IptablesBatch) DeleteChain(table, chain string) error {
+
+	b.add(table, "-X", chain)
+	return nil
+}
+
+// NewChain records the creation of a chain
+func (b *IptablesBatch) NewChain(table, chain string) error {
+
+	b.add(table, "-N", chain)
+	return nil
+}
+
+// Restore is not supported in a batch
+func (b *IptablesBatch) Restore(data []byte) error {
+
+	return fmt.Errorf("Restore is not supported in a batch")
+}
+
+// Tables returns the tables changed by the recorded commands
+func (b *IptablesBatch) Tables() []string {
+
+	return b.tables
+}
+
+// Commands returns the recorded commands of a table with their arguments
+func (b *IptablesBatch) Commands(table string) [][]string {
+
+	return b.commands[table]
+}
+
+// Bytes returns the recorded commands in the iptables-restore format
+func (b *IptablesBatch) Bytes() []byte {
+
+	var buf bytes.Buffer
+
+	for _, table := range b.tables {
+		buf.WriteString("*" + table + "\n")
+		for _, args := range b.commands[table] {
+			quoted := make([]string, len(args))
+			for i, arg := range args {
+				quoted[i] = quoteArg(arg)
+			}
+			buf.WriteString(strings.Join(quoted, " ") + "\n")
+		}
+		buf.WriteString("COMMIT\n")
+	}
+
+	return buf.Bytes()
+}
+
+// Commit applies the recorded commands with the underlying provider
+func (b *IptablesBatch) Commit() error {
+
+	if len(b.tables) == 0 {
+		return nil
+	}
+
+	return b.ipt.Restore(b.Bytes())
+}
+
+// quoteArg quotes an argument for iptables-restore if needed
+func quoteArg(arg string) string {
+
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'") {
+		return arg
+	}
+
+	return "\"" + strings.Replace(arg, "\"", "\\\"", -1) + "\""
+}
+
+// splitRestoreLine splits a line of the iptables-restore format in arguments
+func splitRestoreLine(line string) []string {
+
+	args := []string{}
+	var current bytes.Buffer
+	quoted := false
+	started := false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(line) && line[i+1] == '"':
+			current.WriteByte('"')
+			i++
+		case c == '"':
+			quoted = !quoted
+			started = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteByte(c)
+			started = true
+		}
+	}
+
+	if started {
+		args = append(args, current.String())
+	}
+
+	return args
+}
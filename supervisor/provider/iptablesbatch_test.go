@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIptablesBatch(t *testing.T) {
+
+	Convey("Given a batch of an iptables provider", t, func() {
+		ipt := NewTestIptablesProvider()
+		batch := NewIptablesBatch(ipt)
+
+		Convey("When I commit an empty batch, the provider should not be called", func() {
+			ipt.MockRestore(t, func(data []byte) error {
+				return fmt.Errorf("error")
+			})
+			So(batch.Commit(), ShouldBeNil)
+		})
+
+		Convey("When I record changes in several tables", func() {
+			So(batch.NewChain("mangle", "chain"), ShouldBeNil)
+			So(batch.Append("mangle", "chain", "-s", "10.0.0.1", "-j", "ACCEPT"), ShouldBeNil)
+			So(batch.Insert("raw", "chain", 1, "-m", "comment", "--comment", "a comment", "-j", "DROP"), ShouldBeNil)
+			So(batch.Delete("mangle", "OUTPUT", "-j", "chain"), ShouldBeNil)
+			So(batch.ClearChain("mangle", "old"), ShouldBeNil)
+			So(batch.DeleteChain("mangle", "old"), ShouldBeNil)
+
+			Convey("I should get them in the iptables-restore format", func() {
+				So(string(batch.Bytes()), ShouldEqual, "*mangle\n"+
+					"-N chain\n"+
+					"-A chain -s 10.0.0.1 -j ACCEPT\n"+
+					"-D OUTPUT -j chain\n"+
+					"-F old\n"+
+					"-X old\n"+
+					"COMMIT\n"+
+					"*raw\n"+
+					"-I chain 1 -m comment --comment \"a comment\" -j DROP\n"+
+					"COMMIT\n")
+			})
+
+			Convey("When I commit them, the provider should restore them at once", func() {
+				var restored []byte
+				ipt.MockRestore(t, func(data []byte) error {
+					restored = data
+					return nil
+				})
+				So(batch.Commit(), ShouldBeNil)
+				So(restored, ShouldResemble, batch.Bytes())
+			})
+
+			Convey("When the restore fails, I should get the error", func() {
+				ipt.MockRestore(t, func(data []byte) error {
+					return fmt.Errorf("error")
+				})
+				So(batch.Commit(), ShouldNotBeNil)
+			})
+
+			Convey("When the test provider replays them, the rules should be unchanged", func() {
+				inserted := []string{}
+				ipt.MockInsert(t, func(table string, chain string, pos int, rulespec ...string) error {
+					inserted = rulespec
+					return nil
+				})
+				So(batch.Commit(), ShouldBeNil)
+				So(inserted, ShouldResemble, []string{"-m", "comment", "--comment", "a comment", "-j", "DROP"})
+			})
+		})
+	})
+}
+
+func TestSplitRestoreLine(t *testing.T) {
+
+	Convey("When I split lines of the iptables-restore format", t, func() {
+		So(splitRestoreLine("-A chain  -j ACCEPT"), ShouldResemble, []string{"-A", "chain", "-j", "ACCEPT"})
+		So(splitRestoreLine(`-A chain --comment "a \"quoted\" comment" ""`), ShouldResemble, []string{"-A", "chain", "--comment", `a "quoted" comment`, ""})
+	})
+}
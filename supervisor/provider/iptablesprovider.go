@@ -1,6 +1,25 @@
 package provider
 
-import "github.com/coreos/go-iptables/iptables"
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-iptables/iptables"
+)
+
+const (
+	// restoreLockExitStatus is the exit status of iptables-restore when the
+	// xtables lock is held by another program
+	restoreLockExitStatus = 4
+	// restoreAttempts is the number of attempts of a restore while the lock is held
+	restoreAttempts = 20
+	// restoreRetryInterval is the time between two attempts of a restore
+	restoreRetryInterval = 100 * time.Millisecond
+)
 
 // IptablesProvider is an abstraction of all the methods an implementation of userspace
 // iptables need to provide.
@@ -9,13 +28,78 @@ type IptablesProvider interface {
 	Insert(table, chain string, pos int, rulespec ...string) error
 	Delete(table, chain string, rulespec ...string) error
 	ListChains(table string) ([]string, error)
+	List(table, chain string) ([]string, error)
+	Exists(table, chain string, rulespec ...string) (bool, error)
 	ClearChain(table, chain string) error
 	DeleteChain(table, chain string) error
 	NewChain(table, chain string) error
+	// Restore applies rules in the iptables-restore format without flushing
+	// the existing rules. The rules of a table are committed atomically.
+	Restore(data []byte) error
+}
+
+// goIptablesProvider adds the restore command to the go-iptables implementation
+type goIptablesProvider struct {
+	*iptables.IPTables
+	restoreCmd string
 }
 
 // NewGoIPTablesProvider returns an IptablesProvider interface based on the go-iptables
 // external package.
 func NewGoIPTablesProvider() (IptablesProvider, error) {
-	return iptables.New()
+
+	ipt, err := iptables.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return &goIptablesProvider{IPTables: ipt, restoreCmd: "iptables-restore"}, nil
+}
+
+// NewGoIP6TablesProvider returns an IptablesProvider interface based on the go-iptables
+// external package that programs the IPv6 tables.
+func NewGoIP6TablesProvider() (IptablesProvider, error) {
+
+	ipt, err := iptables.NewWithProtocol(iptables.ProtocolIPv6)
+	if err != nil {
+		return nil, err
+	}
+
+	return &goIptablesProvider{IPTables: ipt, restoreCmd: "ip6tables-restore"}, nil
+}
+
+// Restore applies the rules with iptables-restore --noflush. The restore is
+// retried while another program holds the xtables lock, since the versions of
+// iptables-restore that do not support --wait fail immediately.
+func (p *goIptablesProvider) Restore(data []byte) error {
+
+	for attempt := 1; ; attempt++ {
+		cmd := exec.Command(p.restoreCmd, "--noflush")
+		cmd.Stdin = bytes.NewReader(data)
+
+		output, err := cmd.CombinedOutput()
+		if err == nil {
+			return nil
+		}
+
+		if !lockHeld(err) || attempt >= restoreAttempts {
+			return fmt.Errorf("Failed to restore rules: %s: %s", err, strings.TrimSpace(string(output)))
+		}
+
+		time.Sleep(restoreRetryInterval)
+	}
+}
+
+// lockHeld returns true if iptables-restore exited because another program
+// holds the xtables lock
+func lockHeld(err error) bool {
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+
+	return ok && status.ExitStatus() == restoreLockExitStatus
 }
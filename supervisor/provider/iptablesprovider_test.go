@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeRestore writes a restore command that exits with the status 4 until it
+// was called the given number of times
+func fakeRestore(dir string, locked int) string {
+
+	cmd := filepath.Join(dir, "iptables-restore")
+	script := "#!/bin/sh\n" +
+		"cat > /dev/null\n" +
+		"echo x >> " + filepath.Join(dir, "calls") + "\n" +
+		"[ $(wc -l < " + filepath.Join(dir, "calls") + ") -gt " + strconv.Itoa(locked) + " ] || exit 4\n"
+
+	So(ioutil.WriteFile(cmd, []byte(script), 0755), ShouldBeNil)
+
+	return cmd
+}
+
+func TestRestore(t *testing.T) {
+
+	Convey("Given a directory for a fake restore command", t, func() {
+		dir, err := ioutil.TempDir("", "restore")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir) // nolint
+
+		Convey("When the xtables lock is held for the first attempt, the restore should be retried", func() {
+			p := &goIptablesProvider{restoreCmd: fakeRestore(dir, 1)}
+			So(p.Restore([]byte("*filter\nCOMMIT\n")), ShouldBeNil)
+		})
+
+		Convey("When the xtables lock is never released, the restore should fail", func() {
+			p := &goIptablesProvider{restoreCmd: fakeRestore(dir, restoreAttempts)}
+			So(p.Restore([]byte("*filter\nCOMMIT\n")), ShouldNotBeNil)
+		})
+	})
+}
@@ -1,6 +1,11 @@
 package provider
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -10,9 +15,12 @@ type iptablesProviderMockedMethods struct {
 	insertMock      func(table, chain string, pos int, rulespec ...string) error
 	deleteMock      func(table, chain string, rulespec ...string) error
 	listChainsMock  func(table string) ([]string, error)
+	listMock        func(table, chain string) ([]string, error)
+	existsMock      func(table, chain string, rulespec ...string) (bool, error)
 	clearChainMock  func(table, chain string) error
 	deleteChainMock func(table, chain string) error
 	newChainMock    func(table, chain string) error
+	restoreMock     func(data []byte) error
 }
 
 // TestIptablesProvider is a test implementation for IptablesProvider
@@ -22,9 +30,12 @@ type TestIptablesProvider interface {
 	MockInsert(t *testing.T, impl func(table, chain string, pos int, rulespec ...string) error)
 	MockDelete(t *testing.T, impl func(table, chain string, rulespec ...string) error)
 	MockListChains(t *testing.T, impl func(table string) ([]string, error))
+	MockList(t *testing.T, impl func(table, chain string) ([]string, error))
+	MockExists(t *testing.T, impl func(table, chain string, rulespec ...string) (bool, error))
 	MockClearChain(t *testing.T, impl func(table, chain string) error)
 	MockDeleteChain(t *testing.T, impl func(table, chain string) error)
 	MockNewChain(t *testing.T, impl func(table, chain string) error)
+	MockRestore(t *testing.T, impl func(data []byte) error)
 }
 
 // A testIptablesProvider is an empty TransactionalManipulator that can be easily mocked.
@@ -62,6 +73,16 @@ func (m *testIptablesProvider) MockListChains(t *testing.T, impl func(table stri
 	m.currentMocks(t).listChainsMock = impl
 }
 
+func (m *testIptablesProvider) MockList(t *testing.T, impl func(table, chain string) ([]string, error)) {
+
+	m.currentMocks(t).listMock = impl
+}
+
+func (m *testIptablesProvider) MockExists(t *testing.T, impl func(table, chain string, rulespec ...string) (bool, error)) {
+
+	m.currentMocks(t).existsMock = impl
+}
+
 func (m *testIptablesProvider) MockClearChain(t *testing.T, impl func(table, chain string) error) {
 
 	m.currentMocks(t).clearChainMock = impl
@@ -77,6 +98,11 @@ func (m *testIptablesProvider) MockNewChain(t *testing.T, impl func(table, chain
 	m.currentMocks(t).newChainMock = impl
 }
 
+func (m *testIptablesProvider) MockRestore(t *testing.T, impl func(data []byte) error) {
+
+	m.currentMocks(t).restoreMock = impl
+}
+
 func (m *testIptablesProvider) Append(table, chain string, rulespec ...string) error {
 
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.appendMock != nil {
@@ -113,6 +139,24 @@ func (m *testIptablesProvider) ListChains(table string) ([]string, error) {
 	return nil, nil
 }
 
+func (m *testIptablesProvider) List(table, chain string) ([]string, error) {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.listMock != nil {
+		return mock.listMock(table, chain)
+	}
+
+	return nil, nil
+}
+
+func (m *testIptablesProvider) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.existsMock != nil {
+		return mock.existsMock(table, chain, rulespec...)
+	}
+
+	return true, nil
+}
+
 func (m *testIptablesProvider) ClearChain(table, chain string) error {
 
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.clearChainMock != nil {
@@ -140,6 +184,65 @@ func (m *testIptablesProvider) NewChain(table, chain string) error {
 	return nil
 }
 
+// Restore calls the restore mock if any. Otherwise the commands are replayed
+// with the other methods and the first error is returned.
+func (m *testIptablesProvider) Restore(data []byte) error {
+
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.restoreMock != nil {
+		return mock.restoreMock(data)
+	}
+
+	table := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "COMMIT" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "*") {
+			table = line[1:]
+			continue
+		}
+
+		args := splitRestoreLine(line)
+		if len(args) < 2 {
+			return fmt.Errorf("Invalid restore line %s", line)
+		}
+
+		var err error
+		switch args[0] {
+		case "-A":
+			err = m.Append(table, args[1], args[2:]...)
+		case "-I":
+			if len(args) < 3 {
+				return fmt.Errorf("Invalid restore line %s", line)
+			}
+			pos, perr := strconv.Atoi(args[2])
+			if perr != nil {
+				return fmt.Errorf("Invalid restore line %s", line)
+			}
+			err = m.Insert(table, args[1], pos, args[3:]...)
+		case "-D":
+			err = m.Delete(table, args[1], args[2:]...)
+		case "-N":
+			err = m.NewChain(table, args[1])
+		case "-F":
+			err = m.ClearChain(table, args[1])
+		case "-X":
+			err = m.DeleteChain(table, args[1])
+		default:
+			return fmt.Errorf("Invalid restore line %s", line)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (m *testIptablesProvider) currentMocks(t *testing.T) *iptablesProviderMockedMethods {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -0,0 +1,567 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+)
+
+// Journal records the changes applied through its providers with the changes
+// that revert them. Rollback reverts all the recorded changes in the reverse
+// order, for example when the rules of a PU fail to be configured halfway.
+type Journal struct {
+	changes []journalChange
+	sync.Mutex
+}
+
+// journalChange is a change applied through a journal with the function that
+// reverts it
+type journalChange struct {
+	description string
+	revert      func() error
+}
+
+// NewJournal returns a new journal without any change
+func NewJournal() *Journal {
+
+	return &Journal{
+		changes: []journalChange{},
+	}
+}
+
+// record adds a change to the journal
+func (j *Journal) record(description string, revert func() error) {
+
+	j.Lock()
+	defer j.Unlock()
+
+	j.changes = append(j.changes, journalChange{description: description, revert: revert})
+}
+
+// Changes returns the description of the recorded changes in the order they
+// were applied
+func (j *Journal) Changes() []string {
+
+	j.Lock()
+	defer j.Unlock()
+
+	changes := make([]string, len(j.changes))
+	for k, c := range j.changes {
+		changes[k] = c.description
+	}
+
+	return changes
+}
+
+// Rollback reverts the recorded changes in the reverse order and empties the
+// journal. All the changes are reverted even if some of them fail.
+func (j *Journal) Rollback() error {
+
+	j.Lock()
+	changes := j.changes
+	j.changes = []journalChange{}
+	j.Unlock()
+
+	failed := []string{}
+	for k := len(changes) - 1; k >= 0; k-- {
+		if err := changes[k].revert(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", changes[k].description, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed to revert %d changes: %s", len(failed), strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// IptablesProvider returns an IptablesProvider that applies the changes with
+// the given provider and records them in the journal
+func (j *Journal) IptablesProvider(ipt IptablesProvider) IptablesProvider {
+
+	return &journalIptablesProvider{
+		journal: j,
+		ipt:     ipt,
+	}
+}
+
+// IpsetProvider returns an IpsetProvider that applies the changes with the given
+// provider and records them in the journal. The changes to the sets it creates
+// are recorded too.
+func (j *Journal) IpsetProvider(ips IpsetProvider) IpsetProvider {
+
+	return &journalIpsetProvider{
+		journal: j,
+		ips:     ips,
+	}
+}
+
+// Ipset returns an Ipset that applies the changes to the given set and records
+// them in the journal. It returns nil if the set is nil.
+func (j *Journal) Ipset(name string, set Ipset) Ipset {
+
+	if set == nil {
+		return nil
+	}
+
+	return &journalIpset{
+		journal: j,
+		set:     set,
+		name:    name,
+	}
+}
+
+// journalIptablesProvider records the iptables changes in a journal
+type journalIptablesProvider struct {
+	journal *Journal
+	ipt     IptablesProvider
+}
+
+// describe returns the description of an iptables command
+func describe(table string, args ...string) string {
+
+	quoted := make([]string, len(args))
+	for k, arg := range args {
+		quoted[k] = quoteArg(arg)
+	}
+
+	return "-t " + table + " " + strings.Join(quoted, " ")
+}
+
+func (p *journalIptablesProvider) Append(table, chain string, rulespec ...string) error {
+
+	if err := p.ipt.Append(table, chain, rulespec...); err != nil {
+		return err
+	}
+
+	rule := append([]string{}, rulespec...)
+	p.journal.record(describe(table, append([]string{"-A", chain}, rule...)...), func() error {
+		return p.ipt.Delete(table, chain, rule...)
+	})
+
+	return nil
+}
+
+func (p *journalIptablesProvider) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	if err := p.ipt.Insert(table, chain, pos, rulespec...); err != nil {
+		return err
+	}
+
+	rule := append([]string{}, rulespec...)
+	p.journal.record(describe(table, append([]string{"-I", chain, strconv.Itoa(pos)}, rule...)...), func() error {
+		return p.ipt.Delete(table, chain, rule...)
+	})
+
+	return nil
+}
+
+// Delete deletes a rule. The rule is inserted again at its position if the
+// change is reverted, or appended if its position is not known.
+func (p *journalIptablesProvider) Delete(table, chain string, rulespec ...string) error {
+
+	position := 0
+	if rules, err := p.listRules(table, chain); err == nil {
+		position = rulePosition(rules, rulespec)
+	}
+
+	if err := p.ipt.Delete(table, chain, rulespec...); err != nil {
+		return err
+	}
+
+	rule := append([]string{}, rulespec...)
+	p.journal.record(describe(table, append([]string{"-D", chain}, rule...)...), p.reinsert(table, chain, position, rule))
+
+	return nil
+}
+
+func (p *journalIptablesProvider) ListChains(table string) ([]string, error) {
+
+	return p.ipt.ListChains(table)
+}
+
+func (p *journalIptablesProvider) List(table, chain string) ([]string, error) {
+
+	return p.ipt.List(table, chain)
+}
+
+func (p *journalIptablesProvider) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	return p.ipt.Exists(table, chain, rulespec...)
+}
+
+// ClearChain flushes a chain. The rules listed before the flush are appended
+// again if the change is reverted.
+func (p *journalIptablesProvider) ClearChain(table, chain string) error {
+
+	rules, lerr := p.listRules(table, chain)
+
+	if err := p.ipt.ClearChain(table, chain); err != nil {
+		return err
+	}
+
+	if lerr == nil {
+		p.journal.record(describe(table, "-F", chain), p.reappend(table, chain, rules))
+	}
+
+	return nil
+}
+
+func (p *journalIptablesProvider) DeleteChain(table, chain string) error {
+
+	if err := p.ipt.DeleteChain(table, chain); err != nil {
+		return err
+	}
+
+	p.journal.record(describe(table, "-X", chain), func() error {
+		return p.ipt.NewChain(table, chain)
+	})
+
+	return nil
+}
+
+func (p *journalIptablesProvider) NewChain(table, chain string) error {
+
+	if err := p.ipt.NewChain(table, chain); err != nil {
+		return err
+	}
+
+	p.journal.record(describe(table, "-N", chain), func() error {
+		return p.ipt.DeleteChain(table, chain)
+	})
+
+	return nil
+}
+
+// restoreCommand is a command of the iptables-restore format with what is
+// needed to revert it: the rules of a flushed chain and the position of a
+// deleted rule
+type restoreCommand struct {
+	args     []string
+	rules    [][]string
+	position int
+}
+
+// restoreTable is the part of the iptables-restore data that changes a table
+type restoreTable struct {
+	name     string
+	data     bytes.Buffer
+	commands []restoreCommand
+}
+
+// Restore applies the data with one restore of the provider per table and
+// records the commands of every table that was committed. The commit of a
+// table is atomic, so the commands of a table are either all applied or
+// none of them. The tables after a table that fails are not applied.
+func (p *journalIptablesProvider) Restore(data []byte) error {
+
+	tables := []*restoreTable{}
+	var current *restoreTable
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "*") {
+			current = &restoreTable{name: line[1:]}
+			tables = append(tables, current)
+		}
+
+		if current == nil {
+			return fmt.Errorf("Invalid restore line outside of a table: %s", line)
+		}
+
+		current.data.WriteString(line + "\n")
+
+		if strings.HasPrefix(line, "*") || strings.HasPrefix(line, ":") || line == "COMMIT" {
+			continue
+		}
+
+		if args := splitRestoreLine(line); len(args) >= 2 {
+			current.commands = append(current.commands, restoreCommand{args: args})
+		}
+	}
+
+	for _, t := range tables {
+		p.prepareRevert(t)
+
+		if err := p.ipt.Restore(t.data.Bytes()); err != nil {
+			return err
+		}
+
+		for _, c := range t.commands {
+			if revert := p.revertCommand(t.name, c); revert != nil {
+				p.journal.record(describe(t.name, c.args...), revert)
+			}
+		}
+	}
+
+	return nil
+}
+
+// prepareRevert finds the rules of the flushed chains and the positions of the
+// deleted rules of a table. The commands of the table are replayed on the rules
+// listed before the restore so that every command sees the rules left by the
+// previous ones. Nothing is known about a chain that cannot be listed until it
+// is flushed or created.
+func (p *journalIptablesProvider) prepareRevert(t *restoreTable) {
+
+	chains := map[string][][]string{}
+	listed := map[string]bool{}
+
+	rulesOf := func(chain string) [][]string {
+		if !listed[chain] {
+			chains[chain], _ = p.listRules(t.name, chain) // nolint
+			listed[chain] = true
+		}
+		return chains[chain]
+	}
+
+	for k := range t.commands {
+		c := &t.commands[k]
+		chain := c.args[1]
+		rule := c.args[2:]
+		rules := rulesOf(chain)
+
+		switch c.args[0] {
+		case "-N", "-X":
+			chains[chain] = [][]string{}
+		case "-F":
+			c.rules = rules
+			chains[chain] = [][]string{}
+		case "-A":
+			if rules != nil {
+				chains[chain] = append(rules, rule)
+			}
+		case "-I":
+			if rules == nil {
+				continue
+			}
+			position := 1
+			if len(rule) > 0 {
+				if n, err := strconv.Atoi(rule[0]); err == nil {
+					position = n
+					rule = rule[1:]
+				}
+			}
+			if position < 1 || position > len(rules)+1 {
+				position = len(rules) + 1
+			}
+			inserted := append([][]string{}, rules[:position-1]...)
+			inserted = append(inserted, rule)
+			chains[chain] = append(inserted, rules[position-1:]...)
+		case "-D":
+			c.position = rulePosition(rules, rule)
+			if c.position > 0 {
+				remaining := append([][]string{}, rules[:c.position-1]...)
+				chains[chain] = append(remaining, rules[c.position:]...)
+			}
+		}
+	}
+}
+
+// revertCommand returns the function that reverts an iptables command. It
+// returns nil if the command cannot be reverted.
+func (p *journalIptablesProvider) revertCommand(table string, c restoreCommand) func() error {
+
+	chain := c.args[1]
+	rule := c.args[2:]
+
+	switch c.args[0] {
+	case "-N":
+		return func() error { return p.ipt.DeleteChain(table, chain) }
+	case "-X":
+		return func() error { return p.ipt.NewChain(table, chain) }
+	case "-A":
+		return func() error { return p.ipt.Delete(table, chain, rule...) }
+	case "-I":
+		if len(rule) > 0 {
+			if _, err := strconv.Atoi(rule[0]); err == nil {
+				rule = rule[1:]
+			}
+		}
+		return func() error { return p.ipt.Delete(table, chain, rule...) }
+	case "-D":
+		return p.reinsert(table, chain, c.position, rule)
+	case "-F":
+		if c.rules != nil {
+			return p.reappend(table, chain, c.rules)
+		}
+	}
+
+	return nil
+}
+
+// listRules returns the rules of a chain without the chain name
+func (p *journalIptablesProvider) listRules(table, chain string) ([][]string, error) {
+
+	lines, err := p.ipt.List(table, chain)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := [][]string{}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "-A ") {
+			continue
+		}
+
+		if args := splitRestoreLine(line); len(args) >= 2 {
+			rules = append(rules, args[2:])
+		}
+	}
+
+	return rules, nil
+}
+
+// rulePosition returns the position of the first rule of the list that is the
+// same as the rule, starting at 1. It returns 0 if the rule is not listed.
+func rulePosition(rules [][]string, rule []string) int {
+
+	for k, r := range rules {
+		if strings.Join(r, "\x00") == strings.Join(rule, "\x00") {
+			return k + 1
+		}
+	}
+
+	return 0
+}
+
+// reinsert returns the function that inserts a deleted rule at its position,
+// or appends it if the position is not known
+func (p *journalIptablesProvider) reinsert(table, chain string, position int, rule []string) func() error {
+
+	return func() error {
+		if position > 0 {
+			return p.ipt.Insert(table, chain, position, rule...)
+		}
+		return p.ipt.Append(table, chain, rule...)
+	}
+}
+
+// reappend returns the function that appends the rules of a chain again
+func (p *journalIptablesProvider) reappend(table, chain string, rules [][]string) func() error {
+
+	return func() error {
+		for _, r := range rules {
+			if err := p.ipt.Append(table, chain, r...); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// journalIpsetProvider records the ipset changes in a journal
+type journalIpsetProvider struct {
+	journal *Journal
+	ips     IpsetProvider
+}
+
+// NewIpset creates a set. The set is destroyed if the change is reverted.
+func (p *journalIpsetProvider) NewIpset(name string, hasht string, params *ipset.Params) (Ipset, error) {
+
+	set, err := p.ips.NewIpset(name, hasht, params)
+	if err != nil {
+		return nil, err
+	}
+
+	p.journal.record("ipset create "+name+" "+hasht, set.Destroy)
+
+	return &journalIpset{journal: p.journal, set: set, name: name}, nil
+}
+
+// DestroyAll destroys all the sets. It cannot be reverted.
+func (p *journalIpsetProvider) DestroyAll() error {
+
+	return p.ips.DestroyAll()
+}
+
+// journalIpset records the changes to the entries of a set in a journal. Only
+// the entries that were actually added or deleted are recorded.
+type journalIpset struct {
+	journal *Journal
+	set     Ipset
+	name    string
+}
+
+func (s *journalIpset) Add(entry string, timeout int) error {
+
+	exists, _ := s.set.Test(entry)
+
+	if err := s.set.Add(entry, timeout); err != nil {
+		return err
+	}
+
+	if !exists {
+		s.journal.record("ipset add "+s.name+" "+entry, func() error {
+			return s.set.Del(entry)
+		})
+	}
+
+	return nil
+}
+
+func (s *journalIpset) AddOption(entry string, option string, timeout int) error {
+
+	exists, _ := s.set.Test(entry)
+
+	if err := s.set.AddOption(entry, option, timeout); err != nil {
+		return err
+	}
+
+	if !exists {
+		s.journal.record("ipset add "+s.name+" "+entry+" "+option, func() error {
+			return s.set.Del(entry)
+		})
+	}
+
+	return nil
+}
+
+// Del deletes an entry. The entry is added again without any option if the
+// change is reverted.
+func (s *journalIpset) Del(entry string) error {
+
+	exists, _ := s.set.Test(entry)
+
+	if err := s.set.Del(entry); err != nil {
+		return err
+	}
+
+	if exists {
+		s.journal.record("ipset del "+s.name+" "+entry, func() error {
+			return s.set.Add(entry, 0)
+		})
+	}
+
+	return nil
+}
+
+// Destroy is rejected since the entries of the set cannot be listed to
+// revert it
+func (s *journalIpset) Destroy() error {
+
+	return fmt.Errorf("Cannot destroy set %s: the change cannot be reverted", s.name)
+}
+
+// Flush is rejected since the entries of the set cannot be listed to revert it
+func (s *journalIpset) Flush() error {
+
+	return fmt.Errorf("Cannot flush set %s: the change cannot be reverted", s.name)
+}
+
+func (s *journalIpset) Test(entry string) (bool, error) {
+
+	return s.set.Test(entry)
+}
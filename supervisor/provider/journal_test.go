@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// listingIptablesProvider lists the same rules for every chain and fails
+// the restore of a table
+type listingIptablesProvider struct {
+	IptablesProvider
+	rules     []string
+	failTable string
+}
+
+func (p *listingIptablesProvider) List(table, chain string) ([]string, error) {
+
+	return p.rules, nil
+}
+
+func (p *listingIptablesProvider) Restore(data []byte) error {
+
+	if p.failTable != "" && bytes.HasPrefix(data, []byte("*"+p.failTable+"\n")) {
+		return fmt.Errorf("Restore failed")
+	}
+
+	return p.IptablesProvider.Restore(data)
+}
+
+func TestJournal(t *testing.T) {
+
+	Convey("Given a journal on top of a recorder", t, func() {
+		r := NewRecorder()
+		j := NewJournal()
+		ipt := j.IptablesProvider(r.IptablesProvider("iptables"))
+		ips := j.IpsetProvider(r.IpsetProvider())
+
+		Convey("When I issue iptables and ipset commands", func() {
+			set, err := ips.NewIpset("set", "hash:net", &ipset.Params{HashFamily: "inet"})
+			So(err, ShouldBeNil)
+			So(set.Add("10.0.0.0/8", 0), ShouldBeNil)
+			So(ipt.NewChain("mangle", "chain"), ShouldBeNil)
+			So(ipt.Insert("mangle", "chain", 1, "-j", "ACCEPT"), ShouldBeNil)
+
+			Convey("I should get the changes in order", func() {
+				So(j.Changes(), ShouldResemble, []string{
+					"ipset create set hash:net",
+					"ipset add set 10.0.0.0/8",
+					"-t mangle -N chain",
+					"-t mangle -I chain 1 -j ACCEPT",
+				})
+			})
+
+			Convey("When I roll back, the changes should be reverted in the reverse order", func() {
+				r.Reset()
+				So(j.Rollback(), ShouldBeNil)
+				So(r.Commands(), ShouldResemble, []string{
+					"iptables -t mangle -D chain -j ACCEPT",
+					"iptables -t mangle -X chain",
+					"ipset del set 10.0.0.0/8",
+					"ipset destroy set",
+				})
+				So(j.Changes(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I restore rules", func() {
+			batch := NewIptablesBatch(ipt)
+			So(batch.NewChain("raw", "chain"), ShouldBeNil)
+			So(batch.Append("raw", "chain", "-j", "DROP"), ShouldBeNil)
+			So(batch.Commit(), ShouldBeNil)
+
+			Convey("When I roll back, every command of the restore should be reverted", func() {
+				r.Reset()
+				So(j.Rollback(), ShouldBeNil)
+				So(r.Commands(), ShouldResemble, []string{
+					"iptables -t raw -D chain -j DROP",
+					"iptables -t raw -X chain",
+				})
+			})
+		})
+
+		Convey("When I destroy or flush a set", func() {
+			recorded, err := r.IpsetProvider().NewIpset("set", "hash:net", &ipset.Params{HashFamily: "inet"})
+			So(err, ShouldBeNil)
+			r.Reset()
+			set := j.Ipset("set", recorded)
+
+			Convey("The changes should be rejected", func() {
+				So(set.Destroy(), ShouldNotBeNil)
+				So(set.Flush(), ShouldNotBeNil)
+				So(r.Commands(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I roll back a journal without changes", func() {
+			So(j.Rollback(), ShouldBeNil)
+
+			Convey("No command should be issued", func() {
+				So(r.Commands(), ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestJournalPositions(t *testing.T) {
+
+	Convey("Given a journal on top of a provider with rules", t, func() {
+		r := NewRecorder()
+		j := NewJournal()
+		lister := &listingIptablesProvider{
+			IptablesProvider: r.IptablesProvider("iptables"),
+			rules:            []string{"-N chain", "-A chain -j A", "-A chain -j B", "-A chain -j C"},
+		}
+		ipt := j.IptablesProvider(lister)
+
+		Convey("When I delete a rule and roll back, it should be inserted at its position", func() {
+			So(ipt.Delete("filter", "chain", "-j", "B"), ShouldBeNil)
+			r.Reset()
+			So(j.Rollback(), ShouldBeNil)
+			So(r.Commands(), ShouldResemble, []string{
+				"iptables -t filter -I chain 2 -j B",
+			})
+		})
+
+		Convey("When I delete rules in a restore and roll back, they should be inserted at their positions", func() {
+			batch := NewIptablesBatch(ipt)
+			So(batch.Insert("filter", "chain", 1, "-j", "D"), ShouldBeNil)
+			So(batch.Delete("filter", "chain", "-j", "A"), ShouldBeNil)
+			So(batch.Delete("filter", "chain", "-j", "C"), ShouldBeNil)
+			So(batch.Commit(), ShouldBeNil)
+
+			r.Reset()
+			So(j.Rollback(), ShouldBeNil)
+			So(r.Commands(), ShouldResemble, []string{
+				"iptables -t filter -I chain 3 -j C",
+				"iptables -t filter -I chain 2 -j A",
+				"iptables -t filter -D chain -j D",
+			})
+		})
+
+		Convey("When I flush a chain in a restore after appending a rule and roll back, all the rules should be appended", func() {
+			batch := NewIptablesBatch(ipt)
+			So(batch.Append("filter", "chain", "-j", "D"), ShouldBeNil)
+			So(batch.ClearChain("filter", "chain"), ShouldBeNil)
+			So(batch.Commit(), ShouldBeNil)
+
+			r.Reset()
+			So(j.Rollback(), ShouldBeNil)
+			So(r.Commands(), ShouldResemble, []string{
+				"iptables -t filter -A chain -j A",
+				"iptables -t filter -A chain -j B",
+				"iptables -t filter -A chain -j C",
+				"iptables -t filter -A chain -j D",
+				"iptables -t filter -D chain -j D",
+			})
+		})
+
+		Convey("When the restore of a table fails, only the tables applied before should be recorded", func() {
+			lister.failTable = "mangle"
+			batch := NewIptablesBatch(ipt)
+			So(batch.NewChain("raw", "chain"), ShouldBeNil)
+			So(batch.NewChain("mangle", "chain"), ShouldBeNil)
+			So(batch.NewChain("nat", "chain"), ShouldBeNil)
+			So(batch.Commit(), ShouldNotBeNil)
+
+			So(j.Changes(), ShouldResemble, []string{"-t raw -N chain"})
+			So(r.Commands(), ShouldResemble, []string{"iptables -t raw -N chain"})
+		})
+	})
+}
@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// NftablesProvider is an abstraction of the methods an implementation of userspace
+// nftables needs to provide. A script is applied as one atomic transaction.
+type NftablesProvider interface {
+	Apply(script string) error
+}
+
+type nftProvider struct {
+	path string
+}
+
+// NewNftProvider returns an NftablesProvider interface that applies scripts
+// with the nft command
+func NewNftProvider() (NftablesProvider, error) {
+
+	path, err := exec.LookPath("nft")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to find the nft command: %s", err)
+	}
+
+	return &nftProvider{path: path}, nil
+}
+
+// Apply applies the script with nft -f. The kernel commits all the commands of
+// the script or none of them.
+func (n *nftProvider) Apply(script string) error {
+
+	cmd := exec.Command(n.path, "-f", "-")
+	cmd.Stdin = strings.NewReader(script)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to apply nftables script: %s: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
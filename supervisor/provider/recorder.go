@@ -0,0 +1,282 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+)
+
+// Recorder keeps the iptables and ipset commands issued by its providers in
+// order instead of running them. It renders the rules that an implementation
+// would program without changing the host.
+type Recorder struct {
+	commands []string
+	sync.Mutex
+}
+
+// NewRecorder returns a new recorder without any command
+func NewRecorder() *Recorder {
+
+	return &Recorder{
+		commands: []string{},
+	}
+}
+
+// Commands returns the recorded commands in the order they were issued
+func (r *Recorder) Commands() []string {
+
+	r.Lock()
+	defer r.Unlock()
+
+	commands := make([]string, len(r.commands))
+	copy(commands, r.commands)
+
+	return commands
+}
+
+// Reset removes all the recorded commands
+func (r *Recorder) Reset() {
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.commands = []string{}
+}
+
+// record adds a command with its arguments quoted like in a shell
+func (r *Recorder) record(args ...string) {
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.commands = append(r.commands, strings.Join(quoted, " "))
+}
+
+// IptablesProvider returns an IptablesProvider that records the commands of
+// the given binary, for example iptables or ip6tables
+func (r *Recorder) IptablesProvider(binary string) IptablesProvider {
+
+	return &recordingIptablesProvider{
+		recorder: r,
+		binary:   binary,
+		chains:   map[string][]string{},
+	}
+}
+
+// IpsetProvider returns an IpsetProvider that records the ipset commands
+func (r *Recorder) IpsetProvider() IpsetProvider {
+
+	return &recordingIpsetProvider{
+		recorder: r,
+	}
+}
+
+// recordingIptablesProvider records the iptables commands. The chains created
+// with the provider are the only chains that are listed.
+type recordingIptablesProvider struct {
+	recorder *Recorder
+	binary   string
+	chains   map[string][]string
+	sync.Mutex
+}
+
+func (p *recordingIptablesProvider) Append(table, chain string, rulespec ...string) error {
+
+	p.recorder.record(append([]string{p.binary, "-t", table, "-A", chain}, rulespec...)...)
+	return nil
+}
+
+func (p *recordingIptablesProvider) Insert(table, chain string, pos int, rulespec ...string) error {
+
+	p.recorder.record(append([]string{p.binary, "-t", table, "-I", chain, strconv.Itoa(pos)}, rulespec...)...)
+	return nil
+}
+
+func (p *recordingIptablesProvider) Delete(table, chain string, rulespec ...string) error {
+
+	p.recorder.record(append([]string{p.binary, "-t", table, "-D", chain}, rulespec...)...)
+	return nil
+}
+
+func (p *recordingIptablesProvider) ListChains(table string) ([]string, error) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	return append([]string{}, p.chains[table]...), nil
+}
+
+func (p *recordingIptablesProvider) List(table, chain string) ([]string, error) {
+
+	return []string{}, nil
+}
+
+func (p *recordingIptablesProvider) Exists(table, chain string, rulespec ...string) (bool, error) {
+
+	return false, nil
+}
+
+func (p *recordingIptablesProvider) ClearChain(table, chain string) error {
+
+	p.recorder.record(p.binary, "-t", table, "-F", chain)
+	return nil
+}
+
+func (p *recordingIptablesProvider) DeleteChain(table, chain string) error {
+
+	p.removeChain(table, chain)
+	p.recorder.record(p.binary, "-t", table, "-X", chain)
+	return nil
+}
+
+func (p *recordingIptablesProvider) NewChain(table, chain string) error {
+
+	p.addChain(table, chain)
+	p.recorder.record(p.binary, "-t", table, "-N", chain)
+	return nil
+}
+
+// Restore records every command of the data in the order of the data
+func (p *recordingIptablesProvider) Restore(data []byte) error {
+
+	table := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "COMMIT" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "*") {
+			table = line[1:]
+			continue
+		}
+
+		args := splitRestoreLine(line)
+		if len(args) < 2 {
+			return fmt.Errorf("Invalid restore line %s", line)
+		}
+
+		switch args[0] {
+		case "-N":
+			p.addChain(table, args[1])
+		case "-X":
+			p.removeChain(table, args[1])
+		}
+
+		p.recorder.record(append([]string{p.binary, "-t", table}, args...)...)
+	}
+
+	return nil
+}
+
+// addChain keeps a chain created in the table
+func (p *recordingIptablesProvider) addChain(table, chain string) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.chains[table] = append(p.chains[table], chain)
+}
+
+// removeChain forgets a chain deleted from the table
+func (p *recordingIptablesProvider) removeChain(table, chain string) {
+
+	p.Lock()
+	defer p.Unlock()
+
+	chains := []string{}
+	for _, c := range p.chains[table] {
+		if c != chain {
+			chains = append(chains, c)
+		}
+	}
+	p.chains[table] = chains
+}
+
+// recordingIpsetProvider records the ipset commands
+type recordingIpsetProvider struct {
+	recorder *Recorder
+}
+
+func (p *recordingIpsetProvider) NewIpset(name string, hasht string, params *ipset.Params) (Ipset, error) {
+
+	args := []string{"ipset", "create", name, hasht}
+	if params != nil && params.HashFamily != "" {
+		args = append(args, "family", params.HashFamily)
+	}
+	if params != nil && params.Timeout > 0 {
+		args = append(args, "timeout", strconv.Itoa(params.Timeout))
+	}
+
+	p.recorder.record(args...)
+
+	return &recordingIpset{recorder: p.recorder, name: name}, nil
+}
+
+func (p *recordingIpsetProvider) DestroyAll() error {
+
+	p.recorder.record("ipset", "destroy")
+	return nil
+}
+
+// recordingIpset records the ipset commands of a set
+type recordingIpset struct {
+	recorder *Recorder
+	name     string
+}
+
+func (s *recordingIpset) Add(entry string, timeout int) error {
+
+	args := []string{"ipset", "add", s.name, entry}
+	if timeout > 0 {
+		args = append(args, "timeout", strconv.Itoa(timeout))
+	}
+
+	s.recorder.record(args...)
+	return nil
+}
+
+func (s *recordingIpset) AddOption(entry string, option string, timeout int) error {
+
+	args := []string{"ipset", "add", s.name, entry, option}
+	if timeout > 0 {
+		args = append(args, "timeout", strconv.Itoa(timeout))
+	}
+
+	s.recorder.record(args...)
+	return nil
+}
+
+func (s *recordingIpset) Del(entry string) error {
+
+	s.recorder.record("ipset", "del", s.name, entry)
+	return nil
+}
+
+func (s *recordingIpset) Destroy() error {
+
+	s.recorder.record("ipset", "destroy", s.name)
+	return nil
+}
+
+func (s *recordingIpset) Flush() error {
+
+	s.recorder.record("ipset", "flush", s.name)
+	return nil
+}
+
+func (s *recordingIpset) Test(entry string) (bool, error) {
+
+	return false, nil
+}
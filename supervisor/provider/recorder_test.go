@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/bvandewalle/go-ipset/ipset"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecorder(t *testing.T) {
+
+	Convey("Given a recorder and its providers", t, func() {
+		r := NewRecorder()
+		ipt := r.IptablesProvider("iptables")
+		ips := r.IpsetProvider()
+
+		Convey("When I issue iptables and ipset commands", func() {
+			set, err := ips.NewIpset("set", "hash:net", &ipset.Params{HashFamily: "inet"})
+			So(err, ShouldBeNil)
+			So(set.Add("10.0.0.0/8", 0), ShouldBeNil)
+			So(ipt.NewChain("mangle", "chain"), ShouldBeNil)
+			So(ipt.Insert("mangle", "chain", 1, "-m", "comment", "--comment", "a comment", "-j", "ACCEPT"), ShouldBeNil)
+
+			Convey("I should get the commands in order", func() {
+				So(r.Commands(), ShouldResemble, []string{
+					"ipset create set hash:net family inet",
+					"ipset add set 10.0.0.0/8",
+					"iptables -t mangle -N chain",
+					"iptables -t mangle -I chain 1 -m comment --comment \"a comment\" -j ACCEPT",
+				})
+			})
+
+			Convey("I should get the chains that were created", func() {
+				chains, err := ipt.ListChains("mangle")
+				So(err, ShouldBeNil)
+				So(chains, ShouldResemble, []string{"chain"})
+			})
+
+			Convey("When I reset the recorder, I should get no command", func() {
+				r.Reset()
+				So(r.Commands(), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When I restore rules", func() {
+			batch := NewIptablesBatch(ipt)
+			So(batch.NewChain("raw", "chain"), ShouldBeNil)
+			So(batch.Append("raw", "chain", "-j", "DROP"), ShouldBeNil)
+			So(batch.DeleteChain("raw", "old"), ShouldBeNil)
+			So(batch.Commit(), ShouldBeNil)
+
+			Convey("I should get every command of the restore", func() {
+				So(r.Commands(), ShouldResemble, []string{
+					"iptables -t raw -N chain",
+					"iptables -t raw -A chain -j DROP",
+					"iptables -t raw -X old",
+				})
+			})
+		})
+	})
+}
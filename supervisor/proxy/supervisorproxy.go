@@ -4,6 +4,7 @@ package supervisorproxy
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 
 	"github.com/aporeto-inc/trireme/cache"
@@ -75,6 +76,48 @@ func (s *ProxyInfo) Unsupervise(contextID string) error {
 	return nil
 }
 
+// PUState returns the state of the PU programmed by its remote supervisor
+func (s *ProxyInfo) PUState(contextID string) (*policy.SupervisorState, error) {
+
+	if _, ok := s.initDone[contextID]; !ok {
+		return nil, fmt.Errorf("PU %s is not supervised", contextID)
+	}
+
+	request := &rpcwrapper.Request{
+		Payload: &rpcwrapper.SupervisorStateRequestPayload{
+			ContextID: contextID,
+		},
+	}
+
+	response := &rpcwrapper.Response{}
+	if err := s.rpchdl.RemoteCall(contextID, "Server.SupervisorState", request, response); err != nil {
+		return nil, fmt.Errorf("Failed to get the supervisor state: context=%s error=%s", contextID, err)
+	}
+
+	payload, ok := response.Payload.(rpcwrapper.SupervisorStateResponsePayload)
+	if !ok || payload.State == nil {
+		return nil, fmt.Errorf("Invalid supervisor state: context=%s", contextID)
+	}
+
+	return payload.State, nil
+}
+
+// PUStates returns the state of all the PUs programmed by the remote supervisors
+// ordered by context
+func (s *ProxyInfo) PUStates() ([]*policy.SupervisorState, error) {
+
+	states := []*policy.SupervisorState{}
+	for _, contextID := range s.contextList() {
+		state, err := s.PUState(contextID)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
 // Start This method does nothing and is implemented for completeness
 // THe work done is done in the InitRemoteSupervisor method in the remote enforcer
 func (s *ProxyInfo) Start() error {
@@ -130,23 +173,94 @@ func (s *ProxyInfo) InitRemoteSupervisor(contextID string, puInfo *policy.PUInfo
 
 	s.initDone[contextID] = true
 
+	// A new remote supervisor starts with the exclusions of the node
+	if len(s.ExcludedIPs) > 0 {
+		if err := s.excludedIPsCall(contextID, "Server.AddExcludedIP", s.ExcludedIPs); err != nil {
+			delete(s.initDone, contextID)
+			return fmt.Errorf("Failed to add excluded IP list: context=%s error=%s", contextID, err)
+		}
+	}
+
 	return nil
 
 }
 
-//AddExcludedIPs call addexcluded ip on the remote supervisor
+//AddExcludedIPs call addexcluded ip on the remote supervisors
 func (s *ProxyInfo) AddExcludedIPs(ips []string) error {
-	s.ExcludedIPs = ips
+
+	for _, contextID := range s.contextList() {
+		if err := s.excludedIPsCall(contextID, "Server.AddExcludedIP", ips); err != nil {
+			return fmt.Errorf("Failed to add excluded IP list: context=%s error=%s", contextID, err)
+		}
+	}
+
+	for _, ip := range ips {
+		if !contains(s.ExcludedIPs, ip) {
+			s.ExcludedIPs = append(s.ExcludedIPs, ip)
+		}
+	}
+
+	return nil
+}
+
+//RemoveExcludedIPs call removeexcluded ip on the remote supervisors
+func (s *ProxyInfo) RemoveExcludedIPs(ips []string) error {
+
+	for _, ip := range ips {
+		if !contains(s.ExcludedIPs, ip) {
+			return fmt.Errorf("%s is not excluded", ip)
+		}
+	}
+
+	for _, contextID := range s.contextList() {
+		if err := s.excludedIPsCall(contextID, "Server.RemoveExcludedIP", ips); err != nil {
+			return fmt.Errorf("Failed to remove excluded IP list: context=%s error=%s", contextID, err)
+		}
+	}
+
+	excluded := []string{}
+	for _, ip := range s.ExcludedIPs {
+		if !contains(ips, ip) {
+			excluded = append(excluded, ip)
+		}
+	}
+	s.ExcludedIPs = excluded
+
+	return nil
+}
+
+// excludedIPsCall sends a list of excluded IPs to the remote supervisor of a context
+func (s *ProxyInfo) excludedIPsCall(contextID string, method string, ips []string) error {
+
 	request := &rpcwrapper.Request{
 		Payload: &rpcwrapper.ExcludeIPRequestPayload{
 			IPs: ips,
 		},
 	}
 
-	for _, contextID := range s.rpchdl.ContextList() {
-		if err := s.rpchdl.RemoteCall(contextID, "Server.AddExcludedIP", request, &rpcwrapper.Response{}); err != nil {
-			return fmt.Errorf("Failed to add excluded IP list: context=%s error=%s", contextID, err)
+	return s.rpchdl.RemoteCall(contextID, method, request, &rpcwrapper.Response{})
+}
+
+// contextList returns the contexts of the remote supervisors in order
+func (s *ProxyInfo) contextList() []string {
+
+	contextIDs := []string{}
+	for contextID := range s.initDone {
+		contextIDs = append(contextIDs, contextID)
+	}
+	sort.Strings(contextIDs)
+
+	return contextIDs
+}
+
+// contains returns true if the list has the value
+func contains(list []string, value string) bool {
+
+	for _, v := range list {
+		if v == value {
+			return true
 		}
 	}
-	return nil
+
+	return false
 }
@@ -13,6 +13,9 @@ type mockedMethods struct {
 	UnsuperviseMock func(string) error
 	StartMock       func() error
 	StopMock        func() error
+
+	AddExcludedIPsMock    func([]string) error
+	RemoveExcludedIPsMock func([]string) error
 }
 
 // TestSupervisorLauncher is a mock
@@ -62,6 +65,13 @@ func (m *testSupervisorLauncher) MockStop(t *testing.T, impl func() error) {
 	m.currentMocks(t).StopMock = impl
 }
 
+func (m *testSupervisorLauncher) MockAddExcludedIPs(t *testing.T, impl func([]string) error) {
+	m.currentMocks(t).AddExcludedIPsMock = impl
+}
+func (m *testSupervisorLauncher) MockRemoveExcludedIPs(t *testing.T, impl func([]string) error) {
+	m.currentMocks(t).RemoveExcludedIPsMock = impl
+}
+
 func (m *testSupervisorLauncher) Supervise(contextID string, puInfo *policy.PUInfo) error {
 	if mock := m.currentMocks(m.currentTest); mock != nil && mock.SuperviseMock != nil {
 		return mock.SuperviseMock(contextID, puInfo)
@@ -93,3 +103,19 @@ func (m *testSupervisorLauncher) Stop() error {
 	}
 	return nil
 }
+
+func (m *testSupervisorLauncher) AddExcludedIPs(ips []string) error {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.AddExcludedIPsMock != nil {
+		return mock.AddExcludedIPsMock(ips)
+
+	}
+	return nil
+}
+
+func (m *testSupervisorLauncher) RemoveExcludedIPs(ips []string) error {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.RemoveExcludedIPsMock != nil {
+		return mock.RemoveExcludedIPsMock(ips)
+
+	}
+	return nil
+}
@@ -2,7 +2,12 @@ package supervisor
 
 import (
 	"fmt"
+	"net"
+	"reflect"
+	"sort"
 	"strconv"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -10,17 +15,26 @@ import (
 	"github.com/aporeto-inc/trireme/collector"
 	"github.com/aporeto-inc/trireme/constants"
 	"github.com/aporeto-inc/trireme/enforcer"
+	"github.com/aporeto-inc/trireme/enforcer/utils/packet"
 	"github.com/aporeto-inc/trireme/monitor/linuxmonitor/cgnetcls"
 	"github.com/aporeto-inc/trireme/policy"
 	"github.com/aporeto-inc/trireme/supervisor/ipsetctrl"
 	"github.com/aporeto-inc/trireme/supervisor/iptablesctrl"
+	"github.com/aporeto-inc/trireme/supervisor/nflog"
+	"github.com/aporeto-inc/trireme/supervisor/nftablesctrl"
 )
 
+// DefaultReconcileInterval is the default interval between two checks of the
+// rules of the supervised PUs
+const DefaultReconcileInterval = 30 * time.Second
+
 type cacheData struct {
-	version int
-	ips     *policy.IPMap
-	mark    string
-	port    string
+	version       int
+	ips           *policy.IPMap
+	mark          string
+	port          string
+	managementID  string
+	containerInfo *policy.PUInfo
 }
 
 // Config is the structure holding all information about the supervisor
@@ -37,9 +51,15 @@ type Config struct {
 	Mark        int
 	excludedIPs []string
 	impl        Implementor
+	nflogger    *nflog.NFLogger
+
+	reconcileInterval time.Duration
+	stopReconcile     chan struct{}
+
+	sync.Mutex
 }
 
-// NewSupervisor will create a new connection supervisor that uses IPTables
+// NewSupervisor will create a new connection supervisor that uses IPTables or nftables
 // to redirect specific packets to userspace. It instantiates multiple data stores
 // to maintain efficient mappings between contextID, policy and IP addresses. This
 // simplifies the lookup operations at the expense of memory.
@@ -68,14 +88,19 @@ func NewSupervisor(collector collector.EventCollector, enforcerInstance enforcer
 		applicationQueues: strconv.Itoa(int(filterQueue.ApplicationQueue)) + ":" + strconv.Itoa(int(filterQueue.ApplicationQueue+filterQueue.NumberOfApplicationQueues-1)),
 		Mark:              filterQueue.MarkValue,
 		excludedIPs:       []string{},
+		reconcileInterval: DefaultReconcileInterval,
 	}
 
 	var err error
 	switch implementation {
 	case constants.IPSets:
 		s.impl, err = ipsetctrl.NewInstance(s.networkQueues, s.applicationQueues, s.Mark, false, mode)
+	case constants.NFTables:
+		s.impl, err = nftablesctrl.NewInstance(s.networkQueues, s.applicationQueues, s.Mark, mode)
+		s.nflogger = nflog.NewNFLogger(nflog.Group, s.reportACLPacket)
 	default:
 		s.impl, err = iptablesctrl.NewInstance(s.networkQueues, s.applicationQueues, s.Mark, mode)
+		s.nflogger = nflog.NewNFLogger(nflog.Group, s.reportACLPacket)
 	}
 
 	if err != nil {
@@ -93,6 +118,9 @@ func (s *Config) Supervise(contextID string, containerInfo *policy.PUInfo) error
 		return fmt.Errorf("Runtime, Policy and ContainerInfo should not be nil")
 	}
 
+	s.Lock()
+	defer s.Unlock()
+
 	_, err := s.versionTracker.Get(contextID)
 
 	if err != nil {
@@ -109,6 +137,15 @@ func (s *Config) Supervise(contextID string, containerInfo *policy.PUInfo) error
 // as much cleanup as possible to avoid stale state
 func (s *Config) Unsupervise(contextID string) error {
 
+	s.Lock()
+	defer s.Unlock()
+
+	return s.unsupervise(contextID)
+}
+
+// unsupervise removes the rules of the PU. It must be called with the lock held.
+func (s *Config) unsupervise(contextID string) error {
+
 	version, err := s.versionTracker.Get(contextID)
 
 	if err != nil {
@@ -135,6 +172,17 @@ func (s *Config) Start() error {
 		return fmt.Errorf("Filter of marked packets was not set")
 	}
 
+	if s.nflogger != nil {
+		if err := s.nflogger.Start(); err != nil {
+			zap.L().Warn("ACL matches with the log action will not be reported", zap.Error(err))
+		}
+	}
+
+	if _, ok := s.impl.(Reconciler); ok && s.reconcileInterval > 0 {
+		s.stopReconcile = make(chan struct{})
+		go s.reconcileLoop(s.reconcileInterval, s.stopReconcile)
+	}
+
 	zap.L().Debug("Started the supervisor")
 
 	return nil
@@ -143,6 +191,17 @@ func (s *Config) Start() error {
 // Stop stops the supervisor
 func (s *Config) Stop() error {
 
+	if s.stopReconcile != nil {
+		close(s.stopReconcile)
+		s.stopReconcile = nil
+	}
+
+	if s.nflogger != nil {
+		if err := s.nflogger.Stop(); err != nil {
+			zap.L().Warn("Failed to stop the nflog listener", zap.Error(err))
+		}
+	}
+
 	if err := s.impl.Stop(); err != nil {
 		return fmt.Errorf("Failed to stop the implementer: %s", err)
 	}
@@ -150,6 +209,33 @@ func (s *Config) Stop() error {
 	return nil
 }
 
+// AddExcludedIPs excludes the IP addresses or networks from the enforcement on the
+// whole node
+func (s *Config) AddExcludedIPs(ips []string) error {
+
+	for _, ip := range ips {
+		if net.ParseIP(ip) == nil {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return fmt.Errorf("Invalid IP address or network %s", ip)
+			}
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.impl.AddExcludedIP(ips)
+}
+
+// RemoveExcludedIPs removes the exclusion of the IP addresses or networks
+func (s *Config) RemoveExcludedIPs(ips []string) error {
+
+	s.Lock()
+	defer s.Unlock()
+
+	return s.impl.RemoveExcludedIP(ips)
+}
+
 func (s *Config) doCreatePU(contextID string, containerInfo *policy.PUInfo) error {
 
 	zap.L().Debug("IPTables update for the creation of a pu", zap.String("contextID", contextID))
@@ -161,22 +247,24 @@ func (s *Config) doCreatePU(contextID string, containerInfo *policy.PUInfo) erro
 		port = "0"
 	}
 	cacheEntry := &cacheData{
-		version: version,
-		ips:     containerInfo.Policy.IPAddresses(),
-		mark:    mark,
-		port:    port,
+		version:       version,
+		ips:           containerInfo.Policy.IPAddresses(),
+		mark:          mark,
+		port:          port,
+		managementID:  containerInfo.Policy.ManagementID,
+		containerInfo: containerInfo,
 	}
 
 	// Version the policy so that we can do hitless policy changes
 	s.versionTracker.AddOrUpdate(contextID, cacheEntry)
 
+	// The implementation removes the rules that it added if any of them fails
 	if err := s.impl.ConfigureRules(version, contextID, containerInfo); err != nil {
-		if uerr := s.Unsupervise(contextID); uerr != nil {
-			zap.L().Warn("Failed to clean up state while creating the PU",
-				zap.String("contextID", contextID),
-				zap.Error(uerr),
-			)
+		if rerr := s.versionTracker.Remove(contextID); rerr != nil {
+			zap.L().Warn("Failed to clean the rule version cache", zap.Error(rerr))
 		}
+
+		s.reportRollback(contextID, cacheEntry, fmt.Sprintf("Failed to configure the rules of version %d: %s", version, err))
 		return err
 	}
 
@@ -187,6 +275,11 @@ func (s *Config) doCreatePU(contextID string, containerInfo *policy.PUInfo) erro
 //and the invokes the various handlers that process all policies.
 func (s *Config) doUpdatePU(contextID string, containerInfo *policy.PUInfo) error {
 
+	// Small changes are applied to the current version of the rules
+	if cacheEntry, err := s.versionTracker.Get(contextID); err == nil && s.updateInPlace(contextID, cacheEntry.(*cacheData), containerInfo) {
+		return nil
+	}
+
 	cacheEntry, err := s.versionTracker.LockedModify(contextID, add, 1)
 
 	if err != nil {
@@ -194,20 +287,330 @@ func (s *Config) doUpdatePU(contextID string, containerInfo *policy.PUInfo) erro
 	}
 
 	cachedEntry := cacheEntry.(*cacheData)
+	previousManagementID := cachedEntry.managementID
+	previousContainerInfo := cachedEntry.containerInfo
 
+	cachedEntry.managementID = containerInfo.Policy.ManagementID
+	cachedEntry.containerInfo = containerInfo
+
+	// The implementation keeps the rules of the previous version if the update
+	// fails. The PU stays supervised with the previous version of its policy.
 	if err := s.impl.UpdateRules(cachedEntry.version, contextID, containerInfo); err != nil {
-		if uerr := s.Unsupervise(contextID); uerr != nil {
-			zap.L().Warn("Failed to clean up state while updating the PU",
-				zap.String("contextID", contextID),
-				zap.Error(uerr),
-			)
+		reason := fmt.Sprintf("Failed to update the rules to version %d: %s", cachedEntry.version, err)
+
+		if _, merr := s.versionTracker.LockedModify(contextID, add, -1); merr != nil {
+			zap.L().Warn("Failed to restore the rule version", zap.Error(merr))
 		}
+		cachedEntry.managementID = previousManagementID
+		cachedEntry.containerInfo = previousContainerInfo
+
+		s.reportRollback(contextID, cachedEntry, reason)
 		return err
 	}
 
 	return nil
 }
 
+// updateInPlace applies the update of the policy of a PU to the current version
+// of its rules if the implementation does not need a new version: either none
+// of the rules it programs changed or it can change the few ACLs that changed.
+// It returns false if the rules must be updated to a new version.
+func (s *Config) updateInPlace(contextID string, cachedEntry *cacheData, containerInfo *policy.PUInfo) bool {
+
+	previous := cachedEntry.containerInfo
+	if previous == nil || !reflect.DeepEqual(previous.Runtime.Options(), containerInfo.Runtime.Options()) {
+		return false
+	}
+
+	diff := policy.DiffPolicies(previous.Policy, containerInfo.Policy)
+	if diff.SettingsChanged || diff.ACLChanges() > policy.MaxIncrementalChanges {
+		return false
+	}
+
+	if diff.ACLChanges() > 0 {
+		updater, ok := s.impl.(ACLUpdater)
+		if !ok {
+			return false
+		}
+
+		if err := updater.UpdateACLs(cachedEntry.version, contextID, previous, containerInfo); err != nil {
+			zap.L().Debug("Unable to update the ACLs in place",
+				zap.String("contextID", contextID),
+				zap.Error(err),
+			)
+			return false
+		}
+	}
+
+	cachedEntry.managementID = containerInfo.Policy.ManagementID
+	cachedEntry.containerInfo = containerInfo
+
+	return true
+}
+
+// reportRollback reports that the rules of a PU were rolled back because a step
+// of their configuration failed
+func (s *Config) reportRollback(contextID string, cacheEntry *cacheData, reason string) {
+
+	ip, ok := cacheEntry.ips.Get(policy.DefaultNamespace)
+	if !ok {
+		ip = "N/A"
+	}
+
+	var tags *policy.TagsMap
+	if cacheEntry.containerInfo != nil {
+		tags = cacheEntry.containerInfo.Runtime.Tags()
+	}
+
+	s.collector.CollectContainerEvent(&collector.ContainerRecord{
+		ContextID: contextID,
+		IPAddress: ip,
+		Tags:      tags,
+		Event:     collector.ContainerRollback,
+		Reason:    reason,
+	})
+}
+
+// SetReconcileInterval sets the interval between two checks of the rules of the
+// supervised PUs. The rules are not checked if the interval is 0. It must be
+// called before the supervisor is started.
+func (s *Config) SetReconcileInterval(interval time.Duration) {
+
+	s.reconcileInterval = interval
+}
+
+// Reconcile checks the rules of every supervised PU and repairs the rules that
+// were removed or altered, for example by another agent that flushed the chains.
+// A new version of the rules is configured for every PU that needs a repair and
+// a container event is reported.
+func (s *Config) Reconcile() {
+
+	reconciler, ok := s.impl.(Reconciler)
+	if !ok {
+		return
+	}
+
+	for _, key := range s.versionTracker.KeyList() {
+		s.reconcile(reconciler, key.(string))
+	}
+}
+
+// reconcileLoop reconciles the rules periodically until it is stopped
+func (s *Config) reconcileLoop(interval time.Duration, stop chan struct{}) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Reconcile()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reconcile checks the rules of a PU and repairs them if needed
+func (s *Config) reconcile(reconciler Reconciler, contextID string) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	version, err := s.versionTracker.Get(contextID)
+	if err != nil {
+		// The PU was unsupervised in the meantime
+		return
+	}
+
+	cacheEntry := version.(*cacheData)
+	if cacheEntry.containerInfo == nil {
+		return
+	}
+
+	drift, err := reconciler.CheckRules(cacheEntry.version, contextID, cacheEntry.containerInfo)
+	if err != nil {
+		zap.L().Warn("Unable to check the rules of the PU",
+			zap.String("contextID", contextID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	if len(drift) == 0 {
+		return
+	}
+
+	zap.L().Warn("Repairing the rules of the PU",
+		zap.String("contextID", contextID),
+		zap.Strings("drift", drift),
+	)
+
+	// The repaired rules are configured as a new version so that the altered
+	// version is replaced without any gap in the enforcement
+	if _, err := s.versionTracker.LockedModify(contextID, add, 1); err != nil {
+		return
+	}
+
+	if err := s.impl.UpdateRules(cacheEntry.version, contextID, cacheEntry.containerInfo); err != nil {
+		zap.L().Error("Failed to repair the rules of the PU",
+			zap.String("contextID", contextID),
+			zap.Error(err),
+		)
+
+		// The implementation kept the rules of the previous version
+		if _, merr := s.versionTracker.LockedModify(contextID, add, -1); merr != nil {
+			zap.L().Warn("Failed to restore the rule version", zap.Error(merr))
+		}
+		return
+	}
+
+	ip, ok := cacheEntry.ips.Get(policy.DefaultNamespace)
+	if !ok {
+		ip = "N/A"
+	}
+
+	s.collector.CollectContainerEvent(&collector.ContainerRecord{
+		ContextID: contextID,
+		IPAddress: ip,
+		Tags:      cacheEntry.containerInfo.Runtime.Tags(),
+		Event:     collector.ContainerRepaired,
+	})
+}
+
+// PUState returns the state of a supervised PU as it was programmed
+func (s *Config) PUState(contextID string) (*policy.SupervisorState, error) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	version, err := s.versionTracker.Get(contextID)
+	if err != nil {
+		return nil, fmt.Errorf("PU %s is not supervised", contextID)
+	}
+
+	return s.puState(contextID, version.(*cacheData)), nil
+}
+
+// PUStates returns the state of all the supervised PUs ordered by context
+func (s *Config) PUStates() ([]*policy.SupervisorState, error) {
+
+	s.Lock()
+	defer s.Unlock()
+
+	contextIDs := []string{}
+	for _, key := range s.versionTracker.KeyList() {
+		contextIDs = append(contextIDs, key.(string))
+	}
+	sort.Strings(contextIDs)
+
+	states := []*policy.SupervisorState{}
+	for _, contextID := range contextIDs {
+		version, err := s.versionTracker.Get(contextID)
+		if err != nil {
+			continue
+		}
+
+		states = append(states, s.puState(contextID, version.(*cacheData)))
+	}
+
+	return states, nil
+}
+
+// puState returns the state of a PU from its cache entry. It must be called
+// with the lock held.
+func (s *Config) puState(contextID string, cacheEntry *cacheData) *policy.SupervisorState {
+
+	state := &policy.SupervisorState{
+		ContextID:        contextID,
+		ManagementID:     cacheEntry.managementID,
+		Version:          cacheEntry.version,
+		Chains:           []string{},
+		Mark:             cacheEntry.mark,
+		Port:             cacheEntry.port,
+		IPs:              cacheEntry.ips.Clone(),
+		ApplicationACLs:  policy.NewIPRuleList(nil),
+		NetworkACLs:      policy.NewIPRuleList(nil),
+		ExcludedNetworks: []string{},
+		TriremeNetworks:  []string{},
+	}
+
+	if namer, ok := s.impl.(ChainNamer); ok {
+		state.Chains = namer.ChainNames(cacheEntry.version, contextID)
+	}
+
+	if cacheEntry.containerInfo != nil {
+		p := cacheEntry.containerInfo.Policy
+		state.ApplicationACLs = p.ApplicationACLs()
+		state.NetworkACLs = p.NetworkACLs()
+		state.ExcludedNetworks = append(state.ExcludedNetworks, p.ExcludedNetworks()...)
+		state.TriremeNetworks = append(state.TriremeNetworks, p.TriremeNetworks()...)
+	}
+
+	return state
+}
+
+// reportACLPacket reports a packet logged by an ACL rule with the Log action
+func (s *Config) reportACLPacket(prefix string, payload []byte) {
+
+	contextID, ruleID, action, err := nflog.ParsePrefix(prefix)
+	if err != nil {
+		zap.L().Debug("Ignoring nflog packet", zap.Error(err))
+		return
+	}
+
+	// The entry of the PU is updated under the lock by the supervision of the PU
+	s.Lock()
+	version, err := s.versionTracker.Get(contextID)
+	if err != nil {
+		s.Unlock()
+		zap.L().Debug("Ignoring nflog packet of unknown PU", zap.String("contextID", contextID))
+		return
+	}
+	cacheEntry := version.(*cacheData)
+	managementID := cacheEntry.managementID
+	puIP, hasIP := cacheEntry.ips.Get(policy.DefaultNamespace)
+	s.Unlock()
+
+	record := &collector.FlowRecord{
+		ContextID:    contextID,
+		Count:        1,
+		RuleID:       ruleID,
+		ManagementID: managementID,
+		Action:       collector.FlowAccept,
+		Timestamp:    time.Now(),
+	}
+
+	if action&policy.Reject != 0 {
+		record.Action = collector.FlowReject
+		record.Mode = string(collector.PolicyDrop)
+		record.DropReason = collector.PolicyDrop
+	}
+
+	// The packets of the reject rules of a PU in audit mode are accepted
+	if nflog.IsAuditPrefix(prefix) {
+		record.Action = collector.FlowAudit
+	}
+
+	if p, err := packet.New(0, payload, "0"); err == nil {
+		record.SourceIP = p.SourceAddress.String()
+		record.DestinationIP = p.DestinationAddress.String()
+		if p.IPProto == packet.IPProtocolTCP || p.IPProto == packet.IPProtocolUDP {
+			record.DestinationPort = p.DestinationPort
+		}
+
+		// The PU is the source of the packets of application ACLs
+		record.SourceID = record.SourceIP
+		record.DestinationID = contextID
+		if hasIP && puIP == record.SourceIP {
+			record.SourceID = contextID
+			record.DestinationID = record.DestinationIP
+		}
+	}
+
+	s.collector.CollectFlowEvent(record)
+}
+
 func add(a, b interface{}) interface{} {
 	entry := a.(*cacheData)
 	entry.version += b.(int)
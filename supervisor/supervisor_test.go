@@ -2,6 +2,8 @@ package supervisor
 
 import (
 	"fmt"
+	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/aporeto-inc/mock/gomock"
@@ -11,6 +13,7 @@ import (
 	"github.com/aporeto-inc/trireme/enforcer/utils/tokens"
 	"github.com/aporeto-inc/trireme/policy"
 	mock_supervisor "github.com/aporeto-inc/trireme/supervisor/mock"
+	"github.com/aporeto-inc/trireme/supervisor/nflog"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -88,7 +91,7 @@ func TestSupervise(t *testing.T) {
 	defer ctrl.Finish()
 
 	Convey("Given a valid supervisor", t, func() {
-		c := &collector.DefaultCollector{}
+		c := &recordingCollector{}
 		secrets := tokens.NewPSKSecrets([]byte("test password"))
 		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.LocalContainer, "/proc")
 
@@ -115,11 +118,23 @@ func TestSupervise(t *testing.T) {
 
 		Convey("When I supervise a new PU with valid policy, but there is an error", func() {
 			impl.EXPECT().ConfigureRules(0, "errorPU", puInfo).Return(fmt.Errorf("Error"))
-			impl.EXPECT().DeleteRules(0, "errorPU", gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 			err := s.Supervise("errorPU", puInfo)
 			Convey("I should  get an error", func() {
 				So(err, ShouldNotBeNil)
 			})
+
+			Convey("The PU should not be supervised", func() {
+				_, err := s.versionTracker.Get("errorPU")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("A rollback event should be reported with the failed step", func() {
+				So(len(c.containers), ShouldEqual, 1)
+				So(c.containers[0].ContextID, ShouldEqual, "errorPU")
+				So(c.containers[0].Event, ShouldEqual, collector.ContainerRollback)
+				So(c.containers[0].IPAddress, ShouldEqual, "172.17.0.1")
+				So(c.containers[0].Reason, ShouldContainSubstring, "configure the rules of version 0")
+			})
 		})
 
 		Convey("When I send supervise command for a second time, it should do an update", func() {
@@ -127,27 +142,114 @@ func TestSupervise(t *testing.T) {
 			impl.EXPECT().UpdateRules(1, "contextID", gomock.Any()).Return(nil)
 			noerr := s.Supervise("contextID", puInfo)
 			So(noerr, ShouldBeNil)
-			err := s.Supervise("contextID", puInfo)
+			updated := createPUInfo()
+			updated.Policy.UpdateTriremeNetworks([]string{"10.0.0.0/8"})
+			err := s.Supervise("contextID", updated)
 			Convey("I should not get an error", func() {
 				So(err, ShouldBeNil)
 			})
 		})
 
+		Convey("When I send supervise command with a policy that only differs by its tag selectors", func() {
+			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			So(s.Supervise("contextID", puInfo), ShouldBeNil)
+			updated := createPUInfo()
+			updated.Policy.ManagementID = "updated"
+			updated.Policy.AddReceiverRules(&policy.TagSelector{Action: policy.Accept})
+			err := s.Supervise("contextID", updated)
+
+			Convey("The rules should not be updated", func() {
+				So(err, ShouldBeNil)
+				data, err := s.versionTracker.Get("contextID")
+				So(err, ShouldBeNil)
+				So(data.(*cacheData).version, ShouldEqual, 0)
+				So(data.(*cacheData).containerInfo, ShouldEqual, updated)
+				So(data.(*cacheData).managementID, ShouldEqual, "updated")
+			})
+		})
+
+		Convey("When I send supervise command with a policy that only differs by its ACLs", func() {
+			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+			impl.EXPECT().UpdateRules(1, "contextID", gomock.Any()).Return(nil)
+			So(s.Supervise("contextID", puInfo), ShouldBeNil)
+			updated := withoutApplicationACLs(createPUInfo())
+			err := s.Supervise("contextID", updated)
+
+			Convey("An implementation that cannot update the ACLs should update the rules to a new version", func() {
+				So(err, ShouldBeNil)
+				data, err := s.versionTracker.Get("contextID")
+				So(err, ShouldBeNil)
+				So(data.(*cacheData).version, ShouldEqual, 1)
+			})
+		})
+
 		Convey("When I send supervise command for a second time, and the update fails", func() {
 			impl.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
 			impl.EXPECT().UpdateRules(1, "contextID", gomock.Any()).Return(fmt.Errorf("Error"))
-			impl.EXPECT().DeleteRules(1, "contextID", gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
 			serr := s.Supervise("contextID", puInfo)
 			So(serr, ShouldBeNil)
-			err := s.Supervise("contextID", puInfo)
+			updated := createPUInfo()
+			updated.Policy.ManagementID = "updated"
+			updated.Policy.UpdateTriremeNetworks([]string{"10.0.0.0/8"})
+			err := s.Supervise("contextID", updated)
 			Convey("I should get an error", func() {
 				So(err, ShouldNotBeNil)
 			})
+
+			Convey("The PU should stay supervised with the previous version of its policy", func() {
+				data, err := s.versionTracker.Get("contextID")
+				So(err, ShouldBeNil)
+				So(data.(*cacheData).version, ShouldEqual, 0)
+				So(data.(*cacheData).containerInfo, ShouldEqual, puInfo)
+				So(data.(*cacheData).managementID, ShouldEqual, puInfo.Policy.ManagementID)
+			})
+
+			Convey("A rollback event should be reported with the failed step", func() {
+				So(len(c.containers), ShouldEqual, 1)
+				So(c.containers[0].ContextID, ShouldEqual, "contextID")
+				So(c.containers[0].Event, ShouldEqual, collector.ContainerRollback)
+				So(c.containers[0].Reason, ShouldContainSubstring, "update the rules to version 1")
+			})
+
+			Convey("The next update should retry the same version", func() {
+				impl.EXPECT().UpdateRules(1, "contextID", updated).Return(nil)
+				So(s.Supervise("contextID", updated), ShouldBeNil)
+			})
 		})
 
 	})
 }
 
+func TestExcludedIPs(t *testing.T) {
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a valid supervisor", t, func() {
+		c := &collector.DefaultCollector{}
+		secrets := tokens.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.LocalContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.LocalContainer, constants.IPTables)
+		impl := mock_supervisor.NewMockImplementor(ctrl)
+		s.impl = impl
+
+		Convey("When I exclude valid IP addresses and networks, they should be excluded by the implementation", func() {
+			impl.EXPECT().AddExcludedIP([]string{"10.0.0.1", "fd00::/64"}).Return(nil)
+			So(s.AddExcludedIPs([]string{"10.0.0.1", "fd00::/64"}), ShouldBeNil)
+		})
+
+		Convey("When I exclude an invalid address, I should get an error", func() {
+			So(s.AddExcludedIPs([]string{"10.0.0.1", "invalid"}), ShouldNotBeNil)
+		})
+
+		Convey("When I remove an exclusion, it should be removed by the implementation", func() {
+			impl.EXPECT().RemoveExcludedIP([]string{"10.0.0.1"}).Return(fmt.Errorf("Error"))
+			So(s.RemoveExcludedIPs([]string{"10.0.0.1"}), ShouldNotBeNil)
+		})
+	})
+}
+
 func TestUnsupervise(t *testing.T) {
 
 	ctrl := gomock.NewController(t)
@@ -214,3 +316,336 @@ func TestStart(t *testing.T) {
 		})
 	})
 }
+
+// recordingCollector keeps the flow and container records that are reported
+type recordingCollector struct {
+	records    []*collector.FlowRecord
+	containers []*collector.ContainerRecord
+	sync.Mutex
+}
+
+func (r *recordingCollector) CollectFlowEvent(record *collector.FlowRecord) {
+	r.Lock()
+	defer r.Unlock()
+	r.records = append(r.records, record)
+}
+
+func (r *recordingCollector) CollectContainerEvent(record *collector.ContainerRecord) {
+	r.Lock()
+	defer r.Unlock()
+	r.containers = append(r.containers, record)
+}
+
+// tcpPacket returns an IPv4 TCP packet from 172.17.0.1 to 192.30.253.1:443
+func tcpPacket() []byte {
+
+	return []byte{
+		0x45, 0x00, 0x00, 0x28, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		172, 17, 0, 1,
+		192, 30, 253, 1,
+		0x9c, 0x40, 0x01, 0xbb, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0x50, 0x02, 0x72, 0x10, 0x00, 0x00, 0x00, 0x00,
+	}
+}
+
+func TestReportACLPacket(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a supervisor with a supervised PU", t, func() {
+		c := &recordingCollector{}
+		secrets := tokens.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.LocalContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.LocalContainer, constants.IPTables)
+		impl := mock_supervisor.NewMockImplementor(ctrl)
+		s.impl = impl
+
+		impl.EXPECT().ConfigureRules(0, "contextID", gomock.Any()).Return(nil)
+		So(s.Supervise("contextID", createPUInfo()), ShouldBeNil)
+
+		Convey("When an application ACL with the log action rejects a packet", func() {
+			s.reportACLPacket(nflog.Prefix("contextID", "3", policy.Reject|policy.Log), tcpPacket())
+
+			Convey("Then a detailed reject record should be reported", func() {
+				So(len(c.records), ShouldEqual, 1)
+				record := c.records[0]
+				So(record.ContextID, ShouldEqual, "contextID")
+				So(record.RuleID, ShouldEqual, "3")
+				So(record.ManagementID, ShouldEqual, "context")
+				So(record.Action, ShouldEqual, collector.FlowReject)
+				So(record.DropReason, ShouldEqual, collector.PolicyDrop)
+				So(record.Mode, ShouldEqual, string(collector.PolicyDrop))
+				So(record.SourceID, ShouldEqual, "contextID")
+				So(record.DestinationID, ShouldEqual, "192.30.253.1")
+				So(record.DestinationPort, ShouldEqual, 443)
+				So(record.Timestamp.IsZero(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When an application ACL of a PU in audit mode logs a packet", func() {
+			s.reportACLPacket(nflog.AuditPrefix("contextID", nflog.DefaultRuleID), tcpPacket())
+
+			Convey("Then an audit record should be reported", func() {
+				So(len(c.records), ShouldEqual, 1)
+				So(c.records[0].RuleID, ShouldEqual, nflog.DefaultRuleID)
+				So(c.records[0].Action, ShouldEqual, collector.FlowAudit)
+				So(c.records[0].DropReason, ShouldEqual, collector.PolicyDrop)
+			})
+		})
+
+		Convey("When a packet is logged for an unknown PU", func() {
+			s.reportACLPacket(nflog.Prefix("unknown", "3", policy.Accept|policy.Log), tcpPacket())
+
+			Convey("Then nothing should be reported", func() {
+				So(len(c.records), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+// reconcilingImplementor is an implementor that can check the rules
+type reconcilingImplementor struct {
+	*mock_supervisor.MockImplementor
+	*mock_supervisor.MockReconciler
+}
+
+// withoutApplicationACLs returns the PU with a policy without application ACLs
+func withoutApplicationACLs(puInfo *policy.PUInfo) *policy.PUInfo {
+
+	plc := puInfo.Policy
+	puInfo.Policy = policy.NewPUPolicy(plc.ManagementID, plc.TriremeAction, nil, plc.NetworkACLs(), nil, nil, nil, nil, plc.IPAddresses(), plc.TriremeNetworks(), plc.ExcludedNetworks(), nil)
+
+	return puInfo
+}
+
+// aclUpdatingImplementor is an implementor that can update the ACLs in place
+type aclUpdatingImplementor struct {
+	*mock_supervisor.MockImplementor
+	*mock_supervisor.MockACLUpdater
+}
+
+func TestUpdateACLs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a supervisor with a supervised PU and an implementation that updates the ACLs", t, func() {
+		c := &recordingCollector{}
+		secrets := tokens.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.LocalContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.LocalContainer, constants.IPTables)
+		impl := &aclUpdatingImplementor{
+			MockImplementor: mock_supervisor.NewMockImplementor(ctrl),
+			MockACLUpdater:  mock_supervisor.NewMockACLUpdater(ctrl),
+		}
+		s.impl = impl
+
+		puInfo := createPUInfo()
+		impl.MockImplementor.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+		So(s.Supervise("contextID", puInfo), ShouldBeNil)
+
+		Convey("When a few ACLs change", func() {
+			updated := withoutApplicationACLs(createPUInfo())
+			impl.MockACLUpdater.EXPECT().UpdateACLs(0, "contextID", puInfo, updated).Return(nil)
+			err := s.Supervise("contextID", updated)
+
+			Convey("Then the ACLs of the current version should be updated", func() {
+				So(err, ShouldBeNil)
+				data, err := s.versionTracker.Get("contextID")
+				So(err, ShouldBeNil)
+				So(data.(*cacheData).version, ShouldEqual, 0)
+				So(data.(*cacheData).containerInfo, ShouldEqual, updated)
+			})
+		})
+
+		Convey("When the ACLs cannot be updated", func() {
+			updated := withoutApplicationACLs(createPUInfo())
+			impl.MockACLUpdater.EXPECT().UpdateACLs(0, "contextID", puInfo, updated).Return(fmt.Errorf("Error"))
+			impl.MockImplementor.EXPECT().UpdateRules(1, "contextID", updated).Return(nil)
+			err := s.Supervise("contextID", updated)
+
+			Convey("Then the rules should be updated to a new version", func() {
+				So(err, ShouldBeNil)
+				data, err := s.versionTracker.Get("contextID")
+				So(err, ShouldBeNil)
+				So(data.(*cacheData).version, ShouldEqual, 1)
+				So(data.(*cacheData).containerInfo, ShouldEqual, updated)
+			})
+		})
+
+		Convey("When too many ACLs change", func() {
+			rules := []policy.IPRule{}
+			for i := 0; i <= policy.MaxIncrementalChanges; i++ {
+				rules = append(rules, policy.IPRule{Address: "10.0.0.0/8", Port: strconv.Itoa(1000 + i), Protocol: "TCP", Action: policy.Accept})
+			}
+			updated := createPUInfo()
+			plc := updated.Policy
+			updated.Policy = policy.NewPUPolicy(plc.ManagementID, plc.TriremeAction, policy.NewIPRuleList(rules), plc.NetworkACLs(), nil, nil, nil, nil, plc.IPAddresses(), plc.TriremeNetworks(), plc.ExcludedNetworks(), nil)
+			impl.MockImplementor.EXPECT().UpdateRules(1, "contextID", updated).Return(nil)
+
+			Convey("Then the rules should be updated to a new version", func() {
+				So(s.Supervise("contextID", updated), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a supervisor with a supervised PU", t, func() {
+		c := &recordingCollector{}
+		secrets := tokens.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.LocalContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.LocalContainer, constants.IPTables)
+		impl := &reconcilingImplementor{
+			MockImplementor: mock_supervisor.NewMockImplementor(ctrl),
+			MockReconciler:  mock_supervisor.NewMockReconciler(ctrl),
+		}
+		s.impl = impl
+
+		puInfo := createPUInfo()
+		impl.MockImplementor.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+		So(s.Supervise("contextID", puInfo), ShouldBeNil)
+
+		Convey("When the rules of the PU are intact", func() {
+			impl.MockReconciler.EXPECT().CheckRules(0, "contextID", puInfo).Return([]string{}, nil)
+			s.Reconcile()
+
+			Convey("Then nothing should be repaired", func() {
+				So(len(c.containers), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the rules of the PU were flushed", func() {
+			impl.MockReconciler.EXPECT().CheckRules(0, "contextID", puInfo).Return([]string{"Chain is missing"}, nil)
+			impl.MockImplementor.EXPECT().UpdateRules(1, "contextID", puInfo).Return(nil)
+			s.Reconcile()
+
+			Convey("Then a new version should be configured and a repair event reported", func() {
+				So(len(c.containers), ShouldEqual, 1)
+				So(c.containers[0].ContextID, ShouldEqual, "contextID")
+				So(c.containers[0].IPAddress, ShouldEqual, "172.17.0.1")
+				So(c.containers[0].Event, ShouldEqual, collector.ContainerRepaired)
+
+				Convey("And the next check should use the new version", func() {
+					impl.MockReconciler.EXPECT().CheckRules(1, "contextID", puInfo).Return(nil, nil)
+					s.Reconcile()
+					So(len(c.containers), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("When the repair fails", func() {
+			impl.MockReconciler.EXPECT().CheckRules(0, "contextID", puInfo).Return([]string{"Chain is missing"}, nil)
+			impl.MockImplementor.EXPECT().UpdateRules(1, "contextID", puInfo).Return(fmt.Errorf("Error"))
+			s.Reconcile()
+
+			Convey("Then the PU should stay supervised and no event should be reported", func() {
+				So(len(c.containers), ShouldEqual, 0)
+				data, err := s.versionTracker.Get("contextID")
+				So(err, ShouldBeNil)
+				So(data.(*cacheData).version, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the rules cannot be checked", func() {
+			impl.MockReconciler.EXPECT().CheckRules(0, "contextID", puInfo).Return(nil, fmt.Errorf("Error"))
+			s.Reconcile()
+
+			Convey("Then nothing should be repaired", func() {
+				So(len(c.containers), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+// namingImplementor is an implementor that can name the chains of the PUs
+type namingImplementor struct {
+	*mock_supervisor.MockImplementor
+	*mock_supervisor.MockChainNamer
+}
+
+func TestPUState(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	Convey("Given a supervisor with a supervised PU", t, func() {
+		c := &collector.DefaultCollector{}
+		secrets := tokens.NewPSKSecrets([]byte("test password"))
+		e := enforcer.NewWithDefaults("serverID", c, nil, secrets, constants.LocalContainer, "/proc")
+
+		s, _ := NewSupervisor(c, e, constants.LocalContainer, constants.IPTables)
+		impl := &namingImplementor{
+			MockImplementor: mock_supervisor.NewMockImplementor(ctrl),
+			MockChainNamer:  mock_supervisor.NewMockChainNamer(ctrl),
+		}
+		s.impl = impl
+
+		puInfo := createPUInfo()
+		impl.MockImplementor.EXPECT().ConfigureRules(0, "contextID", puInfo).Return(nil)
+		So(s.Supervise("contextID", puInfo), ShouldBeNil)
+
+		Convey("When I get the state of the PU", func() {
+			impl.MockChainNamer.EXPECT().ChainNames(0, "contextID").Return([]string{"TRIREME-App-contextID-0", "TRIREME-Net-contextID-0"})
+			state, err := s.PUState("contextID")
+
+			Convey("Then I should get the programmed state", func() {
+				So(err, ShouldBeNil)
+				So(state.ContextID, ShouldEqual, "contextID")
+				So(state.ManagementID, ShouldEqual, "context")
+				So(state.Version, ShouldEqual, 0)
+				So(state.Chains, ShouldResemble, []string{"TRIREME-App-contextID-0", "TRIREME-Net-contextID-0"})
+				So(state.Port, ShouldEqual, "0")
+				So(state.IPs.IPs[policy.DefaultNamespace], ShouldEqual, "172.17.0.1")
+				So(len(state.ApplicationACLs.Rules), ShouldEqual, 2)
+				So(len(state.NetworkACLs.Rules), ShouldEqual, 2)
+				So(state.TriremeNetworks, ShouldResemble, []string{"172.17.0.0/24"})
+				So(state.ExcludedNetworks, ShouldResemble, []string{})
+			})
+		})
+
+		Convey("When the policy of the PU is updated", func() {
+			updated := createPUInfo()
+			updated.Policy.UpdateTriremeNetworks([]string{"10.0.0.0/8"})
+			impl.MockImplementor.EXPECT().UpdateRules(1, "contextID", updated).Return(nil)
+			So(s.Supervise("contextID", updated), ShouldBeNil)
+
+			impl.MockChainNamer.EXPECT().ChainNames(1, "contextID").Return([]string{"TRIREME-App-contextID-1", "TRIREME-Net-contextID-1"})
+			state, err := s.PUState("contextID")
+
+			Convey("Then the state should have the new version", func() {
+				So(err, ShouldBeNil)
+				So(state.Version, ShouldEqual, 1)
+				So(state.Chains, ShouldResemble, []string{"TRIREME-App-contextID-1", "TRIREME-Net-contextID-1"})
+			})
+		})
+
+		Convey("When I get the state of all the PUs", func() {
+			impl.MockImplementor.EXPECT().ConfigureRules(0, "another", puInfo).Return(nil)
+			So(s.Supervise("another", puInfo), ShouldBeNil)
+
+			impl.MockChainNamer.EXPECT().ChainNames(0, gomock.Any()).Return([]string{}).Times(2)
+			states, err := s.PUStates()
+
+			Convey("Then I should get the state of every PU ordered by context", func() {
+				So(err, ShouldBeNil)
+				So(len(states), ShouldEqual, 2)
+				So(states[0].ContextID, ShouldEqual, "another")
+				So(states[1].ContextID, ShouldEqual, "contextID")
+			})
+		})
+
+		Convey("When I get the state of an unknown PU", func() {
+			state, err := s.PUState("unknown")
+
+			Convey("Then I should get an error", func() {
+				So(err, ShouldNotBeNil)
+				So(state, ShouldBeNil)
+			})
+		})
+	})
+}
@@ -23,6 +23,9 @@ type mockedMethods struct {
 
 	//AddExcludedIP adds exlcluded iplist
 	AddExcludedIPsMock func(iplist []string) error
+
+	// RemoveExcludedIPs removes the exclusion of the iplist
+	removeExcludedIPsMock func(iplist []string) error
 }
 
 // TestSupervisor is a test implementation for IptablesProvider
@@ -33,6 +36,7 @@ type TestSupervisor interface {
 	MockStart(t *testing.T, impl func() error)
 	MockStop(t *testing.T, impl func() error)
 	MockAddExcludedIPs(t *testing.T, impl func(ips []string) error)
+	MockRemoveExcludedIPs(t *testing.T, impl func(ips []string) error)
 }
 
 // A TestSupervisorInst is an empty TransactionalManipulator that can be easily mocked.
@@ -55,6 +59,11 @@ func (m *TestSupervisorInst) MockAddExcludedIPs(t *testing.T, impl func(ip []str
 	m.currentMocks(t).AddExcludedIPsMock = impl
 }
 
+// MockRemoveExcludedIPs mocks RemoveExcludedIPs
+func (m *TestSupervisorInst) MockRemoveExcludedIPs(t *testing.T, impl func(ip []string) error) {
+	m.currentMocks(t).removeExcludedIPsMock = impl
+}
+
 // MockSupervise mocks the Supervise method
 func (m *TestSupervisorInst) MockSupervise(t *testing.T, impl func(contextID string, puInfo *policy.PUInfo) error) {
 
@@ -107,6 +116,14 @@ func (m *TestSupervisorInst) AddExcludedIPs(ips []string) error {
 	return nil
 }
 
+// RemoveExcludedIPs is a test implementation of the RemoveExcludedIPs interface
+func (m *TestSupervisorInst) RemoveExcludedIPs(ips []string) error {
+	if mock := m.currentMocks(m.currentTest); mock != nil && mock.removeExcludedIPsMock != nil {
+		return mock.removeExcludedIPsMock(ips)
+	}
+	return nil
+}
+
 // Start is a test implementation of the Start interface method
 func (m *TestSupervisorInst) Start() error {
 
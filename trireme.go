@@ -172,6 +172,23 @@ func mustEnforce(contextID string, containerInfo *policy.PUInfo) bool {
 	return true
 }
 
+// validatePolicy returns an error if the policy cannot be enforced. The rules
+// that are likely mistakes are logged.
+func validatePolicy(contextID string, p *policy.PUPolicy) error {
+
+	validation := p.Validate()
+
+	for _, w := range validation.Warnings {
+		zap.L().Warn("Policy warning",
+			zap.String("contextID", contextID),
+			zap.String("field", w.Field),
+			zap.String("warning", w.Message),
+		)
+	}
+
+	return validation.Err()
+}
+
 func (t *trireme) doHandleCreate(contextID string) error {
 
 	// Retrieve the container runtime information from the cache
@@ -214,6 +231,17 @@ func (t *trireme) doHandleCreate(contextID string) error {
 		return fmt.Errorf("Nil policy returned for context: %s. Container killed", contextID)
 	}
 
+	if err := validatePolicy(contextID, policyInfo); err != nil {
+		t.collector.CollectContainerEvent(&collector.ContainerRecord{
+			ContextID: contextID,
+			IPAddress: "N/A",
+			Tags:      nil,
+			Event:     collector.ContainerFailed,
+		})
+
+		return fmt.Errorf("Policy Error for this context: %s. Container killed. %s", contextID, err)
+	}
+
 	ip, _ := policyInfo.DefaultIPAddress()
 
 	// Create a copy as we are going to modify it locally
@@ -344,6 +372,10 @@ func (t *trireme) doUpdatePolicy(contextID string, newPolicy *policy.PUPolicy) e
 		return fmt.Errorf("Policy Update failed because couldn't find runtime for contextID %s", contextID)
 	}
 
+	if err = validatePolicy(contextID, newPolicy); err != nil {
+		return fmt.Errorf("Policy Update failed for contextID %s: %s", contextID, err)
+	}
+
 	containerInfo := policy.PUInfoFromPolicyAndRuntime(contextID, newPolicy, runtimeInfo.(*policy.PURuntime))
 
 	addTransmitterLabel(contextID, containerInfo)
@@ -361,3 +361,41 @@ func TestTransmitterLabel(t *testing.T) {
 	}
 
 }
+
+func TestInvalidPolicy(t *testing.T) {
+	tresolver, tsupervisor, tenforcer, _, tcollector := createMocks()
+	trireme := NewTrireme("serverID", tresolver, tsupervisor, tenforcer, tcollector)
+	if err := trireme.Start(); err != nil {
+		t.Errorf("Failed to start trireme")
+	}
+	contextID := "123123"
+	runtime := policy.NewPURuntimeWithDefaults()
+
+	tresolver.MockResolvePolicy(t, func(contextID string, RuntimeReader policy.RuntimeReader) (*policy.PUPolicy, error) {
+		acls := policy.NewIPRuleList([]policy.IPRule{{Address: "10.0.0.0/33", Port: "80", Protocol: "TCP", Action: policy.Accept}})
+		ipaddrs := policy.NewIPMap(map[string]string{policy.DefaultNamespace: "127.0.0.1"})
+		return policy.NewPUPolicy("SomeId", policy.Police, acls, nil, nil, nil, nil, nil, ipaddrs, []string{"172.17.0.0/24"}, []string{}, nil), nil
+	})
+
+	enforcerCount := 0
+	tenforcer[constants.ContainerPU].(enforcer.TestPolicyEnforcer).MockEnforce(t, func(contextID string, puInfo *policy.PUInfo) error {
+		enforcerCount++
+		return nil
+	})
+
+	if err := trireme.SetPURuntime(contextID, runtime); err != nil {
+		t.Errorf("Error while setting the Runtime in Trireme,  %s", err)
+	}
+
+	if err := <-trireme.HandlePUEvent(contextID, monitor.EventStart); err == nil {
+		t.Errorf("Create was supposed to fail with an invalid policy")
+	}
+
+	if enforcerCount != 0 {
+		t.Errorf("An invalid policy was enforced")
+	}
+
+	if err := <-trireme.UpdatePolicy(contextID, policy.NewPUPolicy("", policy.Police, nil, nil, nil, nil, nil, nil, nil, []string{"172.17.0.0/33"}, []string{}, nil)); err == nil {
+		t.Errorf("Update was supposed to fail with an invalid policy")
+	}
+}
@@ -83,6 +83,9 @@ func (p *CustomPolicyResolver) ResolvePolicy(context string, runtimeInfo policy.
 	if ip, ok := runtimeInfo.DefaultIPAddress(); ok {
 		ipl.IPs[policy.DefaultNamespace] = ip
 	}
+	if ip, ok := runtimeInfo.DefaultIPv6Address(); ok {
+		ipl.IPs[policy.DefaultIPv6Namespace] = ip
+	}
 
 	identity := runtimeInfo.Tags()
 